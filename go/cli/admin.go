@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"ipfs-senc/client"
+)
+
+const adminUsage = `usage: mmipfs admin user ls | user add | user set | user rm | proposals | approve ID | reject ID`
+
+// admin runs the admin commands, which need the admin key of the profile.
+func admin(s *session, args []string) error {
+	if len(args) == 0 {
+		return errors.New(adminUsage)
+	}
+	if args[0] == "user" {
+		if len(args) < 2 {
+			return errors.New(adminUsage)
+		}
+		args = append([]string{"user " + args[1]}, args[2:]...)
+	}
+
+	var run func(c *client.Client, args []string) error
+	switch args[0] {
+	case "user ls":
+		run = users
+	case "user add":
+		run = addUser
+	case "user set":
+		run = setUser
+	case "user rm":
+		run = removeUser
+	case "proposals":
+		run = proposals
+	case "approve":
+		run = approve
+	case "reject":
+		run = reject
+	default:
+		return errors.New(adminUsage)
+	}
+
+	c, err := s.client(false, true)
+	if err != nil {
+		return err
+	}
+
+	return run(c, args[1:])
+}
+
+func users(c *client.Client, args []string) error {
+	if _, err := parse(flag.NewFlagSet("admin user ls", flag.ExitOnError), args, 0, "admin user ls"); err != nil {
+		return err
+	}
+
+	usrs, err := c.Users()
+	if err != nil {
+		return fmt.Errorf("failed to Users: %w", err)
+	}
+
+	for _, u := range usrs {
+		fmt.Printf("%d\t%s\tlevel %d\tdepartment %d\t%s\n", u.ID, u.TgName, u.Level, u.Department, u.Status)
+	}
+
+	return nil
+}
+
+func addUser(c *client.Client, args []string) error {
+	set := flag.NewFlagSet("admin user add", flag.ExitOnError)
+	tgName := set.String("tg", "", "telegram name of the user")
+	level := set.Int("level", 0, "security level")
+	department := set.Int("department", 0, "department")
+	if _, err := parse(set, args, 0, "admin user add -tg NAME -level N -department N"); err != nil {
+		return err
+	}
+	if *tgName == "" {
+		return errors.New("requires -tg")
+	}
+
+	e, p, err := c.AddUser(*tgName, *level, *department)
+	if err != nil {
+		return fmt.Errorf("failed to AddUser: %w", err)
+	}
+	if p != nil {
+		fmt.Printf("enrollment proposed as %d, a second admin has to approve it\n", p.ID)
+		return nil
+	}
+
+	// the code is for the new user, not a secret of this profile
+	fmt.Printf("user %d added, enrollment code %s valid until %s\n", e.ID, e.Code, e.CodeExpiresAt.Format(time.RFC3339))
+
+	return nil
+}
+
+func setUser(c *client.Client, args []string) error {
+	set := flag.NewFlagSet("admin user set", flag.ExitOnError)
+	newLevel := set.Int("level", 0, "new security level")
+	newDepartment := set.Int("department", 0, "new department")
+	id, err := numericArg(set, args, "admin user set [-level N] [-department N] ID", "user")
+	if err != nil {
+		return err
+	}
+
+	// only the flags set are changed
+	var level, department *int
+	set.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "level":
+			level = newLevel
+		case "department":
+			department = newDepartment
+		}
+	})
+	if level == nil && department == nil {
+		return errors.New("requires -level or -department")
+	}
+
+	u, p, err := c.SetUser(int(id), level, department)
+	if err != nil {
+		return fmt.Errorf("failed to SetUser: %w", err)
+	}
+	if p != nil {
+		fmt.Printf("change proposed as %d, a second admin has to approve it\n", p.ID)
+		return nil
+	}
+	fmt.Printf("user %d: level %d, department %d\n", u.ID, u.Level, u.Department)
+
+	return nil
+}
+
+func removeUser(c *client.Client, args []string) error {
+	id, err := numericArg(flag.NewFlagSet("admin user rm", flag.ExitOnError), args, "admin user rm ID", "user")
+	if err != nil {
+		return err
+	}
+
+	p, err := c.DeleteUser(int(id))
+	if err != nil {
+		return fmt.Errorf("failed to DeleteUser: %w", err)
+	}
+	fmt.Printf("revocation of user %d proposed as %d, a second admin has to approve it\n", id, p.ID)
+
+	return nil
+}
+
+func proposals(c *client.Client, args []string) error {
+	set := flag.NewFlagSet("admin proposals", flag.ExitOnError)
+	status := set.String("status", "pending", "status of the proposals to list, all if empty")
+	if _, err := parse(set, args, 0, "admin proposals [-status pending|approved|rejected|expired]"); err != nil {
+		return err
+	}
+
+	ps, err := c.Proposals(*status)
+	if err != nil {
+		return fmt.Errorf("failed to Proposals: %w", err)
+	}
+
+	for _, p := range ps {
+		fmt.Printf("%d\t%s\tuser %d (%s)\tlevel %d\tdepartment %d\tby %d\t%s\texpires %s\n",
+			p.ID, p.Action, p.User.ID, p.User.TgName, p.User.Level, p.User.Department, p.ProposedBy, p.Status, p.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func approve(c *client.Client, args []string) error {
+	id, err := numericArg(flag.NewFlagSet("admin approve", flag.ExitOnError), args, "admin approve ID", "proposal")
+	if err != nil {
+		return err
+	}
+
+	a, err := c.Approve(int(id))
+	if err != nil {
+		return fmt.Errorf("failed to Approve: %w", err)
+	}
+	fmt.Printf("proposal %d %s, user %s\n", a.ID, a.Status, a.Result)
+	if e := a.Enrollment; e != nil {
+		// the code is for the user, it is not stored anywhere else
+		fmt.Printf("enrollment code %s valid until %s\n", e.Code, e.CodeExpiresAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func reject(c *client.Client, args []string) error {
+	id, err := numericArg(flag.NewFlagSet("admin reject", flag.ExitOnError), args, "admin reject ID", "proposal")
+	if err != nil {
+		return err
+	}
+
+	if err = c.Reject(int(id)); err != nil {
+		return fmt.Errorf("failed to Reject: %w", err)
+	}
+	fmt.Printf("proposal %d rejected\n", id)
+
+	return nil
+}
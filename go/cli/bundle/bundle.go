@@ -0,0 +1,149 @@
+// Package bundle reads directories into bundle entries and unpacks opened
+// bundles. The server encrypts each entry at its own classification level.
+package bundle
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"server/api"
+)
+
+// Rule classifies the entries matching Pattern. A pattern ending with a slash
+// matches a directory and everything in it, other patterns are matched with
+// path.Match against the entry path.
+type Rule struct {
+	Pattern string
+	Level   int
+}
+
+// ParseRules parses rules like "annex/=3,*.pdf=2".
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		i := strings.LastIndex(r, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid level rule %q", r)
+		}
+		level, err := strconv.Atoi(r[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid level in rule %q", r)
+		}
+		if _, err = path.Match(r[:i], ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in rule %q: %w", r, err)
+		}
+		rules = append(rules, Rule{Pattern: r[:i], Level: level})
+	}
+
+	return rules, nil
+}
+
+// Level returns the level of the first rule matching p, or def.
+func Level(rules []Rule, p string, def int) int {
+	for _, r := range rules {
+		if strings.HasSuffix(r.Pattern, "/") && strings.HasPrefix(p, r.Pattern) {
+			return r.Level
+		}
+		if ok, _ := path.Match(r.Pattern, p); ok {
+			return r.Level
+		}
+	}
+
+	return def
+}
+
+// ReadDir returns the regular files under root as entries classified by the
+// rules. A single file becomes a bundle of one entry.
+func ReadDir(root string, rules []Rule, def int) ([]api.RequestBundleEntry, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Stat: %w", err)
+	}
+	if !info.IsDir() {
+		entry, err := readEntry(root, filepath.Base(root), rules, def)
+		if err != nil {
+			return nil, err
+		}
+		return []api.RequestBundleEntry{entry}, nil
+	}
+
+	var entries []api.RequestBundleEntry
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		entry, err := readEntry(p, filepath.ToSlash(rel), rules, def)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to WalkDir: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no files in %s", root)
+	}
+
+	return entries, nil
+}
+
+func readEntry(file, p string, rules []Rule, def int) (api.RequestBundleEntry, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return api.RequestBundleEntry{}, fmt.Errorf("failed to ReadFile: %w", err)
+	}
+
+	return api.RequestBundleEntry{
+		Path:     p,
+		Level:    Level(rules, p, def),
+		MimeType: mime.TypeByExtension(path.Ext(p)),
+		Data:     base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// Unpack writes the entries that aren't withheld under dst and returns the
+// withheld ones. Paths leaving dst are refused.
+func Unpack(dst string, entries []api.ResponseBundleEntry) ([]api.ResponseBundleEntry, error) {
+	var withheld []api.ResponseBundleEntry
+	for _, e := range entries {
+		if e.Withheld {
+			withheld = append(withheld, e)
+			continue
+		}
+
+		p := path.Clean(e.Path)
+		if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") || strings.Contains(e.Path, `\`) {
+			return nil, fmt.Errorf("entry path %q leaves the destination", e.Path)
+		}
+		data, err := base64.StdEncoding.DecodeString(e.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to DecodeString %s: %w", e.Path, err)
+		}
+
+		file := filepath.Join(dst, filepath.FromSlash(p))
+		if err = os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to MkdirAll: %w", err)
+		}
+		if err = os.WriteFile(file, data, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to WriteFile: %w", err)
+		}
+	}
+
+	return withheld, nil
+}
@@ -0,0 +1,55 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/api"
+)
+
+func TestReadDirUnpack(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "annex"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "summary.txt"), []byte("summary"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "annex", "report.pdf"), []byte("report"), 0o600))
+
+	rules, err := ParseRules("annex/=3, *.txt=1")
+	require.NoError(t, err)
+	entries, err := ReadDir(src, rules, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "annex/report.pdf", entries[0].Path)
+	require.Equal(t, 3, entries[0].Level)
+	require.Equal(t, "application/pdf", entries[0].MimeType)
+	require.Equal(t, "summary.txt", entries[1].Path)
+	require.Equal(t, 1, entries[1].Level)
+
+	opened := []api.ResponseBundleEntry{
+		{Path: entries[0].Path, Level: 3, Withheld: true},
+		{Path: entries[1].Path, Level: 1, Data: entries[1].Data},
+	}
+	dst := t.TempDir()
+	withheld, err := Unpack(dst, opened)
+	require.NoError(t, err)
+	require.Equal(t, opened[:1], withheld)
+	data, err := os.ReadFile(filepath.Join(dst, "summary.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "summary", string(data))
+	require.NoFileExists(t, filepath.Join(dst, "annex", "report.pdf"))
+
+	_, err = Unpack(dst, []api.ResponseBundleEntry{{Path: "../escape", Data: ""}})
+	require.Error(t, err)
+}
+
+func TestParseRules(t *testing.T) {
+	for _, s := range []string{"annex", "=1", "a=x", "[=1"} {
+		_, err := ParseRules(s)
+		require.Error(t, err, s)
+	}
+	rules, err := ParseRules("")
+	require.NoError(t, err)
+	require.Equal(t, 2, Level(rules, "a", 2))
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"server/api"
+)
+
+// AdminMe returns the admin the key belongs to.
+func (c *Client) AdminMe() (api.Admin, error) {
+	var admin api.Admin
+	_, err := c.do("GET", "/admin/me", nil, &admin)
+
+	return admin, err
+}
+
+// Users lists the users the admin manages.
+func (c *Client) Users() ([]api.User, error) {
+	var users []api.User
+	_, err := c.do("GET", "/admin/all", nil, &users)
+
+	return users, err
+}
+
+// AddUser enrolls a user. Enrollments needing a second admin return a
+// proposal instead.
+func (c *Client) AddUser(tgName string, level, department int) (*api.Enrollment, *api.Proposal, error) {
+	var e api.Enrollment
+	p, err := c.proposable("POST", "/admin/add", api.RequestAdd{TgName: tgName, Level: level, Department: department}, &e)
+	if err != nil || p != nil {
+		return nil, p, err
+	}
+
+	return &e, nil, nil
+}
+
+// SetUser changes the clearance of a user. Nil values are kept. Changes
+// needing a second admin return a proposal instead.
+func (c *Client) SetUser(id int, level, department *int) (*api.User, *api.Proposal, error) {
+	var user api.User
+	p, err := c.proposable("PATCH", "/admin/user/"+strconv.Itoa(id), api.RequestUpdate{Level: level, Department: department}, &user)
+	if err != nil || p != nil {
+		return nil, p, err
+	}
+
+	return &user, nil, nil
+}
+
+// DeleteUser proposes the revocation of a user, which always needs a second
+// admin.
+func (c *Client) DeleteUser(id int) (*api.Proposal, error) {
+	var p api.Proposal
+	_, err := c.do("DELETE", "/admin/user/"+strconv.Itoa(id), nil, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Proposals lists the proposals with the status, all when empty.
+func (c *Client) Proposals(status string) ([]api.Proposal, error) {
+	path := "/admin/proposals"
+	if status != "" {
+		path += "?status=" + status
+	}
+
+	var proposals []api.Proposal
+	_, err := c.do("GET", path, nil, &proposals)
+
+	return proposals, err
+}
+
+// Approve approves and executes a proposal of another admin. An approved
+// enrollment or rebind returns the enrollment code of the user.
+func (c *Client) Approve(id int) (api.ResponseApproval, error) {
+	var a api.ResponseApproval
+	_, err := c.do("POST", "/admin/proposals/"+strconv.Itoa(id)+"/approve", nil, &a)
+
+	return a, err
+}
+
+// Reject rejects a proposal.
+func (c *Client) Reject(id int) error {
+	_, err := c.do("POST", "/admin/proposals/"+strconv.Itoa(id)+"/reject", nil, nil)
+
+	return err
+}
+
+// proposable decodes the response into out, or returns the proposal the
+// server answered with 202 Accepted.
+func (c *Client) proposable(method, path string, in, out interface{}) (*api.Proposal, error) {
+	var raw json.RawMessage
+	status, err := c.do(method, path, in, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusAccepted {
+		var p api.Proposal
+		if err = json.Unmarshal(raw, &p); err != nil {
+			return nil, fmt.Errorf("failed to Unmarshal proposal: %w", err)
+		}
+		return &p, nil
+	}
+	if err = json.Unmarshal(raw, out); err != nil {
+		return nil, fmt.Errorf("failed to Unmarshal: %w", err)
+	}
+
+	return nil, nil
+}
@@ -1,106 +1,103 @@
+// Package client calls the server's HTTP API. Files are encrypted and
+// decrypted by the server, so whatever the CLI uploads the bot and the other
+// clients can open, and the other way round.
 package client
 
 import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-)
+	"strconv"
+	"strings"
 
-type requestAdd struct {
-	Level      int    `json:"level" validate:"required"`
-	Department int    `json:"department" validate:"required"`
-	Data       string `json:"data" validate:"required"`
-}
+	"server/api"
+)
 
-type requestCheck struct {
-	Level         int    `json:"level" validate:"required"`
-	Department    int    `json:"department" validate:"required"`
-	WitLevel      string `json:"wit_level" validate:"required"`
-	WitDepartment string `json:"wit_department" validate:"required"`
-}
+const (
+	userIDHeader = "X-User-ID"
+	userPKHeader = "X-User-PK"
+	adminHeader  = "X-Admin-Key"
+)
 
-type Response struct {
-	WitLevel string `json:"wit_level"`
-	WitDep   string `json:"wit_dep"`
+// Error is an error response of the server.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
 }
 
-func Add(level, dep int, data string) (string, string, error) {
-	body, err := json.Marshal(requestAdd{Level: level, Department: dep, Data: data})
-	if err != nil {
-		return "", "", fmt.Errorf("failed to Marshal: %w", err)
-	}
-	r, err := http.NewRequest("POST", "127.0.0.1:1323/accumulator", bytes.NewBuffer(body))
-	if err != nil {
-		return "", "", fmt.Errorf("failed to NewRequest: %w", err)
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("bad status: %d, %s", e.Status, e.Message)
 	}
 
-	r.Header.Add("Content-Type", "application/json")
-	client := &http.Client{}
-
-	res, err := client.Do(r)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to Do: %w", err)
-	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
 
-	if res.StatusCode != http.StatusOK {
-		return "", "", fmt.Errorf("bad status: %d, %s", res.StatusCode, res.Status)
-	}
+// Client calls the API with the credentials of its config. User requests send
+// the user ID and PK, admin requests the admin key.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
 
-	var resp Response
-	if err = json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return "", "", fmt.Errorf("failed to Unmarshal: %w", err)
+// New returns a client of the server in cfg.
+func New(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	return resp.WitLevel, resp.WitDep, nil
+	return &Client{cfg: cfg, http: &http.Client{}}, nil
 }
 
-func Delete(level, dep int, data string) error {
-	body, err := json.Marshal(requestAdd{Level: level, Department: dep, Data: data})
-	if err != nil {
-		return fmt.Errorf("failed to Marshal: %w", err)
-	}
-	r, err := http.NewRequest("DELETE", "127.0.0.1:1323/accumulator", bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("failed to NewRequest: %w", err)
+// do sends in as JSON and decodes the response into out. It returns the
+// status, 202 Accepted tells a proposal from a change that was made.
+func (c *Client) do(method, path string, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, fmt.Errorf("failed to Marshal: %w", err)
+		}
+		body = bytes.NewReader(data)
 	}
 
-	r.Header.Add("Content-Type", "application/json")
-	client := &http.Client{}
-
-	res, err := client.Do(r)
+	r, err := http.NewRequest(method, c.cfg.Server+path, body)
 	if err != nil {
-		return fmt.Errorf("failed to Do: %w", err)
+		return 0, fmt.Errorf("failed to NewRequest: %w", err)
 	}
-
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %d, %s", res.StatusCode, res.Status)
+	if in != nil {
+		r.Header.Set("Content-Type", "application/json")
 	}
-
-	return nil
-}
-
-func Check(level, dep int, witLevel, witDep string) error {
-	body, err := json.Marshal(requestCheck{Level: level, Department: dep, WitLevel: witLevel, WitDepartment: witDep})
-	if err != nil {
-		return fmt.Errorf("failed to Marshal: %w", err)
-	}
-	r, err := http.NewRequest("PUT", "127.0.0.1:1323/accumulator", bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("failed to NewRequest: %w", err)
+	if strings.HasPrefix(path, "/admin/") {
+		r.Header.Set(adminHeader, c.cfg.AdminKey.Reveal())
+	} else if c.cfg.UserID != 0 {
+		r.Header.Set(userIDHeader, strconv.Itoa(c.cfg.UserID))
+		r.Header.Set(userPKHeader, c.cfg.PK.Reveal())
 	}
 
-	r.Header.Add("Content-Type", "application/json")
-	client := &http.Client{}
-
-	res, err := client.Do(r)
+	res, err := c.http.Do(r)
 	if err != nil {
-		return fmt.Errorf("failed to Do: %w", err)
+		return 0, fmt.Errorf("failed to Do: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusAccepted {
+		e := &Error{Status: res.StatusCode, Message: res.Status}
+		var resp api.ResponseError
+		if json.NewDecoder(res.Body).Decode(&resp) == nil && resp.Code != "" {
+			e.Code, e.Message = resp.Code, resp.Message
+		}
+		return res.StatusCode, e
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %d, %s", res.StatusCode, res.Status)
+	if out != nil {
+		if err = json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, fmt.Errorf("failed to Unmarshal: %w", err)
+		}
 	}
 
-	return nil
+	return res.StatusCode, nil
 }
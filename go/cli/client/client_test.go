@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/api"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/me":
+			if r.Header.Get(userIDHeader) != "7" || r.Header.Get(userPKHeader) != "pk" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(api.ResponseError{Code: "unauthorized", Message: "incorrect user credentials"})
+				return
+			}
+			json.NewEncoder(w).Encode(api.User{ID: 7, Level: 2})
+		case "/files":
+			var req api.RequestUpload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			require.Equal(t, "cmVwb3J0", req.Data)
+			json.NewEncoder(w).Encode(api.File{ID: 1, Name: req.Name, Level: req.Level})
+		case "/files/1/content":
+			json.NewEncoder(w).Encode(api.ResponseContent{File: api.File{ID: 1}, Data: "cmVwb3J0"})
+		case "/admin/add":
+			require.Equal(t, "key", r.Header.Get(adminHeader))
+			require.Empty(t, r.Header.Get(userIDHeader))
+			var req api.RequestAdd
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			if req.Level > 3 {
+				w.WriteHeader(http.StatusAccepted)
+				json.NewEncoder(w).Encode(api.Proposal{ID: 5, Action: "enroll", User: api.User{TgName: req.TgName}})
+				return
+			}
+			json.NewEncoder(w).Encode(api.Enrollment{User: api.User{ID: 8, TgName: req.TgName}, Code: "ABCDE-FGHIJ"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{Server: srv.URL + "/", UserID: 7, PK: "pk", AdminKey: "key"})
+	require.NoError(t, err)
+
+	user, err := c.Me()
+	require.NoError(t, err)
+	require.Equal(t, 2, user.Level)
+
+	file, err := c.Upload("report.txt", "text/plain", 1, []byte("report"))
+	require.NoError(t, err)
+	require.Equal(t, api.File{ID: 1, Name: "report.txt", Level: 1}, file)
+
+	_, data, err := c.Download(1)
+	require.NoError(t, err)
+	require.Equal(t, "report", string(data))
+
+	e, p, err := c.AddUser("bob", 1, 2)
+	require.NoError(t, err)
+	require.Nil(t, p)
+	require.Equal(t, "ABCDE-FGHIJ", e.Code)
+	require.Equal(t, 8, e.ID)
+
+	e, p, err = c.AddUser("carol", 4, 2)
+	require.NoError(t, err)
+	require.Nil(t, e)
+	require.Equal(t, 5, p.ID)
+	require.Equal(t, "carol", p.User.TgName)
+
+	c, err = New(Config{Server: srv.URL, UserID: 7, PK: "wrong"})
+	require.NoError(t, err)
+	_, err = c.Me()
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusUnauthorized, apiErr.Status)
+	require.Equal(t, "unauthorized", apiErr.Code)
+}
+
+func TestConfig(t *testing.T) {
+	for _, s := range []string{"127.0.0.1:1323/accumulator", "ftp://server", "http://"} {
+		c := Config{Server: s}
+		require.Error(t, c.Validate(), s)
+	}
+	c := Config{}
+	require.NoError(t, c.Validate())
+	require.Equal(t, DefaultServer, c.Server)
+
+	c = Config{Server: "https://server/api/"}
+	require.NoError(t, c.Validate())
+	require.Equal(t, "https://server/api", c.Server)
+}
@@ -1,5 +1,39 @@
 package client
 
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"server/logging"
+)
+
+// DefaultServer is the server used when none is configured.
+const DefaultServer = "http://127.0.0.1:8088"
+
+// Config is the server URL and the credentials the client calls it with. The
+// credentials redact themselves, so printing a Config doesn't leak them.
 type Config struct {
-	AccumServer string
+	Server   string
+	UserID   int
+	PK       logging.Secret
+	AdminKey logging.Secret
+}
+
+// Validate checks the server URL, DefaultServer is used when it is empty.
+func (c *Config) Validate() error {
+	if c.Server == "" {
+		c.Server = DefaultServer
+	}
+
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("server URL %q needs an http or https scheme and a host", c.Server)
+	}
+	c.Server = strings.TrimRight(u.Scheme+"://"+u.Host+u.EscapedPath(), "/")
+
+	return nil
 }
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"server/api"
+)
+
+// Me returns the user the credentials belong to.
+func (c *Client) Me() (api.User, error) {
+	var user api.User
+	_, err := c.do("GET", "/me", nil, &user)
+
+	return user, err
+}
+
+// Upload sends data to be encrypted at the level within the user's
+// department.
+func (c *Client) Upload(name, mimeType string, level int, data []byte) (api.File, error) {
+	var file api.File
+	_, err := c.do("POST", "/files", api.RequestUpload{
+		Name:     name,
+		MimeType: mimeType,
+		Level:    level,
+		Data:     base64.StdEncoding.EncodeToString(data),
+	}, &file)
+
+	return file, err
+}
+
+// ListFiles requests a page of the files the user has clearance for. query
+// holds the listing filters: name, mime_type, uploader, level, from, to, sort,
+// order, page and per_page.
+func (c *Client) ListFiles(query url.Values) (api.ResponseFiles, error) {
+	var page api.ResponseFiles
+	_, err := c.do("GET", "/files?"+query.Encode(), nil, &page)
+
+	return page, err
+}
+
+// FileInfo returns the metadata of a file.
+func (c *Client) FileInfo(id int64) (api.File, error) {
+	var file api.File
+	_, err := c.do("GET", "/files/"+strconv.FormatInt(id, 10), nil, &file)
+
+	return file, err
+}
+
+// Download returns the metadata and the decrypted content of a file.
+func (c *Client) Download(id int64) (api.File, []byte, error) {
+	var resp api.ResponseContent
+	if _, err := c.do("GET", "/files/"+strconv.FormatInt(id, 10)+"/content", nil, &resp); err != nil {
+		return api.File{}, nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Data)
+	if err != nil {
+		return api.File{}, nil, fmt.Errorf("failed to DecodeString: %w", err)
+	}
+
+	return resp.File, data, nil
+}
+
+// DeleteFile deletes a file the user uploaded.
+func (c *Client) DeleteFile(id int64) error {
+	_, err := c.do("DELETE", "/file/"+strconv.FormatInt(id, 10), nil, nil)
+
+	return err
+}
+
+// CreateBundle uploads the entries, the server encrypts each at its level.
+func (c *Client) CreateBundle(name string, entries []api.RequestBundleEntry) (api.File, error) {
+	var file api.File
+	_, err := c.do("POST", "/bundle", api.RequestBundle{Name: name, Entries: entries}, &file)
+
+	return file, err
+}
+
+// OpenBundle downloads the entries of the bundle the user can open.
+func (c *Client) OpenBundle(id int64) (api.ResponseBundle, error) {
+	var b api.ResponseBundle
+	_, err := c.do("GET", "/bundle/"+strconv.FormatInt(id, 10), nil, &b)
+
+	return b, err
+}
@@ -0,0 +1,21 @@
+package client
+
+import "server/api"
+
+// RefreshWitness has the server reissue the user's witnesses against the
+// current accumulators and returns them.
+func (c *Client) RefreshWitness() (api.Witness, error) {
+	var w api.Witness
+	_, err := c.do("POST", "/witness", nil, &w)
+
+	return w, err
+}
+
+// Prove presents the witnesses to the server, which checks them against the
+// accumulators of the user's level and department.
+func (c *Client) Prove(w api.Witness) (api.User, error) {
+	var user api.User
+	_, err := c.do("POST", "/prove", w, &user)
+
+	return user, err
+}
@@ -0,0 +1,133 @@
+// Package config reads the CLI config file. It holds named profiles, each a
+// server and a user ID; the secrets of the profiles are in the keystore.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultProfile is the profile used when none is chosen.
+const DefaultProfile = "default"
+
+// The environment variables. The server, the user ID and the secrets override
+// the profile.
+const (
+	EnvConfig     = "MMIPFS_CONFIG"
+	EnvKeystore   = "MMIPFS_KEYSTORE"
+	EnvProfile    = "MMIPFS_PROFILE"
+	EnvPassphrase = "MMIPFS_PASSPHRASE"
+	EnvServer     = "MMIPFS_SERVER"
+	EnvUserID     = "MMIPFS_USER_ID"
+	EnvUserPK     = "MMIPFS_USER_PK"
+	EnvAdminKey   = "MMIPFS_ADMIN_KEY"
+)
+
+// Profile is a server and the user to call it as.
+type Profile struct {
+	Server string `json:"server,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+}
+
+// Config is the config file.
+type Config struct {
+	// Current is the profile used when none is chosen, DefaultProfile if
+	// empty.
+	Current  string             `json:"current,omitempty"`
+	Profiles map[string]Profile `json:"profiles"`
+
+	path string
+}
+
+// Path returns the config file named by MMIPFS_CONFIG, or config.json in the
+// user's config directory.
+func Path() (string, error) {
+	return path(EnvConfig, "config.json")
+}
+
+// KeystorePath returns the keystore named by MMIPFS_KEYSTORE, or keystore in
+// the user's config directory.
+func KeystorePath() (string, error) {
+	return path(EnvKeystore, "keystore")
+}
+
+func path(env, name string) (string, error) {
+	if p := os.Getenv(env); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to UserConfigDir: %w", err)
+	}
+
+	return filepath.Join(dir, "mmipfs", name), nil
+}
+
+// Load reads the config file. A missing file is a config without profiles.
+func Load(path string) (*Config, error) {
+	c := &Config{Profiles: map[string]Profile{}, path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReadFile: %w", err)
+	}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to Unmarshal %s: %w", path, err)
+	}
+	if c.Profiles == nil {
+		c.Profiles = map[string]Profile{}
+	}
+
+	return c, nil
+}
+
+// Save writes the config file.
+func (c *Config) Save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to Marshal: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return fmt.Errorf("failed to MkdirAll: %w", err)
+	}
+	if err = os.WriteFile(c.path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to WriteFile: %w", err)
+	}
+
+	return nil
+}
+
+// Name returns the profile to use: name if set, then MMIPFS_PROFILE, then the
+// current one.
+func (c *Config) Name(name string) string {
+	if name != "" {
+		return name
+	}
+	if name = os.Getenv(EnvProfile); name != "" {
+		return name
+	}
+	if c.Current != "" {
+		return c.Current
+	}
+
+	return DefaultProfile
+}
+
+// Names returns the names of the profiles.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
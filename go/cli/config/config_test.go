@@ -0,0 +1,34 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mmipfs", "config.json")
+
+	c, err := Load(path)
+	require.NoError(t, err)
+	require.Empty(t, c.Profiles)
+
+	t.Setenv(EnvProfile, "")
+	require.Equal(t, DefaultProfile, c.Name(""))
+
+	c.Profiles["work"] = Profile{Server: "https://server", UserID: 7}
+	c.Profiles["home"] = Profile{Server: "http://127.0.0.1:8088", UserID: 8}
+	c.Current = "work"
+	require.NoError(t, c.Save())
+
+	c, err = Load(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"home", "work"}, c.Names())
+	require.Equal(t, Profile{Server: "https://server", UserID: 7}, c.Profiles["work"])
+
+	require.Equal(t, "work", c.Name(""))
+	t.Setenv(EnvProfile, "home")
+	require.Equal(t, "home", c.Name(""))
+	require.Equal(t, "other", c.Name("other"))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"ipfs-senc/bundle"
+)
+
+func upload(s *session, args []string) error {
+	set := flag.NewFlagSet("upload", flag.ExitOnError)
+	name := set.String("name", "", "file name, the base of FILE by default")
+	level := set.Int("level", 0, "security level")
+	args, err := parse(set, args, 1, "upload [-name NAME] [-level N] FILE")
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to ReadFile: %w", err)
+	}
+	if *name == "" {
+		*name = filepath.Base(args[0])
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	file, err := c.Upload(*name, mime.TypeByExtension(filepath.Ext(*name)), *level, data)
+	if err != nil {
+		return fmt.Errorf("failed to Upload: %w", err)
+	}
+	fmt.Printf("file %d shared as %s, level %d\n", file.ID, file.IpfsKey, file.Level)
+
+	return nil
+}
+
+// list passes the filters set on the command line to the server.
+func list(s *session, args []string) error {
+	set := flag.NewFlagSet("ls", flag.ExitOnError)
+	set.String("name", "", "substring of the name")
+	set.String("mime", "", "MIME type")
+	set.Int("level", 0, "security level")
+	set.String("sort", "", "sort field: name, size, level or created_at")
+	set.String("order", "", "sort order, asc or desc")
+	set.Int("page", 1, "page")
+	set.Int("per-page", 0, "files per page")
+	if _, err := parse(set, args, 0, "ls [-name S] [-mime T] [-level N] [-sort F] [-order asc|desc] [-page N] [-per-page N]"); err != nil {
+		return err
+	}
+
+	params := map[string]string{"mime": "mime_type", "per-page": "per_page"}
+	query := url.Values{}
+	set.Visit(func(f *flag.Flag) {
+		key, ok := params[f.Name]
+		if !ok {
+			key = f.Name
+		}
+		query.Set(key, f.Value.String())
+	})
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	page, err := c.ListFiles(query)
+	if err != nil {
+		return fmt.Errorf("failed to ListFiles: %w", err)
+	}
+
+	for _, f := range page.Files {
+		fmt.Printf("%d\t%s\t%s\tlevel %d\t%d bytes\t%s\n", f.ID, f.Name, f.MimeType, f.Level, f.Size, f.CreatedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("page %d, %d of %d files\n", page.Page, len(page.Files), page.Total)
+
+	return nil
+}
+
+func info(s *session, args []string) error {
+	id, err := numericArg(flag.NewFlagSet("info", flag.ExitOnError), args, "info ID", "file")
+	if err != nil {
+		return err
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	f, err := c.FileInfo(id)
+	if err != nil {
+		return fmt.Errorf("failed to FileInfo: %w", err)
+	}
+
+	fmt.Printf("ID:\t\t%d\nName:\t\t%s\nType:\t\t%s\nMIME type:\t%s\nLevel:\t\t%d\nDepartment:\t%d\nPolicy:\t\tlevel %d\nSize:\t\t%d bytes\nUploader:\t%d\nCID:\t\t%s\nCreated:\t%s\n",
+		f.ID, f.Name, f.Type, f.MimeType, f.Level, f.Department, f.Policy, f.Size, f.UserID, f.IpfsKey, f.CreatedAt.Format(time.RFC3339))
+
+	return nil
+}
+
+func download(s *session, args []string) error {
+	set := flag.NewFlagSet("download", flag.ExitOnError)
+	dst := set.String("o", ".", "file or directory to write to")
+	id, err := numericArg(set, args, "download [-o PATH] ID", "file")
+	if err != nil {
+		return err
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	file, data, err := c.Download(id)
+	if err != nil {
+		return fmt.Errorf("failed to Download: %w", err)
+	}
+
+	// file names come from other users, only their base is used
+	path := *dst
+	if st, err := os.Stat(path); err == nil && st.IsDir() {
+		path = filepath.Join(path, filepath.Base(filepath.Clean("/"+file.Name)))
+	}
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to WriteFile: %w", err)
+	}
+	fmt.Printf("file %d written to %s, %d bytes\n", file.ID, path, len(data))
+
+	return nil
+}
+
+func remove(s *session, args []string) error {
+	id, err := numericArg(flag.NewFlagSet("rm", flag.ExitOnError), args, "rm ID", "file")
+	if err != nil {
+		return err
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	if err = c.DeleteFile(id); err != nil {
+		return fmt.Errorf("failed to DeleteFile: %w", err)
+	}
+	fmt.Printf("file %d deleted\n", id)
+
+	return nil
+}
+
+func createBundle(s *session, args []string) error {
+	set := flag.NewFlagSet("bundle", flag.ExitOnError)
+	name := set.String("name", "", "bundle name, the base of DIR by default")
+	level := set.Int("level", 0, "security level of the entries no pattern matches")
+	levels := set.String("levels", "", "entry levels, like annex/=3,*.pdf=2, first matching pattern wins")
+	args, err := parse(set, args, 1, "bundle [-name NAME] [-level N] [-levels annex/=3,*.pdf=2] DIR")
+	if err != nil {
+		return err
+	}
+
+	rules, err := bundle.ParseRules(*levels)
+	if err != nil {
+		return err
+	}
+	entries, err := bundle.ReadDir(args[0], rules, *level)
+	if err != nil {
+		return fmt.Errorf("failed to ReadDir: %w", err)
+	}
+	if *name == "" {
+		*name = filepath.Base(args[0])
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	file, err := c.CreateBundle(*name, entries)
+	if err != nil {
+		return fmt.Errorf("failed to CreateBundle: %w", err)
+	}
+
+	for _, e := range entries {
+		fmt.Printf("%s\tlevel %d\n", e.Path, e.Level)
+	}
+	fmt.Printf("bundle %d shared as %s, level %d\n", file.ID, file.IpfsKey, file.Level)
+
+	return nil
+}
+
+func unbundle(s *session, args []string) error {
+	set := flag.NewFlagSet("unbundle", flag.ExitOnError)
+	dst := set.String("o", ".", "directory to unpack into")
+	id, err := numericArg(set, args, "unbundle [-o DIR] ID", "file")
+	if err != nil {
+		return err
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	b, err := c.OpenBundle(id)
+	if err != nil {
+		return fmt.Errorf("failed to OpenBundle: %w", err)
+	}
+
+	withheld, err := bundle.Unpack(*dst, b.Entries)
+	if err != nil {
+		return fmt.Errorf("failed to Unpack: %w", err)
+	}
+
+	fmt.Printf("unpacked %d of %d entries of %s into %s\n", len(b.Entries)-len(withheld), len(b.Entries), b.File.Name, *dst)
+	for _, e := range withheld {
+		fmt.Printf("withheld: %s\tlevel %d\n", e.Path, e.Level)
+	}
+
+	return nil
+}
+
+// numericArg parses the flags of a command taking a single ID, what names it
+// in the error.
+func numericArg(set *flag.FlagSet, args []string, usage, what string) (int64, error) {
+	args, err := parse(set, args, 1, usage)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s ID %q", what, args[0])
+	}
+
+	return id, nil
+}
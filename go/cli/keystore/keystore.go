@@ -0,0 +1,248 @@
+// Package keystore keeps the secrets of the CLI profiles, the user PK, the
+// admin key and the membership witnesses, in a file encrypted with Kuznechik
+// in GCM mode. The key is derived from a passphrase with PBKDF2 over
+// HMAC-Stribog-256.
+package keystore
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"ipfs-senc/kuznechik"
+	"ipfs-senc/stribog"
+
+	"server/api"
+	"server/logging"
+)
+
+const (
+	version = 1
+	kdf     = "pbkdf2-hmac-stribog256"
+	keySize = 32
+)
+
+// Iterations is the PBKDF2 iteration count of new keystores, existing ones
+// keep the count they were written with. Stribog is slow, 2000 iterations take
+// over a second.
+var Iterations = 2000
+
+var (
+	// ErrPassphrase is returned when the keystore can't be decrypted, because
+	// the passphrase is wrong or the file was changed.
+	ErrPassphrase = errors.New("wrong passphrase or corrupted keystore")
+	// ErrEmptyPassphrase is returned for an empty passphrase.
+	ErrEmptyPassphrase = errors.New("empty passphrase")
+)
+
+// Secrets are the secrets of a profile.
+type Secrets struct {
+	PK       logging.Secret
+	AdminKey logging.Secret
+	Witness  *api.Witness
+}
+
+// stored are the Secrets in the encrypted data, revealed since Secret
+// marshals as logging.Redacted.
+type stored struct {
+	PK       string       `json:",omitempty"`
+	AdminKey string       `json:",omitempty"`
+	Witness  *api.Witness `json:",omitempty"`
+}
+
+// file is the keystore on disk. Everything but Data is authenticated as
+// additional data.
+type file struct {
+	Version    int
+	KDF        string
+	Iterations int
+	Salt       []byte
+	Nonce      []byte
+	Data       []byte
+}
+
+// Keystore holds the decrypted secrets of every profile.
+type Keystore struct {
+	path       string
+	salt       []byte
+	iterations int
+	key        []byte
+	profiles   map[string]Secrets
+}
+
+// Open decrypts the keystore at path. A missing file is an empty keystore
+// that Save creates.
+func Open(path string, passphrase []byte) (*Keystore, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		salt := make([]byte, 16)
+		if _, err = rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to rand.Read: %w", err)
+		}
+		return &Keystore{
+			path:       path,
+			salt:       salt,
+			iterations: Iterations,
+			key:        deriveKey(passphrase, salt, Iterations),
+			profiles:   map[string]Secrets{},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReadFile: %w", err)
+	}
+
+	var f file
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPassphrase, err)
+	}
+	if f.Version != version || f.KDF != kdf || f.Iterations <= 0 {
+		return nil, fmt.Errorf("unsupported keystore version %d, %s", f.Version, f.KDF)
+	}
+
+	k := &Keystore{path: path, salt: f.Salt, iterations: f.Iterations, key: deriveKey(passphrase, f.Salt, f.Iterations)}
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Data, f.additionalData())
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	var profiles map[string]stored
+	if err = json.Unmarshal(plain, &profiles); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPassphrase, err)
+	}
+	k.profiles = make(map[string]Secrets, len(profiles))
+	for name, p := range profiles {
+		k.profiles[name] = Secrets{PK: logging.Secret(p.PK), AdminKey: logging.Secret(p.AdminKey), Witness: p.Witness}
+	}
+
+	return k, nil
+}
+
+// Get returns the secrets of the profile.
+func (k *Keystore) Get(profile string) (Secrets, bool) {
+	s, ok := k.profiles[profile]
+
+	return s, ok
+}
+
+// Set replaces the secrets of the profile.
+func (k *Keystore) Set(profile string, s Secrets) {
+	k.profiles[profile] = s
+}
+
+// Delete removes the secrets of the profile.
+func (k *Keystore) Delete(profile string) {
+	delete(k.profiles, profile)
+}
+
+// Profiles returns the names of the profiles with secrets.
+func (k *Keystore) Profiles() []string {
+	names := make([]string, 0, len(k.profiles))
+	for name := range k.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Save encrypts the secrets with a new nonce and replaces the file, readable
+// by the owner only.
+func (k *Keystore) Save() error {
+	profiles := make(map[string]stored, len(k.profiles))
+	for name, p := range k.profiles {
+		profiles[name] = stored{PK: p.PK.Reveal(), AdminKey: p.AdminKey.Reveal(), Witness: p.Witness}
+	}
+	plain, err := json.Marshal(profiles)
+	if err != nil {
+		return fmt.Errorf("failed to Marshal: %w", err)
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return err
+	}
+
+	f := file{Version: version, KDF: kdf, Iterations: k.iterations, Salt: k.salt, Nonce: make([]byte, aead.NonceSize())}
+	if _, err = rand.Read(f.Nonce); err != nil {
+		return fmt.Errorf("failed to rand.Read: %w", err)
+	}
+	f.Data = aead.Seal(nil, f.Nonce, plain, f.additionalData())
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to Marshal: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(k.path), 0o700); err != nil {
+		return fmt.Errorf("failed to MkdirAll: %w", err)
+	}
+
+	// a failed write must not lose the old keystore
+	tmp := k.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to WriteFile: %w", err)
+	}
+	if err = os.Rename(tmp, k.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to Rename: %w", err)
+	}
+
+	return nil
+}
+
+func (k *Keystore) aead() (cipher.AEAD, error) {
+	block, err := kuznechik.NewCipher(k.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to NewCipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to NewGCM: %w", err)
+	}
+
+	return aead, nil
+}
+
+func (f file) additionalData() []byte {
+	ad := []byte(strconv.Itoa(f.Version) + ":" + f.KDF + ":" + strconv.Itoa(f.Iterations) + ":")
+
+	return append(ad, f.Salt...)
+}
+
+// deriveKey is PBKDF2 (RFC 8018) with HMAC-Stribog-256, whose output is the
+// size of a Kuznechik key, so a single block is computed.
+func deriveKey(passphrase, salt []byte, iterations int) []byte {
+	mac := hmac.New(stribog.New256, passphrase)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	key := make([]byte, keySize)
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+
+	return key
+}
@@ -0,0 +1,79 @@
+package keystore
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"ipfs-senc/stribog"
+
+	"server/api"
+)
+
+func TestKeystore(t *testing.T) {
+	Iterations = 10
+	path := filepath.Join(t.TempDir(), "mmipfs", "keystore")
+
+	k, err := Open(path, []byte("passphrase"))
+	require.NoError(t, err)
+	require.Empty(t, k.Profiles())
+	secrets := Secrets{PK: "user pk", AdminKey: "admin key", Witness: &api.Witness{WitnessLevel: "wl", WitnessDep: "wd"}}
+	k.Set("work", secrets)
+	require.NoError(t, k.Save())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "user pk")
+
+	_, err = Open(path, []byte("wrong"))
+	require.ErrorIs(t, err, ErrPassphrase)
+	_, err = Open(path, nil)
+	require.ErrorIs(t, err, ErrEmptyPassphrase)
+
+	k, err = Open(path, []byte("passphrase"))
+	require.NoError(t, err)
+	got, ok := k.Get("work")
+	require.True(t, ok)
+	require.Equal(t, secrets, got)
+	require.Equal(t, []string{"work"}, k.Profiles())
+
+	// the parameters are authenticated along with the secrets
+	var f file
+	require.NoError(t, json.Unmarshal(data, &f))
+	f.Salt[0] ^= 1
+	data, err = json.Marshal(f)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	_, err = Open(path, []byte("passphrase"))
+	require.ErrorIs(t, err, ErrPassphrase)
+}
+
+func TestDeriveKey(t *testing.T) {
+	passphrase, salt := []byte("passphrase"), []byte("salt")
+
+	// PBKDF2 spelled out with a new HMAC for every iteration
+	prf := func(data []byte) []byte {
+		mac := hmac.New(stribog.New256, passphrase)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	u := prf(append(append([]byte{}, salt...), 0, 0, 0, 1))
+	want := append([]byte{}, u...)
+	for i := 1; i < 5; i++ {
+		u = prf(u)
+		for j := range want {
+			want[j] ^= u[j]
+		}
+	}
+
+	require.Equal(t, want, deriveKey(passphrase, salt, 5))
+	require.NotEqual(t, want, deriveKey(passphrase, salt, 6))
+	require.Len(t, want, keySize)
+}
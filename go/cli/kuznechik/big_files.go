@@ -66,7 +66,6 @@ func readFile(path string) ([]byte, error) {
 
 func bigTextEncode(key, body []byte) ([]byte, error) {
 	batchCount := len(body) / batchSize
-	fmt.Println("KEY: ", key)
 	her, err := NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to NewCipher: %w", err)
@@ -4,67 +4,273 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"ipfs-senc/ipfs/download"
-	"ipfs-senc/ipfs/upload"
+	"io/fs"
 	"os"
+	"strconv"
+
+	"golang.org/x/term"
+
+	"ipfs-senc/client"
+	"ipfs-senc/config"
+	"ipfs-senc/keystore"
+
+	"server/logging"
 )
 
+var Usage = `usage: mmipfs [-profile NAME] [-server URL] COMMAND [ARGS]
+
+The CLI is a client of the server's HTTP API. Files are encrypted and
+decrypted by the server, so they open the same way from the CLI and the bot.
+
+COMMANDS
+    login [-id N] [-admin]          check the credentials and save them in the profile
+    logout                          remove the profile and its secrets
+    profile ls                      list the profiles
+    profile use NAME                make NAME the current profile
+
+    upload [-name NAME] [-level N] FILE
+    download [-o PATH] ID           PATH is a file or a directory, . by default
+    ls [-name S] [-mime T] [-level N] [-sort F] [-order asc|desc] [-page N] [-per-page N]
+    info ID
+    rm ID
+    bundle [-name NAME] [-level N] [-levels annex/=3,*.pdf=2] DIR
+    unbundle [-o DIR] ID            writes the entries your clearance allows
+
+    witness refresh                 fetch fresh witnesses into the keystore
+    prove                           prove membership with the stored witnesses
+
+    admin user ls
+    admin user add -tg NAME -level N -department N
+    admin user set [-level N] [-department N] ID
+    admin user rm ID
+    admin proposals [-status pending|approved|rejected|expired]
+    admin approve ID
+    admin reject ID
+
+PROFILES AND SECRETS
+    Profiles, a server and a user ID each, are kept in the config file. The
+    user PK, the admin key and the witnesses are kept in the keystore,
+    encrypted with Kuznechik under a key derived from your passphrase with
+    Stribog. Secrets are read from the terminal and never printed.
+
+ENVIRONMENT
+    MMIPFS_CONFIG       config file, <user config dir>/mmipfs/config.json by default
+    MMIPFS_KEYSTORE     keystore, <user config dir>/mmipfs/keystore by default
+    MMIPFS_PROFILE      profile to use instead of the current one
+    MMIPFS_PASSPHRASE   keystore passphrase, asked for when unset
+    MMIPFS_SERVER       server API url, overrides the profile
+    MMIPFS_USER_ID      user id, overrides the profile
+    MMIPFS_USER_PK      user pk, overrides the keystore
+    MMIPFS_ADMIN_KEY    admin key, overrides the keystore
+
+OPTIONS
+`
+
 // flags
 var (
-	Use        = flag.String("use", "", "share or download")
-	Link       = flag.String("link", "", "link to file in IPFS")
-	Path       = flag.String("path", "", "path to file")
-	Key        = flag.String("key", "", "an AES encryption key in hex")
-	API        = flag.String("api", "", "override IPFS node API")
-	Encrypt    = flag.Bool("crypto", false, "if true, than it will encrypt your file on upload and decrypt on download")
-	SecureType = flag.Int("secure_type", 0, "security level")
-	Department = flag.Int("department", 0, "your department")
+	Profile = flag.String("profile", "", "profile to use, the current one by default")
+	Server  = flag.String("server", "", "server API url, overrides the profile")
 )
 
-var Usage = `ENCRYPT AND SEND
-    # encrypt with a randomly generated key. will be printed out.
-    go share <local-source-path>
+// command runs with the arguments following its name.
+type command func(s *session, args []string) error
 
+var commands = map[string]command{
+	"login":    login,
+	"logout":   logout,
+	"profile":  profile,
+	"upload":   upload,
+	"download": download,
+	"ls":       list,
+	"info":     info,
+	"rm":       remove,
+	"bundle":   createBundle,
+	"unbundle": unbundle,
+	"witness":  witness,
+	"prove":    prove,
+	"admin":    admin,
+}
 
-GET AND DECRYPT
-    # will ask for key
-    go download <ipfs-link> <local-destination-path>
+// session resolves the profile, the server and the credentials of a command.
+type session struct {
+	cfg  *config.Config
+	name string
+	keys *keystore.Keystore
+}
 
-    # decrypt with given key.
-    go --key <secret-key> download <ipfs-link> <local-destination-path>
+func (s *session) profile() config.Profile {
+	return s.cfg.Profiles[s.name]
+}
 
-OPTIONS
-	--use					 share or download
-    --link					 link to file in IPFS
-	--path         			 path to file
-    --h, --help              show usage
-    --key <secret-key>       a 256bit secret key, encoded with multibase, ONLY in download required
-    --api <ipfs-api-url>     an ipfs node api to use (overrides defaults)
-	--crypto                 if true, than it will encrypt your file on upload and decrypt on download
-	--secure_type            allowed security levels:
-							 	1) 0 - spacial importance
-								2) 1 - absolutely secretly
-								3) 2 - secretly
-	--department			number of your department
-`
+// server returns the server of the profile overridden by the environment and
+// the -server flag.
+func (s *session) server() string {
+	server := s.profile().Server
+	if v := os.Getenv(config.EnvServer); v != "" {
+		server = v
+	}
+	if *Server != "" {
+		server = *Server
+	}
+
+	return server
+}
+
+// userID returns the user of the profile overridden by the environment.
+func (s *session) userID() (int, error) {
+	v := os.Getenv(config.EnvUserID)
+	if v == "" {
+		return s.profile().UserID, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", config.EnvUserID, v)
+	}
+
+	return id, nil
+}
+
+// keystore opens the keystore, asking for the passphrase the first time. A
+// missing keystore is created only when create is set.
+func (s *session) keystore(create bool) (*keystore.Keystore, error) {
+	if s.keys != nil {
+		return s.keys, nil
+	}
+
+	path, err := config.KeystorePath()
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(path)
+	exists := !errors.Is(err, fs.ErrNotExist)
+	if !exists && !create {
+		return nil, errors.New("there is no keystore, run login first")
+	}
+
+	passphrase := []byte(os.Getenv(config.EnvPassphrase))
+	if len(passphrase) == 0 {
+		if passphrase, err = readSecret("Keystore passphrase"); err != nil {
+			return nil, err
+		}
+		if !exists {
+			again, err := readSecret("Repeat the passphrase")
+			if err != nil {
+				return nil, err
+			}
+			if string(again) != string(passphrase) {
+				return nil, errors.New("the passphrases don't match")
+			}
+		}
+	}
+
+	if s.keys, err = keystore.Open(path, passphrase); err != nil {
+		return nil, fmt.Errorf("failed to open the keystore: %w", err)
+	}
+
+	return s.keys, nil
+}
+
+// client returns a client of the profile. user and admin tell the credentials
+// the command needs, secrets missing in the environment are read from the
+// keystore.
+func (s *session) client(user, admin bool) (*client.Client, error) {
+	cfg := client.Config{Server: s.server(), PK: secretEnv(config.EnvUserPK), AdminKey: secretEnv(config.EnvAdminKey)}
+	var err error
+	if cfg.UserID, err = s.userID(); err != nil {
+		return nil, err
+	}
+
+	if (user && cfg.PK == "") || (admin && cfg.AdminKey == "") {
+		keys, err := s.keystore(false)
+		if err != nil {
+			return nil, err
+		}
+		secrets, _ := keys.Get(s.name)
+		if cfg.PK == "" {
+			cfg.PK = secrets.PK
+		}
+		if cfg.AdminKey == "" {
+			cfg.AdminKey = secrets.AdminKey
+		}
+	}
+
+	if user && (cfg.UserID == 0 || cfg.PK == "") {
+		return nil, fmt.Errorf("profile %q has no user credentials, run login", s.name)
+	}
+	if admin && cfg.AdminKey == "" {
+		return nil, fmt.Errorf("profile %q has no admin key, run login -admin", s.name)
+	}
+
+	return client.New(cfg)
+}
+
+// secretEnv returns the secret in the environment variable.
+func secretEnv(name string) logging.Secret {
+	return logging.Secret(os.Getenv(name))
+}
+
+// readSecret reads a line from the terminal without echoing it.
+func readSecret(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%s: stdin is not a terminal, set it in the environment", prompt)
+	}
+
+	fmt.Fprint(os.Stderr, prompt+": ")
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReadPassword: %w", err)
+	}
+
+	return secret, nil
+}
+
+// parse parses the flags of a command, which come before its arguments, and
+// checks the number of arguments.
+func parse(set *flag.FlagSet, args []string, n int, usage string) ([]string, error) {
+	set.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: mmipfs %s\n", usage)
+		set.PrintDefaults()
+	}
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+	if set.NArg() != n {
+		set.Usage()
+		return nil, fmt.Errorf("usage: mmipfs %s", usage)
+	}
+
+	return set.Args(), nil
+}
 
 func errMain() error {
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		return errors.New("no command")
+	}
+	run, ok := commands[args[0]]
+	if !ok {
+		return errors.New("Unknown command: " + args[0])
+	}
 
-	switch *Use {
-	case "download":
-		return download.Download(*Link, *Path, *Key, *API, *Encrypt, *SecureType)
-	case "share":
-		return upload.Upload(*Key, *API, *Path, *Encrypt, *Department, *SecureType)
-	default:
-		return errors.New("Unknown command: " + *Use)
+	path, err := config.Path()
+	if err != nil {
+		return err
 	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return fmt.Errorf("failed to Load config: %w", err)
+	}
+
+	return run(&session{cfg: cfg, name: cfg.Name(*Profile)}, args[1:])
 }
 
-// ipfs daemon
-// go --key D44DHB54VE62PMID4JLG6WYZWTPKUJFO3Q2NJOOTKMUGKLX5B57A==== download /ipfs/Qme4rKqR3iDUa9iEx9iyYRTFhY4X1skXQFGSJdTGFQw9Zx
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, Usage)
+		fmt.Fprint(os.Stderr, Usage)
+		flag.PrintDefaults()
 	}
 
 	flag.Parse()
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"ipfs-senc/client"
+	"ipfs-senc/config"
+
+	"server/logging"
+)
+
+// login checks the credentials with the server and saves them in the profile,
+// the secrets in the keystore. The secrets are read from the environment or
+// the terminal, never from flags, which end up in the shell history.
+func login(s *session, args []string) error {
+	set := flag.NewFlagSet("login", flag.ExitOnError)
+	id := set.Int("id", 0, "your user id, the profile's by default")
+	asAdmin := set.Bool("admin", false, "log in with an admin key as well")
+	if _, err := parse(set, args, 0, "login [-id N] [-admin]"); err != nil {
+		return err
+	}
+
+	p := s.profile()
+	p.Server = s.server()
+	userID, err := s.userID()
+	if err != nil {
+		return err
+	}
+	if *id != 0 {
+		userID = *id
+	}
+	if userID == 0 && !*asAdmin {
+		return errors.New("requires -id or -admin")
+	}
+	p.UserID = userID
+
+	cfg := client.Config{Server: p.Server, UserID: userID, PK: secretEnv(config.EnvUserPK), AdminKey: secretEnv(config.EnvAdminKey)}
+	if userID != 0 && cfg.PK == "" {
+		pk, err := readSecret("User PK")
+		if err != nil {
+			return err
+		}
+		cfg.PK = logging.Secret(pk)
+	}
+	if *asAdmin && cfg.AdminKey == "" {
+		key, err := readSecret("Admin key")
+		if err != nil {
+			return err
+		}
+		cfg.AdminKey = logging.Secret(key)
+	}
+
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+	p.Server = cfg.Server
+	if userID != 0 {
+		user, err := c.Me()
+		if err != nil {
+			return fmt.Errorf("failed to log in as user %d: %w", userID, err)
+		}
+		fmt.Printf("user %d (%s), level %d, department %d\n", user.ID, user.TgName, user.Level, user.Department)
+	}
+	if *asAdmin {
+		admin, err := c.AdminMe()
+		if err != nil {
+			return fmt.Errorf("failed to log in as admin: %w", err)
+		}
+		fmt.Printf("admin %d (%s), role %s, department %d\n", admin.ID, admin.Name, admin.Role, admin.Department)
+	}
+
+	keys, err := s.keystore(true)
+	if err != nil {
+		return err
+	}
+	secrets, _ := keys.Get(s.name)
+	if secrets.PK != cfg.PK || s.profile().UserID != userID {
+		// the witnesses belong to the old user
+		secrets.Witness = nil
+	}
+	secrets.PK = cfg.PK
+	if *asAdmin {
+		secrets.AdminKey = cfg.AdminKey
+	}
+	keys.Set(s.name, secrets)
+	if err = keys.Save(); err != nil {
+		return fmt.Errorf("failed to Save keystore: %w", err)
+	}
+
+	s.cfg.Profiles[s.name] = p
+	if s.cfg.Current == "" {
+		s.cfg.Current = s.name
+	}
+	if err = s.cfg.Save(); err != nil {
+		return fmt.Errorf("failed to Save config: %w", err)
+	}
+	fmt.Printf("logged in to %s as profile %s\n", p.Server, s.name)
+
+	return nil
+}
+
+// logout removes the profile and its secrets.
+func logout(s *session, args []string) error {
+	if _, err := parse(flag.NewFlagSet("logout", flag.ExitOnError), args, 0, "logout"); err != nil {
+		return err
+	}
+
+	keys, err := s.keystore(false)
+	if err != nil {
+		return err
+	}
+	keys.Delete(s.name)
+	if err = keys.Save(); err != nil {
+		return fmt.Errorf("failed to Save keystore: %w", err)
+	}
+
+	delete(s.cfg.Profiles, s.name)
+	if s.cfg.Current == s.name {
+		s.cfg.Current = ""
+	}
+	if err = s.cfg.Save(); err != nil {
+		return fmt.Errorf("failed to Save config: %w", err)
+	}
+	fmt.Printf("profile %s removed\n", s.name)
+
+	return nil
+}
+
+// profile lists the profiles or switches the current one.
+func profile(s *session, args []string) error {
+	if len(args) == 2 && args[0] == "use" {
+		if _, ok := s.cfg.Profiles[args[1]]; !ok {
+			return fmt.Errorf("no profile %q, log in with -profile %s", args[1], args[1])
+		}
+		s.cfg.Current = args[1]
+		if err := s.cfg.Save(); err != nil {
+			return fmt.Errorf("failed to Save config: %w", err)
+		}
+		fmt.Printf("using profile %s\n", args[1])
+		return nil
+	}
+	if len(args) != 1 || args[0] != "ls" {
+		return errors.New("usage: mmipfs profile ls | profile use NAME")
+	}
+
+	current := s.cfg.Name("")
+	for _, name := range s.cfg.Names() {
+		mark := " "
+		if name == current {
+			mark = "*"
+		}
+		p := s.cfg.Profiles[name]
+		fmt.Printf("%s %s\t%s\tuser %d\n", mark, name, p.Server, p.UserID)
+	}
+
+	return nil
+}
+
+// witness refreshes the user's witnesses, which go stale whenever a member
+// joins or leaves, and stores them in the keystore.
+func witness(s *session, args []string) error {
+	if len(args) != 1 || args[0] != "refresh" {
+		return errors.New("usage: mmipfs witness refresh")
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	w, err := c.RefreshWitness()
+	if err != nil {
+		return fmt.Errorf("failed to RefreshWitness: %w", err)
+	}
+
+	keys, err := s.keystore(false)
+	if err != nil {
+		return err
+	}
+	secrets, _ := keys.Get(s.name)
+	secrets.Witness = &w
+	keys.Set(s.name, secrets)
+	if err = keys.Save(); err != nil {
+		return fmt.Errorf("failed to Save keystore: %w", err)
+	}
+	fmt.Printf("witnesses of profile %s refreshed and stored in the keystore\n", s.name)
+
+	return nil
+}
+
+// prove presents the stored witnesses to the server.
+func prove(s *session, args []string) error {
+	if _, err := parse(flag.NewFlagSet("prove", flag.ExitOnError), args, 0, "prove"); err != nil {
+		return err
+	}
+
+	c, err := s.client(true, false)
+	if err != nil {
+		return err
+	}
+	keys, err := s.keystore(false)
+	if err != nil {
+		return err
+	}
+	secrets, _ := keys.Get(s.name)
+	if secrets.Witness == nil {
+		return errors.New("no witnesses in the keystore, run witness refresh")
+	}
+
+	user, err := c.Prove(*secrets.Witness)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.Code == "witness_invalid" {
+		return fmt.Errorf("%w, run witness refresh if members joined or left since", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to Prove: %w", err)
+	}
+	fmt.Printf("membership proven: user %d, level %d, department %d\n", user.ID, user.Level, user.Department)
+
+	return nil
+}
@@ -45,6 +45,8 @@ func (d *digest) Reset() {
 		d.sigma[i] = 0x00
 		d.h[i] = initVal
 	}
+	// a partial block written before the reset must not be hashed
+	d.nx = 0
 }
 
 func (d *digest) BlockSize() int {
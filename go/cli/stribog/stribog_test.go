@@ -0,0 +1,28 @@
+package stribog
+
+import (
+	"crypto/hmac"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReset(t *testing.T) {
+	d := New256()
+	d.Write([]byte("partial block"))
+	d.Reset()
+	d.Write([]byte("abc"))
+	fresh := New256()
+	fresh.Write([]byte("abc"))
+	require.Equal(t, fresh.Sum(nil), d.Sum(nil))
+
+	// hmac resets the inner digest between messages
+	mac := hmac.New(New256, []byte("key"))
+	mac.Write([]byte("first"))
+	mac.Sum(nil)
+	mac.Reset()
+	mac.Write([]byte("abc"))
+	freshMAC := hmac.New(New256, []byte("key"))
+	freshMAC.Write([]byte("abc"))
+	require.Equal(t, freshMAC.Sum(nil), mac.Sum(nil))
+}
@@ -126,6 +126,11 @@ func (mw *MembershipWitness) MultiBatchUpdate(A [][]Element, D [][]Element, C []
 	return mw, nil
 }
 
+// GetElement returns the element y the witness is for
+func (mw MembershipWitness) GetElement() Element {
+	return mw.y
+}
+
 // MarshalBinary converts a membership witness to bytes
 func (mw MembershipWitness) MarshalBinary() ([]byte, error) {
 	if mw.c == nil || mw.y == nil {
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/security"
+	"server/storage"
+	"server/stribog"
+)
+
+const adminHeader = "X-Admin-Key"
+
+// requireAdmin authenticates the caller by the X-Admin-Key header against the
+// admin accounts stored in the database.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.Request().Header.Get(adminHeader)
+		if token == "" {
+			return echo.NewHTTPError(http.StatusForbidden, "incorrect admin header")
+		}
+
+		admin, err := storage.GetAdminByToken(db.DB, hashAdminToken(token))
+		if errors.Is(err, pgx.ErrNoRows) {
+			zap.L().Warn("unknown admin token", zap.String("uri", c.Request().RequestURI))
+			return echo.NewHTTPError(http.StatusForbidden, "incorrect admin header")
+		} else if err != nil {
+			zap.L().Error("failed to GetAdminByToken", zap.Error(err))
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		l := zap.L().With(zap.Int("admin_id", admin.ID), zap.String("admin", admin.Name), zap.String("role", admin.Role))
+		c.Set("admin", &admin)
+		c.Set("logger", l)
+
+		return next(c)
+	}
+}
+
+// auditRoles may read the audit trail.
+var auditRoles = []string{storage.RoleChief, storage.RoleAuditor}
+
+// hasRole reports whether the admin's role is in roles.
+func hasRole(admin *storage.Admin, roles ...string) error {
+	for _, role := range roles {
+		if admin.Role == role {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("not allowed for role %s", admin.Role)
+}
+
+// requireRole rejects admins whose role is not in roles.
+func requireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			admin := c.Get("admin").(*storage.Admin)
+			if err := hasRole(admin, roles...); err != nil {
+				c.Get("logger").(*zap.Logger).Warn("role not allowed", zap.String("uri", c.Request().RequestURI))
+				return echo.NewHTTPError(http.StatusForbidden, err.Error())
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// canManage reports whether the admin may enroll or revoke a user with the
+// given clearance level in the given department.
+func canManage(admin *storage.Admin, level, department int) error {
+	switch admin.Role {
+	case storage.RoleChief:
+		return nil
+	case storage.RoleDepartment:
+		if department != admin.Department {
+			return fmt.Errorf("department %d is out of scope", department)
+		}
+		if level >= security.MaxLevel {
+			return fmt.Errorf("level %d can be granted only by a chief officer", level)
+		}
+		return nil
+	default:
+		return fmt.Errorf("role %s is read-only", admin.Role)
+	}
+}
+
+// canView reports whether the admin may read users of the given department.
+func canView(admin *storage.Admin, department int) error {
+	if admin.Role == storage.RoleDepartment && department != admin.Department {
+		return fmt.Errorf("department %d is out of scope", department)
+	}
+
+	return nil
+}
+
+func hashAdminToken(token string) string {
+	h := stribog.New512()
+	h.Write([]byte(token))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func newAdminToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to rand.Read: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Handler
+//
+// getAdminMe returns the calling admin, clients use it to check their key.
+func getAdminMe(c echo.Context) error {
+	return c.JSON(http.StatusOK, apiAdmin(*c.Get("admin").(*storage.Admin)))
+}
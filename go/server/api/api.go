@@ -0,0 +1,197 @@
+// Package api holds the request and response types of the HTTP API. It is
+// shared by the server and its clients and depends on the standard library
+// only.
+package api
+
+import "time"
+
+// File is the metadata of a stored file. Type is empty for plain files and
+// "bundle" for bundles.
+type File struct {
+	ID         int64
+	Name       string
+	IpfsKey    string
+	UserID     int
+	MimeType   string
+	Type       string
+	Level      int
+	Department int
+	// Policy is the level whose delivery policy applies to the file.
+	Policy    int
+	Size      int64
+	CreatedAt time.Time
+}
+
+// User is a user along with their clearance.
+type User struct {
+	ID     int
+	TgName string
+	// TgID is the Telegram user ID bound with an enrollment code, 0 until then.
+	TgID int64
+	// PK is returned to the user only, by GET /me and POST /prove, never by
+	// the admin API.
+	PK         string
+	Department int
+	Level      int
+	Status     string
+}
+
+// Admin is an administrator. The token is only ever returned on creation, in
+// ResponseAdmin.
+type Admin struct {
+	ID         int
+	Name       string
+	Role       string
+	Department int
+	TgID       int64
+}
+
+// Proposal is a change waiting for the approval of a second admin.
+type Proposal struct {
+	ID         int
+	Action     string
+	User       User
+	ProposedBy int
+	DecidedBy  int
+	Status     string
+	Result     string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	DecidedAt  time.Time
+}
+
+// ResponseApproval is an executed proposal, whose Result is the ID of the user
+// changed. Enrollment holds the code issued by an approved enrollment or
+// rebind, returned to the approver only.
+type ResponseApproval struct {
+	Proposal
+	Enrollment *Enrollment `json:"Enrollment,omitempty"`
+}
+
+// Enrollment is a user along with the one-time code binding their Telegram
+// account.
+type Enrollment struct {
+	User
+	Code          string
+	CodeExpiresAt time.Time
+}
+
+type RequestAdd struct {
+	Level      int    `json:"Level"`
+	Department int    `json:"Department"`
+	ID         int    `json:"ID"`
+	PK         string `json:"PK"`
+	TgName     string `json:"TgName"`
+}
+
+type RequestUpdate struct {
+	Level      *int `json:"Level"`
+	Department *int `json:"Department"`
+}
+
+type RequestAdmin struct {
+	Name       string `json:"Name" validate:"required"`
+	Role       string `json:"Role" validate:"required,oneof=chief department auditor"`
+	Department int    `json:"Department"`
+}
+
+type ResponseAdmin struct {
+	Admin Admin  `json:"Admin"`
+	Token string `json:"Token"`
+}
+
+type ResponseCode struct {
+	Code      string    `json:"Code"`
+	ExpiresAt time.Time `json:"ExpiresAt"`
+}
+
+type RequestChannel struct {
+	Kind    string `json:"Kind" validate:"required,oneof=telegram email webhook"`
+	Address string `json:"Address"`
+}
+
+type RequestFile struct {
+	Level      int    `json:"Level" validate:"required"`
+	Department int    `json:"Department" validate:"required"`
+	ID         int    `json:"ID" validate:"required"`
+	PK         string `json:"PK"`
+	File       string `json:"File" validate:"required"`
+	Name       string `json:"Name"`
+	MimeType   string `json:"MimeType"`
+}
+
+type ResponseFile struct {
+	File string `json:"File" validate:"required"`
+}
+
+// RequestUpload is a file uploaded by an authenticated user. It is encrypted
+// within the user's department. Data is base64.
+type RequestUpload struct {
+	Name     string `json:"Name" validate:"required"`
+	MimeType string `json:"MimeType"`
+	Level    int    `json:"Level"`
+	Data     string `json:"Data"`
+}
+
+// ResponseContent is a decrypted file. Data is base64.
+type ResponseContent struct {
+	File File   `json:"File"`
+	Data string `json:"Data"`
+}
+
+// Witness is the user's membership witnesses of the level and department
+// accumulators, base64. They are refreshed with POST /witness and presented to
+// POST /prove.
+type Witness struct {
+	WitnessLevel string `json:"WitnessLevel" validate:"required"`
+	WitnessDep   string `json:"WitnessDep" validate:"required"`
+}
+
+// RequestBundle lists the entries of a new bundle. Data is base64.
+type RequestBundle struct {
+	Name    string               `json:"Name" validate:"required"`
+	Entries []RequestBundleEntry `json:"Entries" validate:"required,dive"`
+}
+
+type RequestBundleEntry struct {
+	Path     string `json:"Path" validate:"required"`
+	Level    int    `json:"Level"`
+	MimeType string `json:"MimeType"`
+	Data     string `json:"Data"`
+}
+
+type ResponseBundle struct {
+	File    File                  `json:"File"`
+	Entries []ResponseBundleEntry `json:"Entries"`
+}
+
+// ResponseBundleEntry is an entry of an opened bundle. Withheld entries have
+// no Data.
+type ResponseBundleEntry struct {
+	Path     string `json:"Path"`
+	Level    int    `json:"Level"`
+	MimeType string `json:"MimeType"`
+	Size     int64  `json:"Size"`
+	Data     string `json:"Data,omitempty"`
+	Withheld bool   `json:"Withheld"`
+}
+
+type ResponseFiles struct {
+	Files   []File `json:"Files"`
+	Total   int    `json:"Total"`
+	Page    int    `json:"Page"`
+	PerPage int    `json:"PerPage"`
+}
+
+// ResponseError is the body of every error response. Code is stable, Message
+// is for people.
+type ResponseError struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+// ResponseHealth reports the status of the server and of each dependency.
+type ResponseHealth struct {
+	Status string            `json:"Status"`
+	Checks map[string]string `json:"Checks,omitempty"`
+}
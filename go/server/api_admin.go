@@ -4,15 +4,18 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
 
+	"server/api"
 	"server/security"
 	"server/storage"
 )
 
 func add(c echo.Context) error {
-	var req RequestAdd
+	var req api.RequestAdd
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -20,34 +23,31 @@ func add(c echo.Context) error {
 		return err
 	}
 
-	user, err := storage.AddUser(db.DB, req.TgName, req.Level, req.Department)
-	if err != nil {
-		return fmt.Errorf("failed to AddUser: %w", err)
+	admin := c.Get("admin").(*storage.Admin)
+	l := c.Get("logger").(*zap.Logger).With(zap.String("tg_name", req.TgName), zap.Int("level", req.Level), zap.Int("department", req.Department))
+	if err := canManage(admin, req.Level, req.Department); err != nil {
+		l.Warn("add denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
 	}
 
-	data, err := base64.StdEncoding.DecodeString(user.PK)
+	e, p, err := requestEnrollment(admin, req.TgName, req.Level, req.Department)
 	if err != nil {
-		c.Logger().Errorf("failed to DecodeString data: %s", err.Error())
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		l.Error("failed to requestEnrollment", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to enroll user")
 	}
-
-	witLevel, witDep, err := security.Add(req.Level, req.Department, data)
-	if err != nil {
-		c.Logger().Errorf("failed to Add: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err)
+	if p != nil {
+		l.Info("enrollment proposed", zap.Int("proposal_id", p.ID))
+		return c.JSON(http.StatusAccepted, p)
 	}
 
-	if err := storage.SetWitness(db.DB, storage.Witness{ID: user.PK, WitnessLevel: base64.StdEncoding.EncodeToString(witLevel), WitnessDep: base64.StdEncoding.EncodeToString(witDep)}); err != nil {
-		c.Logger().Errorf("failed to SetWitness level: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err)
-	}
+	l.Info("user enrolled", zap.Int("user_id", e.ID))
 
-	return c.JSON(http.StatusOK, user)
+	return c.JSON(http.StatusOK, e)
 }
 
 // Handler
 func check(c echo.Context) error {
-	var req RequestAdd
+	var req api.RequestAdd
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -56,39 +56,96 @@ func check(c echo.Context) error {
 		return err
 	}
 
+	user, l, err := loadUserByID(c, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// the stored department decides, not the one in the request
+	admin := c.Get("admin").(*storage.Admin)
+	if err := canView(admin, user.Department); err != nil {
+		l.Warn("check denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
 	if err := storage.CheckUserPK(db.DB, req.ID, req.PK); err != nil {
 		return fmt.Errorf("failed to CheckUserPK: %w", err)
 	}
 
 	accum, err := storage.GetWitness(db.DB, req.PK)
 	if err != nil {
-		c.Logger().Errorf("failed to GetWitness: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err)
+		return fmt.Errorf("failed to GetWitness: %w", err)
 	}
 
 	witLevel, err := base64.StdEncoding.DecodeString(accum.WitnessLevel)
 	if err != nil {
-		c.Logger().Errorf("failed to DecodeString wit level: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err)
+		return fmt.Errorf("failed to DecodeString wit level: %w", err)
 	}
 
 	witDep, err := base64.StdEncoding.DecodeString(accum.WitnessDep)
 	if err != nil {
-		c.Logger().Errorf("failed to DecodeString wit dep: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err)
+		return fmt.Errorf("failed to DecodeString wit dep: %w", err)
 	}
 
-	if err = security.Check(req.Level, req.Department, witLevel, witDep); err != nil {
-		c.Logger().Errorf("failed to Delete: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err)
+	data, err := base64.StdEncoding.DecodeString(req.PK)
+	if err != nil {
+		return fmt.Errorf("failed to DecodeString data: %w", err)
 	}
 
+	if err = security.Check(req.Level, req.Department, data, witLevel, witDep); err != nil {
+		l.Warn("failed to Check", zap.Error(err))
+		return fmt.Errorf("failed to Check: %w", err)
+	}
+
+	l.Info("user checked")
+
 	return c.String(http.StatusOK, http.StatusText(http.StatusOK))
 }
 
 // Handler
-func delete(c echo.Context) error { // TODO add delete from DB
-	var req RequestAdd
+func delete(c echo.Context) error {
+	var (
+		user storage.User
+		l    *zap.Logger
+		err  error
+	)
+	if c.Param("id") != "" {
+		user, l, err = loadUser(c)
+	} else {
+		var req api.RequestAdd
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := c.Validate(&req); err != nil {
+			return err
+		}
+		user, l, err = loadUserByID(c, req.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	admin := c.Get("admin").(*storage.Admin)
+	if err := canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("delete denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	// revocation always needs a second admin
+	p, err := propose(admin, storage.ProposalDelete, user)
+	if err != nil {
+		l.Error("failed to propose", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create proposal")
+	}
+
+	l.Info("deletion proposed", zap.Int("proposal_id", p.ID))
+
+	return c.JSON(http.StatusAccepted, p)
+}
+
+// Handler
+func getAll(c echo.Context) error {
+	var req api.RequestAdd
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -97,26 +154,74 @@ func delete(c echo.Context) error { // TODO add delete from DB
 		return err
 	}
 
-	if err := storage.DeleteUser(db.DB, req.ID, req.TgName, req.PK); err != nil {
-		return fmt.Errorf("failed to DeleteUser: %w", err)
+	admin := c.Get("admin").(*storage.Admin)
+	l := c.Get("logger").(*zap.Logger)
+
+	var (
+		usrs []storage.User
+		err  error
+	)
+	if admin.Role == storage.RoleDepartment {
+		usrs, err = storage.GetAllByDepartment(db.DB, admin.Department)
+	} else {
+		usrs, err = storage.GetAll(db.DB)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to GetAll: %w", err)
+	}
+
+	l.Info("users listed", zap.Int("count", len(usrs)))
+
+	return c.JSON(http.StatusOK, usrs)
+}
+
+// Handler
+func addAdmin(c echo.Context) error {
+	var req api.RequestAdmin
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	l := c.Get("logger").(*zap.Logger).With(zap.String("new_admin", req.Name), zap.String("new_role", req.Role))
 
-	if err := storage.DeleteWitness(db.DB, req.PK); err != nil {
-		return fmt.Errorf("failed to DeleteWitness: %w", err)
+	token, err := newAdminToken()
+	if err != nil {
+		return fmt.Errorf("failed to newAdminToken: %w", err)
 	}
 
-	err := security.Delete(req.Level, req.Department, []byte(req.PK))
+	admin, err := storage.AddAdmin(db.DB, storage.Admin{
+		Name:       req.Name,
+		TokenHash:  hashAdminToken(token),
+		Role:       req.Role,
+		Department: req.Department,
+	})
 	if err != nil {
-		c.Logger().Errorf("failed to Delete: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err)
+		l.Error("failed to AddAdmin", zap.Error(err))
+		return echo.NewHTTPError(http.StatusConflict, "admin already exists")
 	}
 
-	return c.String(http.StatusOK, http.StatusText(http.StatusOK))
+	l.Info("admin created", zap.Int("new_admin_id", admin.ID))
+
+	return c.JSON(http.StatusOK, api.ResponseAdmin{Admin: apiAdmin(admin), Token: token})
 }
 
 // Handler
-func getAll(c echo.Context) error {
-	var req RequestAdd
+func getAdmins(c echo.Context) error {
+	admins, err := storage.GetAllAdmins(db.DB)
+	if err != nil {
+		return fmt.Errorf("failed to GetAllAdmins: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, admins)
+}
+
+// Handler
+func updateUser(c echo.Context) error {
+	var req api.RequestUpdate
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -125,10 +230,97 @@ func getAll(c echo.Context) error {
 		return err
 	}
 
-	usrs, err := storage.GetAll(db.DB)
+	admin := c.Get("admin").(*storage.Admin)
+	user, l, err := loadUser(c)
 	if err != nil {
-		return fmt.Errorf("failed to GetAll: %w", err)
+		return err
 	}
 
-	return c.JSON(http.StatusOK, usrs)
+	level, department := user.Level, user.Department
+	if req.Level != nil {
+		level = *req.Level
+	}
+	if req.Department != nil {
+		department = *req.Department
+	}
+	if level < 0 || level > security.MaxLevel {
+		return echo.NewHTTPError(http.StatusBadRequest, "unsupported level")
+	}
+
+	l = l.With(zap.Int("new_level", level), zap.Int("new_department", department))
+	if err := canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("update denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err := canManage(admin, level, department); err != nil {
+		l.Warn("update denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	user, p, err := requestClearance(admin, user, level, department)
+	if err != nil {
+		l.Error("failed to requestClearance", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user")
+	}
+	if p != nil {
+		l.Info("clearance change proposed", zap.Int("proposal_id", p.ID))
+		return c.JSON(http.StatusAccepted, p)
+	}
+
+	l.Info("user clearance changed")
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// Handler
+func suspendUser(c echo.Context) error {
+	return changeUserStatus(c, storage.UserSuspended)
+}
+
+// Handler
+func reinstateUser(c echo.Context) error {
+	return changeUserStatus(c, storage.UserActive)
+}
+
+func changeUserStatus(c echo.Context, status string) error {
+	admin := c.Get("admin").(*storage.Admin)
+	user, l, err := loadUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("status change denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	user, err = setUserStatus(user.ID, status)
+	if err != nil {
+		l.Error("failed to setUserStatus", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to change user status")
+	}
+
+	l.Info("user status changed", zap.String("status", status))
+
+	return c.JSON(http.StatusOK, user)
+}
+
+func loadUser(c echo.Context) (storage.User, *zap.Logger, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return storage.User{}, nil, echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	return loadUserByID(c, id)
+}
+
+func loadUserByID(c echo.Context, id int) (storage.User, *zap.Logger, error) {
+	user, err := storage.GetUserByID(db.DB, id)
+	if err != nil {
+		return storage.User{}, nil, fmt.Errorf("failed to GetUserByID: %w", err)
+	}
+
+	l := c.Get("logger").(*zap.Logger).With(zap.Int("user_id", user.ID), zap.Int("level", user.Level), zap.Int("department", user.Department))
+
+	return user, l, nil
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/api"
+	"server/audit"
+	"server/bundle"
+	"server/storage"
+)
+
+// errBundle is returned when a bundle is downloaded as a single file.
+var errBundle = errors.New("file is a bundle")
+
+// Handler
+//
+// createBundle encrypts every entry at its own level within the user's
+// department. The bundle is listed as a file at the lowest level of its
+// entries, everyone who can open one of them can see it.
+func createBundle(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	var req api.RequestBundle
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	files := make([]bundle.File, len(req.Entries))
+	var size int64
+	for i, e := range req.Entries {
+		data, err := base64.StdEncoding.DecodeString(e.Data)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid data of entry %q", e.Path))
+		}
+		files[i] = bundle.File{Path: e.Path, Level: e.Level, MimeType: e.MimeType, Data: data}
+		size += int64(len(data))
+	}
+
+	event := audit.Event{Channel: audit.ChannelHTTP, UserID: user.ID, Action: audit.ActionEncrypt}
+	seal := func(ctx context.Context, level int, data []byte) (string, error) {
+		cipher, auth, err := enc(*user, level, string(data))
+		if err != nil {
+			return "", fmt.Errorf("failed to enc: %w", err)
+		}
+		return storeCipher(ctx, cipher, auth)
+	}
+	root, links, m, err := bundle.Create(c.Request().Context(), blobs, seal, files)
+	if errors.Is(err, bundle.ErrInvalidBundle) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		recordAudit(event, err)
+		return fmt.Errorf("failed to Create: %w", err)
+	}
+	if err = recordPin(root); err != nil {
+		event.CID = root
+		recordAudit(event, err)
+		return fmt.Errorf("failed to recordPin: %w", err)
+	}
+
+	file, err := storage.AddBundle(db.DB, storage.File{
+		Name:       req.Name,
+		IpfsKey:    root,
+		UserID:     user.ID,
+		Level:      m.Level(),
+		Department: user.Department,
+		Size:       size,
+	}, links)
+	if err != nil {
+		event.CID = root
+		recordAudit(event, err)
+		return fmt.Errorf("failed to AddBundle: %w", err)
+	}
+	recordAudit(fileEvent(audit.ChannelHTTP, audit.ActionEncrypt, user.ID, file), nil)
+	notifyFileAvailable(file)
+
+	return c.JSON(http.StatusOK, file)
+}
+
+// Handler
+//
+// openBundle returns the entries of the bundle the user can open. The entries
+// above the clearance or outside the policy are listed as withheld.
+func openBundle(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid file id")
+	}
+
+	file, err := storage.GetFileByID(db.DB, id)
+	if err != nil {
+		return fmt.Errorf("failed to GetFileByID: %w", err)
+	}
+
+	event := fileEvent(audit.ChannelHTTP, audit.ActionDecrypt, user.ID, file)
+	if !file.CanRead(*user) {
+		recordAudit(event, denied(audit.CheckClearance, fmt.Errorf("file level %d is above the user clearance", file.Level)))
+		// files above the clearance are hidden, not forbidden
+		return storage.ErrFileNotFound
+	}
+	if file.Type != storage.FileTypeBundle {
+		return echo.NewHTTPError(http.StatusBadRequest, "file is not a bundle")
+	}
+
+	// opening a bundle counts as one delivery of it
+	cancel, err := logDownload(user, file)
+	if err != nil {
+		recordAudit(event, err)
+		return fmt.Errorf("failed to logDownload: %w", err)
+	}
+
+	open := func(ctx context.Context, link string) ([]byte, error) {
+		raw, err := blobs.Get(ctx, link)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Get: %w", err)
+		}
+		data, err := dec(*user, link, raw)
+		return []byte(data), err
+	}
+
+	ctx := c.Request().Context()
+	m, err := bundle.ReadManifest(ctx, blobs, open, file.IpfsKey)
+	if err != nil {
+		cancel()
+		recordAudit(event, err)
+		return fmt.Errorf("failed to ReadManifest: %w", err)
+	}
+
+	resp := api.ResponseBundle{File: apiFile(file), Entries: make([]api.ResponseBundleEntry, len(m.Entries))}
+	withheld := 0
+	for i, e := range m.Entries {
+		entry := api.ResponseBundleEntry{Path: e.Path, Level: e.Level, MimeType: e.MimeType, Size: e.Size, Withheld: true}
+		if e.Level <= user.Level {
+			data, err := open(ctx, e.Link)
+			var d *deniedError
+			if err != nil && !errors.As(err, &d) {
+				cancel()
+				recordAudit(event, err)
+				return fmt.Errorf("failed to open %s: %w", e.Path, err)
+			}
+			if err == nil {
+				entry.Data, entry.Withheld = base64.StdEncoding.EncodeToString(data), false
+			}
+		}
+		if entry.Withheld {
+			withheld++
+		}
+		resp.Entries[i] = entry
+	}
+	recordAudit(event, nil)
+	c.Get("logger").(*zap.Logger).Info("bundle opened", zap.Int64("file_id", file.ID), zap.Int("withheld", withheld))
+
+	return c.JSON(http.StatusOK, resp)
+}
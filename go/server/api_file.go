@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
-	"github.com/labstack/echo/v4"
 	"net/http"
-	"server/ipfs"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
 
+	"server/api"
+	"server/audit"
 	"server/crypto"
 	"server/security"
 	"server/storage"
@@ -14,7 +21,7 @@ import (
 )
 
 func Encrypt(c echo.Context) error {
-	var req RequestFile
+	var req api.RequestFile
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -23,80 +30,125 @@ func Encrypt(c echo.Context) error {
 		return err
 	}
 
+	level := req.Level
+	event := audit.Event{Channel: audit.ChannelHTTP, UserID: req.ID, Action: audit.ActionEncrypt, Level: &level}
 	if err := storage.CheckUserPK(db.DB, req.ID, req.PK); err != nil {
-		c.Logger().Errorf("failed to CheckUserPK: %s", err.Error())
-		return c.JSON(http.StatusForbidden, err.Error())
+		err = denied(audit.CheckPK, fmt.Errorf("failed to CheckUserPK: %w", err))
+		recordAudit(event, err)
+		return err
 	}
 
-	base64Cipher, err := enc(storage.User{
+	cipher, auth, err := enc(storage.User{
 		ID:         req.ID,
 		TgName:     "",
 		PK:         req.PK,
 		Department: req.Department,
 		Level:      req.Level,
-	}, req.File)
+	}, req.Level, req.File)
 	if err != nil {
-		c.Logger().Errorf("failed to enc: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err.Error())
+		recordAudit(event, err)
+		return fmt.Errorf("failed to enc: %w", err)
 	}
 
-	link, err := ipfs.Upload("", []byte(base64Cipher))
+	link, err := storeCipher(c.Request().Context(), cipher, auth)
 	if err != nil {
-		c.Logger().Errorf("failed to Upload: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err.Error())
+		recordAudit(event, err)
+		return fmt.Errorf("failed to storeCipher: %w", err)
 	}
-
-	if err = storage.AddFile(db.DB, storage.File{
-		Name:    req.File,
-		IpfsKey: link,
-		UserID:  req.ID,
-	}); err != nil {
-		c.Logger().Errorf("failed to AddFile: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err.Error())
+	event.CID = link
+
+	// the content is never the name, AddFile names the file after its ID
+	file, err := storage.AddFile(db.DB, storage.File{
+		Name:       req.Name,
+		IpfsKey:    link,
+		UserID:     req.ID,
+		MimeType:   req.MimeType,
+		Level:      req.Level,
+		Department: req.Department,
+		Size:       int64(len(req.File)),
+	})
+	if err != nil {
+		recordAudit(event, err)
+		return fmt.Errorf("failed to AddFile: %w", err)
 	}
+	recordAudit(fileEvent(audit.ChannelHTTP, audit.ActionEncrypt, req.ID, file), nil)
+	notifyFileAvailable(file)
 
-	return c.JSON(http.StatusOK, ResponseFile{File: base64Cipher})
+	return c.JSON(http.StatusOK, api.ResponseFile{File: base64.StdEncoding.EncodeToString(cipher)})
 }
 
-func enc(user storage.User, file string) (string, error) {
+// enc checks the user's membership at their clearance and encrypts the file at
+// the classification level, which must not be above the clearance. It returns
+// the ciphertext container and the authority keys needed to decrypt it.
+func enc(user storage.User, level int, file string) ([]byte, storage.AbeAuth, error) {
+	if level < 0 || level > user.Level {
+		return nil, storage.AbeAuth{}, denied(audit.CheckClearance, fmt.Errorf("classification level %d is above the user clearance %d", level, user.Level))
+	}
+
 	accum, err := storage.GetWitness(db.DB, user.PK)
 	if err != nil {
-		return "", fmt.Errorf("failed to GetWitness: %s", err.Error())
+		return nil, storage.AbeAuth{}, fmt.Errorf("failed to GetWitness: %w", err)
 	}
 
 	witLevel, err := base64.StdEncoding.DecodeString(accum.WitnessLevel)
 	if err != nil {
-		return "", fmt.Errorf("failed to DecodeString wit level: %s", err.Error())
+		return nil, storage.AbeAuth{}, fmt.Errorf("failed to DecodeString wit level: %w", err)
 	}
 
 	witDep, err := base64.StdEncoding.DecodeString(accum.WitnessDep)
 	if err != nil {
-		return "", fmt.Errorf("failed to DecodeString wit dep: %s", err.Error())
+		return nil, storage.AbeAuth{}, fmt.Errorf("failed to DecodeString wit dep: %w", err)
 	}
 
-	if err = security.Check(user.Level, user.Department, witLevel, witDep); err != nil {
-		return "", fmt.Errorf("failed to Check: %s", err.Error())
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return nil, storage.AbeAuth{}, fmt.Errorf("failed to DecodeString data: %w", err)
 	}
 
-	cipherRaw, depAuthRaw, levelAuthRaw, err := crypto.Encrypt(user.Department, user.Level, []byte(file))
+	if err = security.Check(user.Level, user.Department, data, witLevel, witDep); err != nil {
+		return nil, storage.AbeAuth{}, denied(audit.CheckWitness, fmt.Errorf("failed to Check: %w", err))
+	}
+
+	cipher, depAuthRaw, levelAuthRaw, err := crypto.Encrypt(user.Department, level, []byte(file))
 	if err != nil {
-		return "", fmt.Errorf("failed to Encrypt: %s", err.Error())
+		return nil, storage.AbeAuth{}, fmt.Errorf("failed to Encrypt: %w", err)
 	}
 
-	base64Cipher := base64.StdEncoding.EncodeToString(cipherRaw)
-	if err = storage.SetAbe(db.DB, storage.AbeAuth{
-		ID:        base64.StdEncoding.EncodeToString(stribog.New512().Sum([]byte(base64Cipher))),
+	return cipher, storage.AbeAuth{
 		LevelAuth: base64.StdEncoding.EncodeToString(levelAuthRaw),
-		DepAuth:   base64.StdEncoding.EncodeToString(depAuthRaw)}); err != nil {
-		return "", fmt.Errorf("failed to SetAbe: %s", err.Error())
+		DepAuth:   base64.StdEncoding.EncodeToString(depAuthRaw),
+	}, nil
+}
+
+// storeCipher uploads the ciphertext as raw bytes and stores its authority keys
+// under the returned link.
+func storeCipher(ctx context.Context, cipher []byte, auth storage.AbeAuth) (string, error) {
+	link, err := blobs.Put(ctx, cipher)
+	if err != nil {
+		return "", fmt.Errorf("failed to Put: %w", err)
 	}
 
-	return base64Cipher, nil
+	auth.ID = link
+	if err = recordPin(link); err != nil {
+		err = fmt.Errorf("failed to recordPin: %w", err)
+	} else if err = storage.SetAbe(db.DB, auth); err != nil {
+		err = fmt.Errorf("failed to SetAbe: %w", err)
+	}
+	if err != nil {
+		// the content can't be decrypted without the keys, nor reconciled
+		// without the record
+		if err := blobs.Unpin(ctx, link); err != nil {
+			zap.L().Error("failed to Unpin", zap.String("cid", link), zap.Error(err))
+		}
+		return "", err
+	}
+
+	return link, nil
 }
 
 // Handler
 func decrypt(c echo.Context) error {
-	var req RequestFile
+	var req api.RequestFile
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -107,13 +159,17 @@ func decrypt(c echo.Context) error {
 
 	file, err := storage.GetFile(db.DB, req.ID)
 	if err != nil {
-		c.Logger().Errorf("failed to GetFile: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err.Error())
+		recordAudit(audit.Event{Channel: audit.ChannelHTTP, UserID: req.ID, Action: audit.ActionDecrypt}, err)
+		return fmt.Errorf("failed to GetFile: %w", err)
+	}
+	event := fileEvent(audit.ChannelHTTP, audit.ActionDecrypt, req.ID, file)
+	if file.Type == storage.FileTypeBundle {
+		return errBundle
 	}
-	raw, err := ipfs.Download(file.IpfsKey, "")
+	raw, err := blobs.Get(c.Request().Context(), file.IpfsKey)
 	if err != nil {
-		c.Logger().Errorf("failed to Download: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err.Error())
+		recordAudit(event, err)
+		return fmt.Errorf("failed to Get: %w", err)
 	}
 	decrypted, err := dec(storage.User{
 		ID:         req.ID,
@@ -121,64 +177,282 @@ func decrypt(c echo.Context) error {
 		PK:         req.PK,
 		Department: req.Department,
 		Level:      req.Level,
-	}, string(raw))
+	}, file.IpfsKey, raw)
 
+	recordAudit(event, err)
 	if err != nil {
-		c.Logger().Errorf("failed to dec: %s", err.Error())
-		return c.JSON(http.StatusInternalServerError, err.Error())
+		return fmt.Errorf("failed to dec: %w", err)
 	}
 
-	return c.JSON(http.StatusOK, ResponseFile{File: string(decrypted)}) // TODO add base64
+	return c.JSON(http.StatusOK, api.ResponseFile{File: string(decrypted)}) // TODO add base64
 }
 
-func dec(user storage.User, file string) (string, error) {
+// dec decrypts the content stored under link.
+func dec(user storage.User, link string, file []byte) (string, error) {
 	if err := storage.CheckUserPK(db.DB, user.ID, user.PK); err != nil {
-		return "", fmt.Errorf("failed to CheckUserPK: %s", err.Error())
+		return "", denied(audit.CheckPK, fmt.Errorf("failed to CheckUserPK: %w", err))
 	}
 
 	accum, err := storage.GetWitness(db.DB, user.PK)
 	if err != nil {
-		return "", fmt.Errorf("failed to GetWitness: %s", err.Error())
+		return "", fmt.Errorf("failed to GetWitness: %w", err)
 	}
 
 	witLevel, err := base64.StdEncoding.DecodeString(accum.WitnessLevel)
 	if err != nil {
-		return "", fmt.Errorf("failed to DecodeString wit level: %s", err.Error())
+		return "", fmt.Errorf("failed to DecodeString wit level: %w", err)
 	}
 
 	witDep, err := base64.StdEncoding.DecodeString(accum.WitnessDep)
 	if err != nil {
-		return "", fmt.Errorf("failed to DecodeString wit dep: %s", err.Error())
+		return "", fmt.Errorf("failed to DecodeString wit dep: %w", err)
 	}
 
-	if err = security.Check(user.Level, user.Department, witLevel, witDep); err != nil {
-		return "", fmt.Errorf("failed to Check: %s", err.Error())
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return "", fmt.Errorf("failed to DecodeString data: %w", err)
+	}
+
+	if err = security.Check(user.Level, user.Department, data, witLevel, witDep); err != nil {
+		return "", denied(audit.CheckWitness, fmt.Errorf("failed to Check: %w", err))
 	}
 
-	auth, err := storage.GetAbe(db.DB, base64.StdEncoding.EncodeToString(stribog.New512().Sum([]byte(file))))
+	// ciphertexts stored before containers are base64 text and their keys are
+	// stored under the hash of that text
+	cipher, authID := file, link
+	if !crypto.IsContainer(file) {
+		authID = base64.StdEncoding.EncodeToString(stribog.New512().Sum(file))
+		if cipher, err = base64.StdEncoding.DecodeString(string(file)); err != nil {
+			return "", fmt.Errorf("failed to DecodeString file: %w", err)
+		}
+	}
+
+	auth, err := storage.GetAbe(db.DB, authID)
 	if err != nil {
-		return "", fmt.Errorf("failed to GetAbe: %s", err.Error())
+		return "", fmt.Errorf("failed to GetAbe: %w", err)
 	}
 
 	authLevel, err := base64.StdEncoding.DecodeString(auth.LevelAuth)
 	if err != nil {
-		return "", fmt.Errorf("failed to DecodeString wit auth: %s", err.Error())
+		return "", fmt.Errorf("failed to DecodeString wit auth: %w", err)
 	}
 
 	authDep, err := base64.StdEncoding.DecodeString(auth.DepAuth)
 	if err != nil {
-		return "", fmt.Errorf("failed to DecodeString dep auth: %s", err.Error())
+		return "", fmt.Errorf("failed to DecodeString dep auth: %w", err)
 	}
 
-	cipher, err := base64.StdEncoding.DecodeString(file)
+	decrypted, err := crypto.Decrypt(user.Department, user.Level, cipher, authDep, authLevel)
 	if err != nil {
-		return "", fmt.Errorf("failed to DecodeString file: %s", err.Error())
+		return "", denied(audit.CheckABE, fmt.Errorf("failed to Decrypt: %w", err))
 	}
 
-	decrypted, err := crypto.Decrypt(user.Department, user.Level, cipher, authDep, authLevel)
+	return string(decrypted), nil
+}
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// Handler
+func listFiles(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	filter, page, err := parseFileFilter(c.QueryParams())
 	if err != nil {
-		return "", fmt.Errorf("failed to Encrypt: %s", err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	return string(decrypted), nil
+	files, total, err := storage.ListFiles(db.DB, *user, filter)
+	recordAudit(audit.Event{Channel: audit.ChannelHTTP, UserID: user.ID, Action: audit.ActionList}, err)
+	if err != nil {
+		return fmt.Errorf("failed to ListFiles: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, api.ResponseFiles{Files: apiFiles(files), Total: total, Page: page, PerPage: filter.Limit})
+}
+
+// Handler
+func getFileInfo(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid file id")
+	}
+
+	file, err := storage.GetFileByID(db.DB, id)
+	if err != nil {
+		return fmt.Errorf("failed to GetFileByID: %w", err)
+	}
+
+	event := fileEvent(audit.ChannelHTTP, audit.ActionFileInfo, user.ID, file)
+	if !file.CanRead(*user) {
+		recordAudit(event, denied(audit.CheckClearance, fmt.Errorf("file level %d is above the user clearance", file.Level)))
+		// files above the clearance are hidden, not forbidden
+		return storage.ErrFileNotFound
+	}
+	recordAudit(event, nil)
+
+	return c.JSON(http.StatusOK, file)
+}
+
+// parseFileFilter reads the listing filter and the 1-based page number from
+// query parameters.
+func parseFileFilter(q url.Values) (storage.FileFilter, int, error) {
+	filter := storage.FileFilter{
+		Name:     q.Get("name"),
+		MimeType: q.Get("mime_type"),
+		Sort:     q.Get("sort"),
+		Desc:     q.Get("order") == "desc",
+		Limit:    defaultPerPage,
+	}
+
+	if filter.Sort != "" {
+		if _, ok := storage.FileSorts[filter.Sort]; !ok {
+			return storage.FileFilter{}, 0, fmt.Errorf("unsupported sort %q", filter.Sort)
+		}
+	}
+
+	page := 1
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return storage.FileFilter{}, 0, fmt.Errorf("invalid page %q", v)
+		}
+		page = n
+	}
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			return storage.FileFilter{}, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		filter.Limit = n
+	}
+	filter.Offset = (page - 1) * filter.Limit
+
+	if v := q.Get("uploader"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return storage.FileFilter{}, 0, fmt.Errorf("invalid uploader %q", v)
+		}
+		filter.UploaderID = n
+	}
+	if v := q.Get("level"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return storage.FileFilter{}, 0, fmt.Errorf("invalid level %q", v)
+		}
+		filter.Level = &n
+	}
+
+	var err error
+	if filter.From, err = parseDate(q.Get("from"), false); err != nil {
+		return storage.FileFilter{}, 0, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseDate(q.Get("to"), true); err != nil {
+		return storage.FileFilter{}, 0, fmt.Errorf("invalid to: %w", err)
+	}
+
+	return filter, page, nil
+}
+
+// parseDate accepts RFC 3339 timestamps and plain dates. A plain date used as
+// the end of a range includes the whole day.
+func parseDate(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
+
+// Handler
+//
+// uploadFile encrypts the file at the requested level within the user's
+// department.
+func uploadFile(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	var req api.RequestUpload
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid file data")
+	}
+
+	level := req.Level
+	file, err := upload(c.Request().Context(), data, user, storage.File{Name: req.Name, MimeType: req.MimeType, Level: level})
+	if err != nil {
+		recordAudit(audit.Event{Channel: audit.ChannelHTTP, UserID: user.ID, Action: audit.ActionEncrypt, Level: &level}, err)
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+	recordAudit(fileEvent(audit.ChannelHTTP, audit.ActionEncrypt, user.ID, file), nil)
+
+	return c.JSON(http.StatusOK, apiFile(file))
+}
+
+// Handler
+//
+// downloadFile returns the decrypted content of a file the user has clearance
+// for.
+func downloadFile(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid file id")
+	}
+
+	file, err := storage.GetFileByID(db.DB, id)
+	if err != nil {
+		return fmt.Errorf("failed to GetFileByID: %w", err)
+	}
+
+	event := fileEvent(audit.ChannelHTTP, audit.ActionDecrypt, user.ID, file)
+	if !file.CanRead(*user) {
+		recordAudit(event, denied(audit.CheckClearance, fmt.Errorf("file level %d is above the user clearance", file.Level)))
+		// files above the clearance are hidden, not forbidden
+		return storage.ErrFileNotFound
+	}
+	if file.Type == storage.FileTypeBundle {
+		return errBundle
+	}
+
+	cancel, err := logDownload(user, file)
+	if err != nil {
+		recordAudit(event, err)
+		return fmt.Errorf("failed to logDownload: %w", err)
+	}
+	raw, err := blobs.Get(c.Request().Context(), file.IpfsKey)
+	if err != nil {
+		cancel()
+		recordAudit(event, err)
+		return fmt.Errorf("failed to Get: %w", err)
+	}
+	decrypted, err := dec(*user, file.IpfsKey, raw)
+	recordAudit(event, err)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to dec: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, api.ResponseContent{File: apiFile(file), Data: base64.StdEncoding.EncodeToString([]byte(decrypted))})
 }
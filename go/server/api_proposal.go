@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/storage"
+)
+
+// dualControlLevel is the lowest clearance level whose enrollment needs the
+// approval of a second admin.
+const dualControlLevel = 3
+
+// proposalTTL is how long a proposal waits for approval before it expires.
+var proposalTTL = 24 * time.Hour
+
+func requiresDualControl(action string, level int) bool {
+	return action == storage.ProposalDelete || action == storage.ProposalRebind || level >= dualControlLevel
+}
+
+func propose(admin *storage.Admin, action string, user storage.User) (storage.Proposal, error) {
+	return storage.AddProposal(db.DB, storage.Proposal{
+		Action:     action,
+		User:       user,
+		ProposedBy: admin.ID,
+		ExpiresAt:  time.Now().Add(proposalTTL),
+	})
+}
+
+// Approval is an executed proposal. Enrollment holds the code issued by an
+// approved enrollment or rebind. It is returned to the approver only, once:
+// the stored result is the user ID.
+type Approval struct {
+	storage.Proposal
+	Enrollment *Enrollment `json:",omitempty"`
+}
+
+// executeProposal runs the approved change and returns the ID of the user it
+// changed, along with the enrollment code it issued, if any.
+func executeProposal(p storage.Proposal) (int, *Enrollment, error) {
+	switch p.Action {
+	case storage.ProposalAdd:
+		e, err := enrollUser(p.User.TgName, p.User.Level, p.User.Department)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to enrollUser: %w", err)
+		}
+		return e.ID, &e, nil
+	case storage.ProposalDelete:
+		if _, err := revokeUser(p.User.ID); err != nil {
+			return 0, nil, fmt.Errorf("failed to revokeUser: %w", err)
+		}
+	case storage.ProposalUpdate:
+		if _, err := updateUserClearance(p.User.ID, p.User.Level, p.User.Department); err != nil {
+			return 0, nil, fmt.Errorf("failed to updateUserClearance: %w", err)
+		}
+	case storage.ProposalRebind:
+		user, err := storage.GetUserByID(db.DB, p.User.ID)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to GetUserByID: %w", err)
+		}
+		e, err := issueEnrollmentCode(db.DB, user)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to issueEnrollmentCode: %w", err)
+		}
+		return e.ID, &e, nil
+	default:
+		return 0, nil, fmt.Errorf("unknown proposal action %s", p.Action)
+	}
+
+	return p.User.ID, nil, nil
+}
+
+// Handler
+func getProposals(c echo.Context) error {
+	admin := c.Get("admin").(*storage.Admin)
+
+	if _, err := storage.ExpireProposals(db.DB, time.Now()); err != nil {
+		return fmt.Errorf("failed to ExpireProposals: %w", err)
+	}
+
+	proposals, err := storage.GetProposals(db.DB, c.QueryParam("status"))
+	if err != nil {
+		return fmt.Errorf("failed to GetProposals: %w", err)
+	}
+
+	visible := []storage.Proposal{}
+	for _, p := range proposals {
+		if canView(admin, p.User.Department) == nil {
+			visible = append(visible, p)
+		}
+	}
+
+	return c.JSON(http.StatusOK, visible)
+}
+
+var (
+	errSelfApproval    = errors.New("proposal must be approved by another admin")
+	errProposalExpired = errors.New("proposal expired")
+)
+
+// canApprove reports whether the admin may approve the proposal.
+func canApprove(admin *storage.Admin, p storage.Proposal) error {
+	if p.ProposedBy == admin.ID {
+		return errSelfApproval
+	}
+
+	return canManage(admin, p.User.Level, p.User.Department)
+}
+
+// approve claims and executes the pending proposal and returns it decided.
+// Proposals past their deadline are marked expired instead.
+func approve(admin *storage.Admin, p storage.Proposal) (Approval, error) {
+	if time.Now().After(p.ExpiresAt) {
+		if err := storage.DecideProposal(db.DB, p.ID, storage.ProposalPending, storage.ProposalExpired, 0, ""); err != nil && !errors.Is(err, storage.ErrProposalDecided) {
+			return Approval{}, fmt.Errorf("failed to DecideProposal: %w", err)
+		}
+		return Approval{}, errProposalExpired
+	}
+
+	// claim the proposal first so that it is executed only once
+	if err := storage.DecideProposal(db.DB, p.ID, storage.ProposalPending, storage.ProposalApproved, admin.ID, ""); err != nil {
+		return Approval{}, err
+	}
+
+	userID, e, execErr := executeProposal(p)
+	status, result := storage.ProposalExecuted, strconv.Itoa(userID)
+	if execErr != nil {
+		status, result = storage.ProposalFailed, execErr.Error()
+	}
+	if err := storage.DecideProposal(db.DB, p.ID, storage.ProposalApproved, status, admin.ID, result); err != nil {
+		return Approval{}, fmt.Errorf("failed to DecideProposal: %w", err)
+	}
+	if execErr != nil {
+		return Approval{}, fmt.Errorf("failed to executeProposal: %w", execErr)
+	}
+
+	p, err := storage.GetProposal(db.DB, p.ID)
+	if err != nil {
+		return Approval{}, fmt.Errorf("failed to GetProposal: %w", err)
+	}
+
+	return Approval{Proposal: p, Enrollment: e}, nil
+}
+
+// reject rejects the pending proposal.
+func reject(admin *storage.Admin, p storage.Proposal) error {
+	return storage.DecideProposal(db.DB, p.ID, storage.ProposalPending, storage.ProposalRejected, admin.ID, "")
+}
+
+// Handler
+func approveProposal(c echo.Context) error {
+	admin := c.Get("admin").(*storage.Admin)
+
+	p, l, err := loadProposal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := canApprove(admin, p); errors.Is(err, errSelfApproval) {
+		l.Warn("approval denied", zap.Error(err))
+		return err
+	} else if err != nil {
+		l.Warn("approval denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	a, err := approve(admin, p)
+	switch {
+	case errors.Is(err, errProposalExpired):
+		l.Info("proposal expired")
+		return err
+	case errors.Is(err, storage.ErrProposalDecided):
+		return err
+	case err != nil:
+		l.Error("failed to execute proposal", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to execute proposal")
+	}
+
+	l.Info("proposal executed", zap.String("user_id", a.Result))
+
+	return c.JSON(http.StatusOK, a)
+}
+
+// Handler
+func rejectProposal(c echo.Context) error {
+	admin := c.Get("admin").(*storage.Admin)
+
+	p, l, err := loadProposal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := canManage(admin, p.User.Level, p.User.Department); err != nil {
+		l.Warn("rejection denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	if err := reject(admin, p); err != nil {
+		return fmt.Errorf("failed to DecideProposal: %w", err)
+	}
+
+	l.Info("proposal rejected")
+
+	return c.String(http.StatusOK, http.StatusText(http.StatusOK))
+}
+
+func loadProposal(c echo.Context) (storage.Proposal, *zap.Logger, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return storage.Proposal{}, nil, echo.NewHTTPError(http.StatusBadRequest, "invalid proposal id")
+	}
+
+	p, err := storage.GetProposal(db.DB, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.Proposal{}, nil, echo.NewHTTPError(http.StatusNotFound, "proposal not found")
+	} else if err != nil {
+		return storage.Proposal{}, nil, fmt.Errorf("failed to GetProposal: %w", err)
+	}
+
+	l := c.Get("logger").(*zap.Logger).With(zap.Int("proposal_id", p.ID), zap.String("action", p.Action),
+		zap.Int("proposed_by", p.ProposedBy), zap.Int("level", p.User.Level), zap.Int("department", p.User.Department))
+
+	if p.Status != storage.ProposalPending {
+		return storage.Proposal{}, nil, echo.NewHTTPError(http.StatusConflict, "proposal is "+p.Status)
+	}
+
+	return p, l, nil
+}
@@ -1,178 +1,626 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
-	"server/ipfs"
-	"server/pkg"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 	"go.uber.org/zap"
 
+	"server/audit"
+	"server/config"
+	"server/dialog"
+	"server/pkg"
+	"server/security"
 	"server/storage"
 )
 
 func handler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	l := ctx.Value("logger").(*zap.Logger)
 	data := ctx.Value("data").(*pkg.TgData)
-	user := ctx.Value("user").(*storage.User)
+	if handleAdminCommand(ctx, b, update, data) {
+		return
+	}
+	// officers without a user account only have the officer commands, unless
+	// they send an enrollment code
+	if user, _ := ctx.Value("user").(*storage.User); user == nil {
+		if code := strings.TrimSpace(strings.TrimPrefix(data.Text, "/start")); code != "" && !strings.HasPrefix(code, "/") {
+			bindTelegram(ctx, b, *data, l)
+			return
+		}
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: adminHelp})
+		return
+	}
+
 	switch {
 	case data.Text == "/start":
 		helloMessage(ctx, b, data, l)
-	case update.Message != nil && update.Message.Document != nil:
-		f, err := b.GetFile(ctx, &bot.GetFileParams{FileID: update.Message.Document.FileID})
-		if err != nil {
-			l.Error("failed to GetFile", zap.Error(err))
-			b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("Failed to GetFile")})
+		dialogs.Start(ctx, b, update, data.ChatID)
+	case update.Message != nil:
+		if pending, ok := incomingFile(update.Message); ok {
+			dialogs.With(ctx, b, update, data.ChatID, func(ctx context.Context, c *dialog.Context) {
+				c.Session.Values["upload"] = pending
+				c.Go(ctx, "upload_level", "")
+			})
 			return
 		}
+		dialogs.HandleMessage(ctx, b, update)
+	default:
+		dialogs.HandleMessage(ctx, b, update)
+	}
+}
 
-		resp, err := http.Get(b.FileDownloadLink(f))
-		if err != nil {
-			l.Error("failed to Get", zap.Error(err))
-			b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("Failed to Get")})
-			return
-		}
-		defer resp.Body.Close()
-		raw, err := io.ReadAll(resp.Body)
-		if err != nil {
-			l.Error("failed to ReadAll", zap.Error(err))
-			b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("Failed to ReadAll")})
-			return
+// pendingUpload is a file sent to the bot waiting for its classification.
+type pendingUpload struct {
+	FileID string
+	Meta   storage.File
+}
+
+// incomingFile returns the file attached to the message. Photos have no name
+// so they are named after their unique ID.
+func incomingFile(msg *models.Message) (pendingUpload, bool) {
+	switch {
+	case msg.Document != nil:
+		return pendingUpload{FileID: msg.Document.FileID, Meta: storage.File{
+			Name: msg.Document.FileName, MimeType: msg.Document.MimeType, Type: "Document"}}, true
+	case len(msg.Photo) > 0:
+		// sizes are sorted ascending, the last one is the original
+		photo := msg.Photo[len(msg.Photo)-1]
+		return pendingUpload{FileID: photo.FileID, Meta: storage.File{
+			Name: "photo_" + photo.FileUniqueID + ".jpg", MimeType: "image/jpeg", Type: "Photo"}}, true
+	case msg.Audio != nil:
+		name := msg.Audio.FileName
+		if name == "" {
+			name = "audio_" + msg.Audio.FileUniqueID
 		}
-		if err = upload(raw, user, storage.File{Name: update.Message.Document.FileName, MimeType: update.Message.Document.MimeType, Type: "Document"}); err != nil {
-			l.Error("failed to upload", zap.Error(err))
-			b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("Failed to upload")})
-			return
+		return pendingUpload{FileID: msg.Audio.FileID, Meta: storage.File{
+			Name: name, MimeType: msg.Audio.MimeType, Type: "Audio"}}, true
+	case msg.Video != nil:
+		name := msg.Video.FileName
+		if name == "" {
+			name = "video_" + msg.Video.FileUniqueID + ".mp4"
 		}
-	default:
-		kb := &models.InlineKeyboardMarkup{
-			InlineKeyboard: [][]models.InlineKeyboardButton{
-				{{Text: "Список файлов", CallbackData: "button list"}}, {{Text: "Скачать файл", CallbackData: "button download"}},
-			},
+		return pendingUpload{FileID: msg.Video.FileID, Meta: storage.File{
+			Name: name, MimeType: msg.Video.MimeType, Type: "Video"}}, true
+	case msg.Voice != nil:
+		return pendingUpload{FileID: msg.Voice.FileID, Meta: storage.File{
+			Name: "voice_" + msg.Voice.FileUniqueID + ".ogg", MimeType: msg.Voice.MimeType, Type: "Voice"}}, true
+	}
+
+	return pendingUpload{}, false
+}
+
+// tgMaxFileSize is the largest file bots can download from Telegram.
+const tgMaxFileSize = 20 << 20
+
+// downloadTgFile downloads a file sent to the bot. The download link holds the
+// bot token, so it's kept out of the errors.
+func downloadTgFile(ctx context.Context, b *bot.Bot, fileID string) ([]byte, error) {
+	f, err := b.GetFile(ctx, &bot.GetFileParams{FileID: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetFile: %w", err)
+	}
+	if f.FileSize > tgMaxFileSize {
+		return nil, fmt.Errorf("file of %d bytes is above the limit of %d", f.FileSize, tgMaxFileSize)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(f), nil)
+	if err != nil {
+		return nil, errors.New("failed to NewRequest")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
 		}
+		return nil, fmt.Errorf("failed to Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed with status %s", resp.Status)
+	}
 
-		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:      data.ChatID,
-			Text:        "Выберете действие",
-			ReplyMarkup: kb,
-		})
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, tgMaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReadAll: %w", err)
 	}
+	if len(raw) > tgMaxFileSize {
+		return nil, fmt.Errorf("file is above the limit of %d bytes", tgMaxFileSize)
+	}
+
+	return raw, nil
 }
 
-func upload(file []byte, user *storage.User, fileMeta storage.File) error {
-	base64Cipher, err := enc(storage.User{
+// upload encrypts the file at the classification level of fileMeta within the
+// user's department and stores it.
+func upload(ctx context.Context, file []byte, user *storage.User, fileMeta storage.File) (storage.File, error) {
+	cipher, auth, err := enc(storage.User{
 		ID:         user.ID,
 		TgName:     "",
 		PK:         user.PK,
 		Department: user.Department,
 		Level:      user.Level,
-	}, string(file))
+	}, fileMeta.Level, string(file))
 	if err != nil {
-		return fmt.Errorf("failed to enc: %w", err)
+		return storage.File{}, fmt.Errorf("failed to enc: %w", err)
 	}
 
-	link, err := ipfs.Upload("", []byte(base64Cipher))
+	link, err := storeCipher(ctx, cipher, auth)
 	if err != nil {
-		return fmt.Errorf("failed to Upload: %s", err.Error())
+		return storage.File{}, fmt.Errorf("failed to storeCipher: %w", err)
 	}
 
-	if err = storage.AddFile(db.DB, storage.File{
-		Name:    fileMeta.Name,
-		IpfsKey: link,
-		UserID:  user.ID,
-	}); err != nil {
-		return fmt.Errorf("failed to AddFile: %s", err.Error())
+	stored, err := storage.AddFile(db.DB, storage.File{
+		Name:       fileMeta.Name,
+		IpfsKey:    link,
+		UserID:     user.ID,
+		MimeType:   fileMeta.MimeType,
+		Type:       fileMeta.Type,
+		Level:      fileMeta.Level,
+		Department: user.Department,
+		Policy:     fileMeta.Policy,
+		Size:       int64(len(file)),
+	})
+	if err != nil {
+		return storage.File{}, fmt.Errorf("failed to AddFile: %s", err.Error())
 	}
+	notifyFileAvailable(stored)
 
-	return nil
+	return stored, nil
 }
 
-func callbackMenuHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	data := ctx.Value("data").(*pkg.TgData)
-	user := ctx.Value("user").(*storage.User)
-	l := ctx.Value("logger").(*zap.Logger)
+// levelNames are the names of the classification levels shown by the bot.
+var levelNames = []string{"Не секретно", "Для служебного пользования", "Секретно", "Совершенно секретно", "Особой важности"}
 
-	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
-		CallbackQueryID: update.CallbackQuery.ID,
-		ShowAlert:       false,
-	})
+func levelName(level int) string {
+	if level < 0 || level >= len(levelNames) {
+		return strconv.Itoa(level)
+	}
 
-	switch update.CallbackQuery.Data {
-	case "button list":
-		files, err := storage.GetAccessedFiles(db.DB, *user)
-		if err != nil {
-			l.Error("failed to ReadAll", zap.Error(err))
-			b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("Failed to ReadAll")})
-			return
-		}
-		var output = "List of available files"
-		for _, f := range files {
-			output = fmt.Sprintf("%s\nName: <b>%s</b>, Type: <b>%s</b>", output, f.Name, f.MimeType)
-		}
-		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: output, ParseMode: models.ParseModeHTML})
-	case "button download":
-		files, err := storage.GetAccessedFiles(db.DB, *user)
-		if err != nil {
-			l.Error("failed to ReadAll", zap.Error(err))
-			b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("Failed to ReadAll")})
-			return
+	return fmt.Sprintf("%d (%s)", level, levelNames[level])
+}
+
+// pending returns the upload waiting for classification, if any.
+func pending(ctx context.Context, c *dialog.Context) (pendingUpload, bool) {
+	l := ctx.Value("logger").(*zap.Logger)
+
+	p, ok := c.Session.Values["upload"].(pendingUpload)
+	if !ok {
+		if err := c.Show(ctx, "No file is waiting for upload. Send a document, photo, audio, video or voice message.", c.NavRow()); err != nil {
+			l.Error("failed to Show", zap.Error(err))
 		}
-		buttons := [][]models.InlineKeyboardButton{}
-		for _, file := range files {
-			buttons = append(buttons, []models.InlineKeyboardButton{{Text: file.Name, CallbackData: "file " + strconv.FormatInt(file.ID, 10)}})
+	}
+
+	return p, ok
+}
+
+// uploadLevelState asks for the classification of the pending upload. Users may
+// only write at or below their own clearance.
+func uploadLevelState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	p, ok := pending(ctx, c)
+	if !ok {
+		return
+	}
+
+	rows := [][]models.InlineKeyboardButton{}
+	for level := 0; level <= user.Level; level++ {
+		rows = append(rows, []models.InlineKeyboardButton{c.Button(levelName(level), "upload_policy", strconv.Itoa(level))})
+	}
+	rows = append(rows, c.NavRow())
+
+	text := fmt.Sprintf("File: <b>%s</b>\nChoose the classification level:", html.EscapeString(p.Meta.Name))
+	if err := c.Show(ctx, text, rows...); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+// uploadLevel returns the classification and the delivery policy chosen for
+// the pending upload, given as "level/policy" or "level" before the policy is
+// chosen.
+func uploadLevel(ctx context.Context, c *dialog.Context) (int, int, bool) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return 0, 0, false
+	}
+
+	arg, policyArg, hasPolicy := strings.Cut(c.Arg, "/")
+	level, err := strconv.Atoi(arg)
+	if err != nil || level < 0 || level > user.Level {
+		l.Warn("upload level above user clearance", zap.String("level", c.Arg))
+		c.Show(ctx, "Access denied", c.NavRow())
+		return 0, 0, false
+	}
+
+	policy := level
+	if hasPolicy {
+		if policy, err = strconv.Atoi(policyArg); err != nil || policy < level || policy > security.MaxLevel {
+			l.Warn("invalid upload policy", zap.String("policy", c.Arg))
+			c.Show(ctx, "Invalid delivery policy", c.NavRow())
+			return 0, 0, false
 		}
+	}
 
-		kb := &models.InlineKeyboardMarkup{
-			InlineKeyboard: buttons,
+	return level, policy, true
+}
+
+// policyLevels returns the levels whose delivery policies a file of level may
+// be given: its own and the ones configured for the levels above it.
+func policyLevels(level int) []int {
+	var above []int
+	for l := range tgConfig.Delivery {
+		if l > level && l <= security.MaxLevel {
+			above = append(above, l)
 		}
+	}
+	sort.Ints(above)
 
-		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:      data.ChatID,
-			Text:        "Files available for download",
-			ReplyMarkup: kb,
-		})
-	default:
-		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID: update.CallbackQuery.Message.Message.Chat.ID,
-			Text:   "unknown button",
-		})
+	return append([]int{level}, above...)
+}
+
+// describePolicy returns the restrictions of a delivery policy for the bot.
+func describePolicy(p config.DeliveryPolicy) string {
+	var parts []string
+	if p.ProtectContent {
+		parts = append(parts, "no forwarding")
+	}
+	if p.Watermark {
+		parts = append(parts, "watermarked")
+	}
+	if p.DeleteAfter > 0 {
+		parts = append(parts, fmt.Sprintf("deleted after %s", p.DeleteAfter))
+	}
+	if p.MaxDownloads > 0 {
+		parts = append(parts, fmt.Sprintf("%d downloads", p.MaxDownloads))
+	}
+	if len(parts) == 0 {
+		return "no restrictions"
 	}
 
+	return strings.Join(parts, ", ")
 }
 
-func callbackFileHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	data := ctx.Value("data").(*pkg.TgData)
-	user := ctx.Value("user").(*storage.User)
+// uploadPolicyState asks for the delivery policy of the pending upload, the
+// one of its level or a stricter one.
+func uploadPolicyState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+
+	p, ok := pending(ctx, c)
+	if !ok {
+		return
+	}
+	level, _, ok := uploadLevel(ctx, c)
+	if !ok {
+		return
+	}
+
+	rows := [][]models.InlineKeyboardButton{}
+	for _, policy := range policyLevels(level) {
+		text := fmt.Sprintf("Level %d: %s", policy, describePolicy(tgConfig.DeliveryPolicy(policy)))
+		rows = append(rows, []models.InlineKeyboardButton{c.Button(text, "upload", fmt.Sprintf("%d/%d", level, policy))})
+	}
+	rows = append(rows, c.NavRow())
+
+	text := fmt.Sprintf("File: <b>%s</b>\nLevel: <b>%s</b>\nChoose the delivery policy:", html.EscapeString(p.Meta.Name), html.EscapeString(levelName(level)))
+	if err := c.Show(ctx, text, rows...); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+func uploadConfirmState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	p, ok := pending(ctx, c)
+	if !ok {
+		return
+	}
+	level, policy, ok := uploadLevel(ctx, c)
+	if !ok {
+		return
+	}
+
+	// content is encrypted for the uploader's department only, there is no
+	// other to choose
+	text := fmt.Sprintf("File: <b>%s</b>\nType: <b>%s</b>\nLevel: <b>%s</b>\nDelivery: <b>level %d, %s</b>\nDepartment: <b>%d</b>\n\nFiles are shared within your department. Upload the file?",
+		html.EscapeString(p.Meta.Name), html.EscapeString(p.Meta.MimeType), html.EscapeString(levelName(level)),
+		policy, html.EscapeString(describePolicy(tgConfig.DeliveryPolicy(policy))), user.Department)
+	if err := c.Confirm(ctx, text, "upload_send", c.Arg); err != nil {
+		l.Error("failed to Confirm", zap.Error(err))
+	}
+}
+
+func uploadSendState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	p, ok := pending(ctx, c)
+	if !ok {
+		return
+	}
+	level, policy, ok := uploadLevel(ctx, c)
+	if !ok {
+		return
+	}
+
+	if !lc.beginUpload() {
+		c.Show(ctx, "The server is shutting down, try again later", c.NavRow())
+		return
+	}
+	defer lc.endUpload()
+
+	// the upload finishes even when shutdown cancels the bot context
+	ctx, cancel := context.WithTimeout(detached{ctx}, uploadTimeout)
+	defer cancel()
+
+	raw, err := downloadTgFile(ctx, c.Bot, p.FileID)
+	if err != nil {
+		l.Error("failed to downloadTgFile", zap.Error(err))
+		c.Show(ctx, "Failed to download the file", c.NavRow())
+		return
+	}
+
+	meta := p.Meta
+	meta.Level, meta.Policy = level, policy
+	file, err := upload(ctx, raw, user, meta)
+	if err != nil {
+		l.Error("failed to upload", zap.Error(err))
+		recordAudit(audit.Event{Channel: audit.ChannelTelegram, UserID: user.ID, Action: audit.ActionEncrypt, Level: &level}, err)
+		c.Show(ctx, "Failed to upload", c.NavRow())
+		return
+	}
+	recordAudit(fileEvent(audit.ChannelTelegram, audit.ActionEncrypt, user.ID, file), nil)
+	l.Info("file uploaded", zap.Int64("file_id", file.ID), zap.Int("level", file.Level))
+
+	c.Reset()
+	text := fmt.Sprintf("Uploaded <b>%s</b>\nID: <code>%d</code>\nCID: <code>%s</code>\nLevel: <b>%s</b>\nDelivery: <b>level %d</b>\nDepartment: <b>%d</b>",
+		html.EscapeString(file.Name), file.ID, html.EscapeString(file.IpfsKey), html.EscapeString(levelName(file.Level)), file.Policy, file.Department)
+	if err = c.Show(ctx, text); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+// tgPageSize is the number of files on a page of the bot's file lists.
+const tgPageSize = 8
+
+// registerDialogs registers the bot dialog states.
+func registerDialogs(m *dialog.Machine) {
+	m.Register("menu", dialog.State{Enter: menuState})
+	m.Register("files", dialog.State{Enter: filesState})
+	m.Register("download", dialog.State{Enter: downloadState})
+	m.Register("file", dialog.State{Enter: fileState})
+	m.Register("get", dialog.State{Enter: getFileState, Action: true})
+	m.Register("upload_level", dialog.State{Enter: uploadLevelState})
+	m.Register("upload_policy", dialog.State{Enter: uploadPolicyState})
+	m.Register("upload", dialog.State{Enter: uploadConfirmState})
+	m.Register("upload_send", dialog.State{Enter: uploadSendState, Action: true})
+}
+
+// dialogUser returns the user of the chat. Officers without a user account
+// are shown the officer commands instead.
+func dialogUser(ctx context.Context, c *dialog.Context) (*storage.User, bool) {
+	user, ok := ctx.Value("user").(*storage.User)
+	if !ok || user == nil {
+		if err := c.Show(ctx, adminHelp); err != nil {
+			ctx.Value("logger").(*zap.Logger).Error("failed to Show", zap.Error(err))
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+func menuState(ctx context.Context, c *dialog.Context) {
 	l := ctx.Value("logger").(*zap.Logger)
+	if _, ok := dialogUser(ctx, c); !ok {
+		return
+	}
+
+	err := c.Show(ctx, "Выберете действие",
+		[]models.InlineKeyboardButton{c.Button("Список файлов", "files", "1")},
+		[]models.InlineKeyboardButton{c.Button("Скачать файл", "download", "1")},
+	)
+	if err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+// filesPage returns a page of the files the user has clearance for and the
+// number of pages. page is taken from the state argument.
+func filesPage(c *dialog.Context, user *storage.User) ([]storage.File, int, int, error) {
+	page, err := strconv.Atoi(c.Arg)
+	if err != nil || page < 1 {
+		page = 1
+	}
 
-	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
-		CallbackQueryID: update.CallbackQuery.ID,
-		ShowAlert:       false,
+	files, total, err := storage.ListFiles(db.DB, *user, storage.FileFilter{
+		Sort:   "created_at",
+		Desc:   true,
+		Limit:  tgPageSize,
+		Offset: (page - 1) * tgPageSize,
 	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	pages := (total + tgPageSize - 1) / tgPageSize
+	if pages == 0 {
+		pages = 1
+	}
+
+	return files, page, pages, nil
+}
+
+func filesState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	files, page, pages, err := filesPage(c, user)
+	recordAudit(audit.Event{Channel: audit.ChannelTelegram, UserID: user.ID, Action: audit.ActionList}, err)
+	if err != nil {
+		l.Error("failed to ListFiles", zap.Error(err))
+		c.Show(ctx, "Failed to ListFiles", c.NavRow())
+		return
+	}
+
+	var output = "List of available files"
+	for _, f := range files {
+		output = fmt.Sprintf("%s\nName: <b>%s</b>, Type: <b>%s</b>", output, html.EscapeString(f.Name), html.EscapeString(f.MimeType))
+	}
+	if len(files) == 0 {
+		output += "\nNo files"
+	}
+
+	if err = c.Show(ctx, output, c.PageRow("files", page, pages), c.NavRow()); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
 
-	id, err := strconv.Atoi(update.CallbackQuery.Data[5:])
+func downloadState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	files, page, pages, err := filesPage(c, user)
+	recordAudit(audit.Event{Channel: audit.ChannelTelegram, UserID: user.ID, Action: audit.ActionList}, err)
 	if err != nil {
-		l.Error("failed to ReadAll", zap.Error(err))
-		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("Failed to Atoi")})
+		l.Error("failed to ListFiles", zap.Error(err))
+		c.Show(ctx, "Failed to ListFiles", c.NavRow())
 		return
 	}
 
-	file, err := storage.GetFile(db.DB, id)
+	rows := [][]models.InlineKeyboardButton{}
+	for _, file := range files {
+		rows = append(rows, []models.InlineKeyboardButton{c.Button(file.Name, "file", strconv.FormatInt(file.ID, 10))})
+	}
+	rows = append(rows, c.PageRow("download", page, pages), c.NavRow())
+
+	if err = c.Show(ctx, "Files available for download", rows...); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+// readableFile returns the file named by the state argument if the user has
+// clearance for it. Denials are recorded as the given action.
+func readableFile(ctx context.Context, c *dialog.Context, action string) (storage.File, bool) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return storage.File{}, false
+	}
+
+	id, err := strconv.ParseInt(c.Arg, 10, 64)
+	if err != nil {
+		l.Error("failed to ParseInt", zap.Error(err))
+		c.Show(ctx, "Failed to ParseInt", c.NavRow())
+		return storage.File{}, false
+	}
+
+	file, err := storage.GetFileByID(db.DB, id)
 	if err != nil {
-		l.Error("failed to GetFile", zap.Error(err))
-		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("failed to GetFile")})
+		l.Error("failed to GetFileByID", zap.Error(err))
+		c.Show(ctx, "failed to GetFile", c.NavRow())
+		return storage.File{}, false
+	}
+	if !file.CanRead(*user) {
+		l.Warn("file is above user clearance", zap.Int64("file_id", file.ID))
+		recordAudit(fileEvent(audit.ChannelTelegram, action, user.ID, file),
+			denied(audit.CheckClearance, fmt.Errorf("file level %d is above the user clearance", file.Level)))
+		c.Show(ctx, "Access denied", c.NavRow())
+		return storage.File{}, false
+	}
+
+	return file, true
+}
+
+func fileState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
 		return
 	}
-	raw, err := ipfs.Download(file.IpfsKey, "")
+
+	file, ok := readableFile(ctx, c, audit.ActionFileInfo)
+	if !ok {
+		return
+	}
+	recordAudit(fileEvent(audit.ChannelTelegram, audit.ActionFileInfo, user.ID, file), nil)
+
+	text := fmt.Sprintf("Name: <b>%s</b>\nType: <b>%s</b>\nSize: <b>%d</b> bytes\nLevel: <b>%d</b>\nUploaded: <b>%s</b>\n",
+		html.EscapeString(file.Name), html.EscapeString(file.MimeType), file.Size, file.Level, file.CreatedAt.Format("2006-01-02 15:04"))
+
+	policy := tgConfig.DeliveryPolicy(file.Policy)
+	if policy.MaxDownloads > 0 {
+		n, err := storage.CountDeliveries(db.DB, file.ID, user.ID)
+		if err != nil {
+			l.Error("failed to CountDeliveries", zap.Error(err))
+		} else if n < policy.MaxDownloads {
+			text += fmt.Sprintf("Downloads left: <b>%d</b>\n", policy.MaxDownloads-n)
+		} else {
+			text += "Download limit reached\n"
+		}
+	}
+	if policy.DeleteAfter > 0 {
+		text += fmt.Sprintf("The sent file is deleted after <b>%s</b>\n", policy.DeleteAfter)
+	}
+	text += "\nDownload the file?"
+
+	if err := c.Confirm(ctx, text, "get", c.Arg); err != nil {
+		l.Error("failed to Confirm", zap.Error(err))
+	}
+}
+
+func getFileState(ctx context.Context, c *dialog.Context) {
+	l := ctx.Value("logger").(*zap.Logger)
+	user, ok := dialogUser(ctx, c)
+	if !ok {
+		return
+	}
+	b := c.Bot
+
+	file, ok := readableFile(ctx, c, audit.ActionDecrypt)
+	if !ok {
+		return
+	}
+	event := fileEvent(audit.ChannelTelegram, audit.ActionDecrypt, user.ID, file)
+	if file.Type == storage.FileTypeBundle {
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: c.ChatID(), Text: "This file is a bundle, open it with the CLI"})
+		return
+	}
+
+	raw, err := blobs.Get(ctx, file.IpfsKey)
 	if err != nil {
-		l.Error("failed to Download", zap.Error(err))
-		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("failed to Download")})
+		l.Error("failed to Get", zap.Error(err))
+		recordAudit(event, err)
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: c.ChatID(), Text: fmt.Sprintf("failed to Download")})
 		return
 	}
 	decrypted, err := dec(storage.User{
@@ -181,29 +629,38 @@ func callbackFileHandler(ctx context.Context, b *bot.Bot, update *models.Update)
 		PK:         user.PK,
 		Department: user.Department,
 		Level:      user.Level,
-	}, string(raw))
+	}, file.IpfsKey, raw)
 
 	if err != nil {
 		l.Error("failed to dec", zap.Error(err))
-		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("failed to dec")})
+		recordAudit(event, err)
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: c.ChatID(), Text: fmt.Sprintf("failed to dec")})
 		return
 	}
 
-	m, err := b.SendDocument(ctx, &bot.SendDocumentParams{
-		ChatID:   data.ChatID,
-		Document: &models.InputFileUpload{Filename: file.Name, Data: bytes.NewReader([]byte(decrypted))},
-		Caption:  "Document",
-	})
-	if err != nil {
-		fmt.Println(m)
+	d, err := deliverFile(ctx, b, c.ChatID(), user, file, []byte(decrypted))
+	if errors.Is(err, storage.ErrDownloadLimit) {
+		l.Warn("download limit reached", zap.Int64("file_id", file.ID))
+		recordAudit(event, denied(audit.CheckLimit, err))
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: c.ChatID(), Text: "Download limit for this file reached"})
+		return
+	} else if err != nil {
 		l.Error("failed to send file", zap.Error(err))
-		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: fmt.Sprintf("failed to send file")})
+		recordAudit(event, err)
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: c.ChatID(), Text: fmt.Sprintf("failed to send file")})
 		return
 	}
+
+	recordAudit(event, nil)
+	l.Info("file delivered", zap.Int64("file_id", file.ID), zap.Int("delivery_id", d.ID), zap.Time("delete_at", d.DeleteAt))
 }
 
 func helloMessage(ctx context.Context, b *bot.Bot, data *pkg.TgData, l *zap.Logger) {
-	user := ctx.Value("user").(*storage.User)
+	user, ok := ctx.Value("user").(*storage.User)
+	if !ok || user == nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: adminHelp})
+		return
+	}
 	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: data.ChatID,
 		Text:   fmt.Sprintf("Hello %s\nYour department: %d\nYour level: %d\nIf there is a mistake, please contact your system adminstrator.", user.TgName, user.Department, user.Level),
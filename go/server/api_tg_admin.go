@@ -0,0 +1,760 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+
+	"server/audit"
+	"server/dialog"
+	"server/pkg"
+	"server/security"
+	"server/storage"
+)
+
+// adminPageSize is the number of lines on a page of the officer listings.
+const adminPageSize = 15
+
+const adminHelp = `Officer commands:
+/enroll @user level department - enroll a user
+/revoke id - propose revoking a user
+/setlevel id level [department] - change a user's clearance
+/users - list users
+/pending - approve or reject proposals, proposal history
+/audit - audit trail`
+
+type adminCommand func(ctx context.Context, c *dialog.Context, admin *storage.Admin, args []string)
+
+// adminCommands are the bot commands of officers with a linked Telegram account.
+var adminCommands = map[string]adminCommand{
+	"/enroll":   enrollCommand,
+	"/revoke":   revokeCommand,
+	"/setlevel": setLevelCommand,
+	"/users":    listCommand("adm_users"),
+	"/pending":  listCommand("adm_pending"),
+	"/audit":    auditCommand,
+}
+
+// registerAdminDialogs registers the dialog states of the officer commands.
+// Every state checks the officer's permissions again, as they may have changed
+// since the buttons were sent.
+func registerAdminDialogs(m *dialog.Machine) {
+	m.Register("adm_enroll", dialog.State{Enter: enrollState, Action: true})
+	m.Register("adm_revoke", dialog.State{Enter: revokeState, Action: true})
+	m.Register("adm_setlevel", dialog.State{Enter: setLevelState, Action: true})
+	m.Register("adm_users", dialog.State{Enter: usersState})
+	m.Register("adm_pending", dialog.State{Enter: pendingState})
+	m.Register("adm_proposal", dialog.State{Enter: proposalState})
+	m.Register("adm_approve", dialog.State{Enter: approveState, Action: true})
+	m.Register("adm_reject", dialog.State{Enter: rejectState, Action: true})
+	m.Register("adm_history", dialog.State{Enter: historyState})
+	m.Register("adm_audit", dialog.State{Enter: auditState})
+}
+
+// handleAdminCommand runs the officer command in the message. It reports
+// whether the message was an officer command.
+func handleAdminCommand(ctx context.Context, b *bot.Bot, update *models.Update, data *pkg.TgData) bool {
+	fields := strings.Fields(data.Text)
+	if len(fields) == 0 {
+		return false
+	}
+	// commands in groups are addressed like "/users@bot"
+	cmd, ok := adminCommands[strings.SplitN(fields[0], "@", 2)[0]]
+	if !ok {
+		return false
+	}
+
+	admin, _ := ctx.Value("admin").(*storage.Admin)
+	if admin == nil {
+		ctx.Value("logger").(*zap.Logger).Warn("officer command from non-officer")
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: "This command is available to security officers only."})
+		return true
+	}
+
+	dialogs.With(ctx, b, update, data.ChatID, func(ctx context.Context, c *dialog.Context) {
+		cmd(ctx, c, admin, fields[1:])
+	})
+
+	return true
+}
+
+// tgAdmin returns the officer the update came from.
+func tgAdmin(ctx context.Context, c *dialog.Context) (*storage.Admin, bool) {
+	admin, _ := ctx.Value("admin").(*storage.Admin)
+	if admin == nil {
+		ctx.Value("logger").(*zap.Logger).Warn("officer state without officer")
+		c.Show(ctx, "Access denied")
+		return nil, false
+	}
+
+	return admin, true
+}
+
+// showResult ends the dialog with text.
+func showResult(ctx context.Context, c *dialog.Context, text string) {
+	c.Reset()
+	if err := c.Show(ctx, text); err != nil {
+		ctx.Value("logger").(*zap.Logger).Error("failed to Show", zap.Error(err))
+	}
+}
+
+// intArgs parses the colon separated integers of a state argument.
+func intArgs(arg string, n int) ([]int, error) {
+	parts := strings.Split(arg, ":")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d values, got %d", n, len(parts))
+	}
+
+	values := make([]int, n)
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Atoi: %w", err)
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+// pageArg returns the 1-based page in a state argument.
+func pageArg(arg string) int {
+	page, err := strconv.Atoi(arg)
+	if err != nil || page < 1 {
+		return 1
+	}
+
+	return page
+}
+
+// pageOf returns the bounds of the page of n items and the number of pages.
+func pageOf(page, n, size int) (int, int, int) {
+	pages := (n + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		page = pages
+	}
+
+	from := (page - 1) * size
+	to := from + size
+	if to > n {
+		to = n
+	}
+
+	return from, to, pages
+}
+
+func userLine(u storage.User) string {
+	return fmt.Sprintf("#%d @%s, level %d, department %d, %s", u.ID, html.EscapeString(u.TgName), u.Level, u.Department, u.Status)
+}
+
+func proposalLine(p storage.Proposal) string {
+	return fmt.Sprintf("#%d %s @%s, level %d, department %d: %s", p.ID, p.Action, html.EscapeString(p.User.TgName), p.User.Level, p.User.Department, p.Status)
+}
+
+func listCommand(state string) adminCommand {
+	return func(ctx context.Context, c *dialog.Context, _ *storage.Admin, _ []string) {
+		c.Go(ctx, state, "1")
+	}
+}
+
+func enrollCommand(ctx context.Context, c *dialog.Context, admin *storage.Admin, args []string) {
+	l := ctx.Value("logger").(*zap.Logger)
+
+	if len(args) != 3 {
+		c.Show(ctx, "Usage: /enroll @user level department")
+		return
+	}
+	name := strings.TrimPrefix(args[0], "@")
+	level, errLevel := strconv.Atoi(args[1])
+	department, errDep := strconv.Atoi(args[2])
+	if name == "" || strings.Contains(name, ":") || errLevel != nil || errDep != nil || level < 0 || level > security.MaxLevel {
+		c.Show(ctx, "Usage: /enroll @user level department")
+		return
+	}
+
+	if err := canManage(admin, level, department); err != nil {
+		l.Warn("enroll denied", zap.Error(err))
+		c.Show(ctx, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	text := fmt.Sprintf("Enroll @%s at level %d in department %d?", html.EscapeString(name), level, department)
+	if requiresDualControl(storage.ProposalAdd, level) {
+		text += "\nThe enrollment needs the approval of a second officer."
+	}
+	if err := c.Confirm(ctx, text, "adm_enroll", fmt.Sprintf("%s:%d:%d", name, level, department)); err != nil {
+		l.Error("failed to Confirm", zap.Error(err))
+	}
+}
+
+func enrollState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+
+	i := strings.Index(c.Arg, ":")
+	values, err := intArgs(c.Arg[i+1:], 2)
+	if i < 1 || err != nil {
+		showResult(ctx, c, "Invalid enrollment")
+		return
+	}
+	name, level, department := c.Arg[:i], values[0], values[1]
+
+	l := ctx.Value("logger").(*zap.Logger).With(zap.String("tg_name", name), zap.Int("level", level), zap.Int("department", department))
+	event := tgAdminEvent(ctx, "/enroll", "tg_name:"+name)
+	if err := canManage(admin, level, department); err != nil {
+		l.Warn("enroll denied", zap.Error(err))
+		recordAudit(event, denied(audit.CheckRole, err))
+		showResult(ctx, c, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	e, p, err := requestEnrollment(admin, name, level, department)
+	recordAudit(event, err)
+	if err != nil {
+		l.Error("failed to requestEnrollment", zap.Error(err))
+		showResult(ctx, c, "Failed to enroll user")
+		return
+	}
+	if p != nil {
+		l.Info("enrollment proposed", zap.Int("proposal_id", p.ID))
+		showResult(ctx, c, fmt.Sprintf("Enrollment proposed as #%d. A second officer must approve it with /pending.", p.ID))
+		return
+	}
+
+	l.Info("user enrolled", zap.Int("user_id", e.ID))
+	showResult(ctx, c, fmt.Sprintf("Enrolled %s\n%s", userLine(e.User), sendEnrollmentCode(ctx, c, admin, e)))
+}
+
+// sendEnrollmentCode sends the enrollment code to the officer in a private
+// message, never to the chat the command came from, which may be a group. It
+// returns the note for that chat.
+func sendEnrollmentCode(ctx context.Context, c *dialog.Context, admin *storage.Admin, e Enrollment) string {
+	_, err := c.Bot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: admin.TgID,
+		Text: fmt.Sprintf("Enrollment code of user #%d: <code>%s</code>, valid until %s.\nThe user sends it to the bot to link their account.",
+			e.ID, e.Code, e.CodeExpiresAt.Format("2006-01-02 15:04")),
+		ParseMode: models.ParseModeHTML,
+	})
+	if err != nil {
+		ctx.Value("logger").(*zap.Logger).Error("failed to send enrollment code", zap.Int("user_id", e.ID), zap.Error(err))
+		return "Failed to send you the enrollment code, start a private chat with the bot and issue a new code."
+	}
+
+	return "The enrollment code was sent to you in a private message."
+}
+
+// commandUser loads the user named by the first argument of a command.
+func commandUser(ctx context.Context, c *dialog.Context, arg, usage string) (storage.User, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		c.Show(ctx, usage)
+		return storage.User{}, false
+	}
+
+	user, err := storage.GetUserByID(db.DB, id)
+	if err != nil {
+		ctx.Value("logger").(*zap.Logger).Warn("failed to GetUserByID", zap.Int("user_id", id), zap.Error(err))
+		c.Show(ctx, "User not found")
+		return storage.User{}, false
+	}
+
+	return user, true
+}
+
+func revokeCommand(ctx context.Context, c *dialog.Context, admin *storage.Admin, args []string) {
+	l := ctx.Value("logger").(*zap.Logger)
+
+	if len(args) != 1 {
+		c.Show(ctx, "Usage: /revoke id")
+		return
+	}
+	user, ok := commandUser(ctx, c, args[0], "Usage: /revoke id")
+	if !ok {
+		return
+	}
+
+	if err := canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("revoke denied", zap.Int("user_id", user.ID), zap.Error(err))
+		c.Show(ctx, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	text := fmt.Sprintf("Revoke %s?\nThe revocation needs the approval of a second officer.", userLine(user))
+	if err := c.Confirm(ctx, text, "adm_revoke", strconv.Itoa(user.ID)); err != nil {
+		l.Error("failed to Confirm", zap.Error(err))
+	}
+}
+
+func revokeState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	l := ctx.Value("logger").(*zap.Logger)
+
+	user, ok := commandUser(ctx, c, c.Arg, "Invalid user")
+	if !ok {
+		return
+	}
+
+	l = l.With(zap.Int("user_id", user.ID), zap.Int("level", user.Level), zap.Int("department", user.Department))
+	event := tgAdminEvent(ctx, "/revoke", "user:"+strconv.Itoa(user.ID))
+	if err := canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("delete denied", zap.Error(err))
+		recordAudit(event, denied(audit.CheckRole, err))
+		showResult(ctx, c, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	p, err := propose(admin, storage.ProposalDelete, user)
+	recordAudit(event, err)
+	if err != nil {
+		l.Error("failed to propose", zap.Error(err))
+		showResult(ctx, c, "Failed to create proposal")
+		return
+	}
+
+	l.Info("deletion proposed", zap.Int("proposal_id", p.ID))
+	showResult(ctx, c, fmt.Sprintf("Revocation proposed as #%d. A second officer must approve it with /pending.", p.ID))
+}
+
+func setLevelCommand(ctx context.Context, c *dialog.Context, admin *storage.Admin, args []string) {
+	const usage = "Usage: /setlevel id level [department]"
+	l := ctx.Value("logger").(*zap.Logger)
+
+	if len(args) != 2 && len(args) != 3 {
+		c.Show(ctx, usage)
+		return
+	}
+	user, ok := commandUser(ctx, c, args[0], usage)
+	if !ok {
+		return
+	}
+
+	level, err := strconv.Atoi(args[1])
+	if err != nil || level < 0 || level > security.MaxLevel {
+		c.Show(ctx, usage)
+		return
+	}
+	department := user.Department
+	if len(args) == 3 {
+		if department, err = strconv.Atoi(args[2]); err != nil {
+			c.Show(ctx, usage)
+			return
+		}
+	}
+
+	l = l.With(zap.Int("user_id", user.ID), zap.Int("new_level", level), zap.Int("new_department", department))
+	if err := canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("update denied", zap.Error(err))
+		c.Show(ctx, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+	if err := canManage(admin, level, department); err != nil {
+		l.Warn("update denied", zap.Error(err))
+		c.Show(ctx, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	text := fmt.Sprintf("Change the clearance of %s to level %d, department %d?", userLine(user), level, department)
+	if clearanceNeedsApproval(user, level, department) {
+		text += "\nThe change needs the approval of a second officer."
+	}
+	if err := c.Confirm(ctx, text, "adm_setlevel", fmt.Sprintf("%d:%d:%d", user.ID, level, department)); err != nil {
+		l.Error("failed to Confirm", zap.Error(err))
+	}
+}
+
+func setLevelState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+
+	values, err := intArgs(c.Arg, 3)
+	if err != nil {
+		showResult(ctx, c, "Invalid clearance change")
+		return
+	}
+	level, department := values[1], values[2]
+
+	user, ok := commandUser(ctx, c, strconv.Itoa(values[0]), "Invalid user")
+	if !ok {
+		return
+	}
+
+	l := ctx.Value("logger").(*zap.Logger).With(zap.Int("user_id", user.ID), zap.Int("new_level", level), zap.Int("new_department", department))
+	event := tgAdminEvent(ctx, "/setlevel", "user:"+strconv.Itoa(user.ID))
+	event.Level = &level
+	if err := canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("update denied", zap.Error(err))
+		recordAudit(event, denied(audit.CheckRole, err))
+		showResult(ctx, c, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+	if err := canManage(admin, level, department); err != nil {
+		l.Warn("update denied", zap.Error(err))
+		recordAudit(event, denied(audit.CheckRole, err))
+		showResult(ctx, c, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	user, p, err := requestClearance(admin, user, level, department)
+	recordAudit(event, err)
+	if err != nil {
+		l.Error("failed to requestClearance", zap.Error(err))
+		showResult(ctx, c, "Failed to update user")
+		return
+	}
+	if p != nil {
+		l.Info("clearance change proposed", zap.Int("proposal_id", p.ID))
+		showResult(ctx, c, fmt.Sprintf("Clearance change proposed as #%d. A second officer must approve it with /pending.", p.ID))
+		return
+	}
+
+	l.Info("user clearance changed")
+	showResult(ctx, c, "Updated "+userLine(user))
+}
+
+func usersState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	l := ctx.Value("logger").(*zap.Logger)
+
+	var (
+		users []storage.User
+		err   error
+	)
+	if admin.Role == storage.RoleDepartment {
+		users, err = storage.GetAllByDepartment(db.DB, admin.Department)
+	} else {
+		users, err = storage.GetAll(db.DB)
+	}
+	recordAudit(tgAdminEvent(ctx, "/users", ""), err)
+	if err != nil {
+		l.Error("failed to GetAll", zap.Error(err))
+		showResult(ctx, c, "Failed to list users")
+		return
+	}
+
+	page := pageArg(c.Arg)
+	from, to, pages := pageOf(page, len(users), adminPageSize)
+	lines := []string{fmt.Sprintf("Users: %d", len(users))}
+	for _, u := range users[from:to] {
+		lines = append(lines, userLine(u))
+	}
+
+	l.Info("users listed", zap.Int("count", len(users)))
+	if err = c.Show(ctx, strings.Join(lines, "\n"), c.PageRow("adm_users", page, pages), c.NavRow()); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+// visibleProposals returns the proposals in status the officer may see.
+func visibleProposals(admin *storage.Admin, status string) ([]storage.Proposal, error) {
+	if _, err := storage.ExpireProposals(db.DB, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to ExpireProposals: %w", err)
+	}
+
+	proposals, err := storage.GetProposals(db.DB, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetProposals: %w", err)
+	}
+
+	visible := []storage.Proposal{}
+	for _, p := range proposals {
+		if canView(admin, p.User.Department) == nil {
+			visible = append(visible, p)
+		}
+	}
+
+	return visible, nil
+}
+
+func pendingState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	l := ctx.Value("logger").(*zap.Logger)
+
+	proposals, err := visibleProposals(admin, storage.ProposalPending)
+	if err != nil {
+		l.Error("failed to visibleProposals", zap.Error(err))
+		showResult(ctx, c, "Failed to list proposals")
+		return
+	}
+
+	page := pageArg(c.Arg)
+	from, to, pages := pageOf(page, len(proposals), adminPageSize)
+	rows := [][]models.InlineKeyboardButton{}
+	for _, p := range proposals[from:to] {
+		rows = append(rows, []models.InlineKeyboardButton{
+			c.Button(fmt.Sprintf("#%d %s @%s", p.ID, p.Action, p.User.TgName), "adm_proposal", strconv.Itoa(p.ID)),
+		})
+	}
+	rows = append(rows,
+		c.PageRow("adm_pending", page, pages),
+		[]models.InlineKeyboardButton{c.Button("History", "adm_history", "1")},
+		c.NavRow(),
+	)
+
+	if err = c.Show(ctx, fmt.Sprintf("Pending proposals: %d", len(proposals)), rows...); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+// argProposal loads the pending proposal named by the state argument.
+func argProposal(ctx context.Context, c *dialog.Context) (storage.Proposal, *zap.Logger, bool) {
+	l := ctx.Value("logger").(*zap.Logger)
+
+	id, err := strconv.Atoi(c.Arg)
+	if err != nil {
+		showResult(ctx, c, "Invalid proposal")
+		return storage.Proposal{}, nil, false
+	}
+
+	p, err := storage.GetProposal(db.DB, id)
+	if err != nil {
+		l.Warn("failed to GetProposal", zap.Int("proposal_id", id), zap.Error(err))
+		showResult(ctx, c, "Proposal not found")
+		return storage.Proposal{}, nil, false
+	}
+
+	l = l.With(zap.Int("proposal_id", p.ID), zap.String("action", p.Action),
+		zap.Int("proposed_by", p.ProposedBy), zap.Int("level", p.User.Level), zap.Int("department", p.User.Department))
+	if p.Status != storage.ProposalPending {
+		showResult(ctx, c, "Proposal is "+p.Status)
+		return storage.Proposal{}, nil, false
+	}
+
+	return p, l, true
+}
+
+func proposalState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	p, l, ok := argProposal(ctx, c)
+	if !ok {
+		return
+	}
+
+	text := fmt.Sprintf("%s\nProposed by officer #%d at %s, expires at %s.", proposalLine(p), p.ProposedBy,
+		p.CreatedAt.Format("2006-01-02 15:04"), p.ExpiresAt.Format("2006-01-02 15:04"))
+	rows := [][]models.InlineKeyboardButton{}
+	if canApprove(admin, p) == nil {
+		rows = append(rows, []models.InlineKeyboardButton{
+			c.Button("Одобрить", "adm_approve", c.Arg),
+			c.Button("Отклонить", "adm_reject", c.Arg),
+		})
+	} else if canManage(admin, p.User.Level, p.User.Department) == nil {
+		text += "\nYou proposed this change, another officer must approve it."
+		rows = append(rows, []models.InlineKeyboardButton{c.Button("Отклонить", "adm_reject", c.Arg)})
+	}
+	rows = append(rows, c.NavRow())
+
+	if err := c.Show(ctx, text, rows...); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+func approveState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	p, l, ok := argProposal(ctx, c)
+	if !ok {
+		return
+	}
+
+	event := tgAdminEvent(ctx, "approve", "proposal:"+strconv.Itoa(p.ID))
+	if err := canApprove(admin, p); err != nil {
+		l.Warn("approval denied", zap.Error(err))
+		recordAudit(event, denied(audit.CheckRole, err))
+		showResult(ctx, c, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	a, err := approve(admin, p)
+	recordAudit(event, err)
+	switch {
+	case errors.Is(err, errProposalExpired):
+		l.Info("proposal expired")
+		showResult(ctx, c, "Proposal expired")
+	case errors.Is(err, storage.ErrProposalDecided):
+		showResult(ctx, c, "Proposal is already decided")
+	case err != nil:
+		l.Error("failed to execute proposal", zap.Error(err))
+		showResult(ctx, c, "Failed to execute proposal: "+html.EscapeString(err.Error()))
+	default:
+		l.Info("proposal executed", zap.String("user_id", a.Result))
+		text := fmt.Sprintf("Approved proposal #%d, user #%s", a.ID, html.EscapeString(a.Result))
+		if a.Enrollment != nil {
+			text += "\n" + sendEnrollmentCode(ctx, c, admin, *a.Enrollment)
+		}
+		showResult(ctx, c, text)
+	}
+}
+
+func rejectState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	p, l, ok := argProposal(ctx, c)
+	if !ok {
+		return
+	}
+
+	event := tgAdminEvent(ctx, "reject", "proposal:"+strconv.Itoa(p.ID))
+	if err := canManage(admin, p.User.Level, p.User.Department); err != nil {
+		l.Warn("rejection denied", zap.Error(err))
+		recordAudit(event, denied(audit.CheckRole, err))
+		showResult(ctx, c, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	err := reject(admin, p)
+	recordAudit(event, err)
+	if errors.Is(err, storage.ErrProposalDecided) {
+		showResult(ctx, c, "Proposal is already decided")
+		return
+	} else if err != nil {
+		l.Error("failed to DecideProposal", zap.Error(err))
+		showResult(ctx, c, "Failed to reject proposal")
+		return
+	}
+
+	l.Info("proposal rejected")
+	showResult(ctx, c, fmt.Sprintf("Rejected proposal #%d", p.ID))
+}
+
+func historyState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	l := ctx.Value("logger").(*zap.Logger)
+
+	proposals, err := visibleProposals(admin, "")
+	if err != nil {
+		l.Error("failed to visibleProposals", zap.Error(err))
+		showResult(ctx, c, "Failed to list proposals")
+		return
+	}
+
+	page := pageArg(c.Arg)
+	from, to, pages := pageOf(page, len(proposals), adminPageSize)
+	lines := []string{"Proposal history"}
+	for _, p := range proposals[from:to] {
+		line := proposalLine(p)
+		if p.DecidedBy != 0 {
+			line += fmt.Sprintf(" by officer #%d", p.DecidedBy)
+		}
+		lines = append(lines, line)
+	}
+
+	if err = c.Show(ctx, strings.Join(lines, "\n"), c.PageRow("adm_history", page, pages), c.NavRow()); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
+
+// auditCommand opens the audit trail from its start.
+func auditCommand(ctx context.Context, c *dialog.Context, _ *storage.Admin, _ []string) {
+	c.Go(ctx, "adm_audit", "0")
+}
+
+func auditEventLine(e audit.Event) string {
+	line := fmt.Sprintf("#%d %s %s %s", e.Seq, e.Time.UTC().Format("2006-01-02 15:04:05"), e.Channel, html.EscapeString(e.Action))
+	if e.Target != "" {
+		line += " " + html.EscapeString(e.Target)
+	}
+	if e.FileID != 0 {
+		line += fmt.Sprintf(" file #%d", e.FileID)
+	}
+	switch {
+	case e.UserID != 0:
+		line += fmt.Sprintf(" by user #%d", e.UserID)
+	case e.AdminID != 0:
+		line += fmt.Sprintf(" by officer #%d", e.AdminID)
+	}
+	line += ", " + e.Decision
+	if e.Check != "" {
+		line += " (" + e.Check + ")"
+	}
+
+	return line
+}
+
+// auditState shows the page of the audit trail after the seq in its argument,
+// to the roles that may read it over HTTP as well.
+func auditState(ctx context.Context, c *dialog.Context) {
+	admin, ok := tgAdmin(ctx, c)
+	if !ok {
+		return
+	}
+	l := ctx.Value("logger").(*zap.Logger)
+
+	event := tgAdminEvent(ctx, "/audit", "")
+	if err := hasRole(admin, auditRoles...); err != nil {
+		l.Warn("audit denied", zap.Error(err))
+		recordAudit(event, denied(audit.CheckRole, err))
+		showResult(ctx, c, "Access denied: "+html.EscapeString(err.Error()))
+		return
+	}
+
+	after, err := strconv.ParseInt(c.Arg, 10, 64)
+	if err != nil || after < 0 {
+		after = 0
+	}
+	// one more event tells whether there is a next page
+	events, err := storage.ListAuditEvents(db.DB, storage.AuditFilter{AfterSeq: after, Limit: adminPageSize + 1})
+	recordAudit(event, err)
+	if err != nil {
+		l.Error("failed to ListAuditEvents", zap.Error(err))
+		showResult(ctx, c, "Failed to list audit events")
+		return
+	}
+
+	more := len(events) > adminPageSize
+	if more {
+		events = events[:adminPageSize]
+	}
+	lines := []string{"Audit trail"}
+	if len(events) == 0 {
+		lines = append(lines, "No more events")
+	}
+	for _, e := range events {
+		lines = append(lines, auditEventLine(e))
+	}
+
+	rows := [][]models.InlineKeyboardButton{}
+	if more {
+		next := strconv.FormatInt(events[len(events)-1].Seq, 10)
+		rows = append(rows, []models.InlineKeyboardButton{c.Button("›", "adm_audit", next)})
+	}
+	rows = append(rows, c.NavRow())
+
+	l.Info("audit listed", zap.Int64("after", after))
+	if err = c.Show(ctx, strings.Join(lines, "\n"), rows...); err != nil {
+		l.Error("failed to Show", zap.Error(err))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/api"
+	"server/audit"
+	"server/security"
+	"server/storage"
+)
+
+// Handler
+//
+// refreshWitness reissues the caller's witnesses against the current
+// accumulators, which change whenever a member joins or leaves, and returns
+// them.
+func refreshWitness(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+	event := audit.Event{Channel: audit.ChannelHTTP, UserID: user.ID, Action: audit.ActionWitness}
+
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return fmt.Errorf("failed to DecodeString data: %w", err)
+	}
+
+	membershipMu.Lock()
+	witLevel, witDep, err := security.Witness(user.Level, user.Department, data)
+	if err == nil {
+		w := storage.Witness{ID: user.PK, WitnessLevel: base64.StdEncoding.EncodeToString(witLevel), WitnessDep: base64.StdEncoding.EncodeToString(witDep)}
+		err = storage.SetWitness(db.DB, w)
+	}
+	membershipMu.Unlock()
+	recordAudit(event, err)
+	if err != nil {
+		return fmt.Errorf("failed to refresh witness: %w", err)
+	}
+	c.Get("logger").(*zap.Logger).Info("witness refreshed")
+
+	return c.JSON(http.StatusOK, api.Witness{
+		WitnessLevel: base64.StdEncoding.EncodeToString(witLevel),
+		WitnessDep:   base64.StdEncoding.EncodeToString(witDep),
+	})
+}
+
+// Handler
+//
+// prove checks the witnesses the caller presents against the accumulators of
+// their level and department.
+func prove(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	var req api.Witness
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+	witLevel, err := base64.StdEncoding.DecodeString(req.WitnessLevel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid level witness")
+	}
+	witDep, err := base64.StdEncoding.DecodeString(req.WitnessDep)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid department witness")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return fmt.Errorf("failed to DecodeString data: %w", err)
+	}
+
+	level := user.Level
+	event := audit.Event{Channel: audit.ChannelHTTP, UserID: user.ID, Action: audit.ActionProve, Level: &level}
+	// the witnesses must be issued for the caller, not another member
+	if err = security.Check(user.Level, user.Department, data, witLevel, witDep); err != nil {
+		err = denied(audit.CheckWitness, fmt.Errorf("failed to Check: %w", err))
+		recordAudit(event, err)
+		return err
+	}
+	recordAudit(event, nil)
+
+	return c.JSON(http.StatusOK, apiUser(*user))
+}
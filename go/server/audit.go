@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/api"
+	"server/audit"
+	"server/config"
+	"server/storage"
+)
+
+// deniedError is a failed access check. The check is recorded in the audit
+// trail.
+type deniedError struct {
+	check string
+	err   error
+}
+
+func (e *deniedError) Error() string { return e.err.Error() }
+
+func (e *deniedError) Unwrap() error { return e.err }
+
+func denied(check string, err error) error {
+	return &deniedError{check: check, err: err}
+}
+
+// auditKey seals the events of the audit trail.
+var auditKey []byte
+
+// recordAudit appends the event with the decision taken from err. The trail
+// must not stop the action it records, so failures are only logged.
+func recordAudit(e audit.Event, err error) {
+	var d *deniedError
+	switch {
+	case err == nil:
+		e.Decision = audit.Allowed
+	case errors.As(err, &d):
+		e.Decision, e.Check, e.Reason = audit.Denied, d.check, err.Error()
+	default:
+		e.Decision, e.Reason = audit.Failed, err.Error()
+	}
+
+	if _, err := storage.AppendAuditEvent(db.DB, auditKey, e); err != nil {
+		zap.L().Error("failed to AppendAuditEvent", zap.String("action", e.Action), zap.String("decision", e.Decision), zap.Error(err))
+	}
+}
+
+// fileEvent returns an event about the file.
+func fileEvent(channel, action string, userID int, file storage.File) audit.Event {
+	level := file.Level
+	return audit.Event{Channel: channel, UserID: userID, Action: action, FileID: file.ID, CID: file.IpfsKey, Level: &level}
+}
+
+// tgAdminEvent returns an event about an officer action taken in the bot.
+func tgAdminEvent(ctx context.Context, action, target string) audit.Event {
+	e := audit.Event{Channel: audit.ChannelTelegram, Action: action, Target: target}
+	if admin, ok := ctx.Value("admin").(*storage.Admin); ok {
+		e.AdminID = admin.ID
+	}
+
+	return e
+}
+
+// auditAdmin records every officer request. It runs before requireAdmin so
+// that rejected tokens are recorded as well.
+func auditAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+
+		e := audit.Event{Channel: audit.ChannelHTTP, Action: c.Request().Method + " " + c.Path()}
+		admin, _ := c.Get("admin").(*storage.Admin)
+		if admin != nil {
+			e.AdminID = admin.ID
+		}
+		if id := c.Param("id"); id != "" {
+			target := "user"
+			if strings.HasPrefix(c.Path(), "/admin/proposals/") {
+				target = "proposal"
+			}
+			e.Target = target + ":" + id
+		}
+
+		code, resp := c.Response().Status, api.ResponseError{Message: http.StatusText(c.Response().Status)}
+		if err != nil {
+			code, resp = errorResponse(err)
+		}
+
+		var decision error
+		switch {
+		case code == http.StatusForbidden && admin == nil:
+			decision = denied(audit.CheckAuth, errors.New(resp.Message))
+		case code == http.StatusForbidden:
+			decision = denied(audit.CheckRole, errors.New(resp.Message))
+		case code >= http.StatusBadRequest:
+			decision = errors.New(resp.Message)
+		}
+		recordAudit(e, decision)
+
+		return err
+	}
+}
+
+// Handler
+func getAuditEvents(c echo.Context) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if filter.Limit == 0 || filter.Limit > maxPerPage {
+		filter.Limit = maxPerPage
+	}
+
+	events, err := storage.ListAuditEvents(db.DB, filter)
+	if err != nil {
+		return fmt.Errorf("failed to ListAuditEvents: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+// exportBatch is the number of events read at once by the export.
+const exportBatch = 1000
+
+// Handler
+//
+// exportAuditEvents streams the whole trail after the given seq as JSON lines
+// for the verify-audit command.
+func exportAuditEvents(c echo.Context) error {
+	var after int64
+	if v := c.QueryParam("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid after")
+		}
+		after = n
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	c.Response().WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(c.Response())
+	for {
+		events, err := storage.ListAuditEvents(db.DB, storage.AuditFilter{AfterSeq: after, Limit: exportBatch})
+		if err != nil {
+			// the status is sent, so a broken export ends without its tail
+			c.Get("logger").(*zap.Logger).Error("failed to ListAuditEvents", zap.Error(err))
+			return nil
+		}
+		for _, e := range events {
+			if err = enc.Encode(e); err != nil {
+				return nil
+			}
+		}
+		if len(events) < exportBatch {
+			return nil
+		}
+		after = events[len(events)-1].Seq
+		c.Response().Flush()
+	}
+}
+
+func parseAuditFilter(c echo.Context) (storage.AuditFilter, error) {
+	q := c.QueryParams()
+	filter := storage.AuditFilter{
+		Channel:  q.Get("channel"),
+		Action:   q.Get("action"),
+		Decision: q.Get("decision"),
+	}
+
+	ints := map[string]*int{"user": &filter.UserID, "admin": &filter.AdminID, "limit": &filter.Limit}
+	for name, dst := range ints {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return storage.AuditFilter{}, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	int64s := map[string]*int64{"file": &filter.FileID, "after": &filter.AfterSeq}
+	for name, dst := range int64s {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return storage.AuditFilter{}, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = n
+		}
+	}
+
+	var err error
+	if filter.From, err = parseDate(q.Get("from"), false); err != nil {
+		return storage.AuditFilter{}, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseDate(q.Get("to"), true); err != nil {
+		return storage.AuditFilter{}, fmt.Errorf("invalid to: %w", err)
+	}
+
+	return filter, nil
+}
+
+// verifyAudit checks an export of the audit trail offline with the audit key
+// of the config:
+//
+//	server verify-audit [-config FILE] [-head HASH] [FILE]
+//
+// It reads standard input without FILE. The export has to start with the
+// first event, or with -head continue the chain from a head printed by an
+// earlier run.
+func verifyAudit(args []string) int {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	cfgPath := flags.String("config", "./build/config.yaml", "path to config file")
+	head := flags.String("head", "", "head printed by an earlier run")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+
+	cfg, err := config.NewConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	key := cfg.Audit.Key.Reveal()
+	if key == "" {
+		fmt.Fprintln(os.Stderr, "audit.key is required")
+		return 2
+	}
+
+	in := os.Stdin
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		in = f
+	}
+
+	v, err := audit.Verify(bufio.NewReader(in), []byte(key), *head)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verification failed after %d events: %v\n", v.Count, err)
+		return 1
+	}
+
+	fmt.Printf("verified %d events\nhead: %s\n", v.Count, v.Head)
+	return 0
+}
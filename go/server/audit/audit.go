@@ -0,0 +1,169 @@
+// Package audit defines the tamper-evident audit trail of the server. Every
+// event is chained to the one before it by an HMAC-Stribog-512 over its fields
+// and the previous hash, so changing, removing or reordering stored events
+// breaks the chain, and without the key the chain can't be resealed.
+package audit
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"server/stribog"
+)
+
+// Channels the actions come through.
+const (
+	ChannelHTTP     = "http"
+	ChannelTelegram = "telegram"
+)
+
+// Actions of users. Officer actions are named by their route or bot command.
+const (
+	ActionEncrypt  = "encrypt"
+	ActionDecrypt  = "decrypt"
+	ActionList     = "list"
+	ActionFileInfo = "file_info"
+	ActionDelete   = "delete"
+	// ActionWitness reissues the user's witnesses, ActionProve verifies the
+	// ones they present.
+	ActionWitness = "witness"
+	ActionProve   = "prove"
+)
+
+// Decisions.
+const (
+	Allowed = "allowed"
+	Denied  = "denied"
+	// Failed is recorded when the action was allowed but couldn't be completed.
+	Failed = "failed"
+)
+
+// Checks that deny an action.
+const (
+	CheckPK        = "pk"
+	CheckWitness   = "witness"
+	CheckABE       = "abe"
+	CheckClearance = "clearance"
+	CheckLimit     = "limit"
+	CheckAuth      = "auth"
+	// CheckRole covers the role and the department scope of an officer.
+	CheckRole = "role"
+	// CheckOwner is the check that only the uploader deletes a file.
+	CheckOwner = "owner"
+)
+
+// ErrBrokenChain is returned when the events don't form an unbroken chain.
+var ErrBrokenChain = errors.New("audit chain is broken")
+
+// Event is an entry of the audit trail.
+type Event struct {
+	// Seq numbers the events from 1 without gaps.
+	Seq     int64
+	Time    time.Time
+	Channel string
+	UserID  int
+	AdminID int
+	Action  string
+	// Target names what an officer action is about, like "user:6".
+	Target string
+	FileID int64
+	CID    string
+	// Level is the classification of the file, nil if there is no file.
+	Level    *int
+	Decision string
+	Check    string
+	Reason   string
+	PrevHash string
+	Hash     string
+}
+
+// Digest returns the MAC of the event chained to PrevHash under the key. Time
+// is hashed in UTC with the microsecond precision Postgres stores.
+func (e Event) Digest(key []byte) string {
+	level := "-"
+	if e.Level != nil {
+		level = strconv.Itoa(*e.Level)
+	}
+
+	h := hmac.New(stribog.New512, key)
+	fmt.Fprintf(h, "%d\n%s\n%q\n%d\n%d\n%q\n%q\n%d\n%q\n%s\n%q\n%q\n%q\n%q",
+		e.Seq, e.Time.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano), e.Channel, e.UserID, e.AdminID,
+		e.Action, e.Target, e.FileID, e.CID, level, e.Decision, e.Check, e.Reason, e.PrevHash)
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Chain links the event to the previous one and seals it with the key.
+func Chain(prev *Event, e Event, key []byte) Event {
+	e.Seq, e.PrevHash = 1, ""
+	if prev != nil {
+		e.Seq, e.PrevHash = prev.Seq+1, prev.Hash
+	}
+	e.Time = e.Time.UTC().Truncate(time.Microsecond)
+	e.Hash = e.Digest(key)
+
+	return e
+}
+
+// Verifier checks events in order. The events have to start with the first
+// one, or continue from Start, the head kept from an earlier verification.
+type Verifier struct {
+	// Key is the key the events are sealed with.
+	Key []byte
+	// Start is the hash the verified events are chained to, empty when they
+	// start with the first event.
+	Start string
+	// Head is the hash of the last verified event.
+	Head  string
+	Count int
+	last  int64
+}
+
+// Add checks the event against the events added before.
+func (v *Verifier) Add(e Event) error {
+	switch {
+	case v.Count == 0 && e.Seq == 1 && e.PrevHash != "":
+		return fmt.Errorf("%w: event 1 has a previous hash", ErrBrokenChain)
+	case v.Count == 0 && v.Start == "" && e.Seq != 1:
+		return fmt.Errorf("%w: the events start with event %d instead of 1", ErrBrokenChain, e.Seq)
+	case v.Count == 0 && e.PrevHash != v.Start:
+		return fmt.Errorf("%w: event %d doesn't continue from head %s", ErrBrokenChain, e.Seq, v.Start)
+	case v.Count > 0 && e.Seq != v.last+1:
+		return fmt.Errorf("%w: event %d follows event %d", ErrBrokenChain, e.Seq, v.last)
+	case v.Count > 0 && e.PrevHash != v.Head:
+		return fmt.Errorf("%w: event %d isn't chained to event %d", ErrBrokenChain, e.Seq, v.last)
+	case !hmac.Equal([]byte(e.Digest(v.Key)), []byte(e.Hash)):
+		return fmt.Errorf("%w: event %d was modified", ErrBrokenChain, e.Seq)
+	}
+
+	v.Head, v.last = e.Hash, e.Seq
+	v.Count++
+
+	return nil
+}
+
+// Verify checks a stream of JSON encoded events, like the export of
+// GET /admin/audit/export, sealed with the key. Without a head the stream has
+// to start with the first event.
+func Verify(r io.Reader, key []byte, head string) (Verifier, error) {
+	v := Verifier{Key: key, Start: head}
+
+	d := json.NewDecoder(r)
+	for {
+		var e Event
+		if err := d.Decode(&e); err == io.EOF {
+			return v, nil
+		} else if err != nil {
+			return v, fmt.Errorf("failed to Decode event %d: %w", v.Count+1, err)
+		}
+		if err := v.Add(e); err != nil {
+			return v, err
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = []byte("audit-key")
+
+func chain(t *testing.T, n int) []Event {
+	t.Helper()
+
+	level := 2
+	events := make([]Event, 0, n)
+	for i := 0; i < n; i++ {
+		e := Event{
+			Time:     time.Date(2024, 1, 1, 12, 0, i, 123456789, time.FixedZone("MSK", 3*60*60)),
+			Channel:  ChannelHTTP,
+			UserID:   6,
+			Action:   ActionDecrypt,
+			FileID:   int64(i + 1),
+			CID:      "QmHash",
+			Level:    &level,
+			Decision: Allowed,
+		}
+		var prev *Event
+		if i > 0 {
+			prev = &events[i-1]
+		}
+		events = append(events, Chain(prev, e, testKey))
+	}
+
+	return events
+}
+
+func encode(t *testing.T, events []Event) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		require.NoError(t, enc.Encode(e))
+	}
+
+	return &buf
+}
+
+func TestVerify(t *testing.T) {
+	events := chain(t, 5)
+	require.Equal(t, int64(5), events[4].Seq)
+	require.Equal(t, events[3].Hash, events[4].PrevHash)
+
+	v, err := Verify(encode(t, events), testKey, "")
+	require.NoError(t, err)
+	require.Equal(t, 5, v.Count)
+	require.Equal(t, "", v.Start)
+	require.Equal(t, events[4].Hash, v.Head)
+
+	// a tail of the chain only verifies from the head kept before it
+	v, err = Verify(encode(t, events[2:]), testKey, events[1].Hash)
+	require.NoError(t, err)
+	require.Equal(t, events[4].Hash, v.Head)
+	_, err = Verify(encode(t, events[2:]), testKey, "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+	_, err = Verify(encode(t, events[2:]), testKey, events[0].Hash)
+	require.ErrorIs(t, err, ErrBrokenChain)
+}
+
+func TestVerifyKey(t *testing.T) {
+	events := chain(t, 3)
+
+	_, err := Verify(encode(t, events), []byte("another-key"), "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+
+	// a chain rewritten without the key doesn't verify
+	forged := make([]Event, 0, len(events))
+	for i, e := range events {
+		var prev *Event
+		if i > 0 {
+			prev = &forged[i-1]
+		}
+		e.Decision = Denied
+		forged = append(forged, Chain(prev, e, nil))
+	}
+	_, err = Verify(encode(t, forged), testKey, "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+}
+
+func TestVerifyTampered(t *testing.T) {
+	events := chain(t, 4)
+
+	modified := append([]Event{}, events...)
+	modified[1].Decision = Denied
+	_, err := Verify(encode(t, modified), testKey, "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+
+	// resealing the modified event breaks the link to the next one
+	modified[1].Hash = modified[1].Digest(testKey)
+	_, err = Verify(encode(t, modified), testKey, "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+
+	removed := append(append([]Event{}, events[:1]...), events[2:]...)
+	_, err = Verify(encode(t, removed), testKey, "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+
+	reordered := []Event{events[0], events[2], events[1], events[3]}
+	_, err = Verify(encode(t, reordered), testKey, "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+
+	level := 3
+	relabeled := append([]Event{}, events...)
+	relabeled[2].Level = &level
+	_, err = Verify(encode(t, relabeled), testKey, "")
+	require.ErrorIs(t, err, ErrBrokenChain)
+}
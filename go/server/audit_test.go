@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"server/audit"
+)
+
+func writeExport(t *testing.T, events []audit.Event) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range events {
+		require.NoError(t, enc.Encode(e))
+	}
+
+	return path
+}
+
+func TestVerifyAudit(t *testing.T) {
+	key := []byte("audit-key-value")
+	var events []audit.Event
+	var prev *audit.Event
+	for i, action := range []string{audit.ActionEncrypt, audit.ActionList, audit.ActionDecrypt} {
+		e := audit.Chain(prev, audit.Event{Time: time.Now(), Channel: audit.ChannelTelegram, UserID: 6, Action: action, Decision: audit.Allowed}, key)
+		events = append(events, e)
+		prev = &events[i]
+	}
+
+	cfgPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(cfgPath, []byte("audit:\n  key: \""+string(key)+"\"\n"), 0o600))
+	verify := func(args ...string) int {
+		return verifyAudit(append([]string{"-config", cfgPath}, args...))
+	}
+
+	require.Equal(t, 0, verify(writeExport(t, events)))
+	require.Equal(t, 0, verify("-head", events[0].Hash, writeExport(t, events[1:])))
+	require.Equal(t, 1, verify("-head", events[1].Hash, writeExport(t, events[1:])))
+	// a tail needs the head it continues from
+	require.Equal(t, 1, verify(writeExport(t, events[1:])))
+
+	events[1].Decision = audit.Denied
+	require.Equal(t, 1, verify(writeExport(t, events)))
+
+	require.Equal(t, 2, verify(filepath.Join(t.TempDir(), "missing.jsonl")))
+	require.Equal(t, 2, verifyAudit([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml"), writeExport(t, events)}))
+}
+
+func TestAuditEventLine(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	require.Equal(t, "#7 2024-03-01 12:30:00 http decrypt file #4 by user #2, denied (witness)", auditEventLine(audit.Event{
+		Seq: 7, Time: at, Channel: audit.ChannelHTTP, UserID: 2, Action: "decrypt", FileID: 4, Decision: audit.Denied, Check: "witness",
+	}))
+	require.Equal(t, "#8 2024-03-01 12:30:00 telegram reject proposal:3 by officer #1, allowed", auditEventLine(audit.Event{
+		Seq: 8, Time: at, Channel: audit.ChannelTelegram, AdminID: 1, Action: "reject", Target: "proposal:3", Decision: audit.Allowed,
+	}))
+}
@@ -0,0 +1,138 @@
+// Package bundle defines the format of multi-file bundles. Each entry of a
+// bundle is encrypted under the policy of its own classification level, the
+// manifest listing the entries is encrypted at the lowest level among them, and
+// a dag-json root node links the manifest and the entries, so the whole bundle
+// is one IPFS DAG. Recipients read the manifest and open only the entries their
+// clearance allows.
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Version is the manifest version written by this package.
+const Version = 1
+
+// ErrInvalidBundle is returned for manifests and root nodes that don't follow
+// the format.
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// Entry is a file of the bundle.
+type Entry struct {
+	// Path is relative and slash separated, like "annex/report.pdf".
+	Path     string
+	Level    int
+	MimeType string
+	Size     int64
+	// Link is the IPFS path of the entry ciphertext.
+	Link string
+}
+
+// Manifest lists the entries of a bundle. It is readable by everyone who can
+// open the lowest entry, so the paths and levels of the other entries aren't
+// secret to them; their content is.
+type Manifest struct {
+	Version int
+	Entries []Entry
+}
+
+// CheckPath checks that p is a clean relative path that stays inside the
+// directory the bundle is unpacked to.
+func CheckPath(p string) error {
+	switch {
+	case p == "" || p == ".":
+		return fmt.Errorf("%w: empty entry path", ErrInvalidBundle)
+	case strings.HasPrefix(p, "/") || strings.Contains(p, `\`):
+		return fmt.Errorf("%w: entry path %q isn't relative", ErrInvalidBundle, p)
+	case path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../"):
+		return fmt.Errorf("%w: entry path %q isn't clean", ErrInvalidBundle, p)
+	}
+
+	return nil
+}
+
+// Validate checks the version and the entry paths, which have to be unique.
+func (m Manifest) Validate() error {
+	if m.Version != Version {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, m.Version)
+	}
+	if len(m.Entries) == 0 {
+		return fmt.Errorf("%w: no entries", ErrInvalidBundle)
+	}
+
+	seen := make(map[string]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		if err := CheckPath(e.Path); err != nil {
+			return err
+		}
+		if seen[e.Path] {
+			return fmt.Errorf("%w: duplicate entry %q", ErrInvalidBundle, e.Path)
+		}
+		seen[e.Path] = true
+	}
+
+	return nil
+}
+
+// Level is the lowest level of the entries, the one the manifest is encrypted
+// at.
+func (m Manifest) Level() int {
+	level := m.Entries[0].Level
+	for _, e := range m.Entries[1:] {
+		if e.Level < level {
+			level = e.Level
+		}
+	}
+
+	return level
+}
+
+// Root is the dag-json node of a bundle. Its links are CIDs.
+type Root struct {
+	Manifest string
+	Entries  []string
+}
+
+// link is a dag-json link.
+type link struct {
+	CID string `json:"/"`
+}
+
+// rootNode is the dag-json form of Root. dag-json sorts the keys, so the
+// fields are in order.
+type rootNode struct {
+	Entries  []link `json:"entries"`
+	Manifest link   `json:"manifest"`
+}
+
+// MarshalJSON encodes the root as a dag-json node.
+func (r Root) MarshalJSON() ([]byte, error) {
+	node := rootNode{Manifest: link{r.Manifest}, Entries: make([]link, len(r.Entries))}
+	for i, c := range r.Entries {
+		node.Entries[i] = link{c}
+	}
+
+	return json.Marshal(node)
+}
+
+func (r *Root) UnmarshalJSON(data []byte) error {
+	var node rootNode
+	if err := json.Unmarshal(data, &node); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if node.Manifest.CID == "" {
+		return fmt.Errorf("%w: no manifest link", ErrInvalidBundle)
+	}
+
+	r.Manifest = node.Manifest.CID
+	r.Entries = make([]string, len(node.Entries))
+	for i, l := range node.Entries {
+		r.Entries[i] = l.CID
+	}
+
+	return nil
+}
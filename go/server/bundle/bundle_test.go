@@ -0,0 +1,153 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/ipfs"
+)
+
+// sealer stores the data behind a level byte instead of encrypting it. It
+// fails after limit seals when limit is set.
+type sealer struct {
+	store ipfs.BlobStore
+	limit int
+	calls int
+}
+
+func (s *sealer) seal(ctx context.Context, level int, data []byte) (string, error) {
+	s.calls++
+	if s.limit > 0 && s.calls > s.limit {
+		return "", errors.New("seal failed")
+	}
+
+	return s.store.Put(ctx, append([]byte{byte(level)}, data...))
+}
+
+// opener opens the content of levels up to clearance.
+func opener(store ipfs.BlobStore, clearance int) OpenFunc {
+	return func(ctx context.Context, link string) ([]byte, error) {
+		data, err := store.Get(ctx, link)
+		if err != nil {
+			return nil, err
+		}
+		if int(data[0]) > clearance {
+			return nil, fmt.Errorf("level %d is above the clearance", data[0])
+		}
+
+		return data[1:], nil
+	}
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	store := ipfs.NewMemoryStore()
+	s := &sealer{store: store}
+
+	files := []File{
+		{Path: "summary.txt", Level: 1, MimeType: "text/plain", Data: []byte("summary")},
+		{Path: "annex/report.pdf", Level: 3, MimeType: "application/pdf", Data: []byte("report")},
+	}
+	root, links, m, err := Create(ctx, store, s.seal, files)
+	require.NoError(t, err)
+	require.Len(t, links, 3)
+	require.Equal(t, 1, m.Level())
+
+	// the manifest is sealed at the lowest level
+	manifest, err := store.Get(ctx, links[2])
+	require.NoError(t, err)
+	require.Equal(t, byte(1), manifest[0])
+
+	read, err := ReadManifest(ctx, store, opener(store, 1), root)
+	require.NoError(t, err)
+	require.Equal(t, m, read)
+	require.Equal(t, "annex/report.pdf", read.Entries[1].Path)
+	require.Equal(t, int64(6), read.Entries[1].Size)
+
+	_, err = opener(store, 1)(ctx, read.Entries[1].Link)
+	require.Error(t, err)
+	data, err := opener(store, 3)(ctx, read.Entries[1].Link)
+	require.NoError(t, err)
+	require.Equal(t, "report", string(data))
+
+	_, err = ReadManifest(ctx, store, opener(store, 0), root)
+	require.Error(t, err)
+
+	pins, err := store.Pins(ctx)
+	require.NoError(t, err)
+	require.Len(t, pins, 4)
+}
+
+func TestCreateCleanup(t *testing.T) {
+	ctx := context.Background()
+	store := ipfs.NewMemoryStore()
+
+	_, _, _, err := Create(ctx, store, (&sealer{store: store, limit: 2}).seal, []File{
+		{Path: "a", Data: []byte("a")},
+		{Path: "b", Data: []byte("b")},
+	})
+	require.Error(t, err)
+	pins, err := store.Pins(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pins)
+}
+
+func TestReadManifestMismatch(t *testing.T) {
+	ctx := context.Background()
+	store := ipfs.NewMemoryStore()
+	s := &sealer{store: store}
+
+	root, links, _, err := Create(ctx, store, s.seal, []File{{Path: "a", Data: []byte("a")}})
+	require.NoError(t, err)
+
+	// a root that links other content than the manifest lists
+	other, err := s.seal(ctx, 0, []byte("other"))
+	require.NoError(t, err)
+	manifest, err := ipfs.CID(links[1])
+	require.NoError(t, err)
+	entry, err := ipfs.CID(other)
+	require.NoError(t, err)
+	node, err := json.Marshal(Root{Manifest: manifest, Entries: []string{entry}})
+	require.NoError(t, err)
+	forged, err := store.PutDAG(ctx, node)
+	require.NoError(t, err)
+	require.NotEqual(t, root, forged)
+
+	_, err = ReadManifest(ctx, store, opener(store, 0), forged)
+	require.ErrorIs(t, err, ErrInvalidBundle)
+}
+
+func TestManifestValidate(t *testing.T) {
+	for _, p := range []string{"", ".", "/etc/passwd", "../x", "a/../../x", "a//b", `a\b`, "a/"} {
+		require.ErrorIs(t, CheckPath(p), ErrInvalidBundle, p)
+	}
+	for _, p := range []string{"a", "a/b.txt", "..a"} {
+		require.NoError(t, CheckPath(p), p)
+	}
+
+	m := Manifest{Version: Version, Entries: []Entry{{Path: "a"}, {Path: "a"}}}
+	require.ErrorIs(t, m.Validate(), ErrInvalidBundle)
+	m.Entries[1].Path = "b"
+	require.NoError(t, m.Validate())
+	m.Version = 2
+	require.ErrorIs(t, m.Validate(), ErrInvalidBundle)
+	require.ErrorIs(t, Manifest{Version: Version}.Validate(), ErrInvalidBundle)
+}
+
+func TestRootDagJSON(t *testing.T) {
+	node, err := json.Marshal(Root{Manifest: "m", Entries: []string{"a", "b"}})
+	require.NoError(t, err)
+	require.Equal(t, `{"entries":[{"/":"a"},{"/":"b"}],"manifest":{"/":"m"}}`, string(node))
+	require.False(t, bytes.Contains(node, []byte(" ")))
+
+	var root Root
+	require.NoError(t, json.Unmarshal(node, &root))
+	require.Equal(t, Root{Manifest: "m", Entries: []string{"a", "b"}}, root)
+	require.ErrorIs(t, json.Unmarshal([]byte(`{"entries":[]}`), &root), ErrInvalidBundle)
+}
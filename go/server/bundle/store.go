@@ -0,0 +1,129 @@
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"server/ipfs"
+)
+
+// File is the content of an entry to bundle.
+type File struct {
+	Path     string
+	Level    int
+	MimeType string
+	Data     []byte
+}
+
+// SealFunc encrypts data at the classification level, stores it and returns
+// its link.
+type SealFunc func(ctx context.Context, level int, data []byte) (string, error)
+
+// OpenFunc loads the content under link and decrypts it.
+type OpenFunc func(ctx context.Context, link string) ([]byte, error)
+
+// Create seals every file and the manifest and links them with a root node. It
+// returns the root link and the links of the manifest and the entries. Nothing
+// stays pinned when it fails.
+func Create(ctx context.Context, store ipfs.BlobStore, seal SealFunc, files []File) (string, []string, Manifest, error) {
+	m := Manifest{Version: Version, Entries: make([]Entry, len(files))}
+	for i, f := range files {
+		m.Entries[i] = Entry{Path: f.Path, Level: f.Level, MimeType: f.MimeType, Size: int64(len(f.Data))}
+	}
+	if err := m.Validate(); err != nil {
+		return "", nil, Manifest{}, err
+	}
+
+	var links []string
+	cleanup := func() {
+		for _, link := range links {
+			if err := store.Unpin(ctx, link); err != nil {
+				zap.L().Error("failed to Unpin", zap.String("cid", link), zap.Error(err))
+			}
+		}
+	}
+
+	root := Root{Entries: make([]string, len(files))}
+	for i, f := range files {
+		link, err := seal(ctx, f.Level, f.Data)
+		if err != nil {
+			cleanup()
+			return "", nil, Manifest{}, fmt.Errorf("failed to seal %s: %w", f.Path, err)
+		}
+		links = append(links, link)
+		m.Entries[i].Link = link
+		if root.Entries[i], err = ipfs.CID(link); err != nil {
+			cleanup()
+			return "", nil, Manifest{}, err
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		cleanup()
+		return "", nil, Manifest{}, fmt.Errorf("failed to Marshal manifest: %w", err)
+	}
+	link, err := seal(ctx, m.Level(), data)
+	if err != nil {
+		cleanup()
+		return "", nil, Manifest{}, fmt.Errorf("failed to seal manifest: %w", err)
+	}
+	links = append(links, link)
+	if root.Manifest, err = ipfs.CID(link); err != nil {
+		cleanup()
+		return "", nil, Manifest{}, err
+	}
+
+	node, err := json.Marshal(root)
+	if err != nil {
+		cleanup()
+		return "", nil, Manifest{}, fmt.Errorf("failed to Marshal root: %w", err)
+	}
+	rootLink, err := store.PutDAG(ctx, node)
+	if err != nil {
+		cleanup()
+		return "", nil, Manifest{}, fmt.Errorf("failed to PutDAG: %w", err)
+	}
+
+	return rootLink, links, m, nil
+}
+
+// ReadManifest loads the root node and opens the manifest. The entries of the
+// manifest have to be the ones the root links to.
+func ReadManifest(ctx context.Context, store ipfs.BlobStore, open OpenFunc, rootLink string) (Manifest, error) {
+	node, err := store.Get(ctx, rootLink)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to Get root: %w", err)
+	}
+	var root Root
+	if err = json.Unmarshal(node, &root); err != nil {
+		return Manifest{}, err
+	}
+
+	// content is stored under its IPFS path
+	data, err := open(ctx, "/ipfs/"+root.Manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if err = m.Validate(); err != nil {
+		return Manifest{}, err
+	}
+
+	if len(m.Entries) != len(root.Entries) {
+		return Manifest{}, fmt.Errorf("%w: the manifest lists %d entries, the root links %d", ErrInvalidBundle, len(m.Entries), len(root.Entries))
+	}
+	for i, e := range m.Entries {
+		if c, err := ipfs.CID(e.Link); err != nil || c != root.Entries[i] {
+			return Manifest{}, fmt.Errorf("%w: entry %q isn't linked by the root", ErrInvalidBundle, e.Path)
+		}
+	}
+
+	return m, nil
+}
@@ -1,15 +1,17 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
-	"strings"
+	"reflect"
 	"time"
 
-	"github.com/labstack/echo/v4"
 	"gopkg.in/yaml.v3"
+
+	"server/logging"
 )
 
 type Config struct {
@@ -17,49 +19,215 @@ type Config struct {
 	Database DB     `yaml:"database"`
 	IPFS     IPFS   `yaml:"ipfs"`
 	Telegram Tg     `yaml:"telegram"`
+	Notify   Notify `yaml:"notify"`
+	Audit    Audit  `yaml:"audit"`
+}
+
+// Audit configures the audit trail.
+type Audit struct {
+	// Key seals the events of the trail. It is required, and verify-audit
+	// needs it too.
+	Key     logging.Secret `yaml:"key"`
+	KeyFile string         `yaml:"key_file"`
+}
+
+// Notify configures the notification channels besides Telegram. Email is
+// available when an SMTP server is set.
+type Notify struct {
+	SMTP    SMTP          `yaml:"smtp"`
+	Webhook NotifyWebhook `yaml:"webhook"`
+}
+
+type SMTP struct {
+	// Addr is the host:port of the mail server.
+	Addr     string         `yaml:"addr"`
+	From     string         `yaml:"from"`
+	Username string         `yaml:"username"`
+	Password logging.Secret `yaml:"password"`
+	// PasswordFile reads Password from a file, like a Docker secret.
+	PasswordFile string `yaml:"password_file"`
 }
 
+type NotifyWebhook struct {
+	// Secret signs the posted events.
+	Secret     logging.Secret `yaml:"secret"`
+	SecretFile string         `yaml:"secret_file"`
+	// AllowedHosts are the hosts users may register webhooks for, none by
+	// default.
+	AllowedHosts []string      `yaml:"allowed_hosts"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// IPFS selects where the encrypted files are stored.
 type IPFS struct {
+	// Backend is "http" for the HTTP API of an IPFS node, the default, "fs"
+	// for a local directory or "memory" for running offline.
+	Backend string `yaml:"backend"`
+	// API is the address of the node's HTTP API, http://localhost:5001 by
+	// default.
 	API string `yaml:"api"`
+	// Nodes replicate the content to several nodes instead of API. Cluster is
+	// the REST API of an IPFS Cluster, which replicates the content itself;
+	// content is then read through API, the cluster's IPFS proxy, or Nodes.
+	Nodes   []string `yaml:"nodes"`
+	Cluster string   `yaml:"cluster"`
+	// Replication is the number of pins an upload needs, all the nodes or 1
+	// for a cluster by default.
+	Replication int `yaml:"replication"`
+	// Dir is the directory of the "fs" backend.
+	Dir string `yaml:"dir"`
+	// Timeout bounds a request to the node, 1m by default. Failed requests
+	// are retried Retries times, 3 by default.
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+	// ReconcileInterval is how often the pinset is compared with the files,
+	// 1h by default. Deleted files stay pinned for Retention, 7 days by
+	// default.
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+	Retention         time.Duration `yaml:"retention"`
+	// RemoveOrphans lets the reconciler unpin the content the server pinned
+	// that no file refers to, off by default. Other pins are never removed.
+	RemoveOrphans bool `yaml:"remove_orphans"`
 }
 
 type Tg struct {
-	Key string `yaml:"key"`
+	// Key is the bot token.
+	Key     logging.Secret `yaml:"key"`
+	KeyFile string         `yaml:"key_file"`
+	// CallbackSecret signs the bot's inline button data.
+	CallbackSecret     logging.Secret `yaml:"callback_secret"`
+	CallbackSecretFile string         `yaml:"callback_secret_file"`
+	Webhook            Webhook        `yaml:"webhook"`
+	// Delivery maps classification levels to the policies for sending files of
+	// that level and above, up to the next configured level.
+	Delivery map[int]DeliveryPolicy `yaml:"delivery"`
+}
+
+// Webhook configures receiving bot updates on the HTTP server instead of long
+// polling.
+type Webhook struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is the public address of the HTTP server, like https://example.org.
+	URL string `yaml:"url"`
+	// Path is where updates are served, "/telegram/webhook" by default.
+	Path string `yaml:"path"`
+	// SecretToken is sent by Telegram with every update. It is required.
+	SecretToken     logging.Secret `yaml:"secret_token"`
+	SecretTokenFile string         `yaml:"secret_token_file"`
+	// Certificate is the public certificate uploaded to Telegram when the
+	// server uses a self-signed one.
+	Certificate    string `yaml:"certificate"`
+	MaxConnections int    `yaml:"max_connections"`
+}
+
+// DeliveryPolicy restricts the documents the bot sends.
+type DeliveryPolicy struct {
+	// DeleteAfter deletes the sent message after the duration. Telegram only
+	// lets bots delete messages younger than 48 hours.
+	DeleteAfter time.Duration `yaml:"delete_after"`
+	// ProtectContent forbids forwarding and saving the message.
+	ProtectContent bool `yaml:"protect_content"`
+	// Watermark adds the recipient and the time of delivery to the caption.
+	Watermark bool `yaml:"watermark"`
+	// MaxDownloads limits the deliveries of a file to a user, by the bot and
+	// over HTTP together, 0 is unlimited.
+	MaxDownloads int `yaml:"max_downloads"`
+}
+
+// DeliveryPolicy returns the policy of the highest configured level not above
+// level.
+func (t Tg) DeliveryPolicy(level int) DeliveryPolicy {
+	best := -1
+	for l := range t.Delivery {
+		if l <= level && l > best {
+			best = l
+		}
+	}
+
+	return t.Delivery[best]
 }
 
 type Server struct {
-	Port         string        `yaml:"default_port"`
-	ReadTimeout  time.Duration `yaml:"read_timeout"`
-	WriteTimeout time.Duration `yaml:"write_timeout"`
-	AdminToken   string        `yaml:"admin_token"`
+	Port string `yaml:"default_port"`
+	// ReadTimeout and WriteTimeout bound reading a request, body included,
+	// and writing the response, 0 is unlimited. ReadHeaderTimeout bounds the
+	// headers, 10s by default, and IdleTimeout keep-alive connections, the
+	// ReadTimeout by default.
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// AdminToken bootstraps the first chief officer. It has to be at least
+	// MinAdminTokenLength characters and not a known default.
+	AdminToken     logging.Secret `yaml:"admin_token"`
+	AdminTokenFile string         `yaml:"admin_token_file"`
+	ProposalTTL    time.Duration  `yaml:"proposal_ttl"`
+	// EnrollmentCodeTTL is how long the codes binding Telegram accounts last.
+	EnrollmentCodeTTL time.Duration `yaml:"enrollment_code_ttl"`
+	// TLSCert and TLSKey make the server serve HTTPS, which webhooks require.
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+	// TLSClientCA is a PEM file of the CAs client certificates are verified
+	// with. TLSClientAuth is "none", "request", "require", "verify_if_given"
+	// or "require_and_verify", the default with a TLSClientCA. Telegram sends
+	// no client certificate, so webhooks need "verify_if_given" at most.
+	TLSClientCA   string `yaml:"tls_client_ca"`
+	TLSClientAuth string `yaml:"tls_client_auth"`
+	// TLSMinVersion is "1.2", the default, or "1.3". TLSCipherSuites are the
+	// TLS 1.2 suites allowed, by their Go names like
+	// TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384; TLS 1.3 suites aren't
+	// configurable.
+	TLSMinVersion   string   `yaml:"tls_min_version"`
+	TLSCipherSuites []string `yaml:"tls_cipher_suites"`
+	// ShutdownTimeout bounds draining requests and uploads on shutdown, 30s by
+	// default.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 }
 
 type DB struct {
-	Host     string `yaml:"host"`
-	Port     string `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Name     string `yaml:"name"`
-	SslMode  string `yaml:"ssl_mode"`
+	Host         string         `yaml:"host"`
+	Port         string         `yaml:"port"`
+	User         string         `yaml:"user"`
+	Password     logging.Secret `yaml:"password"`
+	PasswordFile string         `yaml:"password_file"`
+	Name         string         `yaml:"name"`
+	// SslMode is the libpq sslmode: "disable", "allow", "prefer", the
+	// default, "require", "verify-ca" or "verify-full". SslRootCert is the PEM
+	// file of the CAs the server certificate is verified with, the system
+	// ones by default; with it "require" verifies the CA like "verify-ca".
+	// SslCert and SslKey are the client certificate.
+	SslMode     string `yaml:"ssl_mode"`
+	SslRootCert string `yaml:"ssl_root_cert"`
+	SslCert     string `yaml:"ssl_cert"`
+	SslKey      string `yaml:"ssl_key"`
+	// ConnectRetries is how many times connecting is retried at startup, 10 by
+	// default. The delay starts at ConnectBackoff, 1s by default, and doubles
+	// up to 30s.
+	ConnectRetries int           `yaml:"connect_retries"`
+	ConnectBackoff time.Duration `yaml:"connect_backoff"`
 }
 
-// NewConfig returns a new decoded Config struct
+// NewConfig reads the YAML file at configPath, then applies the environment
+// overrides, see EnvPrefix, and reads the secrets given as files. It doesn't
+// validate the config, see Validate.
 func NewConfig(configPath string) (*Config, error) {
-	// Create config structure
 	config := &Config{}
 
-	// Open config file
 	file, err := os.Open(configPath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	// Init new YAML decode
-	d := yaml.NewDecoder(file)
+	// an empty file leaves everything to the environment
+	if err = yaml.NewDecoder(file).Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to decode %s: %w", configPath, err)
+	}
 
-	// Start YAML decoding from file
-	if err := d.Decode(&config); err != nil {
+	if err = applyEnv(reflect.ValueOf(config).Elem(), EnvPrefix, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err = readSecretFiles(reflect.ValueOf(config).Elem(), ""); err != nil {
 		return nil, err
 	}
 
@@ -98,17 +266,3 @@ func ParseFlags() (string, error) {
 	// Return the configuration path
 	return configPath, nil
 }
-
-// CheckAdmin is the middleware function.
-func (conf *Config) CheckAdmin(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		if strings.Contains(c.Request().RequestURI, `/admin/`) {
-			if c.Request().Header.Get("X-Admin-Key") != conf.Server.AdminToken {
-				c.Logger().Errorf("incorrect admin header")
-				return echo.NewHTTPError(http.StatusForbidden, "incorrect admin header")
-			}
-		}
-
-		return next(c)
-	}
-}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const testConfig = `
+server:
+  default_port: ":8088"
+  admin_token_file: "%s"
+database:
+  host: "localhost"
+  port: "5432"
+  user: "postgres"
+  name: "sos"
+telegram:
+  key: "123:bot-token"
+  callback_secret: "callback-secret-value"
+audit:
+  key: "audit-key-value"
+ipfs:
+  nodes: ["http://ipfs-1:5001"]
+`
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	return path
+}
+
+func TestNewConfig(t *testing.T) {
+	token := writeFile(t, "admin_token", "a-long-admin-token-value\n")
+	path := writeFile(t, "config.yaml", strings.Replace(testConfig, "%s", token, 1))
+	dbPassword := writeFile(t, "db_password", "db-password\r\n")
+
+	t.Setenv("MMIPFS_DATABASE_HOST", "db")
+	t.Setenv("MMIPFS_DATABASE_PASSWORD_FILE", dbPassword)
+	t.Setenv("MMIPFS_DATABASE_CONNECT_BACKOFF", "2s")
+	t.Setenv("MMIPFS_DATABASE_CONNECT_RETRIES", "3")
+	t.Setenv("MMIPFS_TELEGRAM_WEBHOOK_ENABLED", "true")
+	t.Setenv("MMIPFS_TELEGRAM_WEBHOOK_URL", "https://example.org")
+	t.Setenv("MMIPFS_TELEGRAM_WEBHOOK_SECRET_TOKEN", "webhook-secret-value")
+	t.Setenv("MMIPFS_IPFS_NODES", "http://ipfs-1:5001, http://ipfs-2:5001")
+
+	cfg, err := NewConfig(path)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, "a-long-admin-token-value", cfg.Server.AdminToken.Reveal())
+	require.Equal(t, "db-password", cfg.Database.Password.Reveal())
+	require.Equal(t, "db", cfg.Database.Host)
+	require.Equal(t, 2*time.Second, cfg.Database.ConnectBackoff)
+	require.Equal(t, 3, cfg.Database.ConnectRetries)
+	require.True(t, cfg.Telegram.Webhook.Enabled)
+	require.Equal(t, "webhook-secret-value", cfg.Telegram.Webhook.SecretToken.Reveal())
+	require.Equal(t, []string{"http://ipfs-1:5001", "http://ipfs-2:5001"}, cfg.IPFS.Nodes)
+
+	// the effective config, as config check prints it
+	out, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	for _, secret := range []string{"a-long-admin-token-value", "db-password", "123:bot-token", "webhook-secret-value", "audit-key-value"} {
+		require.NotContains(t, string(out), secret)
+	}
+
+	t.Setenv("MMIPFS_DATABASE_CONNECT_RETRIES", "many")
+	_, err = NewConfig(path)
+	require.ErrorContains(t, err, "MMIPFS_DATABASE_CONNECT_RETRIES")
+}
+
+func TestSecretFiles(t *testing.T) {
+	token := writeFile(t, "admin_token", "a-long-admin-token-value")
+	path := writeFile(t, "config.yaml", strings.Replace(testConfig, "%s", token, 1))
+
+	t.Setenv("MMIPFS_SERVER_ADMIN_TOKEN", "another-long-admin-token")
+	_, err := NewConfig(path)
+	require.ErrorContains(t, err, "server.admin_token and server.admin_token_file are both set")
+
+	t.Setenv("MMIPFS_SERVER_ADMIN_TOKEN", "")
+	t.Setenv("MMIPFS_SERVER_ADMIN_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = NewConfig(path)
+	require.ErrorContains(t, err, "failed to read server.admin_token")
+
+	t.Setenv("MMIPFS_SERVER_ADMIN_TOKEN_FILE", writeFile(t, "empty", "\n"))
+	_, err = NewConfig(path)
+	require.ErrorContains(t, err, "is empty")
+
+	// an empty file leaves everything to the environment
+	t.Setenv("MMIPFS_SERVER_ADMIN_TOKEN_FILE", "")
+	t.Setenv("MMIPFS_SERVER_DEFAULT_PORT", ":9000")
+	cfg, err := NewConfig(writeFile(t, "empty.yaml", ""))
+	require.NoError(t, err)
+	require.Equal(t, ":9000", cfg.Server.Port)
+}
+
+func TestValidate(t *testing.T) {
+	var cfg Config
+	err := cfg.Validate()
+	var invalid *ValidationError
+	require.True(t, errors.As(err, &invalid))
+	require.Contains(t, invalid.Problems, "server.admin_token is required, set it, server.admin_token_file or MMIPFS_SERVER_ADMIN_TOKEN")
+	require.Contains(t, invalid.Problems, "database.host is required")
+	require.Contains(t, invalid.Problems, "telegram.key is required, set it, telegram.key_file or MMIPFS_TELEGRAM_KEY")
+	require.Contains(t, invalid.Problems, "audit.key is required, set it, audit.key_file or MMIPFS_AUDIT_KEY")
+
+	cfg.Server.AdminToken = "admin"
+	cfg.Telegram.Webhook.Enabled = true
+	cfg.Server.TLSCert = "cert.pem"
+	require.True(t, errors.As(cfg.Validate(), &invalid))
+	require.Contains(t, invalid.Problems, "server.admin_token is a known default, replace it")
+	require.Contains(t, invalid.Problems, "telegram.webhook.url is required")
+	require.Contains(t, invalid.Problems, "server.tls_cert and server.tls_key are set together")
+
+	cfg.Server.AdminToken = "short-token"
+	require.True(t, errors.As(cfg.Validate(), &invalid))
+	require.Contains(t, invalid.Problems, "server.admin_token is shorter than 16 characters")
+	// the problems never hold the secrets
+	require.NotContains(t, invalid.Error(), "short-token")
+
+	cfg.Server.TLSClientCA = "ca.pem"
+	cfg.Server.TLSMinVersion = "1.1"
+	cfg.Database.SslMode = "verify"
+	cfg.Database.SslCert = "client.pem"
+	require.True(t, errors.As(cfg.Validate(), &invalid))
+	require.Contains(t, invalid.Problems, `server.tls_min_version "1.1" is not 1.2 or 1.3`)
+	require.Contains(t, invalid.Problems, `database.ssl_mode "verify" is unknown`)
+	require.Contains(t, invalid.Problems, "database.ssl_cert and database.ssl_key are set together")
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/logging"
+)
+
+// EnvPrefix prefixes the environment variables overriding the config. They are
+// named after the YAML keys, so server.admin_token is set by
+// MMIPFS_SERVER_ADMIN_TOKEN and database.password_file by
+// MMIPFS_DATABASE_PASSWORD_FILE. Lists are comma separated, maps can't be set.
+const EnvPrefix = "MMIPFS"
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	secretType   = reflect.TypeOf(logging.Secret(""))
+)
+
+// applyEnv sets the fields of the struct v from the environment variables
+// named prefix and their YAML key.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("%s can't be set from the environment", field.Type())
+	}
+
+	return nil
+}
+
+// readSecretFiles sets every Secret field X of the struct v whose XFile field
+// names a file to the content of the file, without the trailing newline.
+// path is the YAML path of v, used in the errors.
+func readSecretFiles(v reflect.Value, path string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := path + yamlKey(f)
+		if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+			if err := readSecretFiles(v.Field(i), key+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Type != secretType {
+			continue
+		}
+		file, ok := t.FieldByName(f.Name + "File")
+		if !ok {
+			continue
+		}
+		name := v.FieldByIndex(file.Index).String()
+		if name == "" {
+			continue
+		}
+		if v.Field(i).String() != "" {
+			return fmt.Errorf("%s and %s%s are both set", key, path, yamlKey(file))
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		secret := strings.TrimRight(string(data), "\r\n")
+		if secret == "" {
+			return fmt.Errorf("%s: %s is empty", key, name)
+		}
+		v.Field(i).SetString(secret)
+	}
+
+	return nil
+}
+
+func yamlKey(f reflect.StructField) string {
+	key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+
+	return key
+}
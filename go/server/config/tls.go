@@ -0,0 +1,110 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// TLSConfig returns the TLS config of the HTTP server, nil when TLSCert isn't
+// set.
+func (s Server) TLSConfig() (*tls.Config, error) {
+	if s.TLSCert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server.tls_cert: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if cfg.MinVersion, cfg.CipherSuites, cfg.ClientAuth, err = s.tlsOptions(); err != nil {
+		return nil, err
+	}
+	if s.TLSClientCA != "" {
+		if cfg.ClientCAs, err = CertPool(s.TLSClientCA); err != nil {
+			return nil, fmt.Errorf("failed to load server.tls_client_ca: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// tlsOptions parses the TLS options that don't need files.
+func (s Server) tlsOptions() (uint16, []uint16, tls.ClientAuthType, error) {
+	version, ok := tlsVersions[s.TLSMinVersion]
+	if !ok {
+		return 0, nil, 0, fmt.Errorf("server.tls_min_version %q is not 1.2 or 1.3", s.TLSMinVersion)
+	}
+
+	var suites []uint16
+	for _, name := range s.TLSCipherSuites {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return 0, nil, 0, fmt.Errorf("server.tls_cipher_suites: %q is not a secure TLS 1.2 cipher suite", name)
+		}
+		suites = append(suites, id)
+	}
+
+	auth := "none"
+	if s.TLSClientCA != "" {
+		auth = "require_and_verify"
+	}
+	if s.TLSClientAuth != "" {
+		auth = s.TLSClientAuth
+	}
+	clientAuth, ok := clientAuthTypes[auth]
+	if !ok {
+		return 0, nil, 0, fmt.Errorf("server.tls_client_auth %q is unknown", auth)
+	}
+	if clientAuth >= tls.VerifyClientCertIfGiven && s.TLSClientCA == "" {
+		return 0, nil, 0, fmt.Errorf("server.tls_client_auth %q needs server.tls_client_ca", auth)
+	}
+
+	return version, suites, clientAuth, nil
+}
+
+// cipherSuite returns the ID of a secure cipher suite usable with TLS 1.2.
+func cipherSuite(name string) (uint16, bool) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name != name {
+			continue
+		}
+		for _, v := range s.SupportedVersions {
+			if v == tls.VersionTLS12 {
+				return s.ID, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// CertPool returns the certificates of the PEM file.
+func CertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReadFile: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/testcert"
+)
+
+func TestServerTLSConfig(t *testing.T) {
+	cfg, err := Server{}.TLSConfig()
+	require.NoError(t, err)
+	require.Nil(t, cfg)
+
+	ca := testcert.NewCA(t, "ca")
+	cert, key := ca.Issue(t, "server", "localhost")
+	s := Server{
+		TLSCert:         cert,
+		TLSKey:          key,
+		TLSMinVersion:   "1.3",
+		TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"},
+	}
+	cfg, err = s.TLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}, cfg.CipherSuites)
+	require.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	// a TLS 1.2 client can't connect to a TLS 1.3 server
+	handshake := func(server, client *tls.Config) error {
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			if conn, err := ln.Accept(); err == nil {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+
+		conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	client := &tls.Config{RootCAs: ca.Pool, ServerName: "localhost"}
+	require.NoError(t, handshake(cfg, client))
+	client.MaxVersion = tls.VersionTLS12
+	require.Error(t, handshake(cfg, client))
+
+	s.TLSClientCA = ca.CertFile
+	cfg, err = s.TLSConfig()
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	require.NotNil(t, cfg.ClientCAs)
+
+	for _, bad := range []Server{
+		{TLSCert: cert, TLSKey: key, TLSMinVersion: "1.0"},
+		{TLSCert: cert, TLSKey: key, TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{TLSCert: cert, TLSKey: key, TLSCipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+		{TLSCert: cert, TLSKey: key, TLSClientAuth: "require_and_verify"},
+		{TLSCert: cert, TLSKey: key, TLSClientAuth: "always"},
+		{TLSCert: cert, TLSKey: cert},
+		{TLSCert: cert, TLSKey: key, TLSClientCA: key},
+	} {
+		_, err = bad.TLSConfig()
+		require.Error(t, err, "%+v", bad)
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MinAdminTokenLength is the shortest admin token accepted.
+const MinAdminTokenLength = 16
+
+// weakSecrets are defaults of example configs and other values that must not
+// guard a server.
+var weakSecrets = []string{"admin", "change-me", "changeme", "password", "secret", "token", "test"}
+
+var sslModes = map[string]bool{
+	"": true, "disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
+
+// ValidationError lists the problems of a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the config the server starts with. The error is a
+// *ValidationError listing every problem.
+func (c *Config) Validate() error {
+	var problems []string
+	problem := func(p string) {
+		problems = append(problems, p)
+	}
+	required := func(key, value string) {
+		if value == "" {
+			problem(key + " is required")
+		}
+	}
+	requiredSecret := func(key, value string) {
+		if value == "" {
+			problem(key + " is required, set it, " + key + "_file or " + envName(key))
+		} else if isWeak(value) {
+			problem(key + " is a known default, replace it")
+		}
+	}
+
+	required("server.default_port", c.Server.Port)
+	token := c.Server.AdminToken.Reveal()
+	requiredSecret("server.admin_token", token)
+	if token != "" && !isWeak(token) && len(token) < MinAdminTokenLength {
+		problem("server.admin_token is shorter than " + strconv.Itoa(MinAdminTokenLength) + " characters")
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		problem("server.tls_cert and server.tls_key are set together")
+	}
+	if c.Server.TLSClientCA != "" && c.Server.TLSCert == "" {
+		problem("server.tls_client_ca needs server.tls_cert")
+	}
+	if _, _, _, err := c.Server.tlsOptions(); err != nil {
+		problem(err.Error())
+	}
+
+	required("database.host", c.Database.Host)
+	required("database.port", c.Database.Port)
+	required("database.user", c.Database.User)
+	required("database.name", c.Database.Name)
+	if !sslModes[c.Database.SslMode] {
+		problem("database.ssl_mode " + strconv.Quote(c.Database.SslMode) + " is unknown")
+	}
+	if (c.Database.SslCert == "") != (c.Database.SslKey == "") {
+		problem("database.ssl_cert and database.ssl_key are set together")
+	}
+
+	requiredSecret("audit.key", c.Audit.Key.Reveal())
+
+	requiredSecret("telegram.key", c.Telegram.Key.Reveal())
+	requiredSecret("telegram.callback_secret", c.Telegram.CallbackSecret.Reveal())
+	if c.Telegram.Webhook.Enabled {
+		required("telegram.webhook.url", c.Telegram.Webhook.URL)
+		requiredSecret("telegram.webhook.secret_token", c.Telegram.Webhook.SecretToken.Reveal())
+	}
+
+	if c.Notify.SMTP.Username != "" {
+		requiredSecret("notify.smtp.password", c.Notify.SMTP.Password.Reveal())
+	}
+	if len(c.Notify.Webhook.AllowedHosts) > 0 {
+		requiredSecret("notify.webhook.secret", c.Notify.Webhook.Secret.Reveal())
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func isWeak(secret string) bool {
+	for _, weak := range weakSecrets {
+		if strings.EqualFold(secret, weak) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// envName returns the environment variable of the YAML key.
+func envName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
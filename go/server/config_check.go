@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"server/config"
+)
+
+// configCommand checks the config the server would start with:
+//
+//	server config check [-config FILE]
+//
+// It prints the effective config, the file with the environment overrides and
+// the secret files applied, with the secrets redacted. It exits with 1 when the
+// config is invalid.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: server config check [-config FILE]")
+		return 2
+	}
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	cfgPath := flags.String("config", "./build/config.yaml", "path to config file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := config.NewConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	os.Stdout.Write(out)
+
+	var invalid *config.ValidationError
+	if err = cfg.Validate(); errors.As(err, &invalid) {
+		for _, p := range invalid.Problems {
+			fmt.Fprintln(os.Stderr, "invalid:", p)
+		}
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "config is valid")
+
+	return 0
+}
@@ -2,12 +2,29 @@ package crypto
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 
 	"server/abe"
 )
 
+// ErrPolicyNotSatisfied is returned when the department and level of the user
+// don't satisfy the access policy of the ciphertext.
+var ErrPolicyNotSatisfied = errors.New("access policy is not satisfied")
+
+// policyError keeps the message of the ABE error and matches
+// ErrPolicyNotSatisfied.
+type policyError struct {
+	err error
+}
+
+func (e policyError) Error() string { return e.err.Error() }
+
+func (e policyError) Unwrap() error { return e.err }
+
+func (e policyError) Is(target error) bool { return target == ErrPolicyNotSatisfied }
+
 func Encrypt(dep, level int, file []byte) ([]byte, []byte, []byte, error) {
 	attribDep, attribLevel, err := createAttribs(dep, level)
 	if err != nil {
@@ -37,9 +54,9 @@ func Encrypt(dep, level int, file []byte) ([]byte, []byte, []byte, error) {
 		return nil, nil, nil, fmt.Errorf("failed to Encrypt: %w", err)
 	}
 
-	cipherRaw, err := json.Marshal(ct)
+	cipherRaw, err := seal(ct, policy)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to Marshal: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to seal: %w", err)
 	}
 
 	levelAuthRaw, err := json.Marshal(levelAuth)
@@ -76,12 +93,12 @@ func Decrypt(dep, level int, file []byte, depAuthRaw, levelAuthRaw []byte) ([]by
 
 	depKeys, err := depAuth.GenerateAttribKeys("gid", attribDep)
 	if err != nil {
-		return nil, fmt.Errorf("failed to GenerateAttribKeys: %w", err)
+		return nil, policyError{fmt.Errorf("failed to GenerateAttribKeys: %w", err)}
 	}
 
 	levelKeys, err := levelAuth.GenerateAttribKeys("gid", attribLevel)
 	if err != nil {
-		return nil, fmt.Errorf("failed to GenerateAttribKeys: %w", err)
+		return nil, policyError{fmt.Errorf("failed to GenerateAttribKeys: %w", err)}
 	}
 
 	if len(depKeys) != 1 {
@@ -93,14 +110,14 @@ func Decrypt(dep, level int, file []byte, depAuthRaw, levelAuthRaw []byte) ([]by
 		ks = append(ks, key)
 	}
 
-	var cipher = new(abe.MAABECipher)
-	if err = json.Unmarshal(file, cipher); err != nil {
-		return nil, fmt.Errorf("failed to Unmarshal: %w", err)
+	cipher, err := open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open: %w", err)
 	}
 
 	decrypted, err := abe.NewMAABE().Decrypt(cipher, ks)
 	if err != nil {
-		return nil, fmt.Errorf("failed to Decrypt: %w", err)
+		return nil, policyError{fmt.Errorf("failed to Decrypt: %w", err)}
 	}
 
 	return []byte(decrypted), nil
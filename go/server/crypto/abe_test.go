@@ -35,6 +35,7 @@ func TestABE_Fail_differentDep(t *testing.T) {
 
 	decrypted, err := Decrypt(2, 2, encrypted, depAuth, levelAuth)
 	require.EqualError(t, err, "failed to GenerateAttribKeys: attribute not found in secret key")
+	require.ErrorIs(t, err, ErrPolicyNotSatisfied)
 	require.Nil(t, decrypted)
 }
 
@@ -45,5 +46,6 @@ func TestABE_Fail_lowPrivilege(t *testing.T) {
 
 	decrypted, err := Decrypt(1, 1, encrypted, depAuth, levelAuth)
 	require.EqualError(t, err, "failed to GenerateAttribKeys: attribute not found in secret key")
+	require.ErrorIs(t, err, ErrPolicyNotSatisfied)
 	require.Nil(t, decrypted)
 }
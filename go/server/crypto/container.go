@@ -0,0 +1,184 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fentec-project/bn256"
+
+	"server/abe"
+)
+
+// Container layout, all lengths are uvarints:
+//
+//	magic "MMIC" | version | scheme | len policy | policy | len key | key |
+//	len iv | iv | chunks: len chunk | chunk ... | 0
+//
+// The key is the MA-ABE encryption of the symmetric key and the body is the
+// symmetric ciphertext split into chunks.
+const (
+	containerMagic   = "MMIC"
+	containerVersion = 1
+	// SchemeMAABEKuznechik is MA-ABE wrapping a Kuznechik key.
+	SchemeMAABEKuznechik = 1
+	// chunkSize is the size of the body chunks, a multiple of the block size.
+	chunkSize = 64 << 10
+	// maxField bounds the header fields so a corrupted length fails early.
+	maxField = 1 << 20
+)
+
+// ErrInvalidContainer is returned for data that isn't a container this
+// version can read.
+var ErrInvalidContainer = errors.New("invalid ciphertext container")
+
+// Container is a ciphertext with everything needed to decrypt it besides the
+// authority keys.
+type Container struct {
+	Scheme byte
+	// Policy is the boolean access policy, kept for inspection.
+	Policy string
+	Key    []byte
+	IV     []byte
+	Body   []byte
+}
+
+// IsContainer reports whether data starts like a container. Ciphertexts stored
+// before containers are base64 text, which can't start with the magic.
+func IsContainer(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(containerMagic))
+}
+
+func (c Container) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(c.Body)+len(c.Key)+len(c.Policy)+64))
+	buf.WriteString(containerMagic)
+	buf.WriteByte(containerVersion)
+	buf.WriteByte(c.Scheme)
+
+	for _, field := range [][]byte{[]byte(c.Policy), c.Key, c.IV} {
+		writeField(buf, field)
+	}
+
+	for body := c.Body; len(body) > 0; {
+		n := chunkSize
+		if n > len(body) {
+			n = len(body)
+		}
+		writeField(buf, body[:n])
+		body = body[n:]
+	}
+	writeField(buf, nil)
+
+	return buf.Bytes(), nil
+}
+
+func writeField(buf *bytes.Buffer, field []byte) {
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(field)))])
+	buf.Write(field)
+}
+
+func (c *Container) UnmarshalBinary(data []byte) error {
+	if !IsContainer(data) {
+		return fmt.Errorf("%w: no magic", ErrInvalidContainer)
+	}
+	r := bytes.NewReader(data[len(containerMagic):])
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w: no version", ErrInvalidContainer)
+	}
+	if version != containerVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidContainer, version)
+	}
+	if c.Scheme, err = r.ReadByte(); err != nil {
+		return fmt.Errorf("%w: no scheme", ErrInvalidContainer)
+	}
+
+	fields := make([][]byte, 3)
+	for i := range fields {
+		if fields[i], err = readField(r, maxField); err != nil {
+			return err
+		}
+	}
+	c.Policy, c.Key, c.IV = string(fields[0]), fields[1], fields[2]
+
+	c.Body = make([]byte, 0, r.Len())
+	for {
+		chunk, err := readField(r, chunkSize)
+		if err != nil {
+			return err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		c.Body = append(c.Body, chunk...)
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: trailing data", ErrInvalidContainer)
+	}
+
+	return nil
+}
+
+func readField(r *bytes.Reader, max int) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated", ErrInvalidContainer)
+	}
+	if n > uint64(max) || n > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: field of %d bytes", ErrInvalidContainer, n)
+	}
+
+	field := make([]byte, n)
+	r.Read(field)
+
+	return field, nil
+}
+
+// wrappedKey is the MA-ABE part of the cipher.
+type wrappedKey struct {
+	C0  *bn256.GT
+	C1x map[string]*bn256.GT
+	C2x map[string]*bn256.G2
+	C3x map[string]*bn256.G2
+	Msp *abe.MSP
+}
+
+// seal packs the cipher into a container.
+func seal(ct *abe.MAABECipher, policy string) ([]byte, error) {
+	key, err := json.Marshal(wrappedKey{C0: ct.C0, C1x: ct.C1x, C2x: ct.C2x, C3x: ct.C3x, Msp: ct.Msp})
+	if err != nil {
+		return nil, fmt.Errorf("failed to Marshal key: %w", err)
+	}
+
+	return Container{Scheme: SchemeMAABEKuznechik, Policy: policy, Key: key, IV: ct.Iv, Body: ct.SymEnc}.MarshalBinary()
+}
+
+// open unpacks the cipher from a container or, for ciphertexts stored before
+// containers, from its JSON.
+func open(data []byte) (*abe.MAABECipher, error) {
+	if !IsContainer(data) {
+		ct := new(abe.MAABECipher)
+		if err := json.Unmarshal(data, ct); err != nil {
+			return nil, fmt.Errorf("failed to Unmarshal: %w", err)
+		}
+		return ct, nil
+	}
+
+	var c Container
+	if err := c.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if c.Scheme != SchemeMAABEKuznechik {
+		return nil, fmt.Errorf("%w: unsupported scheme %d", ErrInvalidContainer, c.Scheme)
+	}
+	var key wrappedKey
+	if err := json.Unmarshal(c.Key, &key); err != nil {
+		return nil, fmt.Errorf("%w: failed to Unmarshal key: %v", ErrInvalidContainer, err)
+	}
+
+	return &abe.MAABECipher{C0: key.C0, C1x: key.C1x, C2x: key.C2x, C3x: key.C3x, Msp: key.Msp, SymEnc: c.Body, Iv: c.IV}, nil
+}
@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainer(t *testing.T) {
+	c := Container{
+		Scheme: SchemeMAABEKuznechik,
+		Policy: "department:1 AND level:4",
+		Key:    []byte("wrapped key"),
+		IV:     bytes.Repeat([]byte{1}, 16),
+		Body:   bytes.Repeat([]byte("0123456789abcdef"), chunkSize/8+3),
+	}
+	data, err := c.MarshalBinary()
+	require.NoError(t, err)
+	require.True(t, IsContainer(data))
+
+	var got Container
+	require.NoError(t, got.UnmarshalBinary(data))
+	require.Equal(t, c, got)
+
+	for name, bad := range map[string][]byte{
+		"no magic":  data[1:],
+		"version":   append([]byte(containerMagic+"\x02"), data[5:]...),
+		"truncated": data[:len(data)-10],
+		"trailing":  append(append([]byte(nil), data...), 0),
+	} {
+		require.ErrorIs(t, new(Container).UnmarshalBinary(bad), ErrInvalidContainer, name)
+	}
+}
+
+func TestEncryptContainer(t *testing.T) {
+	msg := bytes.Repeat([]byte("secret msg "), 1000)
+	encrypted, depAuth, levelAuth, err := Encrypt(1, 2, msg)
+	require.NoError(t, err)
+
+	var c Container
+	require.NoError(t, c.UnmarshalBinary(encrypted))
+	require.Equal(t, byte(SchemeMAABEKuznechik), c.Scheme)
+	require.Contains(t, c.Policy, "department:1")
+
+	// the JSON cipher stored before containers is bigger and still decrypts
+	ct, err := open(encrypted)
+	require.NoError(t, err)
+	legacy, err := json.Marshal(ct)
+	require.NoError(t, err)
+	require.Less(t, len(encrypted), len(legacy))
+
+	for _, data := range [][]byte{encrypted, legacy} {
+		decrypted, err := Decrypt(1, 2, data, depAuth, levelAuth)
+		require.NoError(t, err)
+		require.Equal(t, msg, decrypted)
+	}
+
+	_, err = Decrypt(1, 2, append([]byte(containerMagic), 1, 2), depAuth, levelAuth)
+	require.ErrorIs(t, err, ErrInvalidContainer)
+	_, err = open(append([]byte(containerMagic+"\x01\x07"), 0, 0, 0, 0))
+	require.ErrorIs(t, err, ErrInvalidContainer)
+}
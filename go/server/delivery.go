@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+
+	"server/audit"
+	"server/config"
+	"server/storage"
+)
+
+// deliverySweepInterval is how often sent messages due for deletion are deleted.
+const deliverySweepInterval = 30 * time.Second
+
+// maxDeleteAge is how old messages Telegram still lets bots delete.
+const maxDeleteAge = 48 * time.Hour
+
+// tgConfig holds the delivery policies of the bot.
+var tgConfig config.Tg
+
+// deliverFile sends the decrypted file to the user according to the delivery
+// policy of its level and records the delivery in the access log. The delivery
+// is reserved before the file is sent, so that download limits can't be
+// exceeded by concurrent requests.
+func deliverFile(ctx context.Context, b *bot.Bot, chatID int64, user *storage.User, file storage.File, content []byte) (storage.Delivery, error) {
+	policy := tgConfig.DeliveryPolicy(file.Policy)
+
+	d, err := storage.ReserveDelivery(db.DB, storage.Delivery{
+		FileID:  file.ID,
+		UserID:  user.ID,
+		Channel: audit.ChannelTelegram,
+		ChatID:  chatID,
+		Level:   file.Level,
+	}, policy.MaxDownloads)
+	if err != nil {
+		return storage.Delivery{}, fmt.Errorf("failed to ReserveDelivery: %w", err)
+	}
+
+	now := time.Now()
+	caption := "Document"
+	if policy.Watermark {
+		caption = fmt.Sprintf("Document for @%s (#%d), delivered %s", user.TgName, user.ID, now.UTC().Format("2006-01-02 15:04:05 MST"))
+	}
+	if policy.DeleteAfter > 0 {
+		caption += fmt.Sprintf("\nThis message will be deleted in %s.", policy.DeleteAfter)
+	}
+
+	m, err := b.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:         chatID,
+		Document:       &models.InputFileUpload{Filename: file.Name, Data: bytes.NewReader(content)},
+		Caption:        caption,
+		ProtectContent: policy.ProtectContent,
+	})
+	if err != nil {
+		cancelDelivery(d)
+		return storage.Delivery{}, fmt.Errorf("failed to SendDocument: %w", err)
+	}
+
+	d.MessageID = m.ID
+	if policy.DeleteAfter > 0 {
+		d.DeleteAt = now.Add(policy.DeleteAfter)
+	}
+	if err = storage.SetDeliveryMessage(db.DB, d.ID, d.MessageID, d.DeleteAt); err != nil {
+		// a message that can't be tracked must not outlive its policy
+		if policy.DeleteAfter > 0 {
+			b.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: chatID, MessageID: m.ID})
+		}
+		return storage.Delivery{}, fmt.Errorf("failed to SetDeliveryMessage: %w", err)
+	}
+
+	return d, nil
+}
+
+// logDownload records the HTTP delivery of the file to the user in the access
+// log, within the download limit of its delivery policy, which applies to the
+// bot and HTTP deliveries together. It returns a func cancelling the delivery
+// when the content can't be returned after all.
+func logDownload(user *storage.User, file storage.File) (func(), error) {
+	policy := tgConfig.DeliveryPolicy(file.Policy)
+
+	d, err := storage.ReserveDelivery(db.DB, storage.Delivery{FileID: file.ID, UserID: user.ID, Channel: audit.ChannelHTTP, Level: file.Level}, policy.MaxDownloads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReserveDelivery: %w", err)
+	}
+
+	return func() { cancelDelivery(d) }, nil
+}
+
+// cancelDelivery deletes a reserved delivery that didn't happen, giving the
+// download back.
+func cancelDelivery(d storage.Delivery) {
+	if err := storage.DeleteDelivery(db.DB, d.ID); err != nil {
+		zap.L().Error("failed to DeleteDelivery", zap.Int("delivery_id", d.ID), zap.Error(err))
+	}
+}
+
+// sweepDeliveries deletes the sent messages due for deletion until ctx is done.
+// Deliveries are stored, so messages due while the server was down are deleted
+// after a restart.
+func sweepDeliveries(ctx context.Context, b *bot.Bot) error {
+	ticker := time.NewTicker(deliverySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		deleteDueMessages(ctx, b, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func deleteDueMessages(ctx context.Context, b *bot.Bot, now time.Time) {
+	deliveries, err := storage.DueDeliveries(db.DB, now)
+	if err != nil {
+		zap.L().Error("failed to DueDeliveries", zap.Error(err))
+		return
+	}
+
+	for _, d := range deliveries {
+		l := zap.L().With(zap.Int("delivery_id", d.ID), zap.Int64("file_id", d.FileID), zap.Int("user_id", d.UserID))
+
+		if _, err := b.DeleteMessage(ctx, &bot.DeleteMessageParams{ChatID: d.ChatID, MessageID: d.MessageID}); err != nil {
+			// the user may have deleted the message already, and old messages
+			// can't be deleted anymore, so those aren't retried
+			if now.Sub(d.DeliveredAt) < maxDeleteAge && !undeletable(err) {
+				l.Warn("failed to DeleteMessage", zap.Error(err))
+				continue
+			}
+			l.Warn("sent message can't be deleted", zap.Error(err))
+		}
+
+		if err := storage.MarkDeliveryDeleted(db.DB, d.ID, now); err != nil {
+			l.Error("failed to MarkDeliveryDeleted", zap.Error(err))
+			continue
+		}
+		l.Info("sent message deleted")
+	}
+}
+
+// undeletable reports whether Telegram refused to delete a message for good.
+// The bot library reports only the error description.
+func undeletable(err error) bool {
+	return strings.Contains(err.Error(), "message to delete not found") ||
+		strings.Contains(err.Error(), "message can't be deleted")
+}
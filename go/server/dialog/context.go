@@ -0,0 +1,142 @@
+package dialog
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// Context is passed to state handlers.
+type Context struct {
+	Bot     *bot.Bot
+	Update  *models.Update
+	Session *Session
+	// Arg is the argument the current state was entered with.
+	Arg string
+
+	chatID int64
+	m      *Machine
+}
+
+func (c *Context) ChatID() int64 {
+	return c.chatID
+}
+
+// Go moves the chat to state and renders it. Moving within the same state, like
+// turning a page, doesn't add a step to go back to.
+func (c *Context) Go(ctx context.Context, state, arg string) {
+	c.move(state, arg)
+	c.m.enter(ctx, c)
+}
+
+// Back returns the chat to the previous state.
+func (c *Context) Back(ctx context.Context) {
+	c.back()
+	c.m.enter(ctx, c)
+}
+
+// Cancel drops the collected values and returns the chat to the root state.
+func (c *Context) Cancel(ctx context.Context) {
+	c.reset()
+	c.m.enter(ctx, c)
+}
+
+// Reset drops the collected values and returns the chat to the root state
+// without rendering it, for states that end a dialog with their own message.
+func (c *Context) Reset() {
+	c.reset()
+}
+
+func (c *Context) move(state, arg string) {
+	s := c.Session
+	if c.m.states[state].Action {
+		return
+	}
+	if s.current.state != state {
+		s.history = append(s.history, s.current)
+	}
+	s.current = frame{state: state, arg: arg}
+}
+
+func (c *Context) back() {
+	s := c.Session
+	if len(s.history) == 0 {
+		s.current = frame{state: c.m.root}
+		return
+	}
+	s.current = s.history[len(s.history)-1]
+	s.history = s.history[:len(s.history)-1]
+}
+
+func (c *Context) reset() {
+	c.Session.current = frame{state: c.m.root}
+	c.Session.history = nil
+	c.Session.Values = map[string]interface{}{}
+}
+
+// Button returns an inline button moving the chat to state with arg.
+func (c *Context) Button(text, state, arg string) models.InlineKeyboardButton {
+	return models.InlineKeyboardButton{Text: text, CallbackData: c.m.CallbackData(c.chatID, state, arg)}
+}
+
+// NavRow returns the "back" and "cancel" buttons.
+func (c *Context) NavRow() []models.InlineKeyboardButton {
+	return []models.InlineKeyboardButton{
+		c.Button("« Назад", stateBack, ""),
+		c.Button("Отмена", stateCancel, ""),
+	}
+}
+
+// PageRow returns the buttons turning the pages of state. page is 1-based.
+func (c *Context) PageRow(state string, page, pages int) []models.InlineKeyboardButton {
+	var row []models.InlineKeyboardButton
+	if page > 1 {
+		row = append(row, c.Button("‹", state, fmt.Sprint(page-1)))
+	}
+	if pages > 1 {
+		row = append(row, c.Button(fmt.Sprintf("%d/%d", page, pages), state, fmt.Sprint(page)))
+	}
+	if page < pages {
+		row = append(row, c.Button("›", state, fmt.Sprint(page+1)))
+	}
+
+	return row
+}
+
+// Show renders a state. Callbacks edit the message with the pressed button,
+// other updates send a new message.
+func (c *Context) Show(ctx context.Context, text string, rows ...[]models.InlineKeyboardButton) error {
+	var markup models.ReplyMarkup
+	if len(rows) > 0 {
+		markup = &models.InlineKeyboardMarkup{InlineKeyboard: rows}
+	}
+
+	if cq := c.Update.CallbackQuery; cq != nil && cq.Message.Message != nil {
+		_, err := c.Bot.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      c.chatID,
+			MessageID:   cq.Message.Message.ID,
+			Text:        text,
+			ParseMode:   models.ParseModeHTML,
+			ReplyMarkup: markup,
+		})
+		return err
+	}
+
+	_, err := c.Bot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      c.chatID,
+		Text:        text,
+		ParseMode:   models.ParseModeHTML,
+		ReplyMarkup: markup,
+	})
+	return err
+}
+
+// Confirm asks to confirm moving to state with arg.
+func (c *Context) Confirm(ctx context.Context, text, state, arg string) error {
+	return c.Show(ctx, text,
+		[]models.InlineKeyboardButton{c.Button("Подтвердить", state, arg)},
+		c.NavRow(),
+	)
+}
@@ -0,0 +1,274 @@
+// Package dialog keeps per-chat conversation state for the Telegram bot.
+//
+// Every dialog step is a named State. Inline buttons carry compact callback
+// data of the form "state|arg|mac", where mac is a truncated Stribog HMAC over
+// the chat ID, the state and the argument, so users can't forge callbacks to
+// states or arguments they were never offered. The reserved "back" and
+// "cancel" callbacks return to the previous state and to the root state.
+package dialog
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"server/stribog"
+)
+
+const (
+	stateBack   = "back"
+	stateCancel = "cancel"
+
+	// macLen is the length of the encoded callback MAC.
+	macLen = 8
+	// MaxCallbackData is the Telegram limit for callback data.
+	MaxCallbackData = 64
+
+	// SessionTTL is how long an idle chat keeps its session.
+	SessionTTL = time.Hour
+)
+
+var ErrInvalidCallback = errors.New("invalid callback data")
+
+// Handler handles an update for a chat in a state.
+type Handler func(ctx context.Context, c *Context)
+
+type State struct {
+	// Enter renders the state. It gets the argument the state was entered with.
+	Enter Handler
+	// Text handles text messages sent while the chat is in the state. States
+	// without it re-render on text messages.
+	Text Handler
+	// Action marks states that only run Enter and don't become the chat's state,
+	// like sending a file.
+	Action bool
+}
+
+type frame struct {
+	state string
+	arg   string
+}
+
+// Session is the conversation state of a chat.
+type Session struct {
+	mu      sync.Mutex
+	current frame
+	history []frame
+	// Values holds data collected by the dialog, like a pending upload.
+	Values map[string]interface{}
+	// seen is when the chat last used the session, guarded by Machine.mu.
+	seen time.Time
+}
+
+// State returns the name of the chat's current state.
+func (s *Session) State() string {
+	return s.current.state
+}
+
+type Machine struct {
+	mu        sync.Mutex
+	root      string
+	key       []byte
+	states    map[string]State
+	sessions  map[int64]*Session
+	ttl       time.Duration
+	lastSweep time.Time
+	known     func(ctx context.Context) bool
+}
+
+// New returns a Machine that starts chats in the root state and signs callback
+// data with key. Sessions idle for SessionTTL are evicted.
+func New(root string, key []byte) *Machine {
+	return &Machine{
+		root:     root,
+		key:      key,
+		states:   map[string]State{},
+		sessions: map[int64]*Session{},
+		ttl:      SessionTTL,
+	}
+}
+
+// Known sets the check of whether an update comes from a chat that may have a
+// session. Updates failing it are ignored and no session is created for them.
+func (m *Machine) Known(fn func(ctx context.Context) bool) {
+	m.known = fn
+}
+
+// Register adds a state. Names must not contain "|".
+func (m *Machine) Register(name string, s State) {
+	m.states[name] = s
+}
+
+// session returns the session of the chat, creating it on first use. It fails
+// for chats that aren't known.
+func (m *Machine) session(ctx context.Context, chatID int64) (*Session, bool) {
+	if m.known != nil && !m.known(ctx) {
+		return nil, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) >= m.ttl {
+		m.evict(now)
+	}
+
+	s, ok := m.sessions[chatID]
+	if !ok {
+		s = &Session{current: frame{state: m.root}, Values: map[string]interface{}{}}
+		m.sessions[chatID] = s
+	}
+	s.seen = now
+
+	return s, true
+}
+
+// evict drops the sessions idle for the TTL. m.mu must be held.
+func (m *Machine) evict(now time.Time) {
+	for chatID, s := range m.sessions {
+		if now.Sub(s.seen) >= m.ttl {
+			delete(m.sessions, chatID)
+		}
+	}
+	m.lastSweep = now
+}
+
+// Start resets the chat and renders the root state.
+func (m *Machine) Start(ctx context.Context, b *bot.Bot, update *models.Update, chatID int64) {
+	s, ok := m.session(ctx, chatID)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &Context{Bot: b, Update: update, Session: s, chatID: chatID, m: m}
+	c.reset()
+	m.enter(ctx, c)
+}
+
+// With runs fn for the chat with its session locked. It lets handlers outside
+// the dialog, like the one receiving files, store values and move the chat.
+func (m *Machine) With(ctx context.Context, b *bot.Bot, update *models.Update, chatID int64, fn Handler) {
+	s, ok := m.session(ctx, chatID)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(ctx, &Context{Bot: b, Update: update, Session: s, chatID: chatID, Arg: s.current.arg, m: m})
+}
+
+// HandleMessage passes a message to the Text handler of the chat's state.
+func (m *Machine) HandleMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		return
+	}
+	chatID := update.Message.Chat.ID
+
+	s, ok := m.session(ctx, chatID)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &Context{Bot: b, Update: update, Session: s, chatID: chatID, Arg: s.current.arg, m: m}
+	if st := m.states[s.current.state]; st.Text != nil {
+		st.Text(ctx, c)
+		return
+	}
+	m.enter(ctx, c)
+}
+
+// HandleCallback verifies the callback data and moves the chat to the state it
+// names. It is a bot.HandlerFunc for callback queries.
+func (m *Machine) HandleCallback(ctx context.Context, b *bot.Bot, update *models.Update) {
+	cq := update.CallbackQuery
+	if cq == nil || cq.Message.Message == nil {
+		return
+	}
+	chatID := cq.Message.Message.Chat.ID
+
+	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: cq.ID})
+
+	s, ok := m.session(ctx, chatID)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &Context{Bot: b, Update: update, Session: s, chatID: chatID, m: m}
+	if err := m.dispatch(ctx, c, cq.Data); err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: "This button is no longer valid."})
+	}
+}
+
+func (m *Machine) dispatch(ctx context.Context, c *Context, data string) error {
+	state, arg, err := m.verify(c.chatID, data)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case stateBack:
+		c.back()
+	case stateCancel:
+		c.reset()
+	default:
+		if _, ok := m.states[state]; !ok {
+			return ErrInvalidCallback
+		}
+		c.move(state, arg)
+		if m.states[state].Action {
+			c.Arg = arg
+			m.states[state].Enter(ctx, c)
+			return nil
+		}
+	}
+	m.enter(ctx, c)
+
+	return nil
+}
+
+func (m *Machine) enter(ctx context.Context, c *Context) {
+	c.Arg = c.Session.current.arg
+	if st, ok := m.states[c.Session.current.state]; ok && st.Enter != nil {
+		st.Enter(ctx, c)
+	}
+}
+
+func (m *Machine) sign(chatID int64, state, arg string) string {
+	mac := hmac.New(stribog.New256, m.key)
+	mac.Write([]byte(strconv.FormatInt(chatID, 10) + "|" + state + "|" + arg))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:macLen]
+}
+
+// CallbackData returns the signed callback data for moving the chat to state.
+func (m *Machine) CallbackData(chatID int64, state, arg string) string {
+	return state + "|" + arg + "|" + m.sign(chatID, state, arg)
+}
+
+func (m *Machine) verify(chatID int64, data string) (string, string, error) {
+	parts := strings.Split(data, "|")
+	if len(parts) != 3 {
+		return "", "", ErrInvalidCallback
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(m.sign(chatID, parts[0], parts[1]))) {
+		return "", "", ErrInvalidCallback
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,107 @@
+package dialog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallbackData(t *testing.T) {
+	m := New("menu", []byte("key"))
+
+	data := m.CallbackData(42, "files", "12")
+	require.LessOrEqual(t, len(data), MaxCallbackData)
+
+	state, arg, err := m.verify(42, data)
+	require.NoError(t, err)
+	require.Equal(t, "files", state)
+	require.Equal(t, "12", arg)
+
+	_, _, err = m.verify(43, data)
+	require.ErrorIs(t, err, ErrInvalidCallback)
+
+	_, _, err = m.verify(42, "files|13|"+data[len(data)-macLen:])
+	require.ErrorIs(t, err, ErrInvalidCallback)
+
+	_, _, err = New("menu", []byte("other key")).verify(42, data)
+	require.ErrorIs(t, err, ErrInvalidCallback)
+
+	_, _, err = m.verify(42, "button list")
+	require.ErrorIs(t, err, ErrInvalidCallback)
+}
+
+type recorder struct {
+	entered []string
+}
+
+func (r *recorder) state(name string) State {
+	return State{Enter: func(_ context.Context, c *Context) {
+		r.entered = append(r.entered, name+":"+c.Arg)
+	}}
+}
+
+func TestNavigation(t *testing.T) {
+	r := &recorder{}
+	m := New("menu", []byte("key"))
+	m.Register("menu", r.state("menu"))
+	m.Register("files", r.state("files"))
+	m.Register("file", r.state("file"))
+	m.Register("send", State{Action: true, Enter: r.state("send").Enter})
+
+	ctx := context.Background()
+	s, ok := m.session(ctx, 1)
+	require.True(t, ok)
+	c := &Context{Session: s, chatID: 1, m: m}
+
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, "files", "1")))
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, "files", "2")))
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, "file", "7")))
+	require.Equal(t, "file", s.State())
+
+	// actions run without changing the state
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, "send", "7")))
+	require.Equal(t, "file", s.State())
+
+	// turning pages doesn't add steps to go back through
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, stateBack, "")))
+	require.Equal(t, "files", s.State())
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, stateBack, "")))
+	require.Equal(t, "menu", s.State())
+
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, "file", "7")))
+	s.Values["upload"] = "pending"
+	require.NoError(t, m.dispatch(ctx, c, m.CallbackData(1, stateCancel, "")))
+	require.Equal(t, "menu", s.State())
+	require.Empty(t, s.Values)
+
+	require.Equal(t, []string{"files:1", "files:2", "file:7", "send:7", "files:2", "menu:", "file:7", "menu:"}, r.entered)
+
+	require.ErrorIs(t, m.dispatch(ctx, c, m.CallbackData(1, "unknown", "")), ErrInvalidCallback)
+}
+
+func TestSessions(t *testing.T) {
+	type key struct{}
+	m := New("menu", []byte("key"))
+	m.Known(func(ctx context.Context) bool { return ctx.Value(key{}) != nil })
+
+	// unknown chats get no session
+	_, ok := m.session(context.Background(), 1)
+	require.False(t, ok)
+	require.Empty(t, m.sessions)
+
+	ctx := context.WithValue(context.Background(), key{}, true)
+	s, ok := m.session(ctx, 1)
+	require.True(t, ok)
+	again, _ := m.session(ctx, 1)
+	require.Same(t, s, again)
+
+	// idle sessions are evicted by the next sweep
+	m.ttl = 10 * time.Millisecond
+	time.Sleep(2 * m.ttl)
+	_, ok = m.session(ctx, 2)
+	require.True(t, ok)
+	require.NotContains(t, m.sessions, int64(1))
+	require.Contains(t, m.sessions, int64(2))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/api"
+	"server/storage"
+)
+
+// enrollmentCodeTTL is how long an enrollment code can be redeemed.
+var enrollmentCodeTTL = 72 * time.Hour
+
+// Enrollment is a user along with the one-time code binding their Telegram
+// account.
+type Enrollment struct {
+	storage.User
+	Code          string
+	CodeExpiresAt time.Time
+}
+
+// newEnrollmentCode returns a random code like "ABCDE-FGHIJ".
+func newEnrollmentCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to rand.Read: %w", err)
+	}
+	code := base32.StdEncoding.EncodeToString(raw)[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeEnrollmentCode makes codes typed with spaces, dashes or in lower
+// case match.
+func normalizeEnrollmentCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+// issueEnrollmentCode creates a new code for the user, replacing the unused
+// ones.
+func issueEnrollmentCode(conn storage.Conn, user storage.User) (Enrollment, error) {
+	code, err := newEnrollmentCode()
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("failed to newEnrollmentCode: %w", err)
+	}
+
+	e := Enrollment{User: user, Code: code, CodeExpiresAt: time.Now().Add(enrollmentCodeTTL)}
+	if err = storage.SetEnrollmentCode(conn, storage.EnrollmentCode{
+		CodeHash:  hashAdminToken(normalizeEnrollmentCode(code)),
+		UserID:    user.ID,
+		ExpiresAt: e.CodeExpiresAt,
+	}); err != nil {
+		return Enrollment{}, fmt.Errorf("failed to SetEnrollmentCode: %w", err)
+	}
+
+	return e, nil
+}
+
+// redeemEnrollmentCode binds the Telegram account to the user the code was
+// issued for.
+func redeemEnrollmentCode(code string, tgID int64, tgName string) (storage.User, error) {
+	return storage.RedeemEnrollmentCode(db.DB, hashAdminToken(normalizeEnrollmentCode(code)), tgID, tgName, time.Now())
+}
+
+// Handler
+//
+// issueCode issues a new enrollment code for the user. Moving a bound user to
+// another Telegram account needs the approval of a second admin.
+func issueCode(c echo.Context) error {
+	admin := c.Get("admin").(*storage.Admin)
+
+	user, l, err := loadUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err = canManage(admin, user.Level, user.Department); err != nil {
+		l.Warn("code denied", zap.Error(err))
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	if user.TgID != 0 {
+		p, err := propose(admin, storage.ProposalRebind, user)
+		if err != nil {
+			l.Error("failed to propose", zap.Error(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create proposal")
+		}
+
+		l.Info("rebinding proposed", zap.Int("proposal_id", p.ID))
+		return c.JSON(http.StatusAccepted, p)
+	}
+
+	e, err := issueEnrollmentCode(db.DB, user)
+	if err != nil {
+		l.Error("failed to issueEnrollmentCode", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to issue enrollment code")
+	}
+
+	l.Info("enrollment code issued")
+
+	return c.JSON(http.StatusOK, e)
+}
+
+// Handler
+//
+// linkTelegram issues the code linking the calling admin's Telegram account,
+// which is sent to the bot as "/officer CODE".
+func linkTelegram(c echo.Context) error {
+	admin := c.Get("admin").(*storage.Admin)
+	l := c.Get("logger").(*zap.Logger)
+
+	code, err := newEnrollmentCode()
+	if err != nil {
+		return fmt.Errorf("failed to newEnrollmentCode: %w", err)
+	}
+
+	resp := api.ResponseCode{Code: code, ExpiresAt: time.Now().Add(enrollmentCodeTTL)}
+	if err = storage.SetAdminTgCode(db.DB, admin.ID, hashAdminToken(normalizeEnrollmentCode(code)), resp.ExpiresAt); err != nil {
+		l.Error("failed to SetAdminTgCode", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to issue code")
+	}
+
+	l.Info("telegram link code issued")
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// redeemOfficerCode links the Telegram account to the admin the code was issued
+// for.
+func redeemOfficerCode(code string, tgID int64) (storage.Admin, error) {
+	return storage.RedeemAdminTgCode(db.DB, hashAdminToken(normalizeEnrollmentCode(code)), tgID, time.Now())
+}
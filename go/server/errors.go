@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/api"
+	"server/audit"
+	"server/bundle"
+	"server/crypto"
+	"server/ipfs"
+	"server/security"
+	"server/storage"
+)
+
+// errorCodes maps the typed errors to stable response codes. The first match
+// wins, so specific errors go before the ones they wrap, like pgx.ErrNoRows.
+// The message is fixed because the wrapping errors may hold internal details.
+var errorCodes = []struct {
+	err     error
+	status  int
+	code    string
+	message string
+}{
+	{storage.ErrInvalidCredentials, http.StatusForbidden, "invalid_credentials", "invalid user credentials"},
+	{storage.ErrUserSuspended, http.StatusForbidden, "user_suspended", "user is suspended"},
+	{storage.ErrUserNotFound, http.StatusNotFound, "user_not_found", "user not found"},
+	{storage.ErrFileNotFound, http.StatusNotFound, "file_not_found", "file not found"},
+	{storage.ErrWitnessNotFound, http.StatusForbidden, "witness_not_found", "witness not found"},
+	{storage.ErrAuthorityNotFound, http.StatusNotFound, "authority_not_found", "abe authority not found"},
+	{storage.ErrProposalDecided, http.StatusConflict, "proposal_decided", "proposal is already decided"},
+	{security.ErrWitnessInvalid, http.StatusForbidden, "witness_invalid", "witness is invalid"},
+	{security.ErrLevelUnsupported, http.StatusBadRequest, "level_unsupported", "level is not supported"},
+	{security.ErrNotMember, http.StatusForbidden, "not_member", "user is not a member of the accumulators"},
+	{crypto.ErrPolicyNotSatisfied, http.StatusForbidden, "policy_not_satisfied", "access policy is not satisfied"},
+	{crypto.ErrInvalidContainer, http.StatusBadGateway, "invalid_ciphertext", "stored ciphertext is invalid"},
+	{bundle.ErrInvalidBundle, http.StatusBadGateway, "invalid_bundle", "stored bundle is invalid"},
+	{errBundle, http.StatusBadRequest, "file_is_bundle", "bundles are opened with GET /bundle/:id"},
+	{ipfs.ErrReplication, http.StatusServiceUnavailable, "replication_failed", "not enough ipfs replicas confirmed"},
+	{ipfs.ErrIPFSUnavailable, http.StatusServiceUnavailable, "ipfs_unavailable", "ipfs is unavailable"},
+	{ipfs.ErrInvalidLink, http.StatusBadGateway, "invalid_link", "invalid ipfs link"},
+	{ipfs.ErrNotFound, http.StatusNotFound, "content_not_found", "file content not found"},
+	{storage.ErrDownloadLimit, http.StatusTooManyRequests, "download_limit", "download limit reached"},
+	{errProposalExpired, http.StatusGone, "proposal_expired", "proposal expired"},
+	{errSelfApproval, http.StatusForbidden, "self_approval", "proposal must be approved by another admin"},
+	{pgx.ErrNoRows, http.StatusNotFound, "not_found", "not found"},
+}
+
+// codeInternal is the code of the errors without a mapping. Their details are
+// only logged.
+const codeInternal = "internal"
+
+// errorResponse returns the status and body for err. Only the messages of the
+// typed errors and of echo.HTTPError reach the client; everything else is
+// reported as an internal error.
+func errorResponse(err error) (int, api.ResponseError) {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code, api.ResponseError{Code: statusCode(he.Code), Message: fmt.Sprint(he.Message)}
+	}
+
+	for _, m := range errorCodes {
+		if errors.Is(err, m.err) {
+			return m.status, api.ResponseError{Code: m.code, Message: m.message}
+		}
+	}
+
+	var d *deniedError
+	if errors.As(err, &d) {
+		if d.check == audit.CheckClearance {
+			return http.StatusForbidden, api.ResponseError{Code: "clearance_insufficient", Message: "classification is above the user clearance"}
+		}
+		return http.StatusForbidden, api.ResponseError{Code: "access_denied", Message: "access denied: " + d.check}
+	}
+
+	return http.StatusInternalServerError, api.ResponseError{Code: codeInternal, Message: "internal server error"}
+}
+
+// statusCode turns the status text into a code, like "bad_request".
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" || status == http.StatusInternalServerError {
+		return codeInternal
+	}
+
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// httpErrorHandler writes every error as an api.ResponseError and logs the ones
+// that aren't the client's fault.
+func httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status, resp := errorResponse(err)
+	if status >= http.StatusInternalServerError {
+		l, ok := c.Get("logger").(*zap.Logger)
+		if !ok {
+			l = zap.L()
+		}
+		l.Error("request failed", zap.String("uri", c.Request().RequestURI), zap.Int("status", status), zap.Error(err))
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, resp)
+	}
+	if err != nil {
+		zap.L().Error("failed to write error response", zap.Error(err))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"server/api"
+	"server/audit"
+	"server/bundle"
+	"server/crypto"
+	"server/ipfs"
+	"server/security"
+	"server/storage"
+)
+
+func TestErrorResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"invalid credentials", denied(audit.CheckPK, fmt.Errorf("failed to CheckUserPK: %w", storage.ErrInvalidCredentials)), http.StatusForbidden, "invalid_credentials"},
+		{"suspended", fmt.Errorf("failed to CheckUserPK: %w", storage.ErrUserSuspended), http.StatusForbidden, "user_suspended"},
+		{"user not found", fmt.Errorf("failed to GetUserByID: %w", storage.ErrUserNotFound), http.StatusNotFound, "user_not_found"},
+		{"file not found", fmt.Errorf("failed to GetFile: %w", storage.ErrFileNotFound), http.StatusNotFound, "file_not_found"},
+		{"witness not found", fmt.Errorf("failed to enc: %w", fmt.Errorf("failed to GetWitness: %w", storage.ErrWitnessNotFound)), http.StatusForbidden, "witness_not_found"},
+		{"witness invalid", denied(audit.CheckWitness, fmt.Errorf("failed to Check: %w", security.ErrWitnessInvalid)), http.StatusForbidden, "witness_invalid"},
+		{"level unsupported", fmt.Errorf("failed to Check: %w", security.ErrLevelUnsupported), http.StatusBadRequest, "level_unsupported"},
+		{"policy", denied(audit.CheckABE, fmt.Errorf("failed to Decrypt: %w", crypto.ErrPolicyNotSatisfied)), http.StatusForbidden, "policy_not_satisfied"},
+		{"ipfs", fmt.Errorf("failed to Get: %w", ipfs.ErrIPFSUnavailable), http.StatusServiceUnavailable, "ipfs_unavailable"},
+		{"replication", fmt.Errorf("failed to Put: %w", ipfs.ErrReplication), http.StatusServiceUnavailable, "replication_failed"},
+		{"bundle", fmt.Errorf("failed to ReadManifest: %w", bundle.ErrInvalidBundle), http.StatusBadGateway, "invalid_bundle"},
+		{"file is bundle", errBundle, http.StatusBadRequest, "file_is_bundle"},
+		{"content", fmt.Errorf("failed to Get: %w", ipfs.ErrNotFound), http.StatusNotFound, "content_not_found"},
+		{"proposal decided", fmt.Errorf("failed to DecideProposal: %w", storage.ErrProposalDecided), http.StatusConflict, "proposal_decided"},
+		{"proposal expired", errProposalExpired, http.StatusGone, "proposal_expired"},
+		{"self approval", errSelfApproval, http.StatusForbidden, "self_approval"},
+		{"no rows", fmt.Errorf("failed to GetProposal: %w", pgx.ErrNoRows), http.StatusNotFound, "not_found"},
+		{"clearance", denied(audit.CheckClearance, errors.New("classification level 3 is above the user clearance 1")), http.StatusForbidden, "clearance_insufficient"},
+		{"http error", echo.NewHTTPError(http.StatusBadRequest, "invalid file id"), http.StatusBadRequest, "bad_request"},
+		{"internal", errors.New(`ERROR: relation "file" does not exist (SQLSTATE 42P01)`), http.StatusInternalServerError, "internal"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, resp := errorResponse(tc.err)
+			require.Equal(t, tc.status, status)
+			require.Equal(t, tc.code, resp.Code)
+		})
+	}
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = httpErrorHandler
+	e.GET("/sql", func(c echo.Context) error {
+		return fmt.Errorf("failed to ListFiles: %w", errors.New(`ERROR: syntax error at or near "ORDER" (SQLSTATE 42601)`))
+	})
+	e.GET("/witness", func(c echo.Context) error {
+		return fmt.Errorf("failed to GetWitness: %w", storage.ErrWitnessNotFound)
+	})
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		want   api.ResponseError
+	}{
+		{"/sql", http.StatusInternalServerError, api.ResponseError{Code: "internal", Message: "internal server error"}},
+		{"/witness", http.StatusForbidden, api.ResponseError{Code: "witness_not_found", Message: "witness not found"}},
+		{"/missing", http.StatusNotFound, api.ResponseError{Code: "not_found", Message: "Not Found"}},
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		require.Equal(t, tc.status, rec.Code, tc.path)
+		var got api.ResponseError
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		require.Equal(t, tc.want, got, tc.path)
+		require.NotContains(t, rec.Body.String(), "SQLSTATE")
+	}
+}
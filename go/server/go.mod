@@ -9,10 +9,12 @@ require (
 	github.com/fentec-project/gofe v0.0.0-20220829150550-ccc7482d20ef
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/go-telegram/bot v1.1.5
+	github.com/ipfs/go-cid v0.4.1
 	github.com/jackc/pgx v3.6.2+incompatible
 	github.com/jbenet/go-simple-encrypt v0.0.0-20180707112328-087dc59b773e
 	github.com/jbenet/ipfs-senc v0.0.0-20200522203019-66ab4c0bd06d
 	github.com/labstack/echo/v4 v4.11.4
+	github.com/multiformats/go-multihash v0.2.3
 	github.com/stretchr/testify v1.8.4
 	go.uber.org/zap v1.27.0
 	golang.org/x/sync v0.1.0
@@ -35,7 +37,6 @@ require (
 	github.com/gofrs/uuid v4.4.0+incompatible // indirect
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/ipfs/boxo v0.12.0 // indirect
-	github.com/ipfs/go-cid v0.4.1 // indirect
 	github.com/ipfs/go-ipfs-api v0.7.0 // indirect
 	github.com/jackc/fake v0.0.0-20150926172116-812a484cc733 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.3 // indirect
@@ -56,7 +57,6 @@ require (
 	github.com/multiformats/go-multiaddr v0.8.0 // indirect
 	github.com/multiformats/go-multibase v0.2.0 // indirect
 	github.com/multiformats/go-multicodec v0.9.0 // indirect
-	github.com/multiformats/go-multihash v0.2.3 // indirect
 	github.com/multiformats/go-multistream v0.4.1 // indirect
 	github.com/multiformats/go-varint v0.0.7 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
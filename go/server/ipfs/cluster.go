@@ -0,0 +1,389 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/config"
+)
+
+// confirmInterval is how often the cluster is asked whether the pins of an
+// upload are confirmed.
+const confirmInterval = 250 * time.Millisecond
+
+// ClusterStore keeps the content on an IPFS Cluster through its REST API. The
+// cluster replicates the pins; the content is read through the reader store.
+type ClusterStore struct {
+	api     string
+	client  *http.Client
+	timeout time.Duration
+	retries int
+	// min is the number of peers that have to pin an upload
+	min    int
+	reader BlobStore
+}
+
+// NewClusterStore returns a store on the cluster that reads the content from
+// reader.
+func NewClusterStore(cfg config.IPFS, reader BlobStore) *ClusterStore {
+	api := cfg.Cluster
+	if !strings.Contains(api, "://") {
+		api = "http://" + api
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	retries := cfg.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	min := cfg.Replication
+	if min <= 0 {
+		min = 1
+	}
+
+	return &ClusterStore{
+		api:     strings.TrimSuffix(api, "/"),
+		client:  &http.Client{Timeout: timeout},
+		timeout: timeout,
+		retries: retries,
+		min:     min,
+		reader:  reader,
+	}
+}
+
+func (s *ClusterStore) Required() int {
+	return s.min
+}
+
+// call sends the request and returns the response body.
+func (s *ClusterStore) call(ctx context.Context, method, path string, args url.Values, body []byte, contentType string) ([]byte, error) {
+	var resp []byte
+	err := retry(ctx, s.retries, func() (err error) {
+		resp, err = s.do(ctx, method, path, args, body, contentType)
+		return err
+	})
+
+	return resp, err
+}
+
+func (s *ClusterStore) do(ctx context.Context, method, path string, args url.Values, body []byte, contentType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.api+path+"?"+args.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to NewRequest: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to %s %s: %v", ErrIPFSUnavailable, method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to ReadAll %s: %v", ErrIPFSUnavailable, path, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return data, nil
+	}
+
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(data, &apiErr)
+	switch {
+	case resp.StatusCode == http.StatusNotFound || isNotFound(apiErr.Message):
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, apiErr.Message)
+	case resp.StatusCode < 500 && apiErr.Message != "":
+		return nil, fmt.Errorf("failed to %s %s: %s", method, path, apiErr.Message)
+	default:
+		return nil, fmt.Errorf("%w: failed to %s %s: %s %s", ErrIPFSUnavailable, method, path, resp.Status, apiErr.Message)
+	}
+}
+
+// Ping checks that the cluster and the reader answer.
+func (s *ClusterStore) Ping(ctx context.Context) error {
+	if _, err := s.do(ctx, http.MethodGet, "/id", nil, nil, ""); err != nil {
+		return err
+	}
+
+	return Ping(ctx, s.reader)
+}
+
+// Put adds the content to the cluster and waits until min peers pin it. When
+// they don't in time the content is unpinned and the upload fails.
+func (s *ClusterStore) Put(ctx context.Context, data []byte) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "file")
+	if err != nil {
+		return "", fmt.Errorf("failed to CreateFormFile: %w", err)
+	}
+	if _, err = part.Write(data); err != nil {
+		return "", fmt.Errorf("failed to Write: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return "", fmt.Errorf("failed to Close: %w", err)
+	}
+
+	args := s.replication()
+	args.Set("cid-version", "1")
+	args.Set("raw-leaves", "true")
+	resp, err := s.call(ctx, http.MethodPost, "/add", args, body.Bytes(), w.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+
+	// the cluster streams an object per added file and directory, the data
+	// is a single file
+	var added struct {
+		CID jsonCID `json:"cid"`
+	}
+	if err = decodeStream(resp, func(data []byte) error { return json.Unmarshal(data, &added) }); err != nil {
+		return "", fmt.Errorf("failed to Unmarshal add: %w", err)
+	}
+	c, err := parseLink(string(added.CID))
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.confirm(ctx, c.String()); err != nil {
+		s.call(ctx, http.MethodDelete, "/pins/"+c.String(), nil, nil, "")
+		return "", err
+	}
+
+	return pathOf(c), nil
+}
+
+// replication are the arguments asking the cluster for min pins.
+func (s *ClusterStore) replication() url.Values {
+	return url.Values{"replication-min": {strconv.Itoa(s.min)}}
+}
+
+// confirm waits until min peers pinned the content.
+func (s *ClusterStore) confirm(ctx context.Context, c string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	for {
+		replicas, err := s.Replicas(ctx, c)
+		if err != nil && !errors.Is(err, ErrIPFSUnavailable) {
+			return err
+		}
+		pinned := 0
+		for _, r := range replicas {
+			if r.Status == ReplicaPinned {
+				pinned++
+			}
+		}
+		if pinned >= s.min {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %d of %d pins confirmed", ErrReplication, pinned, s.min)
+		case <-time.After(confirmInterval):
+		}
+	}
+}
+
+// PutDAG stores the node through the reader and has the cluster pin it, which
+// pins the linked content too.
+func (s *ClusterStore) PutDAG(ctx context.Context, node []byte) (string, error) {
+	link, err := s.reader.PutDAG(ctx, node)
+	if err != nil {
+		return "", err
+	}
+	if err = s.Pin(ctx, link); err != nil {
+		s.Unpin(ctx, link)
+		s.reader.Unpin(ctx, link)
+		return "", err
+	}
+
+	return link, nil
+}
+
+func (s *ClusterStore) Get(ctx context.Context, link string) ([]byte, error) {
+	return s.reader.Get(ctx, link)
+}
+
+func (s *ClusterStore) Pin(ctx context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.call(ctx, http.MethodPost, "/pins/"+c.String(), s.replication(), nil, ""); err != nil {
+		return err
+	}
+
+	return s.confirm(ctx, c.String())
+}
+
+func (s *ClusterStore) Unpin(ctx context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.call(ctx, http.MethodDelete, "/pins/"+c.String(), nil, nil, "")
+	return err
+}
+
+// Stat describes the content on the reader; it's pinned when the cluster
+// tracks a pin of it.
+func (s *ClusterStore) Stat(ctx context.Context, link string) (Stat, error) {
+	stat, err := s.reader.Stat(ctx, link)
+	if err != nil {
+		return Stat{}, err
+	}
+
+	replicas, err := s.Replicas(ctx, stat.CID)
+	if err != nil {
+		return Stat{}, err
+	}
+	stat.Pinned = false
+	for _, r := range replicas {
+		if r.Status == ReplicaPinned {
+			stat.Pinned = true
+		}
+	}
+
+	return stat, nil
+}
+
+// Pins returns the CIDs the cluster keeps pinned.
+func (s *ClusterStore) Pins(ctx context.Context) ([]string, error) {
+	resp, err := s.call(ctx, http.MethodGet, "/allocations", url.Values{"filter": {"pin"}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var pins []string
+	err = decodeStream(resp, func(data []byte) error {
+		var pin struct {
+			CID jsonCID `json:"cid"`
+		}
+		if err := json.Unmarshal(data, &pin); err != nil {
+			return err
+		}
+		pins = append(pins, string(pin.CID))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to Unmarshal allocations: %w", err)
+	}
+	sort.Strings(pins)
+
+	return pins, nil
+}
+
+// Replicas returns the status of the content on every cluster peer. Statuses
+// other than pinned and unpinned are the cluster's own, like pinning or
+// pin_error.
+func (s *ClusterStore) Replicas(ctx context.Context, link string) ([]Replica, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.call(ctx, http.MethodGet, "/pins/"+c.String(), nil, nil, "")
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		PeerMap map[string]struct {
+			PeerName string `json:"peername"`
+			Status   string `json:"status"`
+			Error    string `json:"error"`
+		} `json:"peer_map"`
+	}
+	if err = json.Unmarshal(resp, &info); err != nil {
+		return nil, fmt.Errorf("failed to Unmarshal pin: %w", err)
+	}
+
+	replicas := make([]Replica, 0, len(info.PeerMap))
+	for id, p := range info.PeerMap {
+		name := p.PeerName
+		if name == "" {
+			name = id
+		}
+		replicas = append(replicas, Replica{Node: name, Status: p.Status, Error: p.Error})
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].Node < replicas[j].Node })
+
+	return replicas, nil
+}
+
+// jsonCID is a CID encoded either as a string or as a dag-json link,
+// {"/": cid}.
+type jsonCID string
+
+func (c *jsonCID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = jsonCID(s)
+		return nil
+	}
+
+	var link struct {
+		CID string `json:"/"`
+	}
+	if err := json.Unmarshal(data, &link); err != nil {
+		return err
+	}
+	*c = jsonCID(link.CID)
+
+	return nil
+}
+
+// decodeStream calls fn with every object of a JSON array or of a stream of
+// objects.
+func decodeStream(data []byte, fn func(data []byte) error) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	for d.More() {
+		var item json.RawMessage
+		if err := d.Decode(&item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
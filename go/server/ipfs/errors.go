@@ -0,0 +1,15 @@
+package ipfs
+
+import (
+	"errors"
+
+	"server/pkg"
+)
+
+var (
+	// ErrIPFSUnavailable is returned when the node is offline or fails to
+	// store or return the content.
+	ErrIPFSUnavailable = pkg.ErrNoIPFS
+	// ErrInvalidLink is returned for links that aren't IPFS paths or CIDs.
+	ErrInvalidLink = errors.New("invalid ipfs-link")
+)
@@ -0,0 +1,169 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+)
+
+// FSStore keeps the content in a local directory, one file per CID. Pins are
+// empty files next to the blocks.
+type FSStore struct {
+	dir string
+}
+
+func NewFSStore(dir string) (*FSStore, error) {
+	s := &FSStore{dir: dir}
+	for _, d := range []string{s.blocks(), s.pins()} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to MkdirAll: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *FSStore) blocks() string { return filepath.Join(s.dir, "blocks") }
+
+func (s *FSStore) pins() string { return filepath.Join(s.dir, "pins") }
+
+func (s *FSStore) Put(_ context.Context, data []byte) (string, error) {
+	c, err := sum(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to sum: %w", err)
+	}
+
+	return s.put(c, data)
+}
+
+// PutDAG keeps the node like any other content; the linked content stays
+// pinned on its own.
+func (s *FSStore) PutDAG(_ context.Context, node []byte) (string, error) {
+	c, err := sumDAG(node)
+	if err != nil {
+		return "", fmt.Errorf("failed to sumDAG: %w", err)
+	}
+
+	return s.put(c, node)
+}
+
+func (s *FSStore) put(c cid.Cid, data []byte) (string, error) {
+	// a rename makes the block appear whole or not at all
+	f, err := os.CreateTemp(s.blocks(), ".put-")
+	if err != nil {
+		return "", fmt.Errorf("failed to CreateTemp: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to Write: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return "", fmt.Errorf("failed to Close: %w", err)
+	}
+	if err = os.Rename(f.Name(), filepath.Join(s.blocks(), c.String())); err != nil {
+		return "", fmt.Errorf("failed to Rename: %w", err)
+	}
+	if err = os.WriteFile(filepath.Join(s.pins(), c.String()), nil, 0o600); err != nil {
+		return "", fmt.Errorf("failed to WriteFile pin: %w", err)
+	}
+
+	return pathOf(c), nil
+}
+
+func (s *FSStore) Get(_ context.Context, link string) ([]byte, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.blocks(), c.String()))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReadFile: %w", err)
+	}
+
+	return data, nil
+}
+
+func (s *FSStore) Pin(_ context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(filepath.Join(s.blocks(), c.String())); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to Stat: %w", err)
+	}
+	if err = os.WriteFile(filepath.Join(s.pins(), c.String()), nil, 0o600); err != nil {
+		return fmt.Errorf("failed to WriteFile pin: %w", err)
+	}
+
+	return nil
+}
+
+// Unpin removes the content right away, there's no garbage collection to wait
+// for.
+func (s *FSStore) Unpin(_ context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(filepath.Join(s.pins(), c.String())); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to Remove pin: %w", err)
+	}
+	if err = os.Remove(filepath.Join(s.blocks(), c.String())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to Remove: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FSStore) Stat(_ context.Context, link string) (Stat, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return Stat{}, err
+	}
+
+	info, err := os.Stat(filepath.Join(s.blocks(), c.String()))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Stat{}, ErrNotFound
+	}
+	if err != nil {
+		return Stat{}, fmt.Errorf("failed to Stat: %w", err)
+	}
+
+	_, err = os.Stat(filepath.Join(s.pins(), c.String()))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Stat{}, fmt.Errorf("failed to Stat pin: %w", err)
+	}
+
+	return Stat{CID: c.String(), Size: info.Size(), Pinned: err == nil}, nil
+}
+
+func (s *FSStore) Pins(context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.pins())
+	if err != nil {
+		return nil, fmt.Errorf("failed to ReadDir: %w", err)
+	}
+
+	pins := make([]string, 0, len(entries))
+	for _, e := range entries {
+		pins = append(pins, e.Name())
+	}
+
+	return pins, nil
+}
@@ -0,0 +1,308 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"server/config"
+)
+
+const (
+	defaultAPI     = "http://localhost:5001"
+	defaultTimeout = time.Minute
+	defaultRetries = 3
+	// retryBackoff is the delay before the first retry, it doubles after each.
+	retryBackoff = 200 * time.Millisecond
+)
+
+// HTTPStore keeps the content on an IPFS node through its HTTP API.
+type HTTPStore struct {
+	api     string
+	client  *http.Client
+	retries int
+}
+
+func NewHTTPStore(cfg config.IPFS) *HTTPStore {
+	api := cfg.API
+	if api == "" {
+		api = defaultAPI
+	}
+	if !strings.Contains(api, "://") {
+		api = "http://" + api
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	retries := cfg.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+
+	return &HTTPStore{
+		api:     strings.TrimSuffix(api, "/") + "/api/v0/",
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+	}
+}
+
+// apiError is the body of the node's failed responses.
+type apiError struct {
+	Message string
+}
+
+// call posts the command and returns the response body.
+func (s *HTTPStore) call(ctx context.Context, cmd string, args url.Values, body []byte, contentType string) ([]byte, error) {
+	var resp []byte
+	err := retry(ctx, s.retries, func() (err error) {
+		resp, err = s.post(ctx, cmd, args, body, contentType)
+		return err
+	})
+
+	return resp, err
+}
+
+// retry calls fn until it succeeds or fails with an error other than
+// ErrIPFSUnavailable, at most retries more times.
+func retry(ctx context.Context, retries int, fn func() error) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !errors.Is(err, ErrIPFSUnavailable) || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrIPFSUnavailable, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *HTTPStore) post(ctx context.Context, cmd string, args url.Values, body []byte, contentType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.api+cmd+"?"+args.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to NewRequest: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to %s: %v", ErrIPFSUnavailable, cmd, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to ReadAll %s: %v", ErrIPFSUnavailable, cmd, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return data, nil
+	}
+
+	var apiErr apiError
+	if resp.StatusCode == http.StatusInternalServerError && json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+		if isNotFound(apiErr.Message) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, apiErr.Message)
+		}
+		return nil, fmt.Errorf("failed to %s: %s", cmd, apiErr.Message)
+	}
+
+	return nil, fmt.Errorf("%w: failed to %s: %s", ErrIPFSUnavailable, cmd, resp.Status)
+}
+
+// isNotFound tells the node's errors about missing content apart.
+func isNotFound(message string) bool {
+	for _, s := range []string{"not found", "not pinned", "no link named"} {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Ping checks that the node answers.
+func (s *HTTPStore) Ping(ctx context.Context) error {
+	_, err := s.post(ctx, "id", nil, nil, "")
+	return err
+}
+
+// formFile returns data as the multipart body the node expects for files.
+func formFile(data []byte) ([]byte, string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "file")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to CreateFormFile: %w", err)
+	}
+	if _, err = part.Write(data); err != nil {
+		return nil, "", fmt.Errorf("failed to Write: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to Close: %w", err)
+	}
+
+	return body.Bytes(), w.FormDataContentType(), nil
+}
+
+func (s *HTTPStore) Put(ctx context.Context, data []byte) (string, error) {
+	body, contentType, err := formFile(data)
+	if err != nil {
+		return "", err
+	}
+
+	args := url.Values{"pin": {"true"}, "cid-version": {"1"}, "raw-leaves": {"true"}}
+	resp, err := s.call(ctx, "add", args, body, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	var added struct {
+		Hash string
+	}
+	if err = json.Unmarshal(resp, &added); err != nil {
+		return "", fmt.Errorf("failed to Unmarshal add: %w", err)
+	}
+	c, err := parseLink(added.Hash)
+	if err != nil {
+		return "", err
+	}
+
+	return pathOf(c), nil
+}
+
+func (s *HTTPStore) PutDAG(ctx context.Context, node []byte) (string, error) {
+	body, contentType, err := formFile(node)
+	if err != nil {
+		return "", err
+	}
+
+	args := url.Values{"store-codec": {"dag-json"}, "input-codec": {"dag-json"}, "pin": {"true"}}
+	resp, err := s.call(ctx, "dag/put", args, body, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	var put struct {
+		Cid jsonCID
+	}
+	if err = json.Unmarshal(resp, &put); err != nil {
+		return "", fmt.Errorf("failed to Unmarshal dag/put: %w", err)
+	}
+	c, err := parseLink(string(put.Cid))
+	if err != nil {
+		return "", err
+	}
+
+	return pathOf(c), nil
+}
+
+func (s *HTTPStore) Get(ctx context.Context, link string) ([]byte, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	// dag nodes aren't files, their block is the content
+	if c.Type() == cid.DagJSON {
+		return s.call(ctx, "block/get", url.Values{"arg": {c.String()}}, nil, "")
+	}
+
+	return s.call(ctx, "cat", url.Values{"arg": {c.String()}}, nil, "")
+}
+
+func (s *HTTPStore) Pin(ctx context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.call(ctx, "pin/add", url.Values{"arg": {c.String()}}, nil, "")
+	return err
+}
+
+// Unpin lets the node's garbage collection remove the content.
+func (s *HTTPStore) Unpin(ctx context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.call(ctx, "pin/rm", url.Values{"arg": {c.String()}}, nil, "")
+	return err
+}
+
+func (s *HTTPStore) Stat(ctx context.Context, link string) (Stat, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return Stat{}, err
+	}
+
+	// offline lookups fail fast instead of searching the network
+	var stat struct {
+		CumulativeSize int64
+		Size           int64
+	}
+	cmd := "files/stat"
+	if c.Type() == cid.DagJSON {
+		cmd = "block/stat"
+	}
+	resp, err := s.call(ctx, cmd, url.Values{"arg": {pathOf(c)}, "offline": {"true"}}, nil, "")
+	if err != nil {
+		return Stat{}, err
+	}
+	if err = json.Unmarshal(resp, &stat); err != nil {
+		return Stat{}, fmt.Errorf("failed to Unmarshal stat: %w", err)
+	}
+	if cmd == "block/stat" {
+		stat.CumulativeSize = stat.Size
+	}
+
+	pinned := true
+	if _, err = s.call(ctx, "pin/ls", url.Values{"arg": {c.String()}, "type": {"recursive"}}, nil, ""); errors.Is(err, ErrNotFound) {
+		pinned = false
+	} else if err != nil {
+		return Stat{}, err
+	}
+
+	return Stat{CID: c.String(), Size: stat.CumulativeSize, Pinned: pinned}, nil
+}
+
+func (s *HTTPStore) Pins(ctx context.Context) ([]string, error) {
+	resp, err := s.call(ctx, "pin/ls", url.Values{"type": {"recursive"}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var ls struct {
+		Keys map[string]json.RawMessage
+	}
+	if err = json.Unmarshal(resp, &ls); err != nil {
+		return nil, fmt.Errorf("failed to Unmarshal pins: %w", err)
+	}
+
+	pins := make([]string, 0, len(ls.Keys))
+	for key := range ls.Keys {
+		pins = append(pins, key)
+	}
+	sort.Strings(pins)
+
+	return pins, nil
+}
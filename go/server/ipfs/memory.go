@@ -0,0 +1,136 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// MemoryStore keeps the content in memory, for tests and running offline.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	blobs  map[string][]byte
+	pinned map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blobs: map[string][]byte{}, pinned: map[string]bool{}}
+}
+
+func (s *MemoryStore) Put(_ context.Context, data []byte) (string, error) {
+	c, err := sum(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to sum: %w", err)
+	}
+
+	return s.put(c, data), nil
+}
+
+// PutDAG keeps the node like any other content; the linked content stays
+// pinned on its own.
+func (s *MemoryStore) PutDAG(_ context.Context, node []byte) (string, error) {
+	c, err := sumDAG(node)
+	if err != nil {
+		return "", fmt.Errorf("failed to sumDAG: %w", err)
+	}
+
+	return s.put(c, node), nil
+}
+
+func (s *MemoryStore) put(c cid.Cid, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := c.String()
+	s.blobs[key] = append([]byte(nil), data...)
+	s.pinned[key] = true
+
+	return pathOf(c)
+}
+
+func (s *MemoryStore) Get(_ context.Context, link string) ([]byte, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blobs[c.String()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+func (s *MemoryStore) Pin(_ context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blobs[c.String()]; !ok {
+		return ErrNotFound
+	}
+	s.pinned[c.String()] = true
+
+	return nil
+}
+
+// Unpin drops the content right away, there's no garbage collection to wait
+// for.
+func (s *MemoryStore) Unpin(_ context.Context, link string) error {
+	c, err := parseLink(link)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.pinned[c.String()] {
+		return ErrNotFound
+	}
+	delete(s.pinned, c.String())
+	delete(s.blobs, c.String())
+
+	return nil
+}
+
+func (s *MemoryStore) Stat(_ context.Context, link string) (Stat, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return Stat{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.blobs[c.String()]
+	if !ok {
+		return Stat{}, ErrNotFound
+	}
+
+	return Stat{CID: c.String(), Size: int64(len(data)), Pinned: s.pinned[c.String()]}, nil
+}
+
+func (s *MemoryStore) Pins(context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pins := make([]string, 0, len(s.pinned))
+	for key := range s.pinned {
+		pins = append(pins, key)
+	}
+	sort.Strings(pins)
+
+	return pins, nil
+}
@@ -0,0 +1,311 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrReplication is returned when fewer pins than required are confirmed. It
+// matches ErrIPFSUnavailable.
+var ErrReplication = fmt.Errorf("replication factor not met: %w", ErrIPFSUnavailable)
+
+// Replica states.
+const (
+	ReplicaPinned      = "pinned"
+	ReplicaUnpinned    = "unpinned"
+	ReplicaMissing     = "missing"
+	ReplicaUnreachable = "unreachable"
+)
+
+// Replica is the state of content on one node.
+type Replica struct {
+	Node   string
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// ReplicaStatus is the replica health of content.
+type ReplicaStatus struct {
+	CID      string
+	Replicas []Replica
+	Pinned   int
+	Required int
+}
+
+// Healthy reports whether the content has the required number of pins.
+func (s ReplicaStatus) Healthy() bool {
+	return s.Pinned >= s.Required
+}
+
+// replicator is a store that keeps content on several nodes.
+type replicator interface {
+	Replicas(ctx context.Context, link string) ([]Replica, error)
+	// Required is the number of pins an upload needs.
+	Required() int
+}
+
+// Status returns the replica health of the content. A store on a single node
+// has a single replica.
+func Status(ctx context.Context, s BlobStore, link string) (ReplicaStatus, error) {
+	c, err := CID(link)
+	if err != nil {
+		return ReplicaStatus{}, err
+	}
+
+	status := ReplicaStatus{CID: c, Required: 1}
+	if r, ok := s.(replicator); ok {
+		if status.Replicas, err = r.Replicas(ctx, c); err != nil {
+			return ReplicaStatus{}, err
+		}
+		status.Required = r.Required()
+	} else {
+		status.Replicas = []Replica{replicaOf(ctx, Node{Name: "local", Store: s}, c)}
+	}
+
+	for _, r := range status.Replicas {
+		if r.Status == ReplicaPinned {
+			status.Pinned++
+		}
+	}
+
+	return status, nil
+}
+
+func replicaOf(ctx context.Context, n Node, link string) Replica {
+	stat, err := n.Store.Stat(ctx, link)
+	switch {
+	case err == nil && stat.Pinned:
+		return Replica{Node: n.Name, Status: ReplicaPinned}
+	case err == nil:
+		return Replica{Node: n.Name, Status: ReplicaUnpinned}
+	case errors.Is(err, ErrNotFound):
+		return Replica{Node: n.Name, Status: ReplicaMissing}
+	default:
+		return Replica{Node: n.Name, Status: ReplicaUnreachable, Error: err.Error()}
+	}
+}
+
+// Node is a store of a ReplicatedStore.
+type Node struct {
+	Name  string
+	Store BlobStore
+}
+
+// ReplicatedStore keeps the content on every node. Writes need the pins of
+// min nodes, reads fail over between the nodes.
+type ReplicatedStore struct {
+	nodes []Node
+	min   int
+	// next spreads the reads over the nodes
+	next uint32
+}
+
+// NewReplicatedStore returns a store over the nodes. Without a valid min every
+// node has to confirm the pins.
+func NewReplicatedStore(nodes []Node, min int) *ReplicatedStore {
+	if min <= 0 || min > len(nodes) {
+		min = len(nodes)
+	}
+
+	return &ReplicatedStore{nodes: nodes, min: min}
+}
+
+func (s *ReplicatedStore) Required() int {
+	return s.min
+}
+
+// each calls fn on every node concurrently and returns the errors by node.
+func (s *ReplicatedStore) each(fn func(i int, n Node) error) []error {
+	errs := make([]error, len(s.nodes))
+
+	var wg sync.WaitGroup
+	for i, n := range s.nodes {
+		wg.Add(1)
+		go func(i int, n Node) {
+			defer wg.Done()
+			errs[i] = fn(i, n)
+		}(i, n)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// summary counts the successes and returns ErrNotFound when every node
+// reported it, or else the first other error. Nodes without the content don't
+// fail the call when others succeed.
+func summary(errs []error) (int, error) {
+	var (
+		ok       int
+		notFound int
+		first    error
+	)
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			ok++
+		case errors.Is(err, ErrNotFound):
+			notFound++
+		case first == nil:
+			first = err
+		}
+	}
+	if notFound == len(errs) {
+		return 0, ErrNotFound
+	}
+
+	return ok, first
+}
+
+// Ping checks that enough nodes answer to store content.
+func (s *ReplicatedStore) Ping(ctx context.Context) error {
+	ok, err := summary(s.each(func(_ int, n Node) error { return Ping(ctx, n.Store) }))
+	if ok < s.min {
+		return fmt.Errorf("%w: %d of %d nodes available: %v", ErrReplication, ok, s.min, err)
+	}
+
+	return nil
+}
+
+// Put stores the content on every node. When fewer than min nodes confirm the
+// pin, the content is unpinned from the others and the upload fails.
+func (s *ReplicatedStore) Put(ctx context.Context, data []byte) (string, error) {
+	return s.replicate(ctx, func(n BlobStore) (string, error) { return n.Put(ctx, data) })
+}
+
+// PutDAG stores the node on every node like Put.
+func (s *ReplicatedStore) PutDAG(ctx context.Context, node []byte) (string, error) {
+	return s.replicate(ctx, func(n BlobStore) (string, error) { return n.PutDAG(ctx, node) })
+}
+
+func (s *ReplicatedStore) replicate(ctx context.Context, put func(n BlobStore) (string, error)) (string, error) {
+	links := make([]string, len(s.nodes))
+	errs := s.each(func(i int, n Node) (err error) {
+		links[i], err = put(n.Store)
+		return err
+	})
+
+	link := ""
+	for i, err := range errs {
+		if err != nil {
+			continue
+		}
+		if link == "" {
+			link = links[i]
+		} else if links[i] != link {
+			errs[i] = fmt.Errorf("node %s returned %s instead of %s", s.nodes[i].Name, links[i], link)
+		}
+	}
+
+	ok, err := summary(errs)
+	if ok >= s.min {
+		return link, nil
+	}
+
+	s.each(func(i int, n Node) error {
+		if errs[i] == nil && links[i] != "" {
+			n.Store.Unpin(ctx, links[i])
+		}
+		return nil
+	})
+
+	return "", fmt.Errorf("%w: %d of %d pins confirmed: %v", ErrReplication, ok, s.min, err)
+}
+
+// Get reads the content from the first node that has it.
+func (s *ReplicatedStore) Get(ctx context.Context, link string) ([]byte, error) {
+	if _, err := parseLink(link); err != nil {
+		return nil, err
+	}
+
+	start := int(atomic.AddUint32(&s.next, 1))
+	errs := make([]error, 0, len(s.nodes))
+	for i := range s.nodes {
+		data, err := s.nodes[(start+i)%len(s.nodes)].Store.Get(ctx, link)
+		if err == nil {
+			return data, nil
+		}
+		errs = append(errs, err)
+	}
+
+	_, err := summary(errs)
+	return nil, err
+}
+
+// Pin pins the content on every node that has it.
+func (s *ReplicatedStore) Pin(ctx context.Context, link string) error {
+	ok, err := summary(s.each(func(_ int, n Node) error { return n.Store.Pin(ctx, link) }))
+	if errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if ok < s.min {
+		return fmt.Errorf("%w: %d of %d pins confirmed: %v", ErrReplication, ok, s.min, err)
+	}
+
+	return nil
+}
+
+// Unpin unpins the content from every node. Nodes that don't have the pin
+// are skipped.
+func (s *ReplicatedStore) Unpin(ctx context.Context, link string) error {
+	_, err := summary(s.each(func(_ int, n Node) error { return n.Store.Unpin(ctx, link) }))
+	return err
+}
+
+// Stat returns the stat of the first node that has the content.
+func (s *ReplicatedStore) Stat(ctx context.Context, link string) (Stat, error) {
+	errs := make([]error, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		stat, err := n.Store.Stat(ctx, link)
+		if err == nil {
+			return stat, nil
+		}
+		errs = append(errs, err)
+	}
+
+	_, err := summary(errs)
+	return Stat{}, err
+}
+
+// Pins returns the content pinned on at least min of the available nodes.
+// Content with fewer pins is left out, so that it gets pinned again.
+func (s *ReplicatedStore) Pins(ctx context.Context) ([]string, error) {
+	var mu sync.Mutex
+	count := map[string]int{}
+	ok, err := summary(s.each(func(_ int, n Node) error {
+		pins, err := n.Store.Pins(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, pin := range pins {
+			count[pin]++
+		}
+		return err
+	}))
+	if ok == 0 {
+		return nil, err
+	}
+
+	pins := make([]string, 0, len(count))
+	for pin, n := range count {
+		if n >= s.min {
+			pins = append(pins, pin)
+		}
+	}
+	sort.Strings(pins)
+
+	return pins, nil
+}
+
+func (s *ReplicatedStore) Replicas(ctx context.Context, link string) ([]Replica, error) {
+	replicas := make([]Replica, len(s.nodes))
+	s.each(func(i int, n Node) error {
+		replicas[i] = replicaOf(ctx, n, link)
+		return nil
+	})
+
+	return replicas, nil
+}
@@ -0,0 +1,279 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"server/config"
+)
+
+// downStore is a node that doesn't answer.
+type downStore struct{}
+
+func (downStore) Ping(context.Context) error { return ErrIPFSUnavailable }
+func (downStore) Put(context.Context, []byte) (string, error) {
+	return "", ErrIPFSUnavailable
+}
+func (downStore) PutDAG(context.Context, []byte) (string, error) {
+	return "", ErrIPFSUnavailable
+}
+func (downStore) Get(context.Context, string) ([]byte, error) { return nil, ErrIPFSUnavailable }
+func (downStore) Pin(context.Context, string) error           { return ErrIPFSUnavailable }
+func (downStore) Unpin(context.Context, string) error         { return ErrIPFSUnavailable }
+func (downStore) Stat(context.Context, string) (Stat, error)  { return Stat{}, ErrIPFSUnavailable }
+func (downStore) Pins(context.Context) ([]string, error)      { return nil, ErrIPFSUnavailable }
+
+func memoryNodes(n int) []Node {
+	nodes := make([]Node, n)
+	for i := range nodes {
+		nodes[i] = Node{Name: fmt.Sprintf("node%d", i), Store: NewMemoryStore()}
+	}
+
+	return nodes
+}
+
+func TestReplicatedStore(t *testing.T) {
+	testStore(t, NewReplicatedStore(memoryNodes(3), 0))
+}
+
+func TestReplicatedStoreQuorum(t *testing.T) {
+	ctx := context.Background()
+	nodes := append(memoryNodes(2), Node{Name: "down", Store: downStore{}})
+
+	s := NewReplicatedStore(nodes, 2)
+	require.NoError(t, Ping(ctx, s))
+	link, err := s.Put(ctx, []byte("cipher text"))
+	require.NoError(t, err)
+
+	status, err := Status(ctx, s, link)
+	require.NoError(t, err)
+	require.True(t, status.Healthy())
+	require.Equal(t, 2, status.Pinned)
+	require.Equal(t, 2, status.Required)
+	require.Equal(t, ReplicaUnreachable, status.Replicas[2].Status)
+
+	// reads fail over to the nodes that answer
+	for i := 0; i < len(nodes); i++ {
+		data, err := s.Get(ctx, link)
+		require.NoError(t, err)
+		require.Equal(t, "cipher text", string(data))
+	}
+
+	// without all the pins the upload fails and leaves nothing pinned
+	s = NewReplicatedStore(nodes, 0)
+	require.ErrorIs(t, Ping(ctx, s), ErrReplication)
+	_, err = s.Put(ctx, []byte("secret"))
+	require.ErrorIs(t, err, ErrReplication)
+	require.ErrorIs(t, err, ErrIPFSUnavailable)
+	for _, n := range nodes[:2] {
+		pins, err := n.Store.Pins(ctx)
+		require.NoError(t, err)
+		require.Len(t, pins, 1, n.Name)
+	}
+
+	// a node that lost the content is reported missing
+	require.NoError(t, nodes[0].Store.Unpin(ctx, link))
+	status, err = Status(ctx, s, link)
+	require.NoError(t, err)
+	require.False(t, status.Healthy())
+	require.Equal(t, []Replica{
+		{Node: "node0", Status: ReplicaMissing},
+		{Node: "node1", Status: ReplicaPinned},
+		{Node: "down", Status: ReplicaUnreachable, Error: ErrIPFSUnavailable.Error()},
+	}, status.Replicas)
+}
+
+func TestReplicatedStorePins(t *testing.T) {
+	ctx := context.Background()
+	nodes := memoryNodes(3)
+	s := NewReplicatedStore(nodes, 2)
+	link, err := s.Put(ctx, []byte("cipher text"))
+	require.NoError(t, err)
+	c, err := CID(link)
+	require.NoError(t, err)
+
+	pins, err := s.Pins(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{c}, pins)
+
+	// one pin short of min is still enough
+	require.NoError(t, nodes[0].Store.Unpin(ctx, link))
+	pins, err = s.Pins(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{c}, pins)
+
+	// content on a single node is left out, so that it's pinned again
+	require.NoError(t, nodes[1].Store.Unpin(ctx, link))
+	pins, err = s.Pins(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pins)
+}
+
+func TestStatusSingleNode(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	link, err := s.Put(ctx, []byte("cipher text"))
+	require.NoError(t, err)
+
+	status, err := Status(ctx, s, link)
+	require.NoError(t, err)
+	require.Equal(t, ReplicaStatus{
+		CID:      strings.TrimPrefix(link, "/ipfs/"),
+		Replicas: []Replica{{Node: "local", Status: ReplicaPinned}},
+		Pinned:   1,
+		Required: 1,
+	}, status)
+}
+
+// fakeCluster serves the REST API of an IPFS Cluster used by ClusterStore
+// from a MemoryStore. Of its peers only the first pinning ones pin the
+// content.
+type fakeCluster struct {
+	mu      sync.Mutex
+	store   *MemoryStore
+	peers   int
+	pinning int
+	pins    map[string]bool
+}
+
+func (f *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	notFound := func() {
+		reply(http.StatusNotFound, map[string]interface{}{"code": 404, "message": "cid is not part of the global state"})
+	}
+	ctx, c := r.Context(), strings.TrimPrefix(r.URL.Path, "/pins/")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/id":
+		reply(http.StatusOK, map[string]string{"id": "cluster"})
+	case r.Method == http.MethodPost && r.URL.Path == "/add":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			reply(http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+		data, _ := io.ReadAll(file)
+		link, _ := f.store.Put(ctx, data)
+		f.pins[strings.TrimPrefix(link, "/ipfs/")] = true
+		fmt.Fprintf(w, "{\"name\":\"file\",\"cid\":%q,\"size\":%d}\n", strings.TrimPrefix(link, "/ipfs/"), len(data))
+	case r.Method == http.MethodGet && r.URL.Path == "/allocations":
+		pins := []interface{}{}
+		for pin := range f.pins {
+			pins = append(pins, map[string]interface{}{"cid": map[string]string{"/": pin}})
+		}
+		reply(http.StatusOK, pins)
+	case r.Method == http.MethodGet:
+		if !f.pins[c] {
+			notFound()
+			return
+		}
+		peers := map[string]interface{}{}
+		for i := 0; i < f.peers; i++ {
+			peer := map[string]string{"peername": fmt.Sprintf("peer%d", i), "status": "pinned"}
+			if i >= f.pinning {
+				peer["status"], peer["error"] = "pin_error", "context deadline exceeded"
+			}
+			peers[fmt.Sprintf("id%d", i)] = peer
+		}
+		reply(http.StatusOK, map[string]interface{}{"cid": c, "peer_map": peers})
+	case r.Method == http.MethodPost:
+		f.pins[c] = true
+		reply(http.StatusOK, map[string]string{"cid": c})
+	case r.Method == http.MethodDelete:
+		if !f.pins[c] {
+			notFound()
+			return
+		}
+		delete(f.pins, c)
+		f.store.Unpin(ctx, c)
+		reply(http.StatusOK, map[string]string{"cid": c})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClusterStore(t *testing.T) {
+	ctx := context.Background()
+	cluster := &fakeCluster{store: NewMemoryStore(), peers: 3, pinning: 2, pins: map[string]bool{}}
+	srv := httptest.NewServer(cluster)
+	defer srv.Close()
+
+	s := NewClusterStore(config.IPFS{Cluster: srv.URL, Replication: 2, Timeout: time.Second}, cluster.store)
+	require.NoError(t, Ping(ctx, s))
+	link, err := s.Put(ctx, []byte("cipher text"))
+	require.NoError(t, err)
+
+	data, err := s.Get(ctx, link)
+	require.NoError(t, err)
+	require.Equal(t, "cipher text", string(data))
+	stat, err := s.Stat(ctx, link)
+	require.NoError(t, err)
+	require.True(t, stat.Pinned)
+	pins, err := s.Pins(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{stat.CID}, pins)
+
+	status, err := Status(ctx, s, link)
+	require.NoError(t, err)
+	require.True(t, status.Healthy())
+	require.Equal(t, 2, status.Pinned)
+	require.Equal(t, Replica{Node: "peer2", Status: "pin_error", Error: "context deadline exceeded"}, status.Replicas[2])
+
+	require.NoError(t, s.Unpin(ctx, link))
+	require.ErrorIs(t, s.Unpin(ctx, link), ErrNotFound)
+
+	// uploads fail when the cluster doesn't confirm enough pins in time
+	cluster.pinning = 1
+	_, err = s.Put(ctx, []byte("secret"))
+	require.ErrorIs(t, err, ErrReplication)
+	pins, err = s.Pins(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pins)
+}
+
+func TestNewReplicated(t *testing.T) {
+	s, err := New(config.IPFS{Nodes: []string{"a:5001", "b:5001", "c:5001"}, Replication: 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, s.(*ReplicatedStore).Required())
+
+	s, err = New(config.IPFS{Cluster: "cluster:9094", Nodes: []string{"a:5001"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, s.(*ClusterStore).Required())
+
+	_, err = New(config.IPFS{Backend: "memory", Nodes: []string{"a:5001"}})
+	require.Error(t, err)
+}
+
+func TestDecodeStream(t *testing.T) {
+	for _, data := range []string{`[{"cid":"a"},{"cid":{"/":"b"}}]`, "{\"cid\":\"a\"}\n{\"cid\":{\"/\":\"b\"}}\n"} {
+		var cids []string
+		err := decodeStream([]byte(data), func(item []byte) error {
+			var v struct {
+				CID jsonCID `json:"cid"`
+			}
+			err := json.Unmarshal(item, &v)
+			cids = append(cids, string(v.CID))
+			return err
+		})
+		require.NoError(t, err)
+		sort.Strings(cids)
+		require.Equal(t, []string{"a", "b"}, cids)
+	}
+}
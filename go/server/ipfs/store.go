@@ -0,0 +1,132 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+
+	"server/config"
+)
+
+// ErrNotFound is returned for content the store doesn't have.
+var ErrNotFound = errors.New("ipfs content not found")
+
+// BlobStore stores the encrypted files by content. Links are IPFS paths like
+// /ipfs/<cid>; the methods also accept a bare CID.
+type BlobStore interface {
+	// Put stores data and returns its link. Stored content is pinned.
+	Put(ctx context.Context, data []byte) (string, error)
+	// PutDAG stores a dag-json node linking to stored content and pins it
+	// recursively.
+	PutDAG(ctx context.Context, node []byte) (string, error)
+	Get(ctx context.Context, link string) ([]byte, error)
+	Pin(ctx context.Context, link string) error
+	// Unpin lets the content be garbage collected.
+	Unpin(ctx context.Context, link string) error
+	Stat(ctx context.Context, link string) (Stat, error)
+	// Pins returns the CIDs of the pinned content.
+	Pins(ctx context.Context) ([]string, error)
+}
+
+// Stat describes stored content.
+type Stat struct {
+	CID    string
+	Size   int64
+	Pinned bool
+}
+
+// New returns the store selected by the config. A cluster takes precedence
+// over nodes, which take precedence over a single node.
+func New(cfg config.IPFS) (BlobStore, error) {
+	replicated := cfg.Cluster != "" || len(cfg.Nodes) > 0
+	if replicated && cfg.Backend != "" && cfg.Backend != "http" {
+		return nil, fmt.Errorf("ipfs nodes and cluster can't be used with the %q backend", cfg.Backend)
+	}
+
+	switch {
+	case cfg.Cluster != "":
+		var reader BlobStore = NewHTTPStore(cfg)
+		if len(cfg.Nodes) > 0 {
+			// the cluster replicates the content, any node can serve it
+			reader = NewReplicatedStore(nodes(cfg), 1)
+		}
+		return NewClusterStore(cfg, reader), nil
+	case len(cfg.Nodes) > 0:
+		return NewReplicatedStore(nodes(cfg), cfg.Replication), nil
+	}
+
+	switch cfg.Backend {
+	case "", "http":
+		return NewHTTPStore(cfg), nil
+	case "fs":
+		if cfg.Dir == "" {
+			return nil, errors.New("ipfs dir is required by the fs backend")
+		}
+		return NewFSStore(cfg.Dir)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown ipfs backend %q", cfg.Backend)
+	}
+}
+
+// nodes returns a store for each of the configured nodes.
+func nodes(cfg config.IPFS) []Node {
+	nodes := make([]Node, 0, len(cfg.Nodes))
+	for _, api := range cfg.Nodes {
+		node := cfg
+		node.API = api
+		nodes = append(nodes, Node{Name: api, Store: NewHTTPStore(node)})
+	}
+
+	return nodes
+}
+
+// Ping checks that the store is available. Only remote stores can be down.
+func Ping(ctx context.Context, s BlobStore) error {
+	if p, ok := s.(interface{ Ping(context.Context) error }); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+// parseLink returns the CID of an IPFS path or a bare CID.
+func parseLink(link string) (cid.Cid, error) {
+	c, err := cid.Decode(strings.TrimPrefix(link, "/ipfs/"))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %v", ErrInvalidLink, err)
+	}
+
+	return c, nil
+}
+
+// CID returns the CID of the link in its canonical form, so links to the same
+// content compare equal.
+func CID(link string) (string, error) {
+	c, err := parseLink(link)
+	if err != nil {
+		return "", err
+	}
+
+	return c.String(), nil
+}
+
+func pathOf(c cid.Cid) string {
+	return "/ipfs/" + c.String()
+}
+
+// sum returns the CID of data stored as a single raw block, the way the node
+// adds small files with raw leaves. The offline stores use it as the key.
+func sum(data []byte) (cid.Cid, error) {
+	return cid.V1Builder{Codec: cid.Raw, MhType: mh.SHA2_256}.Sum(data)
+}
+
+// sumDAG returns the CID of a dag-json node.
+func sumDAG(node []byte) (cid.Cid, error) {
+	return cid.V1Builder{Codec: cid.DagJSON, MhType: mh.SHA2_256}.Sum(node)
+}
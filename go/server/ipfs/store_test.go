@@ -0,0 +1,212 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/config"
+)
+
+func testStore(t *testing.T, s BlobStore) {
+	ctx := context.Background()
+
+	link, err := s.Put(ctx, []byte("cipher text"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(link, "/ipfs/"), link)
+
+	data, err := s.Get(ctx, link)
+	require.NoError(t, err)
+	require.Equal(t, "cipher text", string(data))
+	data, err = s.Get(ctx, strings.TrimPrefix(link, "/ipfs/"))
+	require.NoError(t, err)
+	require.Equal(t, "cipher text", string(data))
+
+	stat, err := s.Stat(ctx, link)
+	require.NoError(t, err)
+	require.Equal(t, Stat{CID: strings.TrimPrefix(link, "/ipfs/"), Size: 11, Pinned: true}, stat)
+	pins, err := s.Pins(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{stat.CID}, pins)
+
+	node := []byte(`{"entries":[{"/":"` + stat.CID + `"}]}`)
+	root, err := s.PutDAG(ctx, node)
+	require.NoError(t, err)
+	require.NotEqual(t, link, root)
+	data, err = s.Get(ctx, root)
+	require.NoError(t, err)
+	require.JSONEq(t, string(node), string(data))
+	require.NoError(t, s.Unpin(ctx, root))
+
+	require.NoError(t, s.Pin(ctx, link))
+	require.NoError(t, s.Unpin(ctx, link))
+	require.ErrorIs(t, s.Unpin(ctx, link), ErrNotFound)
+	pins, err = s.Pins(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pins)
+	_, err = s.Get(ctx, link)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = s.Stat(ctx, link)
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, s.Pin(ctx, link), ErrNotFound)
+
+	_, err = s.Get(ctx, "/ipfs/not-a-cid")
+	require.ErrorIs(t, err, ErrInvalidLink)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFSStore(t *testing.T) {
+	s, err := NewFSStore(t.TempDir())
+	require.NoError(t, err)
+	testStore(t, s)
+}
+
+// fakeNode serves the commands of the node's HTTP API used by HTTPStore from
+// a MemoryStore. The first failures requests fail with 503.
+type fakeNode struct {
+	mu       sync.Mutex
+	store    *MemoryStore
+	failures int
+	requests int
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	n.requests++
+	fail := n.failures > 0
+	n.failures--
+	n.mu.Unlock()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, arg := r.Context(), r.URL.Query().Get("arg")
+	reply := func(v interface{}, err error) {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"Message": err.Error(), "Code": 0, "Type": "error"})
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "id":
+		reply(map[string]string{"ID": "node"}, nil)
+	case "add":
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			reply(nil, err)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		link, err := n.store.Put(ctx, data)
+		reply(map[string]string{"Hash": strings.TrimPrefix(link, "/ipfs/")}, err)
+	case "dag/put":
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			reply(nil, err)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		link, err := n.store.PutDAG(ctx, data)
+		reply(map[string]interface{}{"Cid": map[string]string{"/": strings.TrimPrefix(link, "/ipfs/")}}, err)
+	case "cat", "block/get":
+		data, err := n.store.Get(ctx, arg)
+		if err != nil {
+			reply(nil, err)
+			return
+		}
+		w.Write(data)
+	case "pin/add":
+		reply(map[string][]string{"Pins": {arg}}, n.store.Pin(ctx, arg))
+	case "pin/rm":
+		reply(map[string][]string{"Pins": {arg}}, n.store.Unpin(ctx, arg))
+	case "pin/ls":
+		if arg == "" {
+			pins, err := n.store.Pins(ctx)
+			keys := map[string]interface{}{}
+			for _, pin := range pins {
+				keys[pin] = map[string]string{"Type": "recursive"}
+			}
+			reply(map[string]interface{}{"Keys": keys}, err)
+			return
+		}
+		stat, err := n.store.Stat(ctx, arg)
+		if err == nil && !stat.Pinned {
+			reply(nil, errors.New(arg+" is not pinned"))
+			return
+		}
+		reply(map[string]interface{}{"Keys": map[string]interface{}{arg: map[string]string{"Type": "recursive"}}}, err)
+	case "files/stat", "block/stat":
+		stat, err := n.store.Stat(ctx, arg)
+		reply(map[string]interface{}{"Hash": stat.CID, "CumulativeSize": stat.Size}, err)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestHTTPStore(t *testing.T) {
+	node := &fakeNode{store: NewMemoryStore()}
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	s := NewHTTPStore(config.IPFS{API: strings.TrimPrefix(srv.URL, "http://")})
+	require.NoError(t, Ping(context.Background(), s))
+	testStore(t, s)
+}
+
+func TestHTTPStoreRetries(t *testing.T) {
+	node := &fakeNode{store: NewMemoryStore(), failures: 2}
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	s := NewHTTPStore(config.IPFS{API: srv.URL, Retries: 2})
+	link, err := s.Put(context.Background(), []byte("cipher text"))
+	require.NoError(t, err)
+	require.Equal(t, 3, node.requests)
+
+	node.failures = 3
+	_, err = s.Get(context.Background(), link)
+	require.ErrorIs(t, err, ErrIPFSUnavailable)
+}
+
+func TestHTTPStoreUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	s := NewHTTPStore(config.IPFS{API: srv.URL, Retries: 1})
+	require.ErrorIs(t, Ping(context.Background(), s), ErrIPFSUnavailable)
+	_, err := s.Put(context.Background(), []byte("cipher text"))
+	require.ErrorIs(t, err, ErrIPFSUnavailable)
+}
+
+func TestNew(t *testing.T) {
+	for _, backend := range []string{"", "http", "memory"} {
+		_, err := New(config.IPFS{Backend: backend})
+		require.NoError(t, err, backend)
+	}
+	_, err := New(config.IPFS{Backend: "fs"})
+	require.Error(t, err)
+	_, err = New(config.IPFS{Backend: "fs", Dir: t.TempDir()})
+	require.NoError(t, err)
+	_, err = New(config.IPFS{Backend: "s3"})
+	require.Error(t, err)
+}
@@ -8,7 +8,6 @@ const batchSize = 16
 
 func Encode(key [32]uint8, body string) ([]byte, error) {
 	batchCount := len(body) / batchSize
-	fmt.Println("KEY: ", key)
 	her, err := NewCipher(key[:32])
 	if err != nil {
 		return nil, fmt.Errorf("failed to NewCipher: %w", err)
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/api"
+	"server/config"
+)
+
+const (
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	// checkTimeout bounds each readiness check.
+	checkTimeout = 5 * time.Second
+)
+
+// Check is a dependency the server needs to serve requests.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// lifecycle tracks the readiness of the server, the uploads in flight and the
+// queued notifications, so that shutdown can drain them.
+type lifecycle struct {
+	mu       sync.Mutex
+	stopping bool
+	uploads  sync.WaitGroup
+	checks   []Check
+
+	jobs    chan func()
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// lc is the lifecycle of the running server.
+var lc = &lifecycle{}
+
+// uploadTimeout bounds a bot upload, it's the shutdown timeout so that drained
+// uploads don't outlive the shutdown.
+var uploadTimeout = defaultShutdownTimeout
+
+// beginUpload registers an upload. It fails once shutdown started; uploads that
+// began are drained by shutdown and must call endUpload.
+func (l *lifecycle) beginUpload() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopping {
+		return false
+	}
+	l.uploads.Add(1)
+
+	return true
+}
+
+func (l *lifecycle) endUpload() {
+	l.uploads.Done()
+}
+
+// startWorkers starts n workers running the jobs queued by enqueue, at most
+// size of which wait for a worker.
+func (l *lifecycle) startWorkers(n, size int) {
+	l.jobs = make(chan func(), size)
+	for i := 0; i < n; i++ {
+		l.workers.Add(1)
+		go func() {
+			defer l.workers.Done()
+
+			for job := range l.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// enqueue queues the job for a worker. It fails when the queue is full, the
+// workers aren't started or shutdown closed the queue.
+func (l *lifecycle) enqueue(job func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return false
+	}
+	select {
+	case l.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *lifecycle) isStopping() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stopping
+}
+
+// httpServer returns the server of e set up by cfg: e.TLSServer when a
+// certificate is configured, e.Server otherwise, so that e.Shutdown stops it.
+func httpServer(e *echo.Echo, cfg config.Server) (*http.Server, error) {
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	s := e.Server
+	if tlsConfig != nil {
+		s = e.TLSServer
+		s.TLSConfig = tlsConfig
+	}
+	s.Addr = cfg.Port
+	s.ReadTimeout = cfg.ReadTimeout
+	s.WriteTimeout = cfg.WriteTimeout
+	s.ReadHeaderTimeout = cfg.ReadHeaderTimeout
+	if s.ReadHeaderTimeout <= 0 {
+		s.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	s.IdleTimeout = cfg.IdleTimeout
+
+	return s, nil
+}
+
+// shutdown stops the HTTP server after the requests in flight and waits for
+// the uploads, then for the queued jobs, until ctx is done.
+func (l *lifecycle) shutdown(ctx context.Context, e *echo.Echo) error {
+	l.mu.Lock()
+	l.stopping = true
+	l.mu.Unlock()
+
+	if err := e.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to Shutdown server: %w", err)
+	}
+
+	if err := wait(ctx, &l.uploads); err != nil {
+		return fmt.Errorf("failed to drain uploads: %w", err)
+	}
+
+	// drained uploads may still have queued jobs
+	l.mu.Lock()
+	l.closed = true
+	if l.jobs != nil {
+		close(l.jobs)
+	}
+	l.mu.Unlock()
+
+	if err := wait(ctx, &l.workers); err != nil {
+		return fmt.Errorf("failed to drain jobs: %w", err)
+	}
+
+	return nil
+}
+
+// wait waits for wg until ctx is done.
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// check runs the checks concurrently and returns the failed ones.
+func (l *lifecycle) check(ctx context.Context) map[string]error {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed = map[string]error{}
+	)
+	for _, c := range l.checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			// checks without a context still report in time
+			errc := make(chan error, 1)
+			go func() { errc <- c.Run(ctx) }()
+
+			var err error
+			select {
+			case err = <-errc:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				mu.Lock()
+				failed[c.Name] = err
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return failed
+}
+
+// detached keeps the values of the context but not its cancellation, so work
+// started before shutdown isn't cut off by it.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detached) Done() <-chan struct{} { return nil }
+
+func (detached) Err() error { return nil }
+
+// Handler
+//
+// healthz reports that the process serves requests.
+func healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, api.ResponseHealth{Status: "ok"})
+}
+
+// Handler
+//
+// readyz reports whether the dependencies are available. Errors are only
+// logged, the response names the failed checks.
+func readyz(c echo.Context) error {
+	resp := api.ResponseHealth{Status: "ready", Checks: map[string]string{}}
+	status := http.StatusOK
+
+	failed := lc.check(c.Request().Context())
+	for _, check := range lc.checks {
+		resp.Checks[check.Name] = "ok"
+		if err, ok := failed[check.Name]; ok {
+			zap.L().Warn("readiness check failed", zap.String("check", check.Name), zap.Error(err))
+			resp.Checks[check.Name] = "failed"
+			resp.Status, status = "not ready", http.StatusServiceUnavailable
+		}
+	}
+	if lc.isStopping() {
+		resp.Status, status = "stopping", http.StatusServiceUnavailable
+	}
+
+	return c.JSON(status, resp)
+}
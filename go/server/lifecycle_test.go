@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"server/api"
+	"server/config"
+	"server/testcert"
+)
+
+func TestReadyz(t *testing.T) {
+	defer func(l *lifecycle) { lc = l }(lc)
+
+	ready := func() (int, api.ResponseHealth) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		require.NoError(t, readyz(e.NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec)))
+
+		var resp api.ResponseHealth
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	lc = &lifecycle{checks: []Check{
+		{Name: "postgres", Run: func(context.Context) error { return nil }},
+		{Name: "ipfs", Run: func(context.Context) error { return errors.New("connection refused") }},
+	}}
+	code, resp := ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, api.ResponseHealth{Status: "not ready", Checks: map[string]string{"postgres": "ok", "ipfs": "failed"}}, resp)
+
+	lc.checks = lc.checks[:1]
+	code, resp = ready()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "ready", resp.Status)
+
+	require.NoError(t, lc.shutdown(context.Background(), echo.New()))
+	code, resp = ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "stopping", resp.Status)
+}
+
+func TestShutdownDrainsUploads(t *testing.T) {
+	l := &lifecycle{}
+	require.True(t, l.beginUpload())
+
+	done := make(chan error, 1)
+	go func() { done <- l.shutdown(context.Background(), echo.New()) }()
+
+	select {
+	case <-done:
+		t.Fatal("shutdown returned with an upload in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.False(t, l.beginUpload())
+
+	l.endUpload()
+	require.NoError(t, <-done)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	l := &lifecycle{}
+	require.True(t, l.beginUpload())
+	defer l.endUpload()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.shutdown(ctx, echo.New()), context.DeadlineExceeded)
+}
+
+func TestHTTPServerTLS(t *testing.T) {
+	ca := testcert.NewCA(t, "ca")
+	cert, key := ca.Issue(t, "server", "127.0.0.1")
+	cfg := config.Server{
+		Port:         "127.0.0.1:0",
+		ReadTimeout:  time.Minute,
+		WriteTimeout: 2 * time.Minute,
+		TLSCert:      cert,
+		TLSKey:       key,
+		TLSClientCA:  ca.CertFile,
+	}
+
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+	e.GET("/ping", func(c echo.Context) error { return c.String(http.StatusOK, "pong") })
+	srv, err := httpServer(e, cfg)
+	require.NoError(t, err)
+	require.Same(t, e.TLSServer, srv)
+	require.Equal(t, time.Minute, srv.ReadTimeout)
+	require.Equal(t, 2*time.Minute, srv.WriteTimeout)
+	require.Equal(t, defaultReadHeaderTimeout, srv.ReadHeaderTimeout)
+
+	ln, err := net.Listen("tcp", cfg.Port)
+	require.NoError(t, err)
+	e.TLSListener = tls.NewListener(ln, srv.TLSConfig)
+	served := make(chan error, 1)
+	go func() { served <- e.StartServer(srv) }()
+	defer func() {
+		require.NoError(t, e.Shutdown(context.Background()))
+		require.ErrorIs(t, <-served, http.ErrServerClosed)
+	}()
+
+	get := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.Pool, Certificates: certs}}}
+		resp, err := client.Get("https://" + ln.Addr().String() + "/ping")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return nil
+	}
+	require.NoError(t, get(ca.KeyPair(t, "client")))
+	require.Error(t, get(), "no client certificate")
+	require.Error(t, get(testcert.NewCA(t, "other").KeyPair(t, "client")), "client certificate of another CA")
+
+	// without a certificate the plain server is used
+	srv, err = httpServer(echo.New(), config.Server{Port: ":0", ReadHeaderTimeout: time.Second})
+	require.NoError(t, err)
+	require.Nil(t, srv.TLSConfig)
+	require.Equal(t, time.Second, srv.ReadHeaderTimeout)
+
+	cfg.TLSClientCA = "missing.pem"
+	_, err = httpServer(echo.New(), cfg)
+	require.Error(t, err)
+}
+
+func TestShutdownDrainsJobs(t *testing.T) {
+	l := &lifecycle{}
+	require.False(t, l.enqueue(func() {}), "workers not started")
+
+	l.startWorkers(1, 1)
+	release := make(chan struct{})
+	var ran []int
+	require.True(t, l.enqueue(func() { <-release; ran = append(ran, 1) }))
+	// the worker may not have taken the first job yet
+	for !l.enqueue(func() { ran = append(ran, 2) }) {
+		time.Sleep(time.Millisecond)
+	}
+	require.False(t, l.enqueue(func() {}), "queue full")
+
+	done := make(chan error, 1)
+	go func() { done <- l.shutdown(context.Background(), echo.New()) }()
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, []int{1, 2}, ran)
+	require.False(t, l.enqueue(func() {}), "queue closed")
+}
@@ -0,0 +1,133 @@
+// Package logging builds the loggers of the server and the CLI. Secrets are
+// kept in values of type Secret, which print and marshal as Redacted, and the
+// loggers also redact fields whose keys name secret material, so neither a
+// mistyped field nor a secret formatted into a message reaches the output.
+package logging
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces secret values in the output.
+const Redacted = "[REDACTED]"
+
+// Secret is a string that redacts itself when printed, logged or marshaled.
+// Reveal returns the value for the code that needs it.
+type Secret string
+
+// Reveal returns the secret value.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String implements fmt.Stringer, which zap.Any and zap.Stringer use. An
+// empty secret stays empty, so a missing one can be told from a set one.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return Redacted
+}
+
+// GoString implements fmt.GoStringer for %#v.
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Format implements fmt.Formatter, so no verb prints the value.
+func (s Secret) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, s.String())
+}
+
+// MarshalText implements encoding.TextMarshaler, used by the JSON and YAML
+// encoders, zap.Reflect included.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// secretKeys are the parts of the field keys whose values are redacted
+// whatever their type.
+var secretKeys = []string{"password", "passphrase", "token", "secret", "pk", "key", "code", "witness"}
+
+// IsSecretKey tells if the values of a field named key are redacted. Keys are
+// split on "_", "-" and ".", so "user_pk" is secret and "pkg" is not.
+func IsSecretKey(key string) bool {
+	for _, part := range strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	}) {
+		for _, s := range secretKeys {
+			if part == s {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// redactCore redacts the fields with secret keys before the wrapped core
+// encodes them.
+type redactCore struct {
+	zapcore.Core
+}
+
+// Redact wraps a core to redact the fields with secret keys, see IsSecretKey.
+// It is meant for zap.WrapCore.
+func Redact(core zapcore.Core) zapcore.Core {
+	return redactCore{core}
+}
+
+func (c redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactCore{c.Core.With(redact(fields))}
+}
+
+func (c redactCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c redactCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(e, redact(fields))
+}
+
+func redact(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		// errors are named "error" and keep their message
+		if f.Type == zapcore.ErrorType || !IsSecretKey(f.Key) {
+			continue
+		}
+		if out == nil {
+			// the caller's fields are not changed
+			out = append(out, fields...)
+		}
+		out[i] = zap.String(f.Key, Redacted)
+	}
+	if out == nil {
+		return fields
+	}
+
+	return out
+}
+
+// NewProduction returns zap's production logger, JSON to stderr from the info
+// level, with the secret fields redacted.
+func NewProduction() (*zap.Logger, error) {
+	return zap.NewProduction(zap.WrapCore(Redact))
+}
+
+// New returns a logger writing JSON lines to w from level on, with the secret
+// fields redacted.
+func New(w zapcore.WriteSyncer, level zapcore.Level) *zap.Logger {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), w, level)
+
+	return zap.New(Redact(core))
+}
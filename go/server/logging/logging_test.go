@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+const value = "hunter2-s3cret"
+
+type credentials struct {
+	User     string
+	Password Secret
+	Nested   struct{ Token Secret }
+}
+
+// TestSecretNeverLogged fails if a Secret reaches the output through any of
+// the ways values are logged or formatted.
+func TestSecretNeverLogged(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(zapcore.AddSync(&buf), zapcore.DebugLevel)
+	s := Secret(value)
+	creds := credentials{User: "alice", Password: s}
+	creds.Nested.Token = s
+
+	l.Info("any", zap.Any("value", s))
+	l.Info("stringer", zap.Stringer("value", s))
+	l.Info("reflect", zap.Reflect("value", creds))
+	l.Info("struct", zap.Any("value", creds))
+	l.Info("error", zap.Error(fmt.Errorf("failed to login with %v: %w", s, errors.New("denied"))))
+	l.With(zap.Any("value", s)).Info("with")
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%10s", "%d"} {
+		l.Info(fmt.Sprintf(verb, s))
+		l.Info(fmt.Sprintf(verb, creds))
+		l.Sugar().Infof(verb, s)
+	}
+	l.Sugar().Infow("sugar", "value", s, "creds", creds)
+	l.Info(fmt.Sprint(s, []Secret{s}, map[string]Secret{"k": s}, &creds))
+
+	// plain strings under secret keys
+	l.Info("keys", zap.String("password", value), zap.String("user_pk", value), zap.String("X-Admin-Key", value),
+		zap.ByteString("enrollment_code", []byte(value)), zap.Strings("witness", []string{value}))
+	l.With(zap.String("secret_token", value)).Info("with keys")
+	l.Sugar().Infow("sugar keys", "token", value)
+
+	// the other encoders of the repo
+	data, err := json.Marshal(creds)
+	require.NoError(t, err)
+	l.Info(string(data))
+	data, err = yaml.Marshal(creds)
+	require.NoError(t, err)
+	l.Info(string(data))
+
+	out := buf.String()
+	require.NotContains(t, out, value)
+	require.NotContains(t, out, fmt.Sprintf("%x", value))
+	require.Contains(t, out, Redacted)
+	require.Contains(t, out, "alice")
+	require.Contains(t, out, "denied")
+	require.Equal(t, value, s.Reveal())
+	require.Empty(t, Secret("").String())
+}
+
+func TestIsSecretKey(t *testing.T) {
+	for _, key := range []string{"pk", "user_pk", "Password", "admin_token", "X-Admin-Key", "code", "witness", "db.password"} {
+		require.True(t, IsSecretKey(key), key)
+	}
+	for _, key := range []string{"pkg", "user_id", "tg_name", "keys", "cid", "uri", "error", ""} {
+		require.False(t, IsSecretKey(key), key)
+	}
+}
+
+func TestSecretDecoding(t *testing.T) {
+	var cfg struct {
+		Password Secret `yaml:"password" json:"password"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte("password: "+value), &cfg))
+	require.Equal(t, value, cfg.Password.Reveal())
+
+	cfg.Password = ""
+	require.NoError(t, json.Unmarshal([]byte(`{"password":"`+value+`"}`), &cfg))
+	require.Equal(t, value, cfg.Password.Reveal())
+	require.False(t, strings.Contains(fmt.Sprint(cfg), value))
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"server/pkg"
+	"strings"
+	"syscall"
 
 	"github.com/go-playground/validator"
 	"github.com/go-telegram/bot"
@@ -20,100 +20,274 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"server/config"
+	"server/dialog"
+	"server/ipfs"
+	"server/logging"
+	"server/pkg"
+	"server/reconcile"
+	"server/security"
 	"server/storage"
 )
 
 var db storage.Database
 
+// blobs stores the encrypted files.
+var blobs ipfs.BlobStore
+
+// dialogs keeps the conversation state of the bot chats.
+var dialogs *dialog.Machine
+
 func init() {
-	zap.ReplaceGlobals(zap.Must(zap.NewProduction()))
+	zap.ReplaceGlobals(zap.Must(logging.NewProduction()))
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify-audit":
+			os.Exit(verifyAudit(os.Args[2:]))
+		case "replica-status":
+			os.Exit(replicaStatus(os.Args[2:]))
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		}
+	}
+
+	if err := run(); err != nil {
+		zap.L().Fatal("server stopped", zap.Error(err))
+	}
+	zap.L().Info("server stopped")
+}
+
+// tables are created at startup in this order.
+var tables = []struct {
+	name   string
+	create func(*pgx.ConnPool) error
+}{
+	{"auth", storage.CreateTableAbe},
+	{"witness", storage.CreateTableWitness},
+	{"accumulator", storage.CreateTableAccumulator},
+	{"user", storage.CreateTableUser},
+	{"file", storage.CreateTableFile},
+	{"file_link", storage.CreateTableFileLink},
+	{"admin", storage.CreateTableAdmin},
+	{"proposal", storage.CreateTableProposal},
+	{"notification_channel", storage.CreateTableNotificationChannel},
+	{"delivery", storage.CreateTableDelivery},
+	{"enrollment_code", storage.CreateTableEnrollmentCode},
+	{"audit_event", storage.CreateTableAuditEvent},
+	{"pin", storage.CreateTablePin},
+}
+
+// run serves until SIGINT or SIGTERM and shuts down gracefully.
+func run() error {
 	cfgPath, err := config.ParseFlags()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to ParseFlags: %w", err)
 	}
 
-	fmt.Println("-------------:", cfgPath)
 	cfg, err := config.NewConfig(cfgPath)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to NewConfig: %w", err)
+	}
+	if err = cfg.Validate(); err != nil {
+		return err
 	}
+	zap.L().Info("config loaded", zap.String("path", cfgPath))
 
-	if err = db.Init(*cfg); err != nil {
-		panic(err)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err = db.Init(ctx, *cfg); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	defer db.DB.Close()
 
-	if err = storage.CreateTableAbe(db.DB); err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		panic(err)
+	if blobs, err = ipfs.New(cfg.IPFS); err != nil {
+		return fmt.Errorf("failed to create ipfs store: %w", err)
+	}
+	retention := cfg.IPFS.Retention
+	if retention <= 0 {
+		retention = defaultRetention
 	}
+	reconciler = reconcile.New(blobs, retention, cfg.IPFS.RemoveOrphans)
 
-	if err = storage.CreateTableWitness(db.DB); err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		panic(err)
+	for _, t := range tables {
+		if err = t.create(db.DB); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to create table %s: %w", t.name, err)
+		}
 	}
 
-	if err = storage.CreateTableAccumulator(db.DB); err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		panic(err)
+	if cfg.Server.ProposalTTL > 0 {
+		proposalTTL = cfg.Server.ProposalTTL
+	}
+	tgConfig = cfg.Telegram
+	auditKey = []byte(cfg.Audit.Key.Reveal())
+	if cfg.Server.EnrollmentCodeTTL > 0 {
+		enrollmentCodeTTL = cfg.Server.EnrollmentCodeTTL
 	}
 
-	if err = storage.CreateTableUser(db.DB); err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		panic(err)
+	// the configured admin token bootstraps the first chief officer
+	if err = storage.EnsureAdmin(db.DB, storage.Admin{
+		Name:      "root",
+		TokenHash: hashAdminToken(cfg.Server.AdminToken.Reveal()),
+		Role:      storage.RoleChief,
+	}); err != nil {
+		return fmt.Errorf("failed to EnsureAdmin: %w", err)
 	}
 
-	if err = storage.CreateTableFile(db.DB); err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		panic(err)
+	if err = initMembers(); err != nil {
+		return fmt.Errorf("failed to initMembers: %w", err)
 	}
 
 	// Echo instance
 	e := echo.New()
 	e.Validator = &CustomValidator{validator: validator.New()}
+	e.HTTPErrorHandler = httpErrorHandler
 
 	// Middleware
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
 	// Routes
+	e.GET("/healthz", healthz)
+	e.GET("/readyz", readyz)
 	e.POST("/file/encrypt", Encrypt)
 	e.POST("/file/decrypt", decrypt)
+	e.GET("/me", getMe, requireUser)
+	e.POST("/witness", refreshWitness, requireUser)
+	e.POST("/prove", prove, requireUser)
+	e.GET("/files", listFiles, requireUser)
+	e.GET("/files/:id", getFileInfo, requireUser)
+	e.POST("/files", uploadFile, requireUser)
+	e.GET("/files/:id/content", downloadFile, requireUser)
+	e.DELETE("/file/:id", deleteFile, requireUser)
+	e.POST("/bundle", createBundle, requireUser)
+	e.GET("/bundle/:id", openBundle, requireUser)
+	e.GET("/notifications", getNotificationChannels, requireUser)
+	e.POST("/notifications", addNotificationChannel, requireUser)
+	e.DELETE("/notifications/:id", deleteNotificationChannel, requireUser)
 
-	e.Use(cfg.CheckAdmin)
-	e.POST("/admin/add", add)
-	e.PUT("/admin/check", check)
-	e.DELETE("/admin/delete", delete)
-	e.GET("/admin/all", getAll)
-	// set up tg bot
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
+	adm := e.Group("/admin", auditAdmin, requireAdmin)
+	adm.GET("/me", getAdminMe)
+	adm.POST("/add", add, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.PUT("/check", check)
+	adm.DELETE("/delete", delete, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.GET("/all", getAll)
+	adm.POST("/admins", addAdmin, requireRole(storage.RoleChief))
+	adm.GET("/admins", getAdmins, requireRole(storage.RoleChief, storage.RoleAuditor))
+	adm.POST("/telegram", linkTelegram)
+	adm.PATCH("/user/:id", updateUser, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.DELETE("/user/:id", delete, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.POST("/user/:id/suspend", suspendUser, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.POST("/user/:id/reinstate", reinstateUser, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.POST("/user/:id/code", issueCode, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.GET("/proposals", getProposals)
+	adm.POST("/proposals/:id/approve", approveProposal, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.POST("/proposals/:id/reject", rejectProposal, requireRole(storage.RoleChief, storage.RoleDepartment))
+	adm.GET("/audit", getAuditEvents, requireRole(auditRoles...))
+	adm.GET("/audit/export", exportAuditEvents, requireRole(auditRoles...))
+	adm.GET("/reconcile", getReconcileReport, requireRole(storage.RoleChief, storage.RoleAuditor))
+	adm.POST("/reconcile", runReconcile, requireRole(storage.RoleChief))
+	// set up tg bot, the callback secret is required by Validate
+	dialogs = dialog.New("menu", []byte(cfg.Telegram.CallbackSecret.Reveal()))
+	registerDialogs(dialogs)
+	registerAdminDialogs(dialogs)
+	// sessions are only kept for users and officers
+	dialogs.Known(func(ctx context.Context) bool {
+		user, _ := ctx.Value("user").(*storage.User)
+		admin, _ := ctx.Value("admin").(*storage.Admin)
+		return user != nil || admin != nil
+	})
 
 	opts := []bot.Option{
 		bot.WithMiddlewares(showMessageWithUserName),
 		bot.WithDefaultHandler(handler),
-		bot.WithCallbackQueryDataHandler("button", bot.MatchTypePrefix, callbackMenuHandler),
-		bot.WithCallbackQueryDataHandler("file", bot.MatchTypePrefix, callbackFileHandler),
+		bot.WithCallbackQueryDataHandler("", bot.MatchTypePrefix, dialogs.HandleCallback),
+	}
+
+	b, err := bot.New(cfg.Telegram.Key.Reveal(), opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create bot: %w", err)
 	}
 
-	b, err := bot.New(cfg.Telegram.Key, opts...)
-	if nil != err {
-		// panics for the sake of simplicity.
-		// you should handle this error properly in your code.
-		panic(err)
+	notifier = newNotifier(b, cfg.Notify)
+	lc.startWorkers(notifyWorkers, notifyQueueSize)
+
+	webhook := cfg.Telegram.Webhook
+	if webhook.Enabled {
+		if err = mountWebhook(e, b, webhook); err != nil {
+			return fmt.Errorf("failed to mountWebhook: %w", err)
+		}
+		if err = setWebhook(ctx, b, webhook); err != nil {
+			return fmt.Errorf("failed to setWebhook: %w", err)
+		}
+	} else if _, err = b.DeleteWebhook(ctx, &bot.DeleteWebhookParams{}); err != nil {
+		// long polling doesn't work while a webhook is set
+		return fmt.Errorf("failed to DeleteWebhook: %w", err)
+	}
+
+	lc.checks = []Check{
+		{Name: "postgres", Run: db.Ping},
+		{Name: "ipfs", Run: func(ctx context.Context) error { return ipfs.Ping(ctx, blobs) }},
+		{Name: "accumulators", Run: func(context.Context) error { return security.CheckStore() }},
+		{Name: "telegram", Run: func(ctx context.Context) error {
+			_, err := b.GetMe(ctx)
+			return err
+		}},
+	}
+	shutdownTimeout := cfg.Server.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	uploadTimeout = shutdownTimeout
+
+	srv, err := httpServer(e, cfg.Server)
+	if err != nil {
+		return fmt.Errorf("failed to set up the HTTP server: %w", err)
 	}
 
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		// Start server
-		return fmt.Errorf("failed to Start server: %w", e.Start(cfg.Server.Port))
+		err := e.StartServer(srv)
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("failed to Start server: %w", err)
+	})
+	g.Go(func() error {
+		<-ctx.Done()
+		zap.L().Info("shutting down", zap.Duration("timeout", shutdownTimeout))
+
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return lc.shutdown(sctx, e)
+	})
+	g.Go(func() error {
+		interval := cfg.IPFS.ReconcileInterval
+		if interval <= 0 {
+			interval = defaultReconcileInterval
+		}
+		return reconcileLoop(ctx, interval)
+	})
+	g.Go(func() error {
+		if err := sweepDeliveries(ctx, b); !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
 	})
 	g.Go(func() error {
-		// Start tg bot listener
-		b.Start(ctx)
-		return fmt.Errorf("failed to Start tg bot")
+		// Start tg bot listener, it returns after the updates in flight
+		if webhook.Enabled {
+			b.StartWebhook(ctx)
+		} else {
+			b.Start(ctx)
+		}
+		return nil
 	})
 
-	if err = g.Wait(); err != nil {
-		log.Fatal(err)
-	}
+	return g.Wait()
 }
 
 func showMessageWithUserName(next bot.HandlerFunc) bot.HandlerFunc {
@@ -131,34 +305,69 @@ func showMessageWithUserName(next bot.HandlerFunc) bot.HandlerFunc {
 			return
 		}
 
-		l := zap.L().With(zap.String("username", data.Username), zap.String("message", data.Text))
-		if user, err := storage.GetUserByTgName(db.DB, data.Username); err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				l.Info("unknown user")
-				_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-					ChatID: data.ChatID,
-					Text:   "Your account not found in database! Please contact your system administrator.",
-				})
-				if err != nil {
-					l.Error("failed to send message", zap.Error(err))
-				}
-				return
+		l := zap.L().With(zap.Int64("tg_id", data.UserID), zap.String("username", data.Username))
+		if strings.HasPrefix(data.Text, "/officer") {
+			bindOfficer(ctx, b, data, l)
+			return
+		}
+
+		admin, err := storage.GetAdminByTgID(db.DB, data.UserID)
+		if err == nil {
+			l = l.With(zap.Int("admin_id", admin.ID), zap.String("admin", admin.Name), zap.String("role", admin.Role))
+			ctx = context.WithValue(ctx, "admin", &admin)
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			l.Error("failed to GetAdminByTgID", zap.Error(err))
+		}
+
+		user, err := storage.GetUserByTgID(db.DB, data.UserID)
+		if errors.Is(err, pgx.ErrNoRows) && ctx.Value("admin") != nil {
+			// the message may hold an enrollment code, so it isn't logged
+			ctx = context.WithValue(ctx, "logger", l)
+			ctx = context.WithValue(ctx, "data", &data)
+			next(ctx, b, update)
+			return
+		} else if errors.Is(err, pgx.ErrNoRows) {
+			// the message may hold an enrollment code, so it isn't logged
+			l.Info("unknown user")
+			bindTelegram(ctx, b, data, l)
+			return
+		} else if err != nil {
+			l.Error("failed to GetUserByTgID", zap.Error(err))
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: data.ChatID,
+				Text:   "Internal server error. Sorry",
+			})
+			if err != nil {
+				l.Error("failed to send message", zap.Error(err))
+			}
+			return
+		}
+
+		l = l.With(zap.Int("user_id", user.ID), zap.String("message", data.Text))
+		if user.Status == storage.UserSuspended {
+			l.Info("suspended user")
+			_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: data.ChatID,
+				Text:   "Your account is suspended! Please contact your system administrator.",
+			})
+			if err != nil {
+				l.Error("failed to send message", zap.Error(err))
+			}
+			return
+		}
+
+		// users without a username keep the last one known
+		if data.Username != "" && data.Username != user.TgName {
+			if err := storage.UpdateUserTgName(db.DB, user.ID, user.TgName, data.Username); err != nil {
+				l.Error("failed to UpdateUserTgName", zap.Error(err))
 			} else {
-				l.Error("failed to GetUserByTgName", zap.Error(err))
-				_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-					ChatID: data.ChatID,
-					Text:   "Internal server error. Sorry",
-				})
-				if err != nil {
-					l.Error("failed to send message", zap.Error(err))
-				}
-				return
+				l.Info("username changed", zap.String("old_username", user.TgName))
+				user.TgName = data.Username
 			}
-		} else {
-			l.Info("found user")
-			ctx = context.WithValue(ctx, "user", &user)
 		}
 
+		l.Info("found user")
+		ctx = context.WithValue(ctx, "user", &user)
 		ctx = context.WithValue(ctx, "logger", l)
 		ctx = context.WithValue(ctx, "data", &data)
 
@@ -166,6 +375,73 @@ func showMessageWithUserName(next bot.HandlerFunc) bot.HandlerFunc {
 	}
 }
 
+// bindTelegram redeems the enrollment code sent by an unknown Telegram account.
+// Codes can be sent as a message or through a "/start CODE" link.
+func bindTelegram(ctx context.Context, b *bot.Bot, data pkg.TgData, l *zap.Logger) {
+	reply := func(text string) {
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: text}); err != nil {
+			l.Error("failed to send message", zap.Error(err))
+		}
+	}
+
+	code := strings.TrimSpace(strings.TrimPrefix(data.Text, "/start"))
+	if data.IsCallback || code == "" {
+		reply("Your account is not linked. Please send the enrollment code you got from your system administrator.")
+		return
+	}
+
+	user, err := redeemEnrollmentCode(code, data.UserID, data.Username)
+	switch {
+	case errors.Is(err, storage.ErrInvalidCode):
+		l.Warn("invalid enrollment code")
+		reply("The enrollment code is invalid or expired. Please contact your system administrator.")
+	case errors.Is(err, storage.ErrTgIDBound):
+		l.Warn("telegram account is bound to another user")
+		reply("This Telegram account is linked to another user. Please contact your system administrator.")
+	case err != nil:
+		l.Error("failed to redeemEnrollmentCode", zap.Error(err))
+		reply("Internal server error. Sorry")
+	default:
+		l.Info("telegram account bound", zap.Int("user_id", user.ID))
+		// the credentials of the HTTP API reach the user here only, admins
+		// never see them
+		reply(fmt.Sprintf("Your Telegram account is linked. Send /start to begin.\n\n"+
+			"To use the mmipfs client, log in with user ID %d and PK %s. Keep the PK secret and delete this message.", user.ID, user.PK))
+	}
+}
+
+// bindOfficer links the Telegram account to the admin who got the code from
+// POST /admin/telegram.
+func bindOfficer(ctx context.Context, b *bot.Bot, data pkg.TgData, l *zap.Logger) {
+	reply := func(text string) {
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: data.ChatID, Text: text}); err != nil {
+			l.Error("failed to send message", zap.Error(err))
+		}
+	}
+
+	code := strings.TrimSpace(strings.TrimPrefix(data.Text, "/officer"))
+	if code == "" {
+		reply("Usage: /officer CODE")
+		return
+	}
+
+	admin, err := redeemOfficerCode(code, data.UserID)
+	switch {
+	case errors.Is(err, storage.ErrInvalidCode):
+		l.Warn("invalid officer code")
+		reply("The code is invalid or expired.")
+	case errors.Is(err, storage.ErrTgIDBound):
+		l.Warn("telegram account is linked to another officer")
+		reply("This Telegram account is linked to another officer.")
+	case err != nil:
+		l.Error("failed to redeemOfficerCode", zap.Error(err))
+		reply("Internal server error. Sorry")
+	default:
+		l.Info("officer telegram account linked", zap.Int("admin_id", admin.ID))
+		reply("Your officer account is linked.\n\n" + adminHelp)
+	}
+}
+
 func (cv *CustomValidator) Validate(i interface{}) error {
 	if err := cv.validator.Struct(i); err != nil {
 		// Optionally, you could return the error to give each route more control over the status code
@@ -1,25 +1,34 @@
 package main
 
-import "github.com/go-playground/validator"
-
-type RequestAdd struct {
-	Level      int    `json:"Level"`
-	Department int    `json:"Department"`
-	ID         int    `json:"ID"`
-	PK         string `json:"PK"`
-	TgName     string `json:"TgName"`
+import (
+	"github.com/go-playground/validator"
+
+	"server/api"
+	"server/storage"
+)
+
+// The request and response types are in server/api. The storage records
+// returned by the API are converted to their api mirrors here.
+
+func apiFile(f storage.File) api.File {
+	return api.File(f)
+}
+
+func apiFiles(files []storage.File) []api.File {
+	out := make([]api.File, len(files))
+	for i, f := range files {
+		out[i] = apiFile(f)
+	}
+
+	return out
 }
 
-type RequestFile struct {
-	Level      int    `json:"Level" validate:"required"`
-	Department int    `json:"Department" validate:"required"`
-	ID         int    `json:"ID" validate:"required"`
-	PK         string `json:"PK"`
-	File       string `json:"File" validate:"required"`
+func apiUser(u storage.User) api.User {
+	return api.User(u)
 }
 
-type ResponseFile struct {
-	File string `json:"File" validate:"required"`
+func apiAdmin(a storage.Admin) api.Admin {
+	return api.Admin{ID: a.ID, Name: a.Name, Role: a.Role, Department: a.Department, TgID: a.TgID}
 }
 
 type CustomValidator struct {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"server/api"
+	"server/storage"
+)
+
+// The handlers return some storage records as they are, clients decode them
+// into the api mirrors.
+func TestAPIMirrors(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	user := storage.User{ID: 7, TgName: "alice", TgID: 42, PK: "pk", Department: 2, Level: 3, Status: storage.UserActive}
+	// the admin responses never carry the pk
+	listed := apiUser(user)
+	listed.PK = ""
+
+	cases := []struct {
+		name string
+		in   interface{}
+		out  interface{}
+		want interface{}
+	}{
+		{
+			name: "admin",
+			in:   storage.Admin{ID: 1, Name: "root", TokenHash: "secret", Role: storage.RoleChief, Department: 2, TgID: 9},
+			out:  &api.Admin{},
+			want: &api.Admin{ID: 1, Name: "root", Role: storage.RoleChief, Department: 2, TgID: 9},
+		},
+		{
+			name: "proposal",
+			in:   storage.Proposal{ID: 3, Action: storage.ProposalDelete, User: user, ProposedBy: 1, Status: "pending", CreatedAt: now, ExpiresAt: now},
+			out:  &api.Proposal{},
+			want: &api.Proposal{ID: 3, Action: storage.ProposalDelete, User: listed, ProposedBy: 1, Status: "pending", CreatedAt: now, ExpiresAt: now},
+		},
+		{
+			name: "approval",
+			in:   Approval{Proposal: storage.Proposal{ID: 3, Action: storage.ProposalAdd, User: user, Status: "executed", Result: "7"}, Enrollment: &Enrollment{User: user, Code: "ABCDE-FGHIJ", CodeExpiresAt: now}},
+			out:  &api.ResponseApproval{},
+			want: &api.ResponseApproval{
+				Proposal:   api.Proposal{ID: 3, Action: storage.ProposalAdd, User: listed, Status: "executed", Result: "7"},
+				Enrollment: &api.Enrollment{User: listed, Code: "ABCDE-FGHIJ", CodeExpiresAt: now},
+			},
+		},
+		{
+			name: "users",
+			in:   []storage.User{user},
+			out:  &[]api.User{},
+			want: &[]api.User{listed},
+		},
+		{
+			name: "enrollment",
+			in:   Enrollment{User: user, Code: "ABCDE-FGHIJ", CodeExpiresAt: now},
+			out:  &api.Enrollment{},
+			want: &api.Enrollment{User: listed, Code: "ABCDE-FGHIJ", CodeExpiresAt: now},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.in)
+			require.NoError(t, err)
+
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			require.NoError(t, dec.Decode(tc.out))
+			require.Equal(t, tc.want, tc.out)
+		})
+	}
+}
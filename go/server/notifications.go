@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/api"
+	"server/config"
+	"server/notify"
+	"server/storage"
+)
+
+const (
+	// notifyTimeout bounds sending one event to all of a user's channels.
+	notifyTimeout = 30 * time.Second
+	// notifyWorkers send the queued notifications, at most notifyQueueSize of
+	// which wait for them.
+	notifyWorkers   = 4
+	notifyQueueSize = 1024
+)
+
+// notifier sends events to users. It has no channels until main sets it up.
+var notifier = notify.NewDispatcher()
+
+// newNotifier returns a dispatcher with Telegram, the webhook channel and, when
+// an SMTP server is configured, email.
+func newNotifier(b *bot.Bot, cfg config.Notify) *notify.Dispatcher {
+	timeout := cfg.Webhook.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	notifiers := []notify.Notifier{
+		notify.Telegram{Bot: b},
+		notify.Webhook{
+			Client:       &http.Client{Timeout: timeout},
+			Secret:       cfg.Webhook.Secret.Reveal(),
+			AllowedHosts: cfg.Webhook.AllowedHosts,
+		},
+	}
+	if cfg.SMTP.Addr != "" {
+		notifiers = append(notifiers, notify.SMTP{
+			Addr:     cfg.SMTP.Addr,
+			From:     cfg.SMTP.From,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password.Reveal(),
+		})
+	}
+
+	return notify.NewDispatcher(notifiers...)
+}
+
+// userChannels returns the channels the user configured. Users who configured
+// none get Telegram notifications once their account is linked.
+func userChannels(user storage.User) ([]notify.Channel, error) {
+	configured, err := storage.GetNotificationChannels(db.DB, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to GetNotificationChannels: %w", err)
+	}
+
+	channels := []notify.Channel{}
+	for _, ch := range configured {
+		channels = append(channels, notify.Channel{Kind: ch.Kind, Address: ch.Address})
+	}
+	if len(channels) == 0 && user.TgID != 0 {
+		channels = append(channels, notify.Channel{Kind: notify.ChannelTelegram, Address: strconv.FormatInt(user.TgID, 10)})
+	}
+
+	return channels, nil
+}
+
+// queueNotification runs send on a notification worker. Notifications are
+// dropped when the queue is full or shut down.
+func queueNotification(l *zap.Logger, send func()) {
+	if !lc.enqueue(send) {
+		l.Warn("notification dropped, the queue is full or closed")
+	}
+}
+
+// sendToUser sends the event to the user's channels.
+func sendToUser(user storage.User, e notify.Event) {
+	e.UserID = user.ID
+	l := zap.L().With(zap.Int("user_id", user.ID), zap.String("event", e.Kind))
+
+	channels, err := userChannels(user)
+	if err != nil {
+		l.Error("failed to userChannels", zap.Error(err))
+		return
+	}
+	if len(channels) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	if err = notifier.Send(ctx, channels, e); err != nil {
+		l.Warn("failed to notify", zap.Error(err))
+		return
+	}
+	l.Info("user notified", zap.Int("channels", len(channels)))
+}
+
+// notifyUser sends the event to the user's channels in the background.
+func notifyUser(user storage.User, e notify.Event) {
+	queueNotification(zap.L().With(zap.Int("user_id", user.ID), zap.String("event", e.Kind)), func() {
+		sendToUser(user, e)
+	})
+}
+
+// notifyFileAvailable tells the users cleared for the file, except its
+// uploader, that it is available. The file name stays out of the event, which
+// leaves the server by email or webhook. The users are notified one after the
+// other by a single job.
+func notifyFileAvailable(file storage.File) {
+	l := zap.L().With(zap.Int64("file_id", file.ID), zap.String("event", notify.EventFileAvailable))
+
+	queueNotification(l, func() {
+		users, err := storage.GetUsersWithClearance(db.DB, file.Level, file.Department)
+		if err != nil {
+			l.Error("failed to GetUsersWithClearance", zap.Error(err))
+			return
+		}
+
+		e := notify.Event{
+			Kind:  notify.EventFileAvailable,
+			Title: "New file available",
+			Text:  fmt.Sprintf("File #%d of level %d is available at your clearance.", file.ID, file.Level),
+		}
+		for _, user := range users {
+			if user.ID != file.UserID {
+				sendToUser(user, e)
+			}
+		}
+	})
+}
+
+// notifyClearanceChanged tells the user about their new clearance and the
+// witness issued for it.
+func notifyClearanceChanged(user storage.User) {
+	notifyUser(user, notify.Event{
+		Kind:  notify.EventClearanceChanged,
+		Title: "Clearance changed",
+		Text:  fmt.Sprintf("Your clearance is now level %d in department %d.", user.Level, user.Department),
+	})
+	notifyUser(user, notify.Event{
+		Kind:  notify.EventWitnessRefreshed,
+		Title: "Witness refreshed",
+		Text:  "Your membership witness was reissued for your new clearance. No action is needed.",
+	})
+}
+
+// Handler
+func getNotificationChannels(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	channels, err := storage.GetNotificationChannels(db.DB, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to GetNotificationChannels: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, channels)
+}
+
+// Handler
+//
+// addNotificationChannel subscribes the user to a channel. Telegram
+// notifications always go to the user's own linked account.
+func addNotificationChannel(c echo.Context) error {
+	var req api.RequestChannel
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	user := c.Get("user").(*storage.User)
+	l := c.Get("logger").(*zap.Logger).With(zap.String("kind", req.Kind))
+
+	if req.Kind == notify.ChannelTelegram {
+		if user.TgID == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "telegram account is not linked")
+		}
+		req.Address = strconv.FormatInt(user.TgID, 10)
+	}
+	if err := notifier.Validate(notify.Channel{Kind: req.Kind, Address: req.Address}); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ch, err := storage.AddNotificationChannel(db.DB, storage.NotificationChannel{UserID: user.ID, Kind: req.Kind, Address: req.Address})
+	if err != nil {
+		l.Warn("failed to AddNotificationChannel", zap.Error(err))
+		return echo.NewHTTPError(http.StatusConflict, "channel already exists")
+	}
+
+	l.Info("notification channel added", zap.Int("channel_id", ch.ID))
+
+	return c.JSON(http.StatusOK, ch)
+}
+
+// Handler
+func deleteNotificationChannel(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+	l := c.Get("logger").(*zap.Logger)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid channel id")
+	}
+
+	if err = storage.DeleteNotificationChannel(db.DB, user.ID, id); errors.Is(err, pgx.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "channel not found")
+	} else if err != nil {
+		return fmt.Errorf("failed to DeleteNotificationChannel: %w", err)
+	}
+
+	l.Info("notification channel deleted", zap.Int("channel_id", id))
+
+	return c.String(http.StatusOK, http.StatusText(http.StatusOK))
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+)
+
+// SMTP sends events as plain text emails.
+type SMTP struct {
+	// Addr is the host:port of the mail server.
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s SMTP) Kind() string {
+	return ChannelEmail
+}
+
+func (s SMTP) Validate(address string) error {
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("invalid email: %w", err)
+	}
+	if a.Address != address {
+		return fmt.Errorf("invalid email: expected a bare address")
+	}
+
+	return nil
+}
+
+func (s SMTP) Notify(ctx context.Context, address string, e Event) error {
+	if err := s.Validate(address); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to SplitHostPort: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + address,
+		"Subject: " + mime.QEncoding.Encode("utf-8", e.Title),
+		"Date: " + e.Time.Format("Mon, 02 Jan 2006 15:04:05 -0700"),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		e.Text,
+		"",
+	}, "\r\n")
+
+	// net/smtp has no context support, the mail server's timeouts apply
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{address}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to SendMail: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package notify delivers events to users through the channels they configured,
+// like Telegram, email or outgoing webhooks.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Event kinds.
+const (
+	EventFileAvailable    = "file_available"
+	EventClearanceChanged = "clearance_changed"
+	EventWitnessRefreshed = "witness_refreshed"
+)
+
+// Channel kinds.
+const (
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+)
+
+type Event struct {
+	Kind   string    `json:"kind"`
+	UserID int       `json:"user_id"`
+	Title  string    `json:"title"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Channel is where a user receives events. Address is the chat ID, the email
+// address or the URL, depending on the kind.
+type Channel struct {
+	Kind    string
+	Address string
+}
+
+// Notifier sends events through one kind of channel.
+type Notifier interface {
+	Kind() string
+	// Validate checks an address before a user configures it.
+	Validate(address string) error
+	Notify(ctx context.Context, address string, e Event) error
+}
+
+// Dispatcher sends events through the notifiers of each channel kind.
+type Dispatcher struct {
+	notifiers map[string]Notifier
+}
+
+func NewDispatcher(notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{notifiers: map[string]Notifier{}}
+	for _, n := range notifiers {
+		d.notifiers[n.Kind()] = n
+	}
+
+	return d
+}
+
+// Has reports whether a channel kind is available.
+func (d *Dispatcher) Has(kind string) bool {
+	_, ok := d.notifiers[kind]
+	return ok
+}
+
+// Validate checks that the channel kind is available and its address is valid.
+func (d *Dispatcher) Validate(ch Channel) error {
+	n, ok := d.notifiers[ch.Kind]
+	if !ok {
+		return fmt.Errorf("channel %s is not available", ch.Kind)
+	}
+
+	return n.Validate(ch.Address)
+}
+
+// Send sends the event to every channel. A failing channel doesn't stop the
+// others; the failures are returned together.
+func (d *Dispatcher) Send(ctx context.Context, channels []Channel, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	var failed []string
+	for _, ch := range channels {
+		n, ok := d.notifiers[ch.Kind]
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%s: channel is not available", ch.Kind))
+			continue
+		}
+		if err := n.Notify(ctx, ch.Address, e); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", ch.Kind, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to notify: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
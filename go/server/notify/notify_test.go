@@ -0,0 +1,226 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/stretchr/testify/require"
+)
+
+var testEvent = Event{
+	Kind:   EventClearanceChanged,
+	UserID: 7,
+	Title:  "Допуск изменён",
+	Text:   "Your clearance is now level 2 in department 1.",
+	Time:   time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+}
+
+type recordingNotifier struct {
+	kind string
+	err  error
+	sent []string
+}
+
+func (r *recordingNotifier) Kind() string                  { return r.kind }
+func (r *recordingNotifier) Validate(address string) error { return nil }
+func (r *recordingNotifier) Notify(_ context.Context, address string, _ Event) error {
+	r.sent = append(r.sent, address)
+	return r.err
+}
+
+func TestDispatcher(t *testing.T) {
+	ok := &recordingNotifier{kind: ChannelTelegram}
+	broken := &recordingNotifier{kind: ChannelWebhook, err: errors.New("unreachable")}
+	d := NewDispatcher(ok, broken)
+
+	err := d.Send(context.Background(), []Channel{
+		{Kind: ChannelWebhook, Address: "http://hook"},
+		{Kind: ChannelTelegram, Address: "1"},
+		{Kind: ChannelEmail, Address: "a@example.org"},
+	}, testEvent)
+
+	// a failing channel doesn't stop the others
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "webhook: unreachable")
+	require.Contains(t, err.Error(), "email: channel is not available")
+	require.Equal(t, []string{"1"}, ok.sent)
+	require.Equal(t, []string{"http://hook"}, broken.sent)
+
+	require.True(t, d.Has(ChannelTelegram))
+	require.False(t, d.Has(ChannelEmail))
+	require.Error(t, d.Validate(Channel{Kind: ChannelEmail, Address: "a@example.org"}))
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		got       Event
+		signature string
+		status    = http.StatusNoContent
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		require.Equal(t, Sign("secret", body), signature)
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	w := Webhook{Client: srv.Client(), Secret: "secret"}
+	// no host is allowed by default
+	require.Error(t, w.Notify(context.Background(), srv.URL+"/events", testEvent))
+	w.AllowedHosts = []string{"127.0.0.1"}
+	require.NoError(t, w.Notify(context.Background(), srv.URL+"/events", testEvent))
+	require.Equal(t, testEvent, got)
+	require.NotEmpty(t, signature)
+
+	status = http.StatusInternalServerError
+	require.Error(t, w.Notify(context.Background(), srv.URL, testEvent))
+
+	require.Error(t, w.Validate("ftp://example.org"))
+	require.Error(t, w.Validate("/relative"))
+	w.AllowedHosts = []string{"hooks.example.org"}
+	require.NoError(t, w.Validate("https://hooks.example.org/x"))
+	require.Error(t, w.Notify(context.Background(), srv.URL, testEvent))
+}
+
+func TestWebhookRedirect(t *testing.T) {
+	internal := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internal = true
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer srv.Close()
+
+	// the redirect isn't followed to a host that isn't allowed
+	w := Webhook{Client: srv.Client(), AllowedHosts: []string{"127.0.0.1"}}
+	require.ErrorContains(t, w.Notify(context.Background(), srv.URL, testEvent), "unexpected status 307")
+	require.False(t, internal)
+}
+
+// fakeSMTP is a minimal mail server accepting one message per connection.
+type fakeSMTP struct {
+	net.Listener
+
+	mu   sync.Mutex
+	rcpt []string
+	data []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeSMTP{Listener: l}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			f.mu.Lock()
+			f.rcpt = append(f.rcpt, line)
+			f.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(bufio.NewReader(tp.DotReader()))
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.data = append(f.data, string(data))
+			f.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	srv := newFakeSMTP(t)
+	s := SMTP{Addr: srv.Addr().String(), From: "noreply@example.org"}
+
+	require.NoError(t, s.Notify(context.Background(), "officer@example.org", testEvent))
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	require.Equal(t, []string{"RCPT TO:<officer@example.org>"}, srv.rcpt)
+	require.Len(t, srv.data, 1)
+	require.Contains(t, srv.data[0], "To: officer@example.org\n")
+	require.Contains(t, srv.data[0], "Subject: =?utf-8?q?")
+	require.Contains(t, srv.data[0], testEvent.Text)
+
+	require.Error(t, s.Validate("Officer <officer@example.org>"))
+	require.Error(t, s.Notify(context.Background(), "not an email", testEvent))
+}
+
+func TestTelegram(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		fields = map[string]string{}
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, strings.HasSuffix(r.URL.Path, "/sendMessage"))
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		mu.Lock()
+		for k, v := range r.MultipartForm.Value {
+			fields[k] = v[0]
+		}
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":42,"type":"private"}}}`))
+	}))
+	defer api.Close()
+
+	b, err := bot.New("123:test", bot.WithServerURL(api.URL), bot.WithSkipGetMe())
+	require.NoError(t, err)
+
+	tg := Telegram{Bot: b}
+	require.NoError(t, tg.Notify(context.Background(), "42", testEvent))
+	require.Equal(t, "42", fields["chat_id"])
+	require.Equal(t, testEvent.Title+"\n"+testEvent.Text, fields["text"])
+
+	require.Error(t, tg.Validate("@name"))
+}
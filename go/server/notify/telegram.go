@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-telegram/bot"
+)
+
+// Telegram sends events as bot messages. Addresses are chat IDs.
+type Telegram struct {
+	Bot *bot.Bot
+}
+
+func (t Telegram) Kind() string {
+	return ChannelTelegram
+}
+
+func (t Telegram) Validate(address string) error {
+	if _, err := strconv.ParseInt(address, 10, 64); err != nil {
+		return fmt.Errorf("invalid chat id: %w", err)
+	}
+
+	return nil
+}
+
+func (t Telegram) Notify(ctx context.Context, address string, e Event) error {
+	chatID, err := strconv.ParseInt(address, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chat id: %w", err)
+	}
+
+	if _, err = t.Bot.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: e.Title + "\n" + e.Text}); err != nil {
+		return fmt.Errorf("failed to SendMessage: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"server/stribog"
+)
+
+// SignatureHeader carries the hex Stribog-256 HMAC of the webhook body.
+const SignatureHeader = "X-Signature"
+
+// Webhook posts events as JSON to the configured URLs.
+type Webhook struct {
+	Client *http.Client
+	// Secret signs the request bodies when set.
+	Secret string
+	// AllowedHosts are the hosts events are posted to. Without any, webhooks
+	// are disabled, so users can't make the server post to internal addresses.
+	AllowedHosts []string
+}
+
+func (w Webhook) Kind() string {
+	return ChannelWebhook
+}
+
+func (w Webhook) Validate(address string) error {
+	u, err := url.Parse(address)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid url: expected an absolute http(s) url")
+	}
+
+	for _, host := range w.AllowedHosts {
+		if u.Hostname() == host {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("host %s is not allowed", u.Hostname())
+}
+
+// Sign returns the signature of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(stribog.New256, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w Webhook) Notify(ctx context.Context, address string, e Event) error {
+	if err := w.Validate(address); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to Marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+
+	client := http.DefaultClient
+	if w.Client != nil {
+		client = w.Client
+	}
+	// a redirect could lead to a host that isn't allowed
+	noRedirect := *client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
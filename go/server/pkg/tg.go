@@ -6,6 +6,8 @@ import (
 )
 
 type TgData struct {
+	// UserID is the immutable Telegram user ID, unlike the username.
+	UserID     int64
 	Username   string
 	Text       string
 	IsCallback bool
@@ -19,15 +21,17 @@ func GetTgData(update *models.Update) (TgData, error) {
 	} else if update.Message != nil {
 		if update.Message.From != nil {
 			return TgData{
+				UserID:   update.Message.From.ID,
 				Username: update.Message.From.Username,
 				Text:     update.Message.Text,
 				ChatID:   update.Message.Chat.ID,
 			}, nil
 		}
 	} else if update.CallbackQuery != nil {
-		if update.CallbackQuery.Message.Message != nil && update.CallbackQuery.Message.Message.From != nil {
+		if update.CallbackQuery.Message.Message != nil {
 			return TgData{
-				Username:   update.CallbackQuery.Message.Message.Chat.Username,
+				UserID:     update.CallbackQuery.From.ID,
+				Username:   update.CallbackQuery.From.Username,
 				Text:       update.CallbackQuery.Data,
 				ChatID:     update.CallbackQuery.Message.Message.Chat.ID,
 				IsCallback: true,
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/audit"
+	"server/ipfs"
+	"server/reconcile"
+	"server/storage"
+)
+
+const (
+	defaultReconcileInterval = time.Hour
+	defaultRetention         = 7 * 24 * time.Hour
+)
+
+// reconciler keeps the pinset of blobs in line with the file table.
+var reconciler *reconcile.Reconciler
+
+// lastReport is the report of the last reconciliation.
+var lastReport struct {
+	sync.Mutex
+	report *reconcile.Report
+}
+
+// reconcileLoop reconciles the pinset every interval until ctx is done.
+func reconcileLoop(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := reconcilePins(ctx); err != nil {
+			zap.L().Error("failed to reconcilePins", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcilePins runs the reconciler, removes the rows of the purged files and
+// logs the discrepancies.
+func reconcilePins(ctx context.Context) (reconcile.Report, error) {
+	files, err := storage.ListFileLinks(db.DB)
+	if err != nil {
+		return reconcile.Report{}, fmt.Errorf("failed to ListFileLinks: %w", err)
+	}
+
+	owned, err := storage.ListPins(db.DB)
+	if err != nil {
+		return reconcile.Report{}, fmt.Errorf("failed to ListPins: %w", err)
+	}
+
+	report, err := reconciler.Run(ctx, files, owned, time.Now())
+	if err != nil {
+		return reconcile.Report{}, fmt.Errorf("failed to Run: %w", err)
+	}
+
+	for _, f := range report.Purged {
+		if err = storage.PurgeFile(db.DB, f); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("file %d: %v", f.ID, err))
+			continue
+		}
+		if c, err := ipfs.CID(f.IpfsKey); err == nil {
+			forgetPin(c)
+		}
+	}
+	for _, c := range report.Unpinned {
+		forgetPin(c)
+	}
+
+	l := zap.L().With(zap.Int("files", report.Files), zap.Int("pins", report.Pins), zap.Int("purged", len(report.Purged)))
+	if report.Discrepancies() > 0 {
+		l.Warn("pinset discrepancies",
+			zap.Strings("repinned", report.Repinned),
+			zap.Strings("missing", report.Missing),
+			zap.Strings("unpinned", report.Unpinned),
+			zap.Strings("pending", report.Pending),
+			zap.Strings("errors", report.Errors))
+	} else {
+		l.Info("pinset reconciled")
+	}
+
+	lastReport.Lock()
+	lastReport.report = &report
+	lastReport.Unlock()
+
+	return report, nil
+}
+
+// recordPin records content the server pinned, so that the reconciler may
+// unpin it once no file refers to it.
+func recordPin(link string) error {
+	c, err := ipfs.CID(link)
+	if err != nil {
+		return err
+	}
+
+	return storage.AddPin(db.DB, c)
+}
+
+// forgetPin drops the record of unpinned content. A record left behind only
+// matters if the content is pinned again.
+func forgetPin(c string) {
+	if err := storage.DeletePin(db.DB, c); err != nil {
+		zap.L().Error("failed to DeletePin", zap.String("cid", c), zap.Error(err))
+	}
+}
+
+// Handler
+func getReconcileReport(c echo.Context) error {
+	lastReport.Lock()
+	report := lastReport.report
+	lastReport.Unlock()
+
+	if report == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no reconciliation ran yet")
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// Handler
+func runReconcile(c echo.Context) error {
+	report, err := reconcilePins(c.Request().Context())
+	if err != nil {
+		return fmt.Errorf("failed to reconcilePins: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// Handler
+//
+// deleteFile lets the uploader delete their file. The content is unpinned by
+// the reconciler after the retention period.
+func deleteFile(c echo.Context) error {
+	user := c.Get("user").(*storage.User)
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid file id")
+	}
+
+	file, err := storage.GetFileByID(db.DB, id)
+	if err != nil {
+		return fmt.Errorf("failed to GetFileByID: %w", err)
+	}
+
+	event := fileEvent(audit.ChannelHTTP, audit.ActionDelete, user.ID, file)
+	if !file.CanRead(*user) {
+		recordAudit(event, denied(audit.CheckClearance, fmt.Errorf("file level %d is above the user clearance", file.Level)))
+		// files above the clearance are hidden, not forbidden
+		return storage.ErrFileNotFound
+	}
+	if file.UserID != user.ID {
+		err = denied(audit.CheckOwner, fmt.Errorf("file %d was uploaded by another user", file.ID))
+		recordAudit(event, err)
+		return err
+	}
+
+	err = storage.DeleteFile(db.DB, file.ID)
+	recordAudit(event, err)
+	if err != nil {
+		return fmt.Errorf("failed to DeleteFile: %w", err)
+	}
+	c.Get("logger").(*zap.Logger).Info("file deleted", zap.Int64("file_id", file.ID))
+
+	return c.String(http.StatusOK, http.StatusText(http.StatusOK))
+}
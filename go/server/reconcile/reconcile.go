@@ -0,0 +1,149 @@
+// Package reconcile keeps the pinset of the blob store in line with the file
+// table: live files stay pinned, deleted ones are unpinned after the retention
+// period and, when enabled, the pins of the server no file refers to are
+// removed.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"server/ipfs"
+	"server/storage"
+)
+
+// Report lists what a run found and changed. CIDs are in canonical form.
+type Report struct {
+	Time  time.Time
+	Files int
+	Pins  int
+	// Repinned are live files that weren't pinned and were pinned again.
+	Repinned []string
+	// Missing are live files whose content the store doesn't have.
+	Missing []string
+	// Unpinned are pins of the server no file refers to.
+	Unpinned []string
+	// Pending are pins of the server no file refers to that were found for
+	// the first time. They're unpinned by the next run if they stay orphaned.
+	Pending []string
+	// Purged are deleted files past the retention period. Their content is
+	// unpinned and the rows can be removed.
+	Purged []storage.FileLink
+	Errors []string
+}
+
+// Discrepancies is the number of entries that didn't match.
+func (r Report) Discrepancies() int {
+	return len(r.Repinned) + len(r.Missing) + len(r.Unpinned) + len(r.Pending) + len(r.Errors)
+}
+
+// Reconciler compares the file table with the pinset of a store.
+type Reconciler struct {
+	mu        sync.Mutex
+	store     ipfs.BlobStore
+	retention time.Duration
+	// orphans enables the removal of the orphaned pins.
+	orphans bool
+	// suspects are the orphans found by the previous run. A pin is only removed
+	// when it stays orphaned for a whole run, so content uploaded right before
+	// its file row is stored isn't lost.
+	suspects map[string]bool
+}
+
+// New returns a reconciler of the store. Orphaned pins are only removed with
+// orphans set.
+func New(store ipfs.BlobStore, retention time.Duration, orphans bool) *Reconciler {
+	return &Reconciler{store: store, retention: retention, orphans: orphans, suspects: map[string]bool{}}
+}
+
+// Run reconciles the pinset with the files at now. Owned are the CIDs the
+// server pinned itself, the only pins removed as orphans; pins of other
+// content on the nodes are left alone. Failures of single entries are
+// reported, not returned.
+func (r *Reconciler) Run(ctx context.Context, files []storage.FileLink, owned []string, now time.Time) (Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pins, err := r.store.Pins(ctx)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to Pins: %w", err)
+	}
+	pinned := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		pinned[pin] = true
+	}
+
+	report := Report{Time: now, Pins: len(pins)}
+	failed := func(link string, err error) {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", link, err))
+	}
+
+	// referenced are the pins the files keep, handled are the ones this run
+	// took care of
+	referenced, handled := map[string]bool{}, map[string]bool{}
+	for _, f := range files {
+		c, err := ipfs.CID(f.IpfsKey)
+		if err != nil {
+			failed(f.IpfsKey, err)
+			continue
+		}
+
+		switch {
+		case f.DeletedAt == nil:
+			report.Files++
+			referenced[c] = true
+			if pinned[c] {
+				continue
+			}
+			if err = r.store.Pin(ctx, c); errors.Is(err, ipfs.ErrNotFound) {
+				report.Missing = append(report.Missing, c)
+			} else if err != nil {
+				failed(c, err)
+			} else {
+				report.Repinned = append(report.Repinned, c)
+			}
+		case now.Sub(*f.DeletedAt) < r.retention:
+			referenced[c] = true
+		default:
+			handled[c] = true
+			// the pinset may leave out content pinned on too few nodes
+			if err = r.store.Unpin(ctx, c); err != nil && !errors.Is(err, ipfs.ErrNotFound) {
+				failed(c, err)
+				continue
+			}
+			report.Purged = append(report.Purged, f)
+		}
+	}
+
+	if !r.orphans {
+		return report, nil
+	}
+
+	own := make(map[string]bool, len(owned))
+	for _, c := range owned {
+		own[c] = true
+	}
+	suspects := map[string]bool{}
+	for _, pin := range pins {
+		if !own[pin] || referenced[pin] || handled[pin] {
+			continue
+		}
+		if !r.suspects[pin] {
+			suspects[pin] = true
+			report.Pending = append(report.Pending, pin)
+			continue
+		}
+		if err = r.store.Unpin(ctx, pin); err != nil && !errors.Is(err, ipfs.ErrNotFound) {
+			suspects[pin] = true
+			failed(pin, err)
+			continue
+		}
+		report.Unpinned = append(report.Unpinned, pin)
+	}
+	r.suspects = suspects
+
+	return report, nil
+}
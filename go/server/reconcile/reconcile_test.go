@@ -0,0 +1,155 @@
+package reconcile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"server/ipfs"
+	"server/storage"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	store := ipfs.NewMemoryStore()
+	put := func(data string) (string, string) {
+		link, err := store.Put(ctx, []byte(data))
+		require.NoError(t, err)
+		c, err := ipfs.CID(link)
+		require.NoError(t, err)
+		return link, c
+	}
+
+	now := time.Now()
+	recent, old := now.Add(-time.Hour), now.Add(-48*time.Hour)
+
+	live, liveCID := put("live")
+	kept, keptCID := put("deleted recently")
+	expired, expiredCID := put("deleted long ago")
+	_, orphanCID := put("orphan")
+	_, foreignCID := put("pinned by someone else")
+	missing, missingCID := put("missing")
+	require.NoError(t, store.Unpin(ctx, missing))
+
+	files := []storage.FileLink{
+		{ID: 1, IpfsKey: live},
+		{ID: 2, IpfsKey: kept, DeletedAt: &recent},
+		{ID: 3, IpfsKey: expired, DeletedAt: &old},
+		{ID: 4, IpfsKey: missing},
+		{ID: 5, IpfsKey: "/ipfs/not-a-cid"},
+	}
+
+	owned := []string{liveCID, keptCID, expiredCID, orphanCID, missingCID}
+	r := New(store, 24*time.Hour, true)
+	report, err := r.Run(ctx, files, owned, now)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Files)
+	require.Empty(t, report.Repinned)
+	require.Equal(t, []string{missingCID}, report.Missing)
+	require.Equal(t, []string{orphanCID}, report.Pending)
+	require.Empty(t, report.Unpinned)
+	require.Equal(t, []storage.FileLink{files[2]}, report.Purged)
+	require.Len(t, report.Errors, 1)
+
+	pins, err := store.Pins(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{liveCID, keptCID, orphanCID, foreignCID}, pins)
+	require.NotContains(t, pins, expiredCID)
+
+	// the orphan is removed once it stays orphaned for a run, the pins the
+	// server didn't make are left alone
+	report, err = r.Run(ctx, files[:2], owned, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{orphanCID}, report.Unpinned)
+	require.Empty(t, report.Pending)
+
+	pins, err = store.Pins(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{liveCID, keptCID, foreignCID}, pins)
+}
+
+func TestRunKeepsOrphans(t *testing.T) {
+	ctx := context.Background()
+	store := ipfs.NewMemoryStore()
+	link, err := store.Put(ctx, []byte("orphan"))
+	require.NoError(t, err)
+	c, err := ipfs.CID(link)
+	require.NoError(t, err)
+
+	// orphans stay pinned unless their removal is enabled
+	r := New(store, time.Hour, false)
+	for i := 0; i < 2; i++ {
+		report, err := r.Run(ctx, nil, []string{c}, time.Now())
+		require.NoError(t, err)
+		require.Empty(t, report.Pending)
+		require.Empty(t, report.Unpinned)
+		require.Zero(t, report.Discrepancies())
+	}
+
+	pins, err := store.Pins(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{c}, pins)
+}
+
+func TestRunRepins(t *testing.T) {
+	ctx := context.Background()
+	store := &unpinnedStore{MemoryStore: ipfs.NewMemoryStore()}
+	link, err := store.Put(ctx, []byte("live"))
+	require.NoError(t, err)
+	c, err := ipfs.CID(link)
+	require.NoError(t, err)
+	store.hidden = c
+
+	report, err := New(store, time.Hour, true).Run(ctx, []storage.FileLink{{ID: 1, IpfsKey: link}}, []string{c}, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{c}, report.Repinned)
+	require.Equal(t, 1, report.Discrepancies())
+}
+
+func TestRunRepairsReplication(t *testing.T) {
+	ctx := context.Background()
+	lost := &unpinnedStore{MemoryStore: ipfs.NewMemoryStore()}
+	nodes := []ipfs.Node{{Name: "node0", Store: lost}, {Name: "node1", Store: ipfs.NewMemoryStore()}}
+	store := ipfs.NewReplicatedStore(nodes, 2)
+
+	live, err := store.Put(ctx, []byte("live"))
+	require.NoError(t, err)
+	lost.hidden, err = ipfs.CID(live)
+	require.NoError(t, err)
+	expired, err := store.Put(ctx, []byte("expired"))
+	require.NoError(t, err)
+	require.NoError(t, lost.Unpin(ctx, expired))
+
+	// the content pinned on one node of two is repaired and purged
+	deleted := time.Now().Add(-48 * time.Hour)
+	files := []storage.FileLink{{ID: 1, IpfsKey: live}, {ID: 2, IpfsKey: expired, DeletedAt: &deleted}}
+	report, err := New(store, 24*time.Hour, false).Run(ctx, files, nil, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{lost.hidden}, report.Repinned)
+	require.Equal(t, []storage.FileLink{files[1]}, report.Purged)
+	require.Empty(t, report.Errors)
+
+	pins, err := nodes[1].Store.Pins(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{lost.hidden}, pins)
+}
+
+// unpinnedStore leaves a pin out of the pinset, like a node that lost it but
+// still has the content.
+type unpinnedStore struct {
+	*ipfs.MemoryStore
+	hidden string
+}
+
+func (s *unpinnedStore) Pins(ctx context.Context) ([]string, error) {
+	pins, err := s.MemoryStore.Pins(ctx)
+	for i, pin := range pins {
+		if pin == s.hidden {
+			return append(pins[:i], pins[i+1:]...), err
+		}
+	}
+
+	return pins, err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"server/config"
+	"server/ipfs"
+	"server/storage"
+)
+
+// replicaStatus prints the replica health of stored content:
+//
+//	server replica-status [-config FILE] [CID...]
+//
+// Without CIDs it checks the content of every live file. It exits with 1 when
+// any content has fewer pins than the configured replication.
+func replicaStatus(args []string) int {
+	flags := flag.NewFlagSet("replica-status", flag.ContinueOnError)
+	cfgPath := flags.String("config", "./build/config.yaml", "path to config file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.NewConfig(*cfgPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	store, err := ipfs.New(cfg.IPFS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	links := flags.Args()
+	if len(links) == 0 {
+		if links, err = liveLinks(ctx, *cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	code := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	for _, link := range links {
+		status, err := ipfs.Status(ctx, store, link)
+		if err != nil {
+			fmt.Fprintf(w, "%s\terror\t%v\n", link, err)
+			code = 1
+			continue
+		}
+
+		health := "ok"
+		if !status.Healthy() {
+			health = "under-replicated"
+			code = 1
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%d pinned\n", status.CID, health, status.Pinned, status.Required)
+		for _, r := range status.Replicas {
+			fmt.Fprintf(w, "\t%s\t%s\t%s\n", r.Node, r.Status, r.Error)
+		}
+	}
+
+	return code
+}
+
+// liveLinks returns the content links of the files that aren't deleted.
+func liveLinks(ctx context.Context, cfg config.Config) ([]string, error) {
+	if err := db.Init(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.DB.Close()
+
+	files, err := storage.ListFileLinks(db.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ListFileLinks: %w", err)
+	}
+
+	links := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.DeletedAt == nil {
+			links = append(links, f.IpfsKey)
+		}
+	}
+
+	return links, nil
+}
@@ -1,7 +1,10 @@
 package security
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
@@ -28,6 +31,20 @@ const (
 
 const levelCount = 5
 
+// MaxLevel is the highest clearance level an accumulator exists for.
+const MaxLevel = levelCount - 1
+
+var (
+	// ErrLevelUnsupported is returned for levels above MaxLevel.
+	ErrLevelUnsupported = errors.New("level is not supported")
+	// ErrWitnessInvalid is returned when a witness doesn't prove membership in
+	// the accumulator of the level or department.
+	ErrWitnessInvalid = errors.New("witness is invalid")
+	// ErrNotMember is returned when witnesses are asked for data that was not
+	// added to the accumulator or was deleted from it.
+	ErrNotMember = errors.New("not a member of the accumulator")
+)
+
 func Add(level, department int, data []byte) ([]byte, []byte, error) { // data is a pk from crypto
 	accLevel, err := getOrCreateAccumulatorByType(level, 0, typeLevel)
 	if err != nil {
@@ -46,18 +63,14 @@ func Add(level, department int, data []byte) ([]byte, []byte, error) { // data i
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to add to accDepartment: %w", err)
 	}
-	str := base64.StdEncoding.EncodeToString(witLevel)
-	witLevel1, _ := base64.StdEncoding.DecodeString(str)
-	fmt.Println(accLevel.check(witLevel1))
+
 	return witLevel, witDepartment, nil
 }
 
 func (acc *AccumulatorKey) add(data []byte) ([]byte, error) {
-	elem, err := curves.BLS12381(curves.BLS12381G1().Point).Scalar.SetBytes(hashBytes(data))
-	if err != nil {
-		return nil, fmt.Errorf("failed to SetBytes: %w", err)
-	}
+	elem := element(data)
 
+	var err error
 	acc.Acc, err = acc.Acc.Add(acc.SK, elem)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Add: %w", err)
@@ -74,6 +87,10 @@ func (acc *AccumulatorKey) add(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to rewriteFile: %w", err)
 	}
 
+	if err = acc.setMember(elem, true); err != nil {
+		return nil, fmt.Errorf("failed to setMember: %w", err)
+	}
+
 	witBody, err := wit.MarshalBinary()
 	if err != nil {
 		return nil, fmt.Errorf("failed to MarshalBinary: %w", err)
@@ -82,9 +99,12 @@ func (acc *AccumulatorKey) add(data []byte) ([]byte, error) {
 	return witBody, nil
 }
 
-func Check(level, department int, witLevel, witDepartment []byte) error {
+// Check verifies that witLevel and witDepartment prove the membership of data,
+// the decoded user pk, in the accumulators of the level and department. A
+// witness issued for other data is rejected even if it is valid.
+func Check(level, department int, data, witLevel, witDepartment []byte) error {
 	if level >= levelCount {
-		return fmt.Errorf("level is bigger that supported. Max is " + strconv.Itoa(levelCount))
+		return fmt.Errorf("%w: max is %d", ErrLevelUnsupported, MaxLevel)
 	}
 	filePath := strconv.Itoa(level) + "_level"
 
@@ -93,10 +113,10 @@ func Check(level, department int, witLevel, witDepartment []byte) error {
 		return fmt.Errorf("failed to getAccumulatorByPath level: %w", err)
 	}
 
-	if ok, err := acc.check(witLevel); err != nil {
-		return fmt.Errorf("failed to check level: %w", err)
+	if ok, err := acc.check(data, witLevel); err != nil {
+		return fmt.Errorf("%w: failed to check level: %v", ErrWitnessInvalid, err)
 	} else if !ok {
-		return fmt.Errorf("failed to verify wit level")
+		return fmt.Errorf("%w: failed to verify wit level", ErrWitnessInvalid)
 	}
 
 	filePath = strconv.Itoa(department) + "_department"
@@ -105,21 +125,73 @@ func Check(level, department int, witLevel, witDepartment []byte) error {
 		return fmt.Errorf("failed to getAccumulatorByPath dep: %w", err)
 	}
 
-	if ok, err := acc.check(witDepartment); err != nil {
-		return fmt.Errorf("failed to check dep: %w", err)
+	if ok, err := acc.check(data, witDepartment); err != nil {
+		return fmt.Errorf("%w: failed to check dep: %v", ErrWitnessInvalid, err)
 	} else if !ok {
-		return fmt.Errorf("failed to verify wit dep")
+		return fmt.Errorf("%w: failed to verify wit dep", ErrWitnessInvalid)
 	}
 
 	return nil
 }
 
-func (acc *AccumulatorKey) check(witByte []byte) (bool, error) {
+// Witness reissues the witnesses of the element added by Add for the same data
+// against the current level and department accumulators, which other members
+// joining or leaving have changed since. The accumulators are left as they are.
+// Data that is not a member of both accumulators gets ErrNotMember.
+func Witness(level, department int, data []byte) ([]byte, []byte, error) {
+	if level >= levelCount {
+		return nil, nil, fmt.Errorf("%w: max is %d", ErrLevelUnsupported, MaxLevel)
+	}
+
+	accLevel, err := getAccumulatorByPath(strconv.Itoa(level) + "_level")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to getAccumulatorByPath level: %w", err)
+	}
+	witLevel, err := accLevel.witness(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to witness level: %w", err)
+	}
+
+	accDepartment, err := getAccumulatorByPath(strconv.Itoa(department) + "_department")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to getAccumulatorByPath dep: %w", err)
+	}
+	witDepartment, err := accDepartment.witness(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to witness dep: %w", err)
+	}
+
+	return witLevel, witDepartment, nil
+}
+
+func (acc *AccumulatorKey) witness(data []byte) ([]byte, error) {
+	elem := element(data)
+	members, err := acc.members()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read members: %w", err)
+	}
+	if !members[memberKey(elem)] {
+		return nil, ErrNotMember
+	}
+
+	wit, err := new(accumulator.MembershipWitness).New(elem, acc.Acc, acc.SK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to New: %w", err)
+	}
+
+	return wit.MarshalBinary()
+}
+
+func (acc *AccumulatorKey) check(data, witByte []byte) (bool, error) {
 	wit := new(accumulator.MembershipWitness)
 	if err := wit.UnmarshalBinary(witByte); err != nil {
 		return false, fmt.Errorf("failed to UnmarshalBinary: %w", err)
 	}
 
+	if wit.GetElement().Cmp(element(data)) != 0 {
+		return false, errors.New("witness is for another element")
+	}
+
 	if err := wit.Verify(acc.PK, acc.Acc); err != nil {
 		return false, fmt.Errorf("failed to Verify: %w", err)
 	}
@@ -127,9 +199,11 @@ func (acc *AccumulatorKey) check(witByte []byte) (bool, error) {
 	return true, nil
 }
 
+// Delete removes the element added by Add for the same data from the level and
+// department accumulators. data is the decoded user pk, as passed to Add.
 func Delete(level, department int, data []byte) error {
 	if level >= levelCount {
-		return fmt.Errorf("level is bigger that supported. Max is " + strconv.Itoa(levelCount))
+		return fmt.Errorf("%w: max is %d", ErrLevelUnsupported, MaxLevel)
 	}
 	filePath := strconv.Itoa(level) + "_level"
 
@@ -157,9 +231,7 @@ func Delete(level, department int, data []byte) error {
 }
 
 func (acc *AccumulatorKey) delete(data []byte) error {
-	elem := curves.BLS12381(&curves.PointBls12381G1{}).Scalar.Hash(hashBytes(data))
-
-	a, err := acc.Acc.Remove(acc.SK, elem)
+	a, err := acc.Acc.Remove(acc.SK, element(data))
 	if err != nil {
 		return fmt.Errorf("failed to Remove: %w", err)
 	}
@@ -172,9 +244,102 @@ func (acc *AccumulatorKey) delete(data []byte) error {
 		return fmt.Errorf("failed to rewriteFile: %w", err)
 	}
 
+	if err = acc.setMember(element(data), false); err != nil {
+		return fmt.Errorf("failed to setMember: %w", err)
+	}
+
 	return nil
 }
 
+// Member is data added to the accumulators of a level and department.
+type Member struct {
+	Level      int
+	Department int
+	Data       []byte
+}
+
+// InitMembers records the members of accumulators created before their members
+// were tracked, which Witness needs. Accumulators that track their members
+// already are left as they are.
+func InitMembers(members []Member) error {
+	byPath := map[string][][]byte{}
+	for _, m := range members {
+		level := strconv.Itoa(m.Level) + "_level"
+		department := strconv.Itoa(m.Department) + "_department"
+		byPath[level] = append(byPath[level], m.Data)
+		byPath[department] = append(byPath[department], m.Data)
+	}
+
+	for path, data := range byPath {
+		if _, err := os.Stat(path + ".txt"); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if _, err := os.Stat(path + ".members"); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		var body bytes.Buffer
+		for _, d := range data {
+			body.WriteString(memberKey(element(d)) + "\n")
+		}
+		if err := os.WriteFile(path+".members", body.Bytes(), 0o600); err != nil {
+			return fmt.Errorf("failed to WriteFile %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// members reads the elements added to the accumulator and not deleted since,
+// one hex encoded element per line.
+func (acc *AccumulatorKey) members() (map[string]bool, error) {
+	members := map[string]bool{}
+	f, err := os.Open(acc.Path + ".members")
+	if errors.Is(err, os.ErrNotExist) {
+		return members, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to Open: %w", err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			members[line] = true
+		}
+	}
+	if err = s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return members, nil
+}
+
+func (acc *AccumulatorKey) setMember(elem accumulator.Element, member bool) error {
+	members, err := acc.members()
+	if err != nil {
+		return err
+	}
+	members[memberKey(elem)] = member
+
+	var body bytes.Buffer
+	for key, ok := range members {
+		if ok {
+			body.WriteString(key + "\n")
+		}
+	}
+
+	if err = os.WriteFile(acc.Path+".members", body.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to WriteFile: %w", err)
+	}
+
+	return nil
+}
+
+func memberKey(elem accumulator.Element) string {
+	return hex.EncodeToString(elem.Bytes())
+}
+
 func rewriteFileByPath(path string, body []byte) error {
 	if err := os.Truncate(path, 0); err != nil {
 		return fmt.Errorf("failed to Truncate: %w", err)
@@ -192,7 +357,7 @@ func getOrCreateAccumulatorByType(level, department int, which int) (Accumulator
 	switch which {
 	case typeLevel:
 		if level >= levelCount {
-			return AccumulatorKey{}, fmt.Errorf("level is bigger that supported. Max is " + strconv.Itoa(levelCount))
+			return AccumulatorKey{}, fmt.Errorf("%w: max is %d", ErrLevelUnsupported, MaxLevel)
 		}
 		filePath = strconv.Itoa(level) + "_level"
 	case typeDepartment:
@@ -310,6 +475,20 @@ func getAccumulatorByPath(filePath string) (AccumulatorKey, error) {
 	return accKey, nil
 }
 
+// element maps data to an accumulator element. Add and Delete must agree on
+// it, otherwise a revocation removes an element that was never added. Hashes
+// that are a canonical scalar are used as is, which keeps the elements of
+// existing members; the rest are hashed into the scalar field.
+func element(data []byte) accumulator.Element {
+	scalar := curves.BLS12381(curves.BLS12381G1().Point).Scalar
+	h := hashBytes(data)
+	if elem, err := scalar.SetBytes(h); err == nil {
+		return elem
+	}
+
+	return scalar.Hash(h)
+}
+
 func hashStr(str string) []byte {
 	return stribog.New256().Sum([]byte(base64.StdEncoding.EncodeToString([]byte(str))))[:32]
 }
@@ -2,43 +2,169 @@ package security
 
 import (
 	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
 	"github.com/coinbase/kryptology/pkg/core/curves"
 	"github.com/stretchr/testify/assert"
-	"ipfs-senc/accumulator"
-	"ipfs-senc/stribog"
-	"testing"
+	"github.com/stretchr/testify/require"
+
+	"server/accumulator"
+	"server/stribog"
 )
 
-func TestCommon(t *testing.T) {
-	keys, err := setupFromFile()
-	assert.NoError(t, err)
-	wit, err := addUser(keys[0].Level, "alskjdfhiuaf", keys[0].SK)
-	assert.NoError(t, err)
-	ok, err := checkUser(keys[0].Level, wit, keys[1])
-	assert.NoError(t, err)
-	assert.True(t, ok)
+func inTempDir(t *testing.T) {
+	t.Helper()
 
-	elem, err := curves.BLS12381(curves.BLS12381G1().Point).Scalar.SetBytes(hashStr("user_id")[:32])
-	assert.NoError(t, err)
-	wrongKey, err := new(accumulator.SecretKey).New(curves.BLS12381(curves.BLS12381G1().Point), []byte("asdf"))
-	assert.NoError(t, err)
-	wrongAcc, err := new(accumulator.Accumulator).WithElements(curves.BLS12381(curves.BLS12381G1().Point), wrongKey, []accumulator.Element{elem})
-	assert.NoError(t, err)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+}
 
-	wrongWit, err := new(accumulator.MembershipWitness).New(elem, wrongAcc, wrongKey)
-	assert.NoError(t, err)
-	wrongWitByte, err := wrongWit.MarshalBinary()
-	assert.NoError(t, err)
+func TestAddCheck(t *testing.T) {
+	inTempDir(t)
 
-	ok, err = checkUser(keys[0].Level, wrongWitByte, keys[0])
-	assert.NoError(t, err)
-	assert.False(t, ok)
+	data := []byte("user pk")
+	witLevel, witDep, err := Add(1, 2, data)
+	require.NoError(t, err)
+	require.NoError(t, Check(1, 2, data, witLevel, witDep))
+	require.Error(t, Check(1, 3, data, witLevel, witDep))
+	require.ErrorIs(t, Check(MaxLevel+1, 2, data, witLevel, witDep), ErrLevelUnsupported)
+}
 
-	err = deleteUser(keys[0].Level, "alskjdfhiuaf", keys[0].SK)
-	assert.NoError(t, err)
-	ok, err = checkUser(keys[0].Level, wit, keys[0])
-	assert.NoError(t, err)
-	assert.False(t, ok)
+func TestCheckBindsElement(t *testing.T) {
+	inTempDir(t)
+
+	_, _, err := Add(1, 2, []byte("user pk"))
+	require.NoError(t, err)
+	_, _, err = Add(1, 2, []byte("other user pk"))
+	require.NoError(t, err)
+	witLevel, witDep, err := Witness(1, 2, []byte("user pk"))
+	require.NoError(t, err)
+
+	// a valid witness of one member proves nothing for another
+	require.ErrorIs(t, Check(1, 2, []byte("other user pk"), witLevel, witDep), ErrWitnessInvalid)
+	require.ErrorIs(t, Check(1, 2, []byte("unknown pk"), witLevel, witDep), ErrWitnessInvalid)
+}
+
+func TestWitnessRefresh(t *testing.T) {
+	inTempDir(t)
+
+	data := []byte("user pk")
+	witLevel, witDep, err := Add(1, 2, data)
+	require.NoError(t, err)
+	_, _, err = Add(1, 2, []byte("other user pk"))
+	require.NoError(t, err)
+	// the accumulators changed when the other user joined
+	require.ErrorIs(t, Check(1, 2, data, witLevel, witDep), ErrWitnessInvalid)
+
+	witLevel, witDep, err = Witness(1, 2, data)
+	require.NoError(t, err)
+	require.NoError(t, Check(1, 2, data, witLevel, witDep))
+
+	_, _, err = Witness(1, 3, data)
+	require.Error(t, err)
+	_, _, err = Witness(MaxLevel+1, 2, data)
+	require.ErrorIs(t, err, ErrLevelUnsupported)
+
+	// only members get witnesses
+	_, _, err = Witness(1, 2, []byte("never enrolled pk"))
+	require.ErrorIs(t, err, ErrNotMember)
+	require.NoError(t, Delete(1, 2, []byte("other user pk")))
+	_, _, err = Witness(1, 2, []byte("other user pk"))
+	require.ErrorIs(t, err, ErrNotMember)
+}
+
+func TestInitMembers(t *testing.T) {
+	inTempDir(t)
+
+	_, _, err := Add(1, 2, []byte("user pk"))
+	require.NoError(t, err)
+	// accumulators stored before their members were tracked
+	require.NoError(t, os.Remove("1_level.members"))
+	require.NoError(t, os.Remove("2_department.members"))
+	_, _, err = Witness(1, 2, []byte("user pk"))
+	require.ErrorIs(t, err, ErrNotMember)
+
+	require.NoError(t, InitMembers([]Member{{Level: 1, Department: 2, Data: []byte("user pk")}}))
+	_, _, err = Witness(1, 2, []byte("user pk"))
+	require.NoError(t, err)
+
+	// tracked members are not replaced
+	require.NoError(t, InitMembers([]Member{{Level: 1, Department: 2, Data: []byte("other user pk")}}))
+	_, _, err = Witness(1, 2, []byte("other user pk"))
+	require.ErrorIs(t, err, ErrNotMember)
+}
+
+func TestCheckStore(t *testing.T) {
+	inTempDir(t)
+
+	require.NoError(t, CheckStore())
+	_, _, err := Add(1, 2, []byte("user pk"))
+	require.NoError(t, err)
+	require.NoError(t, CheckStore())
+
+	paths, err := filepath.Glob("*_level.txt")
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+	require.NoError(t, os.WriteFile(paths[0], []byte("corrupted"), 0o600))
+	require.Error(t, CheckStore())
+}
+
+func TestDeleteRemovesAddedElement(t *testing.T) {
+	inTempDir(t)
+
+	_, _, err := Add(1, 2, []byte("other user pk"))
+	require.NoError(t, err)
+	levelAcc, err := os.ReadFile("1_level.txt")
+	require.NoError(t, err)
+	depAcc, err := os.ReadFile("2_department.txt")
+	require.NoError(t, err)
+
+	witLevel, witDep, err := Add(1, 2, []byte("user pk"))
+	require.NoError(t, err)
+	require.NoError(t, Delete(1, 2, []byte("user pk")))
+
+	// removing the element that was added restores both accumulators
+	got, err := os.ReadFile("1_level.txt")
+	require.NoError(t, err)
+	require.Equal(t, levelAcc, got)
+	got, err = os.ReadFile("2_department.txt")
+	require.NoError(t, err)
+	require.Equal(t, depAcc, got)
+
+	require.ErrorIs(t, Check(1, 2, []byte("user pk"), witLevel, witDep), ErrWitnessInvalid)
+}
+
+func TestDeleteOtherData(t *testing.T) {
+	inTempDir(t)
+
+	_, _, err := Add(1, 2, []byte("other user pk"))
+	require.NoError(t, err)
+	levelAcc, err := os.ReadFile("1_level.txt")
+	require.NoError(t, err)
+
+	data := []byte("user pk")
+	_, _, err = Add(1, 2, data)
+	require.NoError(t, err)
+
+	// the encoded pk is not the element that was added
+	require.NoError(t, Delete(1, 2, []byte(base64.StdEncoding.EncodeToString(data))))
+
+	got, err := os.ReadFile("1_level.txt")
+	require.NoError(t, err)
+	require.NotEqual(t, levelAcc, got)
+}
+
+func TestDeleteUnknownLevel(t *testing.T) {
+	inTempDir(t)
+
+	require.Error(t, Delete(levelCount, 0, []byte("user pk")))
+	require.Error(t, Delete(1, 0, []byte("user pk")))
 }
 
 func TestMarshaller(t *testing.T) {
@@ -0,0 +1,34 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckStore checks that the accumulators are stored where the server expects
+// them: the working directory is writable for new ones and the existing ones
+// load.
+func CheckStore() error {
+	f, err := os.CreateTemp(".", ".store-check-")
+	if err != nil {
+		return fmt.Errorf("failed to CreateTemp: %w", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	for _, pattern := range []string{"*_level.txt", "*_department.txt"} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("failed to Glob: %w", err)
+		}
+		for _, path := range paths {
+			if _, err = getAccumulatorByPath(strings.TrimSuffix(path, ".txt")); err != nil {
+				return fmt.Errorf("failed to load %s: %w", path, err)
+			}
+		}
+	}
+
+	return nil
+}
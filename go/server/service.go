@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"server/security"
+	"server/storage"
+)
+
+// membershipMu serializes changes to the accumulators, which are rewritten as a
+// whole on every change.
+var membershipMu sync.Mutex
+
+// enrollUser creates the user, adds them to the level and department
+// accumulators, stores their witnesses and issues the code binding their
+// Telegram account.
+func enrollUser(tgName string, level, department int) (Enrollment, error) {
+	membershipMu.Lock()
+	defer membershipMu.Unlock()
+
+	user, err := storage.AddUser(db.DB, tgName, department, level)
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("failed to AddUser: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("failed to DecodeString data: %w", err)
+	}
+
+	witLevel, witDep, err := security.Add(level, department, data)
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("failed to Add: %w", err)
+	}
+
+	if err := storage.SetWitness(db.DB, storage.Witness{ID: user.PK, WitnessLevel: base64.StdEncoding.EncodeToString(witLevel), WitnessDep: base64.StdEncoding.EncodeToString(witDep)}); err != nil {
+		return Enrollment{}, fmt.Errorf("failed to SetWitness: %w", err)
+	}
+	refreshMembers([]int{level}, []int{department}, user.ID)
+
+	e, err := issueEnrollmentCode(db.DB, user)
+	if err != nil {
+		return Enrollment{}, fmt.Errorf("failed to issueEnrollmentCode: %w", err)
+	}
+
+	return e, nil
+}
+
+// requestEnrollment enrolls the user, or proposes the enrollment when the level
+// needs the approval of a second admin.
+func requestEnrollment(admin *storage.Admin, tgName string, level, department int) (Enrollment, *storage.Proposal, error) {
+	if requiresDualControl(storage.ProposalAdd, level) {
+		p, err := propose(admin, storage.ProposalAdd, storage.User{TgName: tgName, Level: level, Department: department})
+		if err != nil {
+			return Enrollment{}, nil, fmt.Errorf("failed to propose: %w", err)
+		}
+		return Enrollment{}, &p, nil
+	}
+
+	e, err := enrollUser(tgName, level, department)
+	if err != nil {
+		return Enrollment{}, nil, fmt.Errorf("failed to enrollUser: %w", err)
+	}
+
+	return e, nil, nil
+}
+
+// clearanceNeedsApproval reports whether changing the user's clearance needs
+// the approval of a second admin: any change to a level under dual control
+// but a downgrade within the department. A move to another department gives
+// access to its files like a raise does.
+func clearanceNeedsApproval(user storage.User, level, department int) bool {
+	downgrade := level < user.Level && department == user.Department
+	return !downgrade && requiresDualControl(storage.ProposalUpdate, level)
+}
+
+// requestClearance changes the user's clearance, or proposes the change when
+// it needs the approval of a second admin.
+func requestClearance(admin *storage.Admin, user storage.User, level, department int) (storage.User, *storage.Proposal, error) {
+	if level == user.Level && department == user.Department {
+		return user, nil, nil
+	}
+
+	if clearanceNeedsApproval(user, level, department) {
+		p, err := propose(admin, storage.ProposalUpdate, storage.User{ID: user.ID, TgName: user.TgName, Level: level, Department: department})
+		if err != nil {
+			return storage.User{}, nil, fmt.Errorf("failed to propose: %w", err)
+		}
+		return storage.User{}, &p, nil
+	}
+
+	user, err := updateUserClearance(user.ID, level, department)
+	if err != nil {
+		return storage.User{}, nil, fmt.Errorf("failed to updateUserClearance: %w", err)
+	}
+
+	return user, nil, nil
+}
+
+// Revocation reports what revokeUser removed.
+type Revocation struct {
+	User           storage.User
+	Accumulators   []string
+	WitnessDeleted bool
+	// FilesDeleted is the number of the user's uploads marked deleted. Their
+	// content is unpinned after the retention period.
+	FilesDeleted int
+}
+
+// revokeUser removes the user from their level and department accumulators,
+// deletes their witnesses and the user record and marks their uploads deleted.
+// No ABE keys are stored per user: they are generated on every decryption
+// after the witness check, so dropping the accumulator membership and
+// witnesses revokes them as well.
+func revokeUser(id int) (Revocation, error) {
+	membershipMu.Lock()
+	defer membershipMu.Unlock()
+
+	user, err := storage.GetUserByID(db.DB, id)
+	if err != nil {
+		return Revocation{}, fmt.Errorf("failed to GetUserByID: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return Revocation{}, fmt.Errorf("failed to DecodeString data: %w", err)
+	}
+
+	if err := security.Delete(user.Level, user.Department, data); err != nil {
+		return Revocation{}, fmt.Errorf("failed to Delete: %w", err)
+	}
+
+	rev := Revocation{
+		User:         user,
+		Accumulators: []string{fmt.Sprintf("level %d", user.Level), fmt.Sprintf("department %d", user.Department)},
+	}
+
+	if rev.WitnessDeleted, rev.FilesDeleted, err = deleteUserRecords(user); err != nil {
+		return Revocation{}, restoreMembership(user, data, err)
+	}
+	refreshMembers([]int{user.Level}, []int{user.Department}, user.ID)
+
+	return rev, nil
+}
+
+func deleteUserRecords(user storage.User) (bool, int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := storage.DeleteWitness(tx, user.PK)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to DeleteWitness: %w", err)
+	}
+
+	files, err := storage.DeleteUserFiles(tx, user.ID)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to DeleteUserFiles: %w", err)
+	}
+
+	if err := storage.DeleteUser(tx, user.ID); err != nil {
+		return false, 0, fmt.Errorf("failed to DeleteUser: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, fmt.Errorf("failed to Commit: %w", err)
+	}
+
+	return deleted, files, nil
+}
+
+// updateUserClearance moves the user to another level and/or department. The
+// user's element is removed from the old accumulators and added to the new
+// ones, and their witnesses and those of the other members of both are
+// reissued. ABE attribute keys are derived from the stored level and
+// department on every decryption, so updating the user row reissues them as
+// well. On failure the old membership is restored.
+func updateUserClearance(id, level, department int) (storage.User, error) {
+	membershipMu.Lock()
+	defer membershipMu.Unlock()
+
+	user, err := storage.GetUserByID(db.DB, id)
+	if err != nil {
+		return storage.User{}, fmt.Errorf("failed to GetUserByID: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return storage.User{}, fmt.Errorf("failed to DecodeString data: %w", err)
+	}
+
+	if err := security.Delete(user.Level, user.Department, data); err != nil {
+		return storage.User{}, fmt.Errorf("failed to Delete: %w", err)
+	}
+
+	witLevel, witDep, err := security.Add(level, department, data)
+	if err != nil {
+		return storage.User{}, restoreMembership(user, data, fmt.Errorf("failed to Add: %w", err))
+	}
+
+	if err := saveClearance(user, level, department, witLevel, witDep); err != nil {
+		if delErr := security.Delete(level, department, data); delErr != nil {
+			return storage.User{}, fmt.Errorf("%v; failed to Delete new membership: %w", err, delErr)
+		}
+		return storage.User{}, restoreMembership(user, data, err)
+	}
+	refreshMembers([]int{user.Level, level}, []int{user.Department, department}, user.ID)
+
+	user.Level, user.Department = level, department
+	notifyClearanceChanged(user)
+
+	return user, nil
+}
+
+func saveClearance(user storage.User, level, department int, witLevel, witDep []byte) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := storage.UpdateUserClearance(tx, user.ID, level, department); err != nil {
+		return fmt.Errorf("failed to UpdateUserClearance: %w", err)
+	}
+
+	if err := storage.SetWitness(tx, storage.Witness{ID: user.PK, WitnessLevel: base64.StdEncoding.EncodeToString(witLevel), WitnessDep: base64.StdEncoding.EncodeToString(witDep)}); err != nil {
+		return fmt.Errorf("failed to SetWitness: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to Commit: %w", err)
+	}
+
+	return nil
+}
+
+// refreshMembers reissues the stored witnesses of the members of the level and
+// department accumulators, except the user who changed them, whose witnesses
+// the change issued. Every change of an accumulator invalidates the witnesses
+// of its members, and bot users have no other way to refresh them.
+// membershipMu must be held.
+func refreshMembers(levels, departments []int, except int) {
+	toInt32 := func(values []int) []int32 {
+		out := make([]int32, len(values))
+		for i, v := range values {
+			out[i] = int32(v)
+		}
+		return out
+	}
+
+	users, err := storage.GetUsersInAccumulators(db.DB, toInt32(levels), toInt32(departments))
+	if err != nil {
+		zap.L().Error("failed to GetUsersInAccumulators", zap.Error(err))
+		return
+	}
+
+	for _, u := range users {
+		if u.ID == except {
+			continue
+		}
+		if err := refreshStoredWitness(u); err != nil {
+			// the user can still refresh them with POST /witness
+			zap.L().Error("failed to refresh witness", zap.Int("user_id", u.ID), zap.Error(err))
+		}
+	}
+}
+
+func refreshStoredWitness(user storage.User) error {
+	data, err := base64.StdEncoding.DecodeString(user.PK)
+	if err != nil {
+		return fmt.Errorf("failed to DecodeString data: %w", err)
+	}
+
+	witLevel, witDep, err := security.Witness(user.Level, user.Department, data)
+	if err != nil {
+		return fmt.Errorf("failed to Witness: %w", err)
+	}
+
+	return storage.SetWitness(db.DB, storage.Witness{ID: user.PK, WitnessLevel: base64.StdEncoding.EncodeToString(witLevel), WitnessDep: base64.StdEncoding.EncodeToString(witDep)})
+}
+
+// restoreMembership puts the user back into their old accumulators after a
+// failed move and reissues their witnesses. It returns cause.
+func restoreMembership(user storage.User, data []byte, cause error) error {
+	witLevel, witDep, err := security.Add(user.Level, user.Department, data)
+	if err != nil {
+		return fmt.Errorf("%v; failed to restore membership: %w", cause, err)
+	}
+
+	if err := storage.SetWitness(db.DB, storage.Witness{ID: user.PK, WitnessLevel: base64.StdEncoding.EncodeToString(witLevel), WitnessDep: base64.StdEncoding.EncodeToString(witDep)}); err != nil {
+		return fmt.Errorf("%v; failed to restore witness: %w", cause, err)
+	}
+
+	return cause
+}
+
+// initMembers records the enrolled users as the members of their accumulators
+// where these don't track their members yet, so their witnesses can be
+// refreshed.
+func initMembers() error {
+	users, err := storage.GetAll(db.DB)
+	if err != nil {
+		return fmt.Errorf("failed to GetAll: %w", err)
+	}
+
+	members := make([]security.Member, 0, len(users))
+	for _, u := range users {
+		data, err := base64.StdEncoding.DecodeString(u.PK)
+		if err != nil {
+			return fmt.Errorf("failed to DecodeString data of user %d: %w", u.ID, err)
+		}
+		members = append(members, security.Member{Level: u.Level, Department: u.Department, Data: data})
+	}
+
+	return security.InitMembers(members)
+}
+
+// setUserStatus suspends or reinstates the user. The enrollment is kept, so a
+// reinstated user keeps their witnesses.
+func setUserStatus(id int, status string) (storage.User, error) {
+	if err := storage.SetUserStatus(db.DB, id, status); err != nil {
+		return storage.User{}, fmt.Errorf("failed to SetUserStatus: %w", err)
+	}
+
+	user, err := storage.GetUserByID(db.DB, id)
+	if err != nil {
+		return storage.User{}, fmt.Errorf("failed to GetUserByID: %w", err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/storage"
+)
+
+func TestClearanceNeedsApproval(t *testing.T) {
+	user := storage.User{ID: 6, Level: 4, Department: 1}
+
+	for _, tt := range []struct {
+		name              string
+		level, department int
+		approval          bool
+	}{
+		{"downgrade", 3, 1, false},
+		{"downgrade below dual control", 1, 1, false},
+		{"department move", 4, 2, true},
+		{"downgrade to another department", 3, 2, true},
+		{"downgrade below dual control to another department", 2, 2, false},
+	} {
+		require.Equal(t, tt.approval, clearanceNeedsApproval(user, tt.level, tt.department), tt.name)
+	}
+
+	require.True(t, clearanceNeedsApproval(storage.User{Level: 2, Department: 1}, 3, 1))
+	require.False(t, clearanceNeedsApproval(storage.User{Level: 1, Department: 1}, 2, 1))
+}
@@ -7,6 +7,13 @@ import (
 	"github.com/jackc/pgx"
 )
 
+// ErrAuthorityNotFound is returned when there are no ABE authority keys for a
+// ciphertext. It wraps pgx.ErrNoRows.
+var ErrAuthorityNotFound = fmt.Errorf("abe authority not found: %w", pgx.ErrNoRows)
+
+// AbeAuth holds the authority keys of a ciphertext. The ID is the IPFS link of
+// the ciphertext; for files stored before the binary container it's the
+// Stribog-512 hash of their base64 text.
 type AbeAuth struct {
 	ID        string
 	LevelAuth string
@@ -27,7 +34,7 @@ func GetAbe(conn *pgx.ConnPool, id string) (AbeAuth, error) {
 	err := conn.QueryRow("SELECT id, level, dep FROM auth WHERE id = $1;", id).Scan(&abe.ID, &abe.LevelAuth, &abe.DepAuth)
 
 	if err == pgx.ErrNoRows {
-		return AbeAuth{}, err
+		return AbeAuth{}, ErrAuthorityNotFound
 	} else if err != nil {
 		return AbeAuth{}, fmt.Errorf("failed to Scan: %w", err)
 	}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Admin roles. A chief officer manages every department and level, a department
+// officer manages users of their own department below the top level, and an
+// auditor has read-only access.
+const (
+	RoleChief      = "chief"
+	RoleDepartment = "department"
+	RoleAuditor    = "auditor"
+)
+
+type Admin struct {
+	ID         int
+	Name       string
+	TokenHash  string `json:"-"`
+	Role       string
+	Department int
+	// TgID is the Telegram account the admin uses for bot commands, 0 if none.
+	TgID int64
+}
+
+func CreateTableAdmin(conn *pgx.ConnPool) error {
+	err := conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "admin"(
+id SERIAL PRIMARY KEY ,
+name TEXT NOT NULL UNIQUE,
+token_hash TEXT NOT NULL UNIQUE,
+role TEXT NOT NULL,
+department int
+)`).Scan()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	_, err = conn.Exec(`
+ALTER TABLE "admin" ADD COLUMN IF NOT EXISTS tg_id BIGINT UNIQUE;
+ALTER TABLE "admin" ADD COLUMN IF NOT EXISTS tg_code_hash TEXT UNIQUE;
+ALTER TABLE "admin" ADD COLUMN IF NOT EXISTS tg_code_expires_at TIMESTAMPTZ;`)
+
+	return err
+}
+
+const adminColumns = `id, name, token_hash, role, department, tg_id`
+
+func scanAdmin(row interface{ Scan(...interface{}) error }) (Admin, error) {
+	var (
+		admin Admin
+		dep   *int
+		tgID  *int64
+	)
+	err := row.Scan(&admin.ID, &admin.Name, &admin.TokenHash, &admin.Role, &dep, &tgID)
+	if dep != nil {
+		admin.Department = *dep
+	}
+	if tgID != nil {
+		admin.TgID = *tgID
+	}
+
+	return admin, err
+}
+
+func AddAdmin(conn *pgx.ConnPool, admin Admin) (Admin, error) {
+	err := conn.QueryRow(`INSERT INTO "admin" (name, token_hash, role, department) VALUES ($1, $2, $3, $4) RETURNING id`,
+		admin.Name, admin.TokenHash, admin.Role, admin.Department).Scan(&admin.ID)
+	if err != nil {
+		return Admin{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return admin, nil
+}
+
+// EnsureAdmin creates the admin, or sets the token of the admin with the same
+// name, so that a rotated token replaces the old one.
+func EnsureAdmin(conn *pgx.ConnPool, admin Admin) error {
+	err := conn.QueryRow(`INSERT INTO "admin" (name, token_hash, role, department) VALUES ($1, $2, $3, $4)
+ON CONFLICT (name) DO UPDATE SET token_hash = EXCLUDED.token_hash`,
+		admin.Name, admin.TokenHash, admin.Role, admin.Department).Scan()
+
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return nil
+}
+
+func GetAdminByToken(conn *pgx.ConnPool, tokenHash string) (Admin, error) {
+	admin, err := scanAdmin(conn.QueryRow(`SELECT `+adminColumns+` FROM "admin" WHERE token_hash = $1;`, tokenHash))
+
+	if err == pgx.ErrNoRows {
+		return Admin{}, err
+	} else if err != nil {
+		return Admin{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return admin, nil
+}
+
+func GetAllAdmins(conn *pgx.ConnPool) ([]Admin, error) {
+	var admins []Admin
+	rows, err := conn.Query(`SELECT ` + adminColumns + ` FROM "admin" ORDER BY id;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		admin, err := scanAdmin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		admins = append(admins, admin)
+	}
+
+	return admins, nil
+}
+
+func GetAdminByTgID(conn *pgx.ConnPool, tgID int64) (Admin, error) {
+	admin, err := scanAdmin(conn.QueryRow(`SELECT `+adminColumns+` FROM "admin" WHERE tg_id = $1;`, tgID))
+
+	if err == pgx.ErrNoRows {
+		return Admin{}, err
+	} else if err != nil {
+		return Admin{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return admin, nil
+}
+
+// SetAdminTgCode stores the hash of the code linking the admin's Telegram
+// account, replacing the previous one.
+func SetAdminTgCode(conn *pgx.ConnPool, id int, codeHash string, expiresAt time.Time) error {
+	tag, err := conn.Exec(`UPDATE "admin" SET tg_code_hash = $2, tg_code_expires_at = $3 WHERE id = $1`, id, codeHash, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// RedeemAdminTgCode uses the code and links the Telegram account to its admin.
+func RedeemAdminTgCode(conn *pgx.ConnPool, codeHash string, tgID int64, now time.Time) (Admin, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return Admin{}, fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	admin, err := scanAdmin(tx.QueryRow(`SELECT `+adminColumns+` FROM "admin" WHERE tg_code_hash = $1 AND tg_code_expires_at > $2 FOR UPDATE`, codeHash, now))
+	if err == pgx.ErrNoRows {
+		return Admin{}, ErrInvalidCode
+	} else if err != nil {
+		return Admin{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	var bound int
+	err = tx.QueryRow(`SELECT id FROM "admin" WHERE tg_id = $1`, tgID).Scan(&bound)
+	if err == nil && bound != admin.ID {
+		return Admin{}, ErrTgIDBound
+	} else if err != nil && err != pgx.ErrNoRows {
+		return Admin{}, fmt.Errorf("failed to Scan bound admin: %w", err)
+	}
+
+	if _, err = tx.Exec(`UPDATE "admin" SET tg_id = $2, tg_code_hash = NULL, tg_code_expires_at = NULL WHERE id = $1`, admin.ID, tgID); err != nil {
+		return Admin{}, fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Admin{}, fmt.Errorf("failed to Commit: %w", err)
+	}
+	admin.TgID = tgID
+
+	return admin, nil
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+
+	"server/audit"
+)
+
+// CreateTableAuditEvent creates the audit trail. Triggers reject changes to
+// stored events, so the table is append-only for the server role.
+func CreateTableAuditEvent(conn *pgx.ConnPool) error {
+	err := conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "audit_event"(
+seq BIGINT PRIMARY KEY ,
+time TIMESTAMPTZ NOT NULL,
+channel TEXT NOT NULL,
+user_id int NOT NULL DEFAULT 0,
+admin_id int NOT NULL DEFAULT 0,
+action TEXT NOT NULL,
+target TEXT NOT NULL DEFAULT '',
+file_id BIGINT NOT NULL DEFAULT 0,
+cid TEXT NOT NULL DEFAULT '',
+level int,
+decision TEXT NOT NULL,
+check_name TEXT NOT NULL DEFAULT '',
+reason TEXT NOT NULL DEFAULT '',
+prev_hash TEXT NOT NULL,
+hash TEXT NOT NULL
+)`).Scan()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	_, err = conn.Exec(`
+CREATE INDEX IF NOT EXISTS audit_event_user_idx ON "audit_event" (user_id, seq);
+CREATE INDEX IF NOT EXISTS audit_event_file_idx ON "audit_event" (file_id, seq);
+CREATE OR REPLACE FUNCTION audit_event_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_event_append_only ON "audit_event";
+CREATE TRIGGER audit_event_append_only BEFORE UPDATE OR DELETE ON "audit_event"
+FOR EACH ROW EXECUTE PROCEDURE audit_event_append_only();
+DROP TRIGGER IF EXISTS audit_event_no_truncate ON "audit_event";
+CREATE TRIGGER audit_event_no_truncate BEFORE TRUNCATE ON "audit_event"
+FOR EACH STATEMENT EXECUTE PROCEDURE audit_event_append_only();`)
+
+	return err
+}
+
+const auditColumns = `seq, time, channel, user_id, admin_id, action, target, file_id, cid, level, decision, check_name, reason, prev_hash, hash`
+
+func scanAuditEvent(row interface{ Scan(...interface{}) error }) (audit.Event, error) {
+	var e audit.Event
+	err := row.Scan(&e.Seq, &e.Time, &e.Channel, &e.UserID, &e.AdminID, &e.Action, &e.Target, &e.FileID, &e.CID,
+		&e.Level, &e.Decision, &e.Check, &e.Reason, &e.PrevHash, &e.Hash)
+
+	return e, err
+}
+
+// AppendAuditEvent chains the event to the last stored one, seals it with the
+// key and stores it. Appends are serialized by a table lock so the chain has
+// no forks.
+func AppendAuditEvent(conn *pgx.ConnPool, key []byte, e audit.Event) (audit.Event, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return audit.Event{}, fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`LOCK TABLE "audit_event" IN EXCLUSIVE MODE`); err != nil {
+		return audit.Event{}, fmt.Errorf("failed to Exec lock: %w", err)
+	}
+
+	var prev *audit.Event
+	last, err := scanAuditEvent(tx.QueryRow(`SELECT ` + auditColumns + ` FROM "audit_event" ORDER BY seq DESC LIMIT 1`))
+	if err == nil {
+		prev = &last
+	} else if err != pgx.ErrNoRows {
+		return audit.Event{}, fmt.Errorf("failed to Scan last: %w", err)
+	}
+
+	e = audit.Chain(prev, e, key)
+	if _, err = tx.Exec(`INSERT INTO "audit_event" (`+auditColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		e.Seq, e.Time, e.Channel, e.UserID, e.AdminID, e.Action, e.Target, e.FileID, e.CID,
+		e.Level, e.Decision, e.Check, e.Reason, e.PrevHash, e.Hash); err != nil {
+		return audit.Event{}, fmt.Errorf("failed to Exec insert: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return audit.Event{}, fmt.Errorf("failed to Commit: %w", err)
+	}
+
+	return e, nil
+}
+
+// AuditFilter selects audit events. Zero fields don't filter.
+type AuditFilter struct {
+	UserID   int
+	AdminID  int
+	Channel  string
+	Action   string
+	Decision string
+	FileID   int64
+	From     time.Time
+	To       time.Time
+	// AfterSeq returns the events after the given one, in order.
+	AfterSeq int64
+	Limit    int
+}
+
+// ListAuditEvents returns the matching events ordered by seq.
+func ListAuditEvents(conn Conn, filter AuditFilter) ([]audit.Event, error) {
+	where := []string{"true"}
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.UserID != 0 {
+		where = append(where, "user_id = "+arg(filter.UserID))
+	}
+	if filter.AdminID != 0 {
+		where = append(where, "admin_id = "+arg(filter.AdminID))
+	}
+	if filter.Channel != "" {
+		where = append(where, "channel = "+arg(filter.Channel))
+	}
+	if filter.Action != "" {
+		where = append(where, "action = "+arg(filter.Action))
+	}
+	if filter.Decision != "" {
+		where = append(where, "decision = "+arg(filter.Decision))
+	}
+	if filter.FileID != 0 {
+		where = append(where, "file_id = "+arg(filter.FileID))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "time >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "time < "+arg(filter.To))
+	}
+	if filter.AfterSeq > 0 {
+		where = append(where, "seq > "+arg(filter.AfterSeq))
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM "audit_event" WHERE ` + strings.Join(where, " AND ") + ` ORDER BY seq`
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	events := []audit.Event{}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
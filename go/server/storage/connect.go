@@ -1,37 +1,52 @@
 package storage
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/jackc/pgx"
+	"go.uber.org/zap"
 
 	"server/config"
+	"server/logging"
+)
+
+const (
+	defaultConnectRetries = 10
+	defaultConnectBackoff = time.Second
+	maxConnectBackoff     = 30 * time.Second
 )
 
 type Database struct {
 	DB           *pgx.ConnPool
 	User         string
-	Password     string
+	Password     logging.Secret
 	DataBaseName string
 }
 
-func (dbInfo *Database) Init(config config.Config) error {
+// Init connects to the database, retrying with a growing delay until the
+// configured number of retries is spent or ctx is done.
+func (dbInfo *Database) Init(ctx context.Context, config config.Config) error {
 	dbInfo.User = config.Database.User
 	dbInfo.Password = config.Database.Password
 	dbInfo.DataBaseName = config.Database.Name
 
-	connStr := fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=%s port=%s",
+	connStr := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s",
 		config.Database.Host,
 		config.Database.User,
-		config.Database.Password,
+		config.Database.Password.Reveal(),
 		config.Database.Name,
-		config.Database.SslMode,
 		config.Database.Port)
 	pgxConn, err := pgx.ParseConnectionString(connStr)
 	if err != nil {
-		fmt.Println(err.Error())
-		return err
+		return fmt.Errorf("failed to ParseConnectionString: %w", err)
+	}
+	if err = configureTLS(&pgxConn, config.Database); err != nil {
+		return fmt.Errorf("failed to configureTLS: %w", err)
 	}
 	pgxConn.PreferSimpleProtocol = true
 	confPGX := pgx.ConnPoolConfig{
@@ -41,18 +56,132 @@ func (dbInfo *Database) Init(config config.Config) error {
 		AcquireTimeout: 0,
 	}
 
-	connPool, err := pgx.NewConnPool(confPGX)
-	for {
+	retries, backoff := config.Database.ConnectRetries, config.Database.ConnectBackoff
+	if retries <= 0 {
+		retries = defaultConnectRetries
+	}
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+
+	l := zap.L().With(zap.String("host", config.Database.Host), zap.String("port", config.Database.Port), zap.String("database", config.Database.Name))
+	for attempt := 0; ; attempt++ {
+		connPool, err := pgx.NewConnPool(confPGX)
+		if err == nil {
+			l.Info("connected to database")
+			dbInfo.DB = connPool
+			return nil
+		}
+		if attempt == retries {
+			return fmt.Errorf("failed to NewConnPool after %d retries: %w", retries, err)
+		}
+
+		// the connection string holds the password, so only its parts are logged
+		l.Warn("failed to connect to database", zap.Int("attempt", attempt+1), zap.Duration("retry_in", backoff), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to connect to database: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// Ping checks that the database answers queries.
+func (dbInfo *Database) Ping(ctx context.Context) error {
+	if dbInfo.DB == nil {
+		return fmt.Errorf("database is not connected")
+	}
+
+	var one int
+	if err := dbInfo.DB.QueryRowEx(ctx, `SELECT 1`, nil).Scan(&one); err != nil {
+		return fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return nil
+}
+
+// configureTLS sets the TLS settings of the connections like libpq does for
+// the ssl mode. "allow" and "prefer" fall back, to TLS and to plain text, and
+// verify nothing, as do "require" without a root certificate.
+func configureTLS(cc *pgx.ConnConfig, db config.DB) error {
+	mode := db.SslMode
+	if mode == "" {
+		mode = "prefer"
+	}
+	cc.TLSConfig, cc.FallbackTLSConfig, cc.UseFallbackTLS = nil, nil, false
+	if mode == "disable" {
+		return nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: db.Host}
+	if db.SslRootCert != "" {
+		pool, err := config.CertPool(db.SslRootCert)
+		if err != nil {
+			return fmt.Errorf("failed to load database.ssl_root_cert: %w", err)
+		}
+		cfg.RootCAs = pool
+	}
+	if db.SslCert != "" {
+		cert, err := tls.LoadX509KeyPair(db.SslCert, db.SslKey)
 		if err != nil {
-			fmt.Println(err.Error() + " : " + connStr)
-			time.Sleep(time.Duration(5) * time.Second)
+			return fmt.Errorf("failed to load database.ssl_cert: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	switch mode {
+	case "allow":
+		cfg.InsecureSkipVerify = true
+		cc.UseFallbackTLS, cc.FallbackTLSConfig = true, cfg
+	case "prefer":
+		cfg.InsecureSkipVerify = true
+		cc.TLSConfig, cc.UseFallbackTLS = cfg, true
+	case "require":
+		if db.SslRootCert == "" {
+			cfg.InsecureSkipVerify = true
 		} else {
-			fmt.Println("Connected to db!")
-			break
+			verifyCA(cfg)
 		}
-		connPool, err = pgx.NewConnPool(confPGX)
+		cc.TLSConfig = cfg
+	case "verify-ca":
+		verifyCA(cfg)
+		cc.TLSConfig = cfg
+	case "verify-full":
+		cc.TLSConfig = cfg
+	default:
+		return fmt.Errorf("unknown ssl_mode %q", db.SslMode)
 	}
-	dbInfo.DB = connPool
 
-	return err
+	return nil
+}
+
+// verifyCA makes cfg verify the chain of the server certificate but not its
+// host name.
+func verifyCA(cfg *tls.Config) {
+	roots := cfg.RootCAs
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return errors.New("no server certificate")
+		}
+		certs := make([]*x509.Certificate, len(raw))
+		for i, der := range raw {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("failed to ParseCertificate: %w", err)
+			}
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+
+		return err
+	}
 }
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/stretchr/testify/require"
+
+	"server/config"
+	"server/testcert"
+)
+
+func TestConfigureTLS(t *testing.T) {
+	ca := testcert.NewCA(t, "ca")
+	serverCert := ca.KeyPair(t, "postgres", "localhost")
+	clientCert, clientKey := ca.Issue(t, "client")
+
+	// handshake connects to a TLS server with the certificate of localhost as
+	// the configured host.
+	handshake := func(db config.DB) error {
+		var cc pgx.ConnConfig
+		require.NoError(t, configureTLS(&cc, db))
+		require.NotNil(t, cc.TLSConfig)
+		require.False(t, cc.UseFallbackTLS)
+
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    ca.Pool,
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		})
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			if conn, err := ln.Accept(); err == nil {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+
+		conn, err := tls.Dial("tcp", ln.Addr().String(), cc.TLSConfig)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	require.NoError(t, handshake(config.DB{Host: "localhost", SslMode: "verify-full", SslRootCert: ca.CertFile}))
+	require.Error(t, handshake(config.DB{Host: "db.example.org", SslMode: "verify-full", SslRootCert: ca.CertFile}), "wrong host")
+	require.NoError(t, handshake(config.DB{Host: "db.example.org", SslMode: "verify-ca", SslRootCert: ca.CertFile}))
+	require.NoError(t, handshake(config.DB{Host: "db.example.org", SslMode: "require", SslRootCert: ca.CertFile}))
+	require.NoError(t, handshake(config.DB{Host: "localhost", SslMode: "verify-full", SslRootCert: ca.CertFile, SslCert: clientCert, SslKey: clientKey}))
+
+	other := testcert.NewCA(t, "other")
+	require.Error(t, handshake(config.DB{Host: "localhost", SslMode: "verify-ca", SslRootCert: other.CertFile}), "other CA")
+	require.Error(t, handshake(config.DB{Host: "localhost", SslMode: "require", SslRootCert: other.CertFile}), "other CA")
+	// like libpq, require verifies nothing without a root certificate
+	require.NoError(t, handshake(config.DB{Host: "db.example.org", SslMode: "require"}))
+
+	var cc pgx.ConnConfig
+	require.NoError(t, configureTLS(&cc, config.DB{SslMode: "disable"}))
+	require.Nil(t, cc.TLSConfig)
+	require.False(t, cc.UseFallbackTLS)
+
+	require.NoError(t, configureTLS(&cc, config.DB{}))
+	require.True(t, cc.TLSConfig.InsecureSkipVerify, "prefer")
+	require.True(t, cc.UseFallbackTLS)
+	require.Nil(t, cc.FallbackTLSConfig)
+
+	require.NoError(t, configureTLS(&cc, config.DB{SslMode: "allow"}))
+	require.Nil(t, cc.TLSConfig)
+	require.True(t, cc.UseFallbackTLS)
+	require.NotNil(t, cc.FallbackTLSConfig)
+
+	require.Error(t, configureTLS(&cc, config.DB{SslMode: "verify"}))
+	require.Error(t, configureTLS(&cc, config.DB{SslMode: "verify-full", SslRootCert: clientKey}))
+	require.Error(t, configureTLS(&cc, config.DB{SslMode: "verify-full", SslCert: clientCert, SslKey: clientCert}))
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Delivery is an entry of the access log of the decrypted files sent by the bot
+// or downloaded over HTTP.
+type Delivery struct {
+	ID     int
+	FileID int64
+	UserID int
+	// Channel is "telegram" or "http". Only the bot sends messages, HTTP
+	// deliveries have no chat and message.
+	Channel     string
+	ChatID      int64
+	MessageID   int
+	Level       int
+	DeliveredAt time.Time
+	// DeleteAt is when the sent message is deleted, zero if it is kept.
+	DeleteAt  time.Time
+	DeletedAt time.Time
+}
+
+func CreateTableDelivery(conn *pgx.ConnPool) error {
+	err := conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "delivery"(
+id SERIAL PRIMARY KEY ,
+file_id int NOT NULL,
+user_id int NOT NULL,
+chat_id BIGINT NOT NULL,
+message_id int NOT NULL,
+level int NOT NULL,
+delivered_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+delete_at TIMESTAMPTZ,
+deleted_at TIMESTAMPTZ
+)`).Scan()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	_, err = conn.Exec(`
+ALTER TABLE "delivery" ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'telegram';
+CREATE INDEX IF NOT EXISTS delivery_file_user_idx ON "delivery" (file_id, user_id);
+CREATE INDEX IF NOT EXISTS delivery_delete_at_idx ON "delivery" (delete_at) WHERE deleted_at IS NULL;`)
+
+	return err
+}
+
+const deliveryColumns = `id, file_id, user_id, channel, chat_id, message_id, level, delivered_at, delete_at, deleted_at`
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (Delivery, error) {
+	var (
+		d                   Delivery
+		deleteAt, deletedAt *time.Time
+	)
+	err := row.Scan(&d.ID, &d.FileID, &d.UserID, &d.Channel, &d.ChatID, &d.MessageID, &d.Level, &d.DeliveredAt, &deleteAt, &deletedAt)
+	if deleteAt != nil {
+		d.DeleteAt = *deleteAt
+	}
+	if deletedAt != nil {
+		d.DeletedAt = *deletedAt
+	}
+
+	return d, err
+}
+
+// ErrDownloadLimit is returned when a file was delivered to a user as many
+// times as allowed.
+var ErrDownloadLimit = errors.New("download limit reached")
+
+// ReserveDelivery adds the delivery unless the file was delivered to the user
+// max times already, over any channel; max 0 is no limit. Reservations of the
+// same file and user are serialized by an advisory lock, so concurrent
+// requests can't exceed the limit. The message and the deletion time of a bot
+// delivery are set by SetDeliveryMessage once it's sent.
+func ReserveDelivery(conn *pgx.ConnPool, d Delivery, max int) (Delivery, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return Delivery{}, fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1::int, $2::int)`, d.FileID, d.UserID); err != nil {
+		return Delivery{}, fmt.Errorf("failed to Exec lock: %w", err)
+	}
+
+	if max > 0 {
+		var n int
+		if err = tx.QueryRow(`SELECT count(*) FROM "delivery" WHERE file_id = $1 AND user_id = $2`, d.FileID, d.UserID).Scan(&n); err != nil {
+			return Delivery{}, fmt.Errorf("failed to Scan count: %w", err)
+		}
+		if n >= max {
+			return Delivery{}, ErrDownloadLimit
+		}
+	}
+
+	d, err = scanDelivery(tx.QueryRow(`INSERT INTO "delivery" (file_id, user_id, channel, chat_id, message_id, level)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+deliveryColumns,
+		d.FileID, d.UserID, d.Channel, d.ChatID, d.MessageID, d.Level))
+	if err != nil {
+		return Delivery{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Delivery{}, fmt.Errorf("failed to Commit: %w", err)
+	}
+
+	return d, nil
+}
+
+// SetDeliveryMessage sets the sent message of a reserved delivery and when it
+// is deleted, never if deleteAt is zero.
+func SetDeliveryMessage(conn *pgx.ConnPool, id int, messageID int, deleteAt time.Time) error {
+	var at *time.Time
+	if !deleteAt.IsZero() {
+		at = &deleteAt
+	}
+
+	if _, err := conn.Exec(`UPDATE "delivery" SET message_id = $2, delete_at = $3 WHERE id = $1`, id, messageID, at); err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteDelivery deletes a delivery that didn't happen.
+func DeleteDelivery(conn *pgx.ConnPool, id int) error {
+	if _, err := conn.Exec(`DELETE FROM "delivery" WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	return nil
+}
+
+// CountDeliveries returns how many times the file was delivered to the user,
+// over any channel.
+func CountDeliveries(conn *pgx.ConnPool, fileID int64, userID int) (int, error) {
+	var n int
+	if err := conn.QueryRow(`SELECT count(*) FROM "delivery" WHERE file_id = $1 AND user_id = $2`, fileID, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return n, nil
+}
+
+// DueDeliveries returns the sent messages that are due for deletion.
+func DueDeliveries(conn *pgx.ConnPool, now time.Time) ([]Delivery, error) {
+	rows, err := conn.Query(`SELECT `+deliveryColumns+` FROM "delivery" WHERE deleted_at IS NULL AND delete_at <= $1 ORDER BY delete_at`, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+func MarkDeliveryDeleted(conn *pgx.ConnPool, id int, now time.Time) error {
+	if _, err := conn.Exec(`UPDATE "delivery" SET deleted_at = $2 WHERE id = $1`, id, now); err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	return nil
+}
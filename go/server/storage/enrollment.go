@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+var (
+	// ErrInvalidCode is returned for unknown, used and expired enrollment codes.
+	ErrInvalidCode = errors.New("invalid enrollment code")
+	// ErrTgIDBound is returned when the Telegram account is bound to another user.
+	ErrTgIDBound = errors.New("telegram account is bound to another user")
+)
+
+// EnrollmentCode is a one-time code binding a Telegram account to a user. Only
+// the hash of the code is stored.
+type EnrollmentCode struct {
+	CodeHash  string
+	UserID    int
+	ExpiresAt time.Time
+}
+
+func CreateTableEnrollmentCode(conn *pgx.ConnPool) error {
+	return conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "enrollment_code"(
+code_hash TEXT PRIMARY KEY ,
+user_id int NOT NULL REFERENCES "user" (id) ON DELETE CASCADE,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+expires_at TIMESTAMPTZ NOT NULL,
+used_at TIMESTAMPTZ
+)`).Scan()
+}
+
+// SetEnrollmentCode stores a new code for the user, replacing the codes issued
+// before that weren't used.
+func SetEnrollmentCode(conn Conn, code EnrollmentCode) error {
+	if _, err := conn.Exec(`DELETE FROM "enrollment_code" WHERE user_id = $1 AND used_at IS NULL`, code.UserID); err != nil {
+		return fmt.Errorf("failed to Exec delete: %w", err)
+	}
+
+	if _, err := conn.Exec(`INSERT INTO "enrollment_code" (code_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		code.CodeHash, code.UserID, code.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to Exec insert: %w", err)
+	}
+
+	return nil
+}
+
+// RedeemEnrollmentCode uses the code and binds the Telegram account to its
+// user, replacing the account bound before.
+func RedeemEnrollmentCode(conn *pgx.ConnPool, codeHash string, tgID int64, tgName string, now time.Time) (User, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return User{}, fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`SELECT user_id FROM "enrollment_code" WHERE code_hash = $1 AND used_at IS NULL AND expires_at > $2 FOR UPDATE`,
+		codeHash, now).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return User{}, ErrInvalidCode
+	} else if err != nil {
+		return User{}, fmt.Errorf("failed to Scan code: %w", err)
+	}
+
+	var bound int
+	err = tx.QueryRow(`SELECT id FROM "user" WHERE tg_id = $1`, tgID).Scan(&bound)
+	if err == nil && bound != userID {
+		return User{}, ErrTgIDBound
+	} else if err != nil && err != pgx.ErrNoRows {
+		return User{}, fmt.Errorf("failed to Scan bound user: %w", err)
+	}
+
+	user, err := GetUserByID(tx, userID)
+	if err != nil {
+		return User{}, fmt.Errorf("failed to GetUserByID: %w", err)
+	}
+
+	// users without a username keep the name they were enrolled with
+	if tgName != "" && tgName != user.TgName {
+		if _, err = tx.Exec(`UPDATE "user" SET tg_name = $2 WHERE id = $1`, userID, tgName); err != nil {
+			return User{}, fmt.Errorf("failed to Exec tg_name: %w", err)
+		}
+		if _, err = tx.Exec(`INSERT INTO "tg_name_change" (user_id, old_name, new_name) VALUES ($1, $2, $3)`, userID, user.TgName, tgName); err != nil {
+			return User{}, fmt.Errorf("failed to Exec tg_name_change: %w", err)
+		}
+		user.TgName = tgName
+	}
+
+	if _, err = tx.Exec(`UPDATE "user" SET tg_id = $2 WHERE id = $1`, userID, tgID); err != nil {
+		return User{}, fmt.Errorf("failed to Exec tg_id: %w", err)
+	}
+	if _, err = tx.Exec(`UPDATE "enrollment_code" SET used_at = $2 WHERE code_hash = $1`, codeHash, now); err != nil {
+		return User{}, fmt.Errorf("failed to Exec used_at: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return User{}, fmt.Errorf("failed to Commit: %w", err)
+	}
+	user.TgID = tgID
+
+	return user, nil
+}
@@ -3,48 +3,186 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/jackc/pgx"
 )
 
+// ErrFileNotFound wraps pgx.ErrNoRows, so checks for either match it.
+var ErrFileNotFound = fmt.Errorf("file not found: %w", pgx.ErrNoRows)
+
 type File struct {
-	ID       int64
-	Name     string
-	IpfsKey  string
-	UserID   int
-	MimeType string
-	Type     string
+	ID         int64
+	Name       string
+	IpfsKey    string
+	UserID     int
+	MimeType   string
+	Type       string
+	Level      int
+	Department int
+	// Policy is the level whose delivery policy applies to the file, its own
+	// level or a stricter one chosen by the uploader.
+	Policy    int
+	Size      int64
+	CreatedAt time.Time
+}
+
+// FileFilter selects and orders the files returned by ListFiles. Zero values
+// don't filter.
+type FileFilter struct {
+	Name       string
+	MimeType   string
+	UploaderID int
+	Level      *int
+	From       time.Time
+	To         time.Time
+	Sort       string
+	Desc       bool
+	Limit      int
+	Offset     int
+}
+
+// FileSorts maps the supported sort keys to their columns.
+var FileSorts = map[string]string{
+	"name":       "f.name",
+	"created_at": "f.created_at",
+	"size":       "f.size",
+	"level":      "f.level",
+	"mime_type":  "f.mime_type",
 }
 
 func CreateTableFile(conn *pgx.ConnPool) error {
-	return conn.QueryRow(`
+	err := conn.QueryRow(`
 CREATE TABLE IF NOT EXISTS "file"(
 id SERIAL PRIMARY KEY ,
 name TEXT NOT NULL,
 ipfs_key TEXT NOT NULL UNIQUE,
 user_id int,
 mime_type TEXT,
-type TEXT                               
+type TEXT
 )`).Scan()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	// files uploaded before classification was stored inherit it from the uploader
+	_, err = conn.Exec(`
+ALTER TABLE "file" ADD COLUMN IF NOT EXISTS level int;
+ALTER TABLE "file" ADD COLUMN IF NOT EXISTS department int;
+ALTER TABLE "file" ADD COLUMN IF NOT EXISTS size bigint NOT NULL DEFAULT 0;
+ALTER TABLE "file" ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE "file" ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE "file" ADD COLUMN IF NOT EXISTS policy int;
+UPDATE "file" AS f SET level = u.level, department = u.department FROM "user" AS u WHERE f.level IS NULL AND f.user_id = u.id;
+UPDATE "file" SET level = 0, department = 0 WHERE level IS NULL;
+UPDATE "file" SET mime_type = '' WHERE mime_type IS NULL;
+UPDATE "file" SET type = '' WHERE type IS NULL;
+CREATE INDEX IF NOT EXISTS file_classification_idx ON "file" (department, level);
+CREATE INDEX IF NOT EXISTS file_user_id_idx ON "file" (user_id);
+CREATE INDEX IF NOT EXISTS file_mime_type_idx ON "file" (mime_type);
+CREATE INDEX IF NOT EXISTS file_created_at_idx ON "file" (created_at);
+CREATE INDEX IF NOT EXISTS file_name_idx ON "file" (lower(name));`)
+
+	return err
 }
 
-func AddFile(conn *pgx.ConnPool, file File) error {
-	err := conn.QueryRow("INSERT INTO file (name, ipfs_key, user_id, mime_type, type) VALUES ($1, $2, $3, $4, $5)", file.Name, file.IpfsKey, file.UserID, file.MimeType, file.Type).
-		Scan(&file.ID, &file.Name, &file.IpfsKey, &file.UserID, &file.MimeType, &file.Type)
+// FileTypeBundle is the type of the files whose content is a bundle.
+const FileTypeBundle = "bundle"
 
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("failed to Scan: %w", err)
+// CreateTableFileLink creates the table of the content a file refers to besides
+// its ipfs_key, like the entries of a bundle.
+func CreateTableFileLink(conn *pgx.ConnPool) error {
+	err := conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "file_link"(
+file_id int NOT NULL REFERENCES "file"(id) ON DELETE CASCADE,
+ipfs_key TEXT NOT NULL,
+PRIMARY KEY (file_id, ipfs_key)
+)`).Scan()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
 	}
 
 	return nil
 }
 
-func GetFile(conn *pgx.ConnPool, userID int) (File, error) {
+const fileColumns = `f.id, f.name, f.ipfs_key, f.user_id, f.mime_type, f.type, f.level, f.department, COALESCE(f.policy, f.level), f.size, f.created_at`
+
+func scanFile(row interface{ Scan(...interface{}) error }) (File, error) {
 	var file File
-	err := conn.QueryRow("SELECT * from file WHERE user_id = $1", userID).Scan(&file.ID, &file.Name, &file.IpfsKey, &file.UserID, &file.MimeType, &file.Type)
+	err := row.Scan(&file.ID, &file.Name, &file.IpfsKey, &file.UserID, &file.MimeType, &file.Type,
+		&file.Level, &file.Department, &file.Policy, &file.Size, &file.CreatedAt)
+
+	return file, err
+}
+
+// AddFile adds the file. A file without a name is named after its ID, a policy
+// below its level is raised to the level.
+func AddFile(conn *pgx.ConnPool, file File) (File, error) {
+	row := conn.QueryRow(`INSERT INTO "file" AS f (name, ipfs_key, user_id, mime_type, type, level, department, policy, size)
+VALUES ($1, $2, $3, $4, $5, $6, $7, GREATEST($8::int, $6::int), $9) RETURNING `+fileColumns,
+		file.Name, file.IpfsKey, file.UserID, file.MimeType, file.Type, file.Level, file.Department, file.Policy, file.Size)
+
+	file, err := scanFile(row)
+	if err != nil {
+		return File{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	if file.Name == "" {
+		file.Name = strconv.FormatInt(file.ID, 10)
+		if _, err = conn.Exec(`UPDATE "file" SET name = $2 WHERE id = $1`, file.ID, file.Name); err != nil {
+			return File{}, fmt.Errorf("failed to Exec: %w", err)
+		}
+	}
+
+	return file, nil
+}
+
+// AddBundle adds the file of a bundle with the root as its content and the
+// links of the rest of the bundle.
+func AddBundle(conn *pgx.ConnPool, file File, links []string) (File, error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return File{}, fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	file, err = scanFile(tx.QueryRow(`INSERT INTO "file" AS f (name, ipfs_key, user_id, mime_type, type, level, department, size)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING `+fileColumns,
+		file.Name, file.IpfsKey, file.UserID, file.MimeType, FileTypeBundle, file.Level, file.Department, file.Size))
+	if err != nil {
+		return File{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	for _, link := range links {
+		if _, err = tx.Exec(`INSERT INTO "file_link" (file_id, ipfs_key) VALUES ($1, $2) ON CONFLICT DO NOTHING`, file.ID, link); err != nil {
+			return File{}, fmt.Errorf("failed to Exec: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return File{}, fmt.Errorf("failed to Commit: %w", err)
+	}
+
+	return file, nil
+}
+
+func GetFile(conn *pgx.ConnPool, userID int) (File, error) {
+	file, err := scanFile(conn.QueryRow(`SELECT `+fileColumns+` from "file" AS f WHERE f.user_id = $1 AND f.deleted_at IS NULL`, userID))
+	if err == pgx.ErrNoRows {
+		return File{}, ErrFileNotFound
+	} else if err != nil {
+		return File{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return file, nil
+}
+
+func GetFileByID(conn *pgx.ConnPool, id int64) (File, error) {
+	file, err := scanFile(conn.QueryRow(`SELECT `+fileColumns+` from "file" AS f WHERE f.id = $1 AND f.deleted_at IS NULL`, id))
 	if err == pgx.ErrNoRows {
-		return File{}, err
+		return File{}, ErrFileNotFound
 	} else if err != nil {
 		return File{}, fmt.Errorf("failed to Scan: %w", err)
 	}
@@ -52,23 +190,150 @@ func GetFile(conn *pgx.ConnPool, userID int) (File, error) {
 	return file, nil
 }
 
-func GetAccessedFiles(conn *pgx.ConnPool, user User) ([]File, error) {
-	var files []File
-	rows, err := conn.Query(`SELECT f.id, f.name, f.ipfs_key, f.user_id, f.mime_type, f.type from "file" as f
-INNER JOIN "user" as u on f.user_id = u.id
-WHERE u.level<=$1 AND u.department=$2`, user.Level, user.Department)
+// CanRead reports whether the user's clearance covers the file's classification.
+func (f File) CanRead(user User) bool {
+	return f.Department == user.Department && f.Level <= user.Level
+}
+
+// ListFiles returns a page of the files the user has clearance for along with
+// the total number of files matching the filter.
+func ListFiles(conn *pgx.ConnPool, user User, filter FileFilter) ([]File, int, error) {
+	where := []string{"f.department = $1", "f.level <= $2", "f.deleted_at IS NULL"}
+	args := []interface{}{user.Department, user.Level}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.Name != "" {
+		where = append(where, "lower(f.name) LIKE "+arg("%"+strings.ToLower(escapeLike(filter.Name))+"%"))
+	}
+	if filter.MimeType != "" {
+		where = append(where, "f.mime_type = "+arg(filter.MimeType))
+	}
+	if filter.UploaderID != 0 {
+		where = append(where, "f.user_id = "+arg(filter.UploaderID))
+	}
+	if filter.Level != nil {
+		where = append(where, "f.level = "+arg(*filter.Level))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "f.created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "f.created_at < "+arg(filter.To))
+	}
+	cond := strings.Join(where, " AND ")
+
+	var total int
+	if err := conn.QueryRow(`SELECT count(*) FROM "file" AS f WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to Scan count: %w", err)
+	}
+
+	order, ok := FileSorts[filter.Sort]
+	if !ok {
+		order = FileSorts["created_at"]
+	}
+	if filter.Desc {
+		order += " DESC"
+	}
+	query := `SELECT ` + fileColumns + ` FROM "file" AS f WHERE ` + cond + ` ORDER BY ` + order + `, f.id`
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query += " OFFSET " + arg(filter.Offset)
+	}
+
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	files := []File{}
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to Scan: %w", err)
+		}
+
+		files = append(files, file)
+	}
+
+	return files, total, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// DeleteFile marks the file deleted. Its content stays pinned for the
+// retention period, then the reconciler purges it.
+func DeleteFile(conn Conn, id int64) error {
+	tag, err := conn.Exec(`UPDATE "file" SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrFileNotFound
+	}
+
+	return nil
+}
+
+// DeleteUserFiles marks the files uploaded by the user deleted and returns how
+// many there were.
+func DeleteUserFiles(conn Conn, userID int) (int, error) {
+	tag, err := conn.Exec(`UPDATE "file" SET deleted_at = now() WHERE user_id = $1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// FileLink is the stored content of a file, deleted or not.
+type FileLink struct {
+	ID        int64
+	IpfsKey   string
+	DeletedAt *time.Time
+}
+
+// ListFileLinks returns the content of every file row, the deleted ones
+// included, with the links of the bundles.
+func ListFileLinks(conn Conn) ([]FileLink, error) {
+	rows, err := conn.Query(`
+SELECT id, ipfs_key, deleted_at FROM "file"
+UNION ALL
+SELECT f.id, l.ipfs_key, f.deleted_at FROM "file_link" AS l JOIN "file" AS f ON f.id = l.file_id
+ORDER BY 1`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Query: %w", err)
 	}
+	defer rows.Close()
 
+	links := []FileLink{}
 	for rows.Next() {
-		var file File
-		if err = rows.Scan(&file.ID, &file.Name, &file.IpfsKey, &file.UserID, &file.MimeType, &file.Type); err != nil {
+		var l FileLink
+		if err = rows.Scan(&l.ID, &l.IpfsKey, &l.DeletedAt); err != nil {
 			return nil, fmt.Errorf("failed to Scan: %w", err)
 		}
+		links = append(links, l)
+	}
 
-		files = append(files, file)
+	return links, rows.Err()
+}
+
+// PurgeFile removes the row of a deleted file and the authority keys of the
+// link. The other links of the file are purged on their own.
+func PurgeFile(conn Conn, link FileLink) error {
+	if _, err := conn.Exec(`DELETE FROM "file" WHERE id = $1 AND deleted_at IS NOT NULL`, link.ID); err != nil {
+		return fmt.Errorf("failed to Exec file: %w", err)
+	}
+	if _, err := conn.Exec(`DELETE FROM "auth" WHERE id = $1`, link.IpfsKey); err != nil {
+		return fmt.Errorf("failed to Exec auth: %w", err)
 	}
 
-	return files, nil
+	return nil
 }
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// NotificationChannel is a channel a user receives notifications through.
+type NotificationChannel struct {
+	ID        int
+	UserID    int
+	Kind      string
+	Address   string
+	CreatedAt time.Time
+}
+
+func CreateTableNotificationChannel(conn *pgx.ConnPool) error {
+	return conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "notification_channel"(
+id SERIAL PRIMARY KEY ,
+user_id int NOT NULL REFERENCES "user" (id) ON DELETE CASCADE,
+kind TEXT NOT NULL,
+address TEXT NOT NULL,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+UNIQUE (user_id, kind, address)
+)`).Scan()
+}
+
+const notificationChannelColumns = `id, user_id, kind, address, created_at`
+
+func AddNotificationChannel(conn *pgx.ConnPool, ch NotificationChannel) (NotificationChannel, error) {
+	err := conn.QueryRow(`INSERT INTO "notification_channel" (user_id, kind, address) VALUES ($1, $2, $3) RETURNING `+notificationChannelColumns,
+		ch.UserID, ch.Kind, ch.Address).Scan(&ch.ID, &ch.UserID, &ch.Kind, &ch.Address, &ch.CreatedAt)
+	if err != nil {
+		return NotificationChannel{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return ch, nil
+}
+
+func GetNotificationChannels(conn *pgx.ConnPool, userID int) ([]NotificationChannel, error) {
+	rows, err := conn.Query(`SELECT `+notificationChannelColumns+` FROM "notification_channel" WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	channels := []NotificationChannel{}
+	for rows.Next() {
+		var ch NotificationChannel
+		if err := rows.Scan(&ch.ID, &ch.UserID, &ch.Kind, &ch.Address, &ch.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		channels = append(channels, ch)
+	}
+
+	return channels, nil
+}
+
+// DeleteNotificationChannel deletes the user's channel with the given id.
+func DeleteNotificationChannel(conn *pgx.ConnPool, userID, id int) error {
+	tag, err := conn.Exec(`DELETE FROM "notification_channel" WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
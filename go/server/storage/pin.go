@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// CreateTablePin creates the table of the CIDs the server pinned itself. Only
+// these pins are ever removed as orphans; the rest of the pinset of the nodes
+// isn't the server's to manage.
+func CreateTablePin(conn *pgx.ConnPool) error {
+	err := conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "pin"(
+cid TEXT PRIMARY KEY,
+pinned_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`).Scan()
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+
+	return err
+}
+
+// AddPin records a CID the server pinned. Recording it again is a no-op.
+func AddPin(conn Conn, cid string) error {
+	if _, err := conn.Exec(`INSERT INTO "pin" (cid) VALUES ($1) ON CONFLICT (cid) DO NOTHING`, cid); err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	return nil
+}
+
+// ListPins returns the CIDs the server pinned.
+func ListPins(conn Conn) ([]string, error) {
+	rows, err := conn.Query(`SELECT cid FROM "pin" ORDER BY cid`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	cids := []string{}
+	for rows.Next() {
+		var cid string
+		if err = rows.Scan(&cid); err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		cids = append(cids, cid)
+	}
+
+	return cids, rows.Err()
+}
+
+// DeletePin forgets a CID the server unpinned.
+func DeletePin(conn Conn, cid string) error {
+	if _, err := conn.Exec(`DELETE FROM "pin" WHERE cid = $1`, cid); err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Proposal actions.
+const (
+	ProposalAdd    = "add"
+	ProposalDelete = "delete"
+	ProposalUpdate = "update"
+	ProposalRebind = "rebind"
+)
+
+// Proposal statuses. A pending proposal is approved by a second admin and then
+// either executed or failed, or it is rejected or expires.
+const (
+	ProposalPending  = "pending"
+	ProposalApproved = "approved"
+	ProposalExecuted = "executed"
+	ProposalFailed   = "failed"
+	ProposalRejected = "rejected"
+	ProposalExpired  = "expired"
+)
+
+// ErrProposalDecided is returned when a proposal is no longer pending.
+var ErrProposalDecided = errors.New("proposal is already decided")
+
+type Proposal struct {
+	ID         int
+	Action     string
+	User       User
+	ProposedBy int
+	DecidedBy  int
+	Status     string
+	Result     string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	DecidedAt  time.Time
+}
+
+func CreateTableProposal(conn *pgx.ConnPool) error {
+	err := conn.QueryRow(`
+CREATE TABLE IF NOT EXISTS "proposal"(
+id SERIAL PRIMARY KEY ,
+action TEXT NOT NULL,
+user_id int NOT NULL DEFAULT 0,
+tg_name TEXT NOT NULL DEFAULT '',
+department int NOT NULL,
+level int NOT NULL,
+proposed_by int NOT NULL,
+decided_by int NOT NULL DEFAULT 0,
+status TEXT NOT NULL,
+result TEXT NOT NULL DEFAULT '',
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+expires_at TIMESTAMPTZ NOT NULL,
+decided_at TIMESTAMPTZ
+)`).Scan()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	// proposals don't keep the pk of the user
+	_, err = conn.Exec(`ALTER TABLE "proposal" DROP COLUMN IF EXISTS pk`)
+
+	return err
+}
+
+const proposalColumns = `id, action, user_id, tg_name, department, level, proposed_by, decided_by, status, result, created_at, expires_at, decided_at`
+
+func scanProposal(row interface{ Scan(...interface{}) error }) (Proposal, error) {
+	var (
+		p         Proposal
+		decidedAt *time.Time
+	)
+	err := row.Scan(&p.ID, &p.Action, &p.User.ID, &p.User.TgName, &p.User.Department, &p.User.Level,
+		&p.ProposedBy, &p.DecidedBy, &p.Status, &p.Result, &p.CreatedAt, &p.ExpiresAt, &decidedAt)
+	if err != nil {
+		return Proposal{}, err
+	}
+	if decidedAt != nil {
+		p.DecidedAt = *decidedAt
+	}
+
+	return p, nil
+}
+
+func AddProposal(conn *pgx.ConnPool, p Proposal) (Proposal, error) {
+	row := conn.QueryRow(`INSERT INTO "proposal" (action, user_id, tg_name, department, level, proposed_by, status, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING `+proposalColumns,
+		p.Action, p.User.ID, p.User.TgName, p.User.Department, p.User.Level, p.ProposedBy, ProposalPending, p.ExpiresAt)
+
+	p, err := scanProposal(row)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return p, nil
+}
+
+func GetProposal(conn *pgx.ConnPool, id int) (Proposal, error) {
+	p, err := scanProposal(conn.QueryRow(`SELECT `+proposalColumns+` FROM "proposal" WHERE id = $1;`, id))
+
+	if err == pgx.ErrNoRows {
+		return Proposal{}, err
+	} else if err != nil {
+		return Proposal{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return p, nil
+}
+
+// GetProposals returns the proposal history, newest first. An empty status
+// returns proposals in every status.
+func GetProposals(conn *pgx.ConnPool, status string) ([]Proposal, error) {
+	var proposals []Proposal
+	rows, err := conn.Query(`SELECT `+proposalColumns+` FROM "proposal" WHERE $1 = '' OR status = $1 ORDER BY id DESC;`, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProposal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		proposals = append(proposals, p)
+	}
+
+	return proposals, nil
+}
+
+// DecideProposal moves a proposal from the from status to the to status. It
+// returns ErrProposalDecided when the proposal is not in the from status, so
+// two admins can't decide the same proposal concurrently.
+func DecideProposal(conn *pgx.ConnPool, id int, from, to string, decidedBy int, result string) error {
+	tag, err := conn.Exec(`UPDATE "proposal" SET status = $3, decided_by = $4, result = $5, decided_at = now() WHERE id = $1 AND status = $2`,
+		id, from, to, decidedBy, result)
+	if err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrProposalDecided
+	}
+
+	return nil
+}
+
+// ExpireProposals marks every pending proposal past its deadline as expired.
+func ExpireProposals(conn *pgx.ConnPool, now time.Time) (int64, error) {
+	tag, err := conn.Exec(`UPDATE "proposal" SET status = $1, decided_at = $2 WHERE status = $3 AND expires_at < $2`,
+		ProposalExpired, now, ProposalPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to Exec: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
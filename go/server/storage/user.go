@@ -11,41 +11,104 @@ import (
 	"github.com/jackc/pgx"
 )
 
+// User statuses. A suspended user keeps their enrollment but can't access files.
+const (
+	UserActive    = "active"
+	UserSuspended = "suspended"
+)
+
+var (
+	// ErrUserSuspended is returned when a suspended user tries to access files.
+	ErrUserSuspended = errors.New("user is suspended")
+	// ErrUserNotFound wraps pgx.ErrNoRows, so checks for either match it.
+	ErrUserNotFound = fmt.Errorf("user not found: %w", pgx.ErrNoRows)
+	// ErrInvalidCredentials is returned when no user has the given ID and pk.
+	// It wraps pgx.ErrNoRows as well.
+	ErrInvalidCredentials = fmt.Errorf("invalid user credentials: %w", pgx.ErrNoRows)
+)
+
+// Conn is implemented by both *pgx.ConnPool and *pgx.Tx.
+type Conn interface {
+	QueryRow(sql string, args ...interface{}) *pgx.Row
+	Query(sql string, args ...interface{}) (*pgx.Rows, error)
+	Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error)
+}
+
 type User struct {
-	ID         int
-	TgName     string
-	PK         string
+	ID     int
+	TgName string
+	// TgID is the Telegram user ID bound with an enrollment code, 0 until then.
+	TgID int64
+	// PK is the credential of the user. The admin API returns users as they
+	// are, so it is never encoded.
+	PK         string `json:"-"`
 	Department int
 	Level      int
+	Status     string
 }
 
 func CreateTableUser(conn *pgx.ConnPool) error {
-	return conn.QueryRow(`
+	err := conn.QueryRow(`
 CREATE TABLE IF NOT EXISTS "user"(
 id SERIAL PRIMARY KEY ,
 tg_name TEXT NOT NULL UNIQUE,
 pk TEXT NOT NULL UNIQUE,
 department int,
-level int                                 
+level int
 )`).Scan()
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	_, err = conn.Exec(`
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS tg_id BIGINT UNIQUE;
+CREATE TABLE IF NOT EXISTS "tg_name_change"(
+id SERIAL PRIMARY KEY ,
+user_id int NOT NULL REFERENCES "user" (id) ON DELETE CASCADE,
+old_name TEXT NOT NULL,
+new_name TEXT NOT NULL,
+changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);`)
+
+	return err
 }
 
+const userColumns = `id, tg_name, tg_id, pk, department, level, status`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
+	var (
+		user User
+		tgID *int64
+	)
+	err := row.Scan(&user.ID, &user.TgName, &tgID, &user.PK, &user.Department, &user.Level, &user.Status)
+	if tgID != nil {
+		user.TgID = *tgID
+	}
+
+	return user, err
+}
+
+// CheckUserPK makes sure the user exists with the given pk and is not suspended.
 func CheckUserPK(conn *pgx.ConnPool, id int, pk string) error {
-	var user User
-	err := conn.QueryRow(`SELECT id FROM "user" WHERE id = $1 AND pk = $2;`, id, pk).Scan(&user.ID)
+	var status string
+	err := conn.QueryRow(`SELECT status FROM "user" WHERE id = $1 AND pk = $2;`, id, pk).Scan(&status)
 
 	if err == pgx.ErrNoRows {
-		return err
+		return ErrInvalidCredentials
 	} else if err != nil {
 		return fmt.Errorf("failed to Scan: %w", err)
 	}
 
+	if status == UserSuspended {
+		return ErrUserSuspended
+	}
+
 	return nil
 }
 
 func GetUserByTgName(conn *pgx.ConnPool, tgName string) (User, error) {
-	var user User
-	err := conn.QueryRow(`SELECT * FROM "user" WHERE tg_name = $1;`, tgName).Scan(&user.ID, &user.TgName, &user.PK, &user.Department, &user.Level)
+	user, err := scanUser(conn.QueryRow(`SELECT `+userColumns+` FROM "user" WHERE tg_name = $1;`, tgName))
 
 	if err == pgx.ErrNoRows {
 		return User{}, err
@@ -56,12 +119,53 @@ func GetUserByTgName(conn *pgx.ConnPool, tgName string) (User, error) {
 	return user, nil
 }
 
+func GetUserByTgID(conn *pgx.ConnPool, tgID int64) (User, error) {
+	user, err := scanUser(conn.QueryRow(`SELECT `+userColumns+` FROM "user" WHERE tg_id = $1;`, tgID))
+
+	if err == pgx.ErrNoRows {
+		return User{}, ErrUserNotFound
+	} else if err != nil {
+		return User{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return user, nil
+}
+
+// UpdateUserTgName records a change of the user's Telegram username.
+func UpdateUserTgName(conn *pgx.ConnPool, id int, oldName, newName string) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to Begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`UPDATE "user" SET tg_name = $2 WHERE id = $1`, id, newName); err != nil {
+		return fmt.Errorf("failed to Exec update: %w", err)
+	}
+	if _, err = tx.Exec(`INSERT INTO "tg_name_change" (user_id, old_name, new_name) VALUES ($1, $2, $3)`, id, oldName, newName); err != nil {
+		return fmt.Errorf("failed to Exec insert: %w", err)
+	}
+
+	return tx.Commit()
+}
+
 func GetUser(conn *pgx.ConnPool, id int, pk string) (User, error) {
-	var user User
-	err := conn.QueryRow(`SELECT * FROM "user" WHERE id = $1 AND pk = $2;`, id, pk).Scan(&user.ID, &user.TgName, &user.PK, &user.Department, &user.Level)
+	user, err := scanUser(conn.QueryRow(`SELECT `+userColumns+` FROM "user" WHERE id = $1 AND pk = $2;`, id, pk))
 
 	if err == pgx.ErrNoRows {
-		return User{}, err
+		return User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return User{}, fmt.Errorf("failed to Scan: %w", err)
+	}
+
+	return user, nil
+}
+
+func GetUserByID(conn Conn, id int) (User, error) {
+	user, err := scanUser(conn.QueryRow(`SELECT `+userColumns+` FROM "user" WHERE id = $1;`, id))
+
+	if err == pgx.ErrNoRows {
+		return User{}, ErrUserNotFound
 	} else if err != nil {
 		return User{}, fmt.Errorf("failed to Scan: %w", err)
 	}
@@ -71,14 +175,14 @@ func GetUser(conn *pgx.ConnPool, id int, pk string) (User, error) {
 
 func GetAll(conn *pgx.ConnPool) ([]User, error) {
 	var users []User
-	rows, err := conn.Query(`SELECT * FROM "user";`)
+	rows, err := conn.Query(`SELECT ` + userColumns + ` FROM "user";`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Query: %w", err)
 	}
 
 	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.TgName, &user.PK, &user.Department, &user.Level); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to Scan: %w", err)
 		}
 		users = append(users, user)
@@ -87,42 +191,130 @@ func GetAll(conn *pgx.ConnPool) ([]User, error) {
 	return users, nil
 }
 
+// uniqueViolation is the Postgres error code of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// AddUser adds the user with a random PK. Only a clash of the PK is retried,
+// other errors, like a taken tg_name, are returned.
 func AddUser(conn *pgx.ConnPool, tgName string, dep, level int) (User, error) {
-	var user User
 	for i := 0; i < 1000; i++ {
 		nBig, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt64)))
 		if err != nil {
 			return User{}, fmt.Errorf("failed to rand.Int: %w", err)
 		}
 		pk := base64.StdEncoding.EncodeToString(nBig.Bytes())
-		err = conn.QueryRow(`INSERT INTO "user" (tg_name, pk, department, level) VALUES ($1, $2, $3, $4)`, tgName, pk, dep, level).
-			Scan(&user.ID, &user.PK, &user.Department, &user.Level)
-		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-			continue
-		}
 
-		err = conn.QueryRow(`SELECT * FROM "user" WHERE pk = $1;`, pk).Scan(&user.ID, &user.TgName, &user.PK, &user.Department, &user.Level)
-		if err == pgx.ErrNoRows {
-			return User{}, err
+		user, err := scanUser(conn.QueryRow(`INSERT INTO "user" (tg_name, pk, department, level) VALUES ($1, $2, $3, $4) RETURNING `+userColumns,
+			tgName, pk, dep, level))
+		var pgErr pgx.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == "user_pk_key" {
+			continue
 		} else if err != nil {
-			return User{}, fmt.Errorf("failed to SELECT: %w", err)
+			return User{}, fmt.Errorf("failed to Scan: %w", err)
 		}
 
-		user.PK = pk
-
 		return user, nil
 	}
 
-	return User{}, nil
+	return User{}, errors.New("failed to generate a unique pk")
 }
 
-func DeleteUser(conn *pgx.ConnPool, id int, tgName, pk string) error {
-	var user User
-	err := conn.QueryRow(`DELETE FROM "user" WHERE id = $1 OR pk = $2 OR tg_name = $3`, id, pk).Scan(&user.ID, &user.PK, &tgName)
+// UpdateUserClearance sets the user's level and department.
+func UpdateUserClearance(conn Conn, id, level, dep int) error {
+	tag, err := conn.Exec(`UPDATE "user" SET level = $2, department = $3 WHERE id = $1`, id, level, dep)
+	if err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
 
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return fmt.Errorf("failed to Scan: %w", err)
+	return nil
+}
+
+func SetUserStatus(conn Conn, id int, status string) error {
+	tag, err := conn.Exec(`UPDATE "user" SET status = $2 WHERE id = $1`, id, status)
+	if err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
 	}
 
 	return nil
 }
+
+// DeleteUser deletes the user with the given id.
+func DeleteUser(conn Conn, id int) error {
+	tag, err := conn.Exec(`DELETE FROM "user" WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+func GetAllByDepartment(conn *pgx.ConnPool, department int) ([]User, error) {
+	rows, err := conn.Query(`SELECT `+userColumns+` FROM "user" WHERE department = $1;`, department)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUsersInAccumulators returns the users of any of the levels or any of the
+// departments, the members of their accumulators.
+func GetUsersInAccumulators(conn *pgx.ConnPool, levels, departments []int32) ([]User, error) {
+	rows, err := conn.Query(`SELECT `+userColumns+` FROM "user" WHERE level = ANY($1) OR department = ANY($2);`, levels, departments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// GetUsersWithClearance returns the active users of the department whose level
+// is at least level.
+func GetUsersWithClearance(conn *pgx.ConnPool, level, department int) ([]User, error) {
+	rows, err := conn.Query(`SELECT `+userColumns+` FROM "user" WHERE department = $1 AND level >= $2 AND status = $3;`,
+		department, level, UserActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to Scan: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
@@ -7,6 +7,10 @@ import (
 	"github.com/jackc/pgx"
 )
 
+// ErrWitnessNotFound is returned for users without issued witnesses. It wraps
+// pgx.ErrNoRows.
+var ErrWitnessNotFound = fmt.Errorf("witness not found: %w", pgx.ErrNoRows)
+
 type Witness struct {
 	ID           string
 	WitnessLevel string
@@ -26,7 +30,7 @@ func GetWitness(conn *pgx.ConnPool, id string) (Witness, error) {
 	err := conn.QueryRow("SELECT id, witness_level, witness_dep FROM witness WHERE id = $1;", id).Scan(&witness.ID, &witness.WitnessLevel, &witness.WitnessDep)
 
 	if err == pgx.ErrNoRows {
-		return Witness{}, err
+		return Witness{}, ErrWitnessNotFound
 	} else if err != nil {
 		return Witness{}, fmt.Errorf("failed to Scan: %w", err)
 	}
@@ -34,8 +38,10 @@ func GetWitness(conn *pgx.ConnPool, id string) (Witness, error) {
 	return witness, nil
 }
 
-func SetWitness(conn *pgx.ConnPool, witness Witness) error {
-	err := conn.QueryRow("INSERT INTO witness (id, witness_level, witness_dep) VALUES ($1, $2, $3)", witness.ID, witness.WitnessLevel, witness.WitnessDep).
+// SetWitness stores the witnesses of a user, replacing the previously issued ones.
+func SetWitness(conn Conn, witness Witness) error {
+	err := conn.QueryRow(`INSERT INTO witness (id, witness_level, witness_dep) VALUES ($1, $2, $3)
+ON CONFLICT (id) DO UPDATE SET witness_level = EXCLUDED.witness_level, witness_dep = EXCLUDED.witness_dep`, witness.ID, witness.WitnessLevel, witness.WitnessDep).
 		Scan(&witness.ID, &witness.WitnessLevel, &witness.WitnessDep)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -47,16 +53,12 @@ func SetWitness(conn *pgx.ConnPool, witness Witness) error {
 	return nil
 }
 
-func DeleteWitness(conn *pgx.ConnPool, id string) error {
-	var witness Witness
-	err := conn.QueryRow("DELETE FROM witness WHERE id = $1", id).
-		Scan(&witness.ID, &witness.WitnessLevel, &witness.WitnessDep)
-
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("failed to Scan: %w", err)
+// DeleteWitness deletes the witnesses of a user and reports whether there were any.
+func DeleteWitness(conn Conn, id string) (bool, error) {
+	tag, err := conn.Exec("DELETE FROM witness WHERE id = $1", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to Exec: %w", err)
 	}
 
-	return nil
+	return tag.RowsAffected() > 0, nil
 }
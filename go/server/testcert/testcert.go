@@ -0,0 +1,130 @@
+// Package testcert generates certificate authorities and the certificates
+// they issue for tests, written as PEM files to the test's temporary
+// directory.
+package testcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// CA is a self-signed certificate authority.
+type CA struct {
+	// CertFile is the PEM file of the CA certificate.
+	CertFile string
+	// Pool holds the CA certificate.
+	Pool *x509.CertPool
+
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+// NewCA returns a new CA named name.
+func NewCA(t testing.TB, name string) *CA {
+	t.Helper()
+
+	key := newKey(t)
+	tmpl := template(t, name)
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to ParseCertificate: %v", err)
+	}
+
+	ca := &CA{Pool: x509.NewCertPool(), cert: cert, key: key, dir: t.TempDir()}
+	ca.Pool.AddCert(cert)
+	ca.CertFile = ca.write(t, name+".crt", "CERTIFICATE", der)
+
+	return ca
+}
+
+// Issue returns the certificate and key files of a certificate named name for
+// hosts, DNS names or IP addresses. It serves for both server and client
+// authentication.
+func (ca *CA) Issue(t testing.TB, name string, hosts ...string) (certFile, keyFile string) {
+	t.Helper()
+
+	key := newKey(t)
+	tmpl := template(t, name)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to MarshalECPrivateKey: %v", err)
+	}
+
+	return ca.write(t, name+".crt", "CERTIFICATE", der), ca.write(t, name+".key", "EC PRIVATE KEY", keyDER)
+}
+
+// KeyPair is Issue loaded as a tls.Certificate.
+func (ca *CA) KeyPair(t testing.TB, name string, hosts ...string) tls.Certificate {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(ca.Issue(t, name, hosts...))
+	if err != nil {
+		t.Fatalf("failed to LoadX509KeyPair: %v", err)
+	}
+
+	return cert
+}
+
+func (ca *CA) write(t testing.TB, name, typ string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to WriteFile: %v", err)
+	}
+
+	return path
+}
+
+func newKey(t testing.TB) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to GenerateKey: %v", err)
+	}
+
+	return key
+}
+
+func template(t testing.TB, name string) *x509.Certificate {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatalf("failed to rand.Int: %v", err)
+	}
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
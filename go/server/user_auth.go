@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/storage"
+)
+
+const (
+	userIDHeader = "X-User-ID"
+	userPKHeader = "X-User-PK"
+)
+
+// requireUser authenticates the caller by the X-User-ID and X-User-PK headers.
+// Suspended users are rejected.
+func requireUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Request().Header.Get(userIDHeader))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "missing user credentials")
+		}
+		pk := c.Request().Header.Get(userPKHeader)
+
+		user, err := storage.GetUser(db.DB, id, pk)
+		if errors.Is(err, pgx.ErrNoRows) {
+			zap.L().Warn("unknown user credentials", zap.Int("user_id", id), zap.String("uri", c.Request().RequestURI))
+			return echo.NewHTTPError(http.StatusUnauthorized, "incorrect user credentials")
+		} else if err != nil {
+			zap.L().Error("failed to GetUser", zap.Error(err))
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		l := zap.L().With(zap.Int("user_id", user.ID))
+		if user.Status == storage.UserSuspended {
+			l.Warn("suspended user", zap.String("uri", c.Request().RequestURI))
+			return storage.ErrUserSuspended
+		}
+
+		c.Set("user", &user)
+		c.Set("logger", l)
+
+		return next(c)
+	}
+}
+
+// Handler
+//
+// getMe returns the calling user, clients use it to check their credentials.
+func getMe(c echo.Context) error {
+	return c.JSON(http.StatusOK, apiUser(*c.Get("user").(*storage.User)))
+}
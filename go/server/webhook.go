@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"server/config"
+)
+
+// webhookSecretHeader carries the secret token Telegram sends with updates.
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+const defaultWebhookPath = "/telegram/webhook"
+
+func webhookPath(cfg config.Webhook) string {
+	if cfg.Path == "" {
+		return defaultWebhookPath
+	}
+
+	return "/" + strings.TrimPrefix(cfg.Path, "/")
+}
+
+// requireWebhookSecret rejects update requests without the webhook secret token.
+func requireWebhookSecret(secret string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := c.Request().Header.Get(webhookSecretHeader)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+				zap.L().Warn("invalid webhook secret token", zap.String("remote_ip", c.RealIP()))
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid secret token")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// mountWebhook serves bot updates on e. The bot processes them once started
+// with StartWebhook.
+func mountWebhook(e *echo.Echo, b *bot.Bot, cfg config.Webhook) error {
+	if cfg.SecretToken == "" {
+		return fmt.Errorf("webhook secret_token is required")
+	}
+
+	e.POST(webhookPath(cfg), echo.WrapHandler(b.WebhookHandler()), requireWebhookSecret(cfg.SecretToken.Reveal()))
+
+	return nil
+}
+
+// setWebhook points Telegram at the webhook endpoint.
+func setWebhook(ctx context.Context, b *bot.Bot, cfg config.Webhook) error {
+	params := &bot.SetWebhookParams{
+		URL:            strings.TrimSuffix(cfg.URL, "/") + webhookPath(cfg),
+		SecretToken:    cfg.SecretToken.Reveal(),
+		MaxConnections: cfg.MaxConnections,
+	}
+	if cfg.Certificate != "" {
+		cert, err := os.ReadFile(cfg.Certificate)
+		if err != nil {
+			return fmt.Errorf("failed to ReadFile certificate: %w", err)
+		}
+		params.Certificate = &models.InputFileUpload{Filename: "cert.pem", Data: bytes.NewReader(cert)}
+	}
+
+	if _, err := b.SetWebhook(ctx, params); err != nil {
+		return fmt.Errorf("failed to SetWebhook: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"server/config"
+)
+
+// fakeTelegram is a Telegram Bot API server recording the called methods.
+type fakeTelegram struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	calls map[string][]map[string]string
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	f := &fakeTelegram{calls: map[string][]map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+		fields := map[string]string{}
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			for k, v := range r.MultipartForm.Value {
+				fields[k] = v[0]
+			}
+		}
+
+		f.mu.Lock()
+		f.calls[method] = append(f.calls[method], fields)
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch method {
+		case "sendMessage":
+			w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`))
+		default:
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeTelegram) called(method string) []map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]map[string]string(nil), f.calls[method]...)
+}
+
+// webhookBot returns an echo server with the webhook of a bot that answers
+// every update with a message naming its kind.
+func webhookBot(t *testing.T, api *fakeTelegram, cfg config.Webhook) *echo.Echo {
+	b, err := bot.New("123:test", bot.WithServerURL(api.URL), bot.WithSkipGetMe(),
+		bot.WithDefaultHandler(func(ctx context.Context, b *bot.Bot, update *models.Update) {
+			var (
+				chatID int64
+				text   string
+			)
+			switch {
+			case update.Message != nil:
+				chatID, text = update.Message.Chat.ID, "message "+update.Message.Text
+			case update.CallbackQuery != nil:
+				chatID, text = update.CallbackQuery.Message.Message.Chat.ID, "callback "+update.CallbackQuery.Data
+			}
+			b.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text})
+		}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go b.StartWebhook(ctx)
+
+	e := echo.New()
+	require.NoError(t, mountWebhook(e, b, cfg))
+
+	return e
+}
+
+func postUpdate(e *echo.Echo, path, secret string, body []byte) int {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if secret != "" {
+		req.Header.Set(webhookSecretHeader, secret)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec.Code
+}
+
+func TestWebhookRecordedUpdates(t *testing.T) {
+	api := newFakeTelegram(t)
+	e := webhookBot(t, api, config.Webhook{SecretToken: "s3cret"})
+
+	files, err := filepath.Glob("testdata/updates/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, postUpdate(e, defaultWebhookPath, "s3cret", body), file)
+	}
+
+	require.Eventually(t, func() bool { return len(api.called("sendMessage")) == len(files) }, 5*time.Second, 10*time.Millisecond)
+
+	texts := []string{}
+	for _, call := range api.called("sendMessage") {
+		require.Equal(t, "5012345678", call["chat_id"])
+		texts = append(texts, call["text"])
+	}
+	require.ElementsMatch(t, []string{"message /start", "callback files|1|AbCdEfGh"}, texts)
+}
+
+func TestWebhookSecretToken(t *testing.T) {
+	api := newFakeTelegram(t)
+	e := webhookBot(t, api, config.Webhook{Path: "updates", SecretToken: "s3cret"})
+
+	body, err := os.ReadFile("testdata/updates/message.json")
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusUnauthorized, postUpdate(e, "/updates", "", body))
+	require.Equal(t, http.StatusUnauthorized, postUpdate(e, "/updates", "wrong", body))
+	require.Equal(t, http.StatusNotFound, postUpdate(e, defaultWebhookPath, "s3cret", body))
+
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, api.called("sendMessage"))
+
+	require.Equal(t, http.StatusOK, postUpdate(e, "/updates", "s3cret", body))
+	require.Eventually(t, func() bool { return len(api.called("sendMessage")) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWebhookRequiresSecret(t *testing.T) {
+	b, err := bot.New("123:test", bot.WithSkipGetMe())
+	require.NoError(t, err)
+
+	require.Error(t, mountWebhook(echo.New(), b, config.Webhook{}))
+}
+
+func TestSetWebhook(t *testing.T) {
+	api := newFakeTelegram(t)
+	b, err := bot.New("123:test", bot.WithServerURL(api.URL), bot.WithSkipGetMe())
+	require.NoError(t, err)
+
+	require.NoError(t, setWebhook(context.Background(), b, config.Webhook{
+		URL:            "https://example.org/",
+		Path:           "/tg",
+		SecretToken:    "s3cret",
+		MaxConnections: 10,
+	}))
+
+	calls := api.called("setWebhook")
+	require.Len(t, calls, 1)
+	require.Equal(t, "https://example.org/tg", calls[0]["url"])
+	require.Equal(t, "s3cret", calls[0]["secret_token"])
+	require.Equal(t, "10", calls[0]["max_connections"])
+}